@@ -0,0 +1,150 @@
+// Command a2listen listens to a live a2web session from a terminal and
+// writes it as one continuous WebM stream, to stdout for piping into a
+// player or to rotating files for redundant recording on a home server:
+//
+//	a2listen -server https://a2web.example.com -session ID | mpv -
+//	a2listen -server https://a2web.example.com -session ID -dir /srv/a2web -rotate 1h -keep 48
+//
+// It authenticates with a personal API token (-token or A2WEB_TOKEN, scope
+// sessions:read) or a login (-mobile and A2WEB_PASSWORD) and reconnects on
+// its own. Whenever the source or the listener reconnects, the server sends
+// the init segment again; it is dropped when the tracks are unchanged and
+// the cluster timecodes are shifted so the output never jumps back in time.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zamibd/a2web/pkg/client"
+)
+
+type options struct {
+	server   string
+	session  string
+	token    string
+	mobile   string
+	password string
+	dir      string
+	prefix   string
+	rotate   time.Duration
+	keep     int
+}
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	slog.SetDefault(logger)
+
+	var o options
+	flag.StringVar(&o.server, "server", os.Getenv("A2WEB_SERVER"), "server URL, e.g. https://a2web.example.com (A2WEB_SERVER)")
+	flag.StringVar(&o.session, "session", "", "session ID to listen to")
+	flag.StringVar(&o.token, "token", os.Getenv("A2WEB_TOKEN"), "personal API token with sessions:read (A2WEB_TOKEN)")
+	flag.StringVar(&o.mobile, "mobile", os.Getenv("A2WEB_MOBILE"), "mobile number to log in with instead of a token (A2WEB_MOBILE); the password is read from A2WEB_PASSWORD")
+	flag.StringVar(&o.dir, "dir", "", "write rotating files to this directory instead of stdout")
+	flag.StringVar(&o.prefix, "prefix", "a2listen", "file name prefix with -dir")
+	flag.DurationVar(&o.rotate, "rotate", time.Hour, "start a new file after this long, with -dir")
+	flag.IntVar(&o.keep, "keep", 0, "number of files to keep with -dir; 0 keeps all")
+	flag.Parse()
+	o.password = os.Getenv("A2WEB_PASSWORD")
+
+	if o.server == "" || o.session == "" || (o.token == "" && o.mobile == "") {
+		fmt.Fprintln(os.Stderr, "usage: a2listen -server URL -session ID (-token TOKEN | -mobile MOBILE) [-dir DIR]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var out sink
+	if o.dir != "" {
+		if err := os.MkdirAll(o.dir, 0755); err != nil {
+			logger.Error("Failed to create output directory", "error", err)
+			os.Exit(1)
+		}
+		out = &fileSink{dir: o.dir, prefix: o.prefix, rotate: o.rotate, keep: o.keep, logger: logger}
+	} else {
+		out = &streamSink{w: os.Stdout}
+	}
+
+	err := run(ctx, o, out, logger)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		logger.Error("Listening failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, o options, out sink, logger *slog.Logger) error {
+	var opts []client.Option
+	if o.token != "" {
+		opts = append(opts, client.WithToken(o.token))
+	}
+	c, err := client.New(o.server, opts...)
+	if err != nil {
+		return err
+	}
+
+	st := &stitcher{out: out, logger: logger}
+	for {
+		if o.token == "" {
+			if err := c.Login(ctx, o.mobile, o.password); err != nil {
+				return fmt.Errorf("login: %w", err)
+			}
+		}
+
+		l, err := c.Listen(ctx, o.session, &client.StreamOptions{
+			OnConnect: func(n int) {
+				logger.Info("Connected", "session_id", o.session, "connection", n)
+			},
+			OnDisconnect: func(err error) {
+				logger.Warn("Connection lost, reconnecting", "session_id", o.session, "error", err)
+			},
+		})
+		if err != nil {
+			return err
+		}
+		go logControl(l, logger)
+
+		err = st.run(l)
+		l.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// A login expires after a while; log in again rather than give up
+		var apiErr *client.APIError
+		if o.token == "" && errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			logger.Info("Login expired, logging in again")
+			continue
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+}
+
+func logControl(l *client.ListenerStream, logger *slog.Logger) {
+	for msg := range l.Control() {
+		switch msg.Type {
+		case client.MsgSourceLost:
+			logger.Warn("Source lost", "reason", msg.Reason)
+		case client.MsgSourceStarted:
+			logger.Info("Source started")
+		case client.MsgSOS:
+			logger.Warn("SOS from the kid device", "alert_id", msg.AlertID, "message", msg.Message)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/zamibd/a2web/internal/webm"
+)
+
+// sink receives a stitched WebM stream.
+type sink interface {
+	// WriteInit starts a stream; it is called again only when the tracks
+	// change.
+	WriteInit(init []byte) error
+	WriteCluster(c webm.Chunk) error
+	WriteBlock(data []byte) error
+	Close() error
+}
+
+// streamSink writes one stream to a pipe. A change of tracks chains a new
+// WebM stream, which players such as mpv and ffplay follow.
+type streamSink struct {
+	w io.Writer
+}
+
+func (s *streamSink) WriteInit(init []byte) error     { return s.write(init) }
+func (s *streamSink) WriteCluster(c webm.Chunk) error { return s.write(c.Data) }
+func (s *streamSink) WriteBlock(data []byte) error    { return s.write(data) }
+func (s *streamSink) Close() error                    { return nil }
+func (s *streamSink) write(p []byte) error {
+	_, err := s.w.Write(p)
+	return err
+}
+
+// fileSink writes rotating files that each play on their own: every file
+// starts with the init segment at a cluster boundary, with its timeline
+// starting at zero.
+type fileSink struct {
+	dir    string
+	prefix string
+	rotate time.Duration
+	keep   int
+	logger *slog.Logger
+
+	init   []byte
+	f      *os.File
+	opened time.Time
+	base   time.Duration // output time of the file's first cluster
+}
+
+func (s *fileSink) WriteInit(init []byte) error {
+	s.init = append([]byte(nil), init...)
+	// New tracks cannot continue the current file
+	return s.closeFile()
+}
+
+func (s *fileSink) WriteCluster(c webm.Chunk) error {
+	if s.f != nil && time.Since(s.opened) >= s.rotate {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+		s.base = c.Time
+	}
+	_, err := s.f.Write(c.Shift(-s.base).Data)
+	return err
+}
+
+func (s *fileSink) WriteBlock(data []byte) error {
+	if s.f == nil {
+		return nil
+	}
+	_, err := s.f.Write(data)
+	return err
+}
+
+func (s *fileSink) Close() error { return s.closeFile() }
+
+func (s *fileSink) open() error {
+	now := time.Now()
+	name := filepath.Join(s.dir, s.prefix+"-"+now.Format("20060102-150405")+".webm")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(s.init); err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.opened = f, now
+	s.logger.Info("Writing file", "path", name)
+	s.prune()
+	return nil
+}
+
+func (s *fileSink) closeFile() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// prune deletes the oldest files beyond the number to keep. Names sort by
+// time, so the newest come last.
+func (s *fileSink) prune() {
+	if s.keep <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(s.dir, s.prefix+"-*.webm"))
+	if err != nil {
+		return
+	}
+	files := matches
+	sort.Strings(files)
+	for len(files) > s.keep {
+		if err := os.Remove(files[0]); err != nil {
+			s.logger.Warn("Failed to delete old file", "path", files[0], "error", err)
+		}
+		files = files[1:]
+	}
+}
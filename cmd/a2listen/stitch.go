@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/zamibd/a2web/internal/webm"
+	"github.com/zamibd/a2web/pkg/client"
+)
+
+// stitcher turns the epochs of a listener stream into one WebM stream. An
+// epoch starts with an init segment, sent again by the server whenever the
+// source or the listener reconnects.
+type stitcher struct {
+	out    sink
+	logger *slog.Logger
+
+	tracks  []byte
+	started bool // a cluster was written
+	offset  time.Duration
+
+	last     time.Duration // output time of the last block
+	frameDur time.Duration
+}
+
+// run stitches epochs until the listener ends.
+func (s *stitcher) run(l *client.ListenerStream) error {
+	er := &epochReader{l: l}
+	for {
+		if err := er.next(); err != nil {
+			if errors.Is(err, client.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if err := s.epoch(webm.NewReader(er)); err != nil {
+			return err
+		}
+	}
+}
+
+// epoch writes one epoch. Its first cluster decides the offset: a listener
+// that reconnected picks up the same timeline further on, while a source
+// that reconnected starts over at zero and is moved after what was written.
+func (s *stitcher) epoch(r *webm.Reader) error {
+	first := true
+	for {
+		c, err := r.Next()
+		switch {
+		case err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF):
+			// The epoch ended, possibly inside an element that is lost
+			return nil
+		case errors.Is(err, webm.ErrInvalid):
+			// Joined in the middle of an element; continue at the next cluster
+			if err := r.Resync(); err != nil {
+				return ignoreEOF(err)
+			}
+			continue
+		case err != nil:
+			return err
+		}
+
+		switch c.Kind {
+		case webm.KindInit:
+			if s.tracks == nil || !bytes.Equal(c.Tracks, s.tracks) {
+				if s.tracks != nil {
+					s.logger.Warn("Source changed its tracks; starting a new stream")
+				}
+				if err := s.out.WriteInit(c.Data); err != nil {
+					return err
+				}
+				s.tracks = c.Tracks
+				s.started = false
+			}
+		case webm.KindCluster:
+			if first {
+				switch {
+				case !s.started:
+					s.offset = -c.Time
+				case c.Time+s.offset <= s.last:
+					s.offset = s.last + s.frameDur - c.Time
+				}
+				first = false
+			}
+			s.started = true
+			if err := s.out.WriteCluster(c.Shift(s.offset)); err != nil {
+				return err
+			}
+		case webm.KindBlock:
+			c = c.Shift(s.offset)
+			if c.Time > s.last {
+				s.frameDur = c.Time - s.last
+			}
+			s.last = c.Time
+			if err := s.out.WriteBlock(c.Data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	return err
+}
+
+// epochReader reads the bytes of one epoch from a listener stream and
+// reports io.EOF where the next one begins.
+type epochReader struct {
+	l       *client.ListenerStream
+	epoch   int
+	pending *client.Chunk
+	buf     []byte
+}
+
+// next waits for the init segment of the next epoch.
+func (er *epochReader) next() error {
+	for er.pending == nil {
+		c, err := er.l.NextChunk(context.Background())
+		if err != nil {
+			return err
+		}
+		if c.Init {
+			er.pending = &c
+		}
+	}
+	er.epoch = er.pending.Epoch
+	er.buf = er.pending.Data
+	er.pending = nil
+	return nil
+}
+
+func (er *epochReader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.pending != nil {
+			return 0, io.EOF
+		}
+		c, err := er.l.NextChunk(context.Background())
+		if errors.Is(err, client.ErrClosed) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if c.Init && c.Epoch != er.epoch {
+			er.pending = &c
+			return 0, io.EOF
+		}
+		er.buf = c.Data
+	}
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/zamibd/a2web/internal/webm"
+)
+
+// el encodes an EBML element of known size; ids are given as on the wire.
+func el(id []byte, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	return append(append(append([]byte(nil), id...), 0x80|byte(len(data))), data...)
+}
+
+var (
+	unknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	idCluster   = []byte{0x1F, 0x43, 0xB6, 0x75}
+)
+
+// epochData is what a listener receives in one epoch: an init segment with
+// a track of codec, then a live cluster at each timecode (in milliseconds)
+// with blocks 20ms apart until the next one, and three blocks in the last.
+func epochData(codec string, timecodes ...int) []byte {
+	b := bytes.Join([][]byte{
+		el([]byte{0x1A, 0x45, 0xDF, 0xA3}, el([]byte{0x42, 0x82}, []byte("webm"))),
+		{0x18, 0x53, 0x80, 0x67}, unknownSize,
+		el([]byte{0x15, 0x49, 0xA9, 0x66}),
+		el([]byte{0x16, 0x54, 0xAE, 0x6B}, el([]byte{0xAE}, el([]byte{0xD7}, []byte{1}), el([]byte{0x86}, []byte(codec)))),
+	}, nil)
+	for i, tc := range timecodes {
+		b = append(append(b, idCluster...), unknownSize...)
+		b = append(b, el([]byte{0xE7}, binary.BigEndian.AppendUint32(nil, uint32(tc)))...)
+		n := 3
+		if i+1 < len(timecodes) {
+			n = (timecodes[i+1] - tc) / 20
+		}
+		for j := range n {
+			b = append(b, el([]byte{0xA3}, binary.BigEndian.AppendUint16([]byte{0x81}, uint16(20*j)), []byte{0x80, 0xFC})...)
+		}
+	}
+	return b
+}
+
+// recorder is a sink keeping what it is given.
+type recorder struct {
+	inits int
+	out   bytes.Buffer
+}
+
+func (r *recorder) WriteInit(init []byte) error {
+	r.inits++
+	r.out.Write(init)
+	return nil
+}
+func (r *recorder) WriteCluster(c webm.Chunk) error { r.out.Write(c.Data); return nil }
+func (r *recorder) WriteBlock(data []byte) error    { r.out.Write(data); return nil }
+func (r *recorder) Close() error                    { return nil }
+
+// streams returns the block times of each stream in the output.
+func (r *recorder) streams(t *testing.T) [][]time.Duration {
+	t.Helper()
+	var streams [][]time.Duration
+	wr := webm.NewReader(bytes.NewReader(r.out.Bytes()))
+	for {
+		c, err := wr.Next()
+		if err == io.EOF {
+			return streams
+		}
+		if err != nil {
+			t.Fatalf("reading the output: %v", err)
+		}
+		switch c.Kind {
+		case webm.KindInit:
+			streams = append(streams, nil)
+		case webm.KindBlock:
+			streams[len(streams)-1] = append(streams[len(streams)-1], c.Time)
+		}
+	}
+}
+
+func ms(times ...int) []time.Duration {
+	d := make([]time.Duration, len(times))
+	for i, t := range times {
+		d[i] = time.Duration(t) * time.Millisecond
+	}
+	return d
+}
+
+func TestStitch(t *testing.T) {
+	cut := epochData("A_OPUS", 5000)
+	cut = cut[:len(cut)-3]
+
+	tests := []struct {
+		name   string
+		epochs [][]byte
+		want   [][]time.Duration
+	}{
+		{
+			name:   "one epoch starts at zero",
+			epochs: [][]byte{epochData("A_OPUS", 5000, 5060)},
+			want:   [][]time.Duration{ms(0, 20, 40, 60, 80, 100)},
+		},
+		{
+			name:   "listener reconnected",
+			epochs: [][]byte{epochData("A_OPUS", 5000, 5060), epochData("A_OPUS", 5200, 5260)},
+			want:   [][]time.Duration{ms(0, 20, 40, 60, 80, 100, 200, 220, 240, 260, 280, 300)},
+		},
+		{
+			// The server replays the clusters of its buffer, some of which
+			// were written before the reconnect
+			name:   "listener reconnected into written clusters",
+			epochs: [][]byte{epochData("A_OPUS", 5000, 5060), epochData("A_OPUS", 5060, 5120)},
+			want:   [][]time.Duration{ms(0, 20, 40, 60, 80, 100, 120, 140, 160, 180, 200, 220)},
+		},
+		{
+			// A looping source that reconnected starts over at zero and is
+			// rebased after the last block
+			name:   "source restarted",
+			epochs: [][]byte{epochData("A_OPUS", 5000, 5060), epochData("A_OPUS", 0, 60)},
+			want:   [][]time.Duration{ms(0, 20, 40, 60, 80, 100, 120, 140, 160, 180, 200, 220)},
+		},
+		{
+			name: "source restarted twice",
+			epochs: [][]byte{epochData("A_OPUS", 5000), epochData("A_OPUS", 0, 60),
+				epochData("A_OPUS", 0)},
+			want: [][]time.Duration{ms(0, 20, 40, 60, 80, 100, 120, 140, 160, 180, 200, 220)},
+		},
+		{
+			name:   "epoch ended inside a block",
+			epochs: [][]byte{cut, epochData("A_OPUS", 0)},
+			want:   [][]time.Duration{ms(0, 20, 40, 60, 80)},
+		},
+		{
+			name:   "tracks changed",
+			epochs: [][]byte{epochData("A_OPUS", 5000), epochData("A_VORBIS", 7000, 7060)},
+			want:   [][]time.Duration{ms(0, 20, 40), ms(0, 20, 40, 60, 80, 100)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &recorder{}
+			s := &stitcher{out: out, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			for _, e := range tt.epochs {
+				if err := s.epoch(webm.NewReader(bytes.NewReader(e))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if out.inits != len(tt.want) {
+				t.Errorf("%d init segments written, want %d", out.inits, len(tt.want))
+			}
+			got := out.streams(t)
+			if len(got) != len(tt.want) {
+				t.Fatalf("block times %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				for j := 1; j < len(got[i]); j++ {
+					if got[i][j] <= got[i][j-1] {
+						t.Errorf("stream %d goes back from %v to %v", i, got[i][j-1], got[i][j])
+					}
+				}
+				if len(got[i]) != len(tt.want[i]) {
+					t.Fatalf("block times %v, want %v", got, tt.want)
+				}
+				for j := range got[i] {
+					if got[i][j] != tt.want[i][j] {
+						t.Fatalf("block times %v, want %v", got, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+// Command a2source broadcasts WebM/Opus audio into an a2web session without a
+// browser, for nursery devices such as a Raspberry Pi and for test rigs.
+//
+// It reads a file or stdin, paces the stream in real time and pushes it to
+// /ws/kid/{id}, reconnecting with backoff when the connection drops:
+//
+//	arecord -f S16_LE -r 48000 -c 1 | ffmpeg -i - -c:a libopus -f webm -live 1 - | a2source -server https://a2web.example.com -broadcast ID
+//	a2source -server http://localhost:8080 -broadcast ID -i test.webm -loop
+//
+// The broadcast ID is the last part of the session's kid link,
+// /kids/{id}. With a token (-token or A2WEB_TOKEN), which needs the
+// stream:ingest scope, the session ID works as well.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zamibd/a2web/internal/webm"
+	"github.com/zamibd/a2web/pkg/client"
+)
+
+type options struct {
+	server    string
+	broadcast string
+	token     string
+	input     string
+	loop      bool
+	pace      bool
+	interval  time.Duration
+}
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	slog.SetDefault(logger)
+
+	var o options
+	flag.StringVar(&o.server, "server", os.Getenv("A2WEB_SERVER"), "server URL, e.g. https://a2web.example.com (A2WEB_SERVER)")
+	flag.StringVar(&o.broadcast, "broadcast", "", "broadcast ID, the last part of the session's kid link /kids/{id}; with -token the session ID works too")
+	flag.StringVar(&o.broadcast, "session", "", "deprecated name of -broadcast")
+	flag.StringVar(&o.token, "token", os.Getenv("A2WEB_TOKEN"), "personal API token with stream:ingest (A2WEB_TOKEN); the kid link is used without one")
+	flag.StringVar(&o.input, "i", "-", "WebM/Opus file to read, or - for stdin")
+	flag.BoolVar(&o.loop, "loop", false, "start the file over at its end, for a never-ending test source")
+	flag.BoolVar(&o.pace, "pace", true, "send in real time; disable for input that is already live")
+	flag.DurationVar(&o.interval, "interval", 300*time.Millisecond, "audio per message, like the kid page's recorder timeslice")
+	flag.Parse()
+
+	if o.server == "" || o.broadcast == "" {
+		fmt.Fprintln(os.Stderr, "usage: a2source -server URL -broadcast ID [-token TOKEN] [-i FILE|-] [-loop]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if o.loop && o.input == "-" {
+		fmt.Fprintln(os.Stderr, "a2source: -loop needs a file, not stdin")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, o, logger); err != nil && !errors.Is(err, context.Canceled) {
+		logger.Error("Broadcast failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, o options, logger *slog.Logger) error {
+	var opts []client.Option
+	if o.token != "" {
+		opts = append(opts, client.WithToken(o.token))
+	}
+	c, err := client.New(o.server, opts...)
+	if err != nil {
+		return err
+	}
+
+	src, err := c.Source(ctx, o.broadcast, &client.StreamOptions{
+		OnConnect: func(n int) {
+			if n > 1 {
+				logger.Info("Reconnected", "broadcast_id", o.broadcast, "connection", n)
+			}
+		},
+		OnDisconnect: func(err error) {
+			logger.Warn("Connection lost, reconnecting", "broadcast_id", o.broadcast, "error", err)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	logger.Info("Connected", "broadcast_id", o.broadcast, "input", o.input)
+
+	go func() {
+		for msg := range src.Control() {
+			switch msg.Type {
+			case client.MsgMute, client.MsgUnmute:
+				logger.Info("Parent changed mute", "muted", msg.Type == client.MsgMute)
+			case client.MsgSOSAck:
+				logger.Info("SOS acknowledged by a parent")
+			case client.MsgStop:
+				logger.Info("Stopped by a parent")
+			}
+		}
+	}()
+
+	b := &broadcaster{src: src, opts: o}
+	for pass := 0; ; pass++ {
+		in, err := open(o.input)
+		if err != nil {
+			return err
+		}
+		err = b.stream(ctx, webm.NewReader(in))
+		in.Close()
+		if errors.Is(err, client.ErrStopped) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !o.loop {
+			break
+		}
+		logger.Debug("Looping input", "pass", pass+1)
+	}
+	if err := b.flush(ctx); err != nil {
+		return err
+	}
+	logger.Info("Input ended", "broadcast_id", o.broadcast)
+	return nil
+}
+
+func open(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// broadcaster sends WebM chunks in batches of about one interval, keeping a
+// single timeline across loops and concatenated inputs.
+type broadcaster struct {
+	src  *client.SourceStream
+	opts options
+
+	tracks []byte // of the init segment that was sent
+	offset time.Duration
+	rebase bool // the next cluster starts a new input on the old timeline
+
+	last     time.Duration // time of the last block
+	frameDur time.Duration // distance between the last two blocks
+
+	batch      bytes.Buffer
+	batchStart time.Duration
+	batchEnd   time.Duration
+	hasMedia   bool
+
+	started bool
+	wall    time.Time
+	origin  time.Duration
+}
+
+// stream sends one input.
+func (b *broadcaster) stream(ctx context.Context, r *webm.Reader) error {
+	for {
+		c, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch c.Kind {
+		case webm.KindInit:
+			if b.tracks == nil {
+				b.tracks = c.Tracks
+				if _, err := b.src.Write(c.Data); err != nil {
+					return err
+				}
+				continue
+			}
+			// Listeners and the recording keep the first init segment, so
+			// later inputs must carry the same tracks
+			if !bytes.Equal(c.Tracks, b.tracks) {
+				return fmt.Errorf("input changed its tracks; restart a2source to broadcast it")
+			}
+			b.rebase = true
+		case webm.KindCluster:
+			if b.rebase {
+				b.offset = b.last + b.frameDur - c.Time
+				b.rebase = false
+			}
+			c = c.Shift(b.offset)
+			if err := b.add(ctx, c); err != nil {
+				return err
+			}
+		case webm.KindBlock:
+			c = c.Shift(b.offset)
+			if c.Time > b.last {
+				b.frameDur = c.Time - b.last
+			}
+			b.last = c.Time
+			// A muted source sends silence on the kid page; without an
+			// encoder the closest is leaving the audio out
+			if b.src.Muted() {
+				continue
+			}
+			if err := b.add(ctx, c); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// add queues a chunk and sends the batch once it spans an interval.
+func (b *broadcaster) add(ctx context.Context, c webm.Chunk) error {
+	if !b.hasMedia && c.Kind == webm.KindBlock {
+		b.batchStart = c.Time
+		b.hasMedia = true
+	}
+	if c.Kind == webm.KindBlock {
+		b.batchEnd = c.Time
+	}
+	b.batch.Write(c.Data)
+	if b.hasMedia && b.batchEnd-b.batchStart >= b.opts.interval {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// flush sends the batch once its last block is due, as a recorder would.
+func (b *broadcaster) flush(ctx context.Context) error {
+	if b.batch.Len() == 0 {
+		return nil
+	}
+	if b.opts.pace && b.hasMedia {
+		if !b.started {
+			b.started, b.wall, b.origin = true, time.Now(), b.batchStart
+		}
+		due := b.wall.Add(b.batchEnd - b.origin)
+		if d := time.Until(due); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		}
+	}
+	if _, err := b.src.Write(b.batch.Bytes()); err != nil {
+		return err
+	}
+	b.batch.Reset()
+	b.hasMedia = false
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errDatabaseInUse is returned by lockDatabase while another process holds
+// the lock.
+var errDatabaseInUse = errors.New("the database is in use by a running server or restore")
+
+// lockDatabase takes the lock file next to the SQLite database at path,
+// which the server holds while it runs and restore holds while it replaces
+// the database. The lock is released when the file is closed or the
+// process exits, however it ends. The file records the holder's PID.
+func lockDatabase(path string) (*os.File, error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+	return f, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// tryLock does not lock on systems without flock; restore relies on the
+// operator having stopped the server there.
+func tryLock(f *os.File) error { return nil }
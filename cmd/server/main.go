@@ -1,154 +1,174 @@
+// Command server runs the a2web web server and its maintenance tasks. Every
+// command reads the same configuration (environment and .env) and opens the
+// same database:
+//
+//	server [serve]                                run the web server
+//	server migrate [-status] [-dry-run]           bring the database schema up to date
+//	server create-admin -mobile M                 create an admin account
+//	server set-role -mobile M -role admin|user    change a user's role
+//	server reset-password -mobile M               set a new password and sign the user out
+//	server backup [-o FILE] [-list]               archive a consistent copy of the database
+//	server restore -i FILE|-name A|-at TIME       replace the database with a validated backup
+//	server verify-storage [-fix]                  compare recordings with the files on disk
+//	server verify-audit                           check the audit log's hash chain
+//	server rotate-keys                            generate new Web Push (VAPID) keys
+//
+// Passwords are read from A2WEB_PASSWORD or, when unset, from the first
+// line of standard input. Commands exit with 0 on success, 1 when they fail
+// and 2 on bad usage.
 package main
 
 import (
-	"context"
-	"html/template"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 
 	"github.com/zamibd/a2web/internal/config"
 	"github.com/zamibd/a2web/internal/database"
-	"github.com/zamibd/a2web/internal/handlers"
-	"github.com/zamibd/a2web/internal/middleware"
-	"golang.org/x/time/rate"
+	"github.com/zamibd/a2web/internal/store"
+	"github.com/zamibd/a2web/internal/store/sqlstore"
 )
 
-func main() {
-	// 1. Initialize Logger
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	slog.SetDefault(logger)
-
-	// 2. Initialize Database
-	if err := os.MkdirAll("./storage", 0755); err != nil {
-		logger.Error("Failed to create storage directory", "error", err)
-		os.Exit(1)
-	}
-	database.InitDB(config.AppConfig.DBPath)
-	logger.Info("Database initialized", "path", config.AppConfig.DBPath)
+type command struct {
+	name    string
+	summary string
+	run     func(logger *slog.Logger, args []string) int
+}
 
-	// 3. Parse Templates
-	// Parse layout first
-	layoutTmpl, err := template.ParseFiles("web/templates/layout.html")
-	if err != nil {
-		logger.Error("Failed to parse layout", "error", err)
-		os.Exit(1)
-	}
+var commands = []command{
+	{"serve", "run the web server (default)", serve},
+	{"migrate", "bring the database schema up to date", migrate},
+	{"create-admin", "create an admin account", createAdmin},
+	{"set-role", "change a user's role", setRole},
+	{"reset-password", "set a new password and sign the user out", resetPassword},
+	{"backup", "archive a consistent copy of the database", backupDatabase},
+	{"restore", "replace the database with a backup; stop the server first", restore},
+	{"verify-storage", "compare recordings with the files on disk", verifyStorage},
+	{"verify-audit", "check the audit log's hash chain", verifyAudit},
+	{"rotate-keys", "generate new Web Push (VAPID) keys", rotateKeys},
+}
 
-	// Define pages to pre-build
-	pages := []string{
-		"login.html", "register.html", "dashboard.html",
-		"kids.html", "parent.html", "admin.html",
+func main() {
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	templateMap := make(map[string]*template.Template)
-
-	for _, page := range pages {
-		// Clone the layout for each page to prevent namespace pollution
-		clone, err := layoutTmpl.Clone()
-		if err != nil {
-			logger.Error("Failed to clone layout", "page", page, "error", err)
-			os.Exit(1)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
 		}
-
-		// Parse the specific page file into the clone
-		_, err = clone.ParseFiles("web/templates/" + page)
-		if err != nil {
-			logger.Error("Failed to parse page template", "page", page, "error", err)
-			os.Exit(1)
+	}
+	if cmd == nil {
+		if name == "help" {
+			usage()
+			os.Exit(0)
 		}
-
-		templateMap[page] = clone
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
 	}
 
-	// 4. Initialize Handlers
-	h := handlers.New(logger, templateMap)
-
-	// 5. Setup Router & Middleware
-	mux := http.NewServeMux()
-	mw := middleware.New(logger)
-
-	// Admin Routes
-	mux.HandleFunc("/admin", h.AdminMiddleware(h.AdminDashboardHandler))
-	mux.HandleFunc("/admin/user/delete", h.AdminMiddleware(h.DeleteUserHandler))
-	mux.HandleFunc("/admin/session/delete", h.AdminMiddleware(h.DeleteSessionHandler))
-
-	// Public Routes (Auth)
-	// Apply rate limiting to login
-	loginLimiter := mw.RateLimit(rate.Every(1*time.Minute/5), 5) // 5 requests per minute
-	mux.Handle("/login", loginLimiter(http.HandlerFunc(h.LoginHandler)))
-	mux.HandleFunc("/register", h.RegisterHandler)
-	mux.HandleFunc("/logout", h.LogoutHandler)
-
-	// Protected Routes
-	mux.HandleFunc("/dashboard", handlers.AuthMiddleware(h.DashboardHandler))
-	mux.HandleFunc("/session/create", handlers.AuthMiddleware(h.CreateSessionHandler))
-	mux.HandleFunc("/user/", handlers.AuthMiddleware(h.ParentPageHandler))
-
-	// Public Routes (Pages)
-	mux.HandleFunc("/login-page", h.LoginPageHandler)
-	mux.HandleFunc("/register-page", h.RegisterPageHandler)
-	mux.HandleFunc("/kids/", h.KidsPageHandler)
-
-	// Static Files (CSS/JS)
-	fs := http.FileServer(http.Dir("web/static"))
-	mux.Handle("/static/", http.StripPrefix("/static/", fs))
-
-	// WebSocket Routes
-	mux.HandleFunc("/ws/kid/", h.KidWSHandler)       // Public, maybe protect with simple token later?
-	mux.HandleFunc("/ws/parent/", h.ParentWSHandler) // Protected by cookie check inside
-
-	// Health Check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
-
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Audio Streamer Backend Running"))
-	})
-
-	// Wrap mux with global logging middleware
-	finalHandler := mw.Logging(mux)
-
-	srv := &http.Server{
-		Addr:    ":" + config.AppConfig.Port,
-		Handler: finalHandler,
+	// 1. Initialize Logger
+	// The server logs JSON for log collectors; maintenance commands log
+	// text to stderr, keeping stdout for their results
+	var logger *slog.Logger
+	if cmd.name == "serve" {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	} else {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
 	}
+	slog.SetDefault(logger)
 
-	// Channel to listen for errors coming from the listener.
-	serverErrors := make(chan error, 1)
+	os.Exit(cmd.run(logger, args))
+}
 
-	go func() {
-		logger.Info("Server started", "port", config.AppConfig.Port)
-		serverErrors <- srv.ListenAndServe()
-	}()
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: server [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun \"server <command> -h\" for the flags of a command.")
+}
 
-	// Channel to listen for an interrupt or terminate signal from the OS.
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+// newFlagSet returns the flag set of a command. args describes its
+// arguments in the usage message.
+func newFlagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace("usage: server "+name+" "+args))
+		flags.PrintDefaults()
+	}
+	return flags
+}
 
-	// Blocking main and waiting for shutdown.
-	select {
-	case err := <-serverErrors:
-		logger.Error("Error starting server", "error", err)
+// parseFlags parses a command's flags. When the command must not run it
+// returns false with the exit status: 0 after -h, 2 on bad usage.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, false
+		}
+		return 2, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected argument %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2, false
+	}
+	return 0, true
+}
 
-	case <-shutdown:
-		logger.Info("Starting shutdown...")
+// openDatabase creates the storage directory, opens the database and
+// applies pending migrations. It refuses a database migrated by a newer
+// release.
+func openDatabase(logger *slog.Logger) bool {
+	// 2. Initialize Database
+	if !connectDatabase(logger) {
+		return false
+	}
+	applied, err := database.Migrate()
+	if err != nil {
+		logger.Error("Failed to migrate database", "error", err)
+		return false
+	}
+	for _, m := range applied {
+		logger.Info("Applied migration", "version", m.Version, "name", m.Name)
+	}
+	logger.Info("Database initialized", "driver", database.DB.Dialect, "schema_version", database.LatestVersion(database.DB.Dialect))
+	return true
+}
 
-		// Give outstanding requests a deadline for completion.
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+// st is the store of the database opened by connectDatabase.
+var st *store.Store
 
-		// Asking listener to shut down and shed load.
-		if err := srv.Shutdown(ctx); err != nil {
-			logger.Error("Graceful shutdown did not complete", "error", err)
-			if err := srv.Close(); err != nil {
-				logger.Error("Could not stop http server", "error", err)
-			}
+// connectDatabase creates the storage directory and opens the database
+// chosen by DB_DRIVER without migrating it.
+func connectDatabase(logger *slog.Logger) bool {
+	if err := os.MkdirAll("./storage", 0755); err != nil {
+		logger.Error("Failed to create storage directory", "error", err)
+		return false
+	}
+	dialect := database.Dialect(config.AppConfig.DBDriver)
+	dsn := config.AppConfig.DBPath
+	if dialect == database.Postgres {
+		dsn = config.AppConfig.DatabaseURL
+	}
+	if err := database.Open(dialect, dsn); err != nil {
+		// The PostgreSQL URL may carry a password, so only SQLite paths are logged
+		if dialect == database.Postgres {
+			logger.Error("Failed to open database", "driver", dialect, "error", err)
+		} else {
+			logger.Error("Failed to open database", "driver", dialect, "path", dsn, "error", err)
 		}
+		return false
 	}
+	st = sqlstore.New(database.DB)
+	return true
 }
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/backup"
+	"github.com/zamibd/a2web/internal/config"
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/recordings"
+	"github.com/zamibd/a2web/internal/webpush"
+)
+
+// migrate applies pending schema migrations. With -status it lists them
+// and with -dry-run it tries them in a transaction that is rolled back;
+// neither changes the database.
+func migrate(logger *slog.Logger, args []string) int {
+	flags := newFlagSet("migrate", "[-status] [-dry-run]")
+	status := flags.Bool("status", false, "list applied and pending migrations")
+	dryRun := flags.Bool("dry-run", false, "check that pending migrations apply, without applying them")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if !connectDatabase(logger) {
+		return 1
+	}
+
+	if *status {
+		list, err := database.Status()
+		if err != nil {
+			logger.Error("Failed to read migrations", "error", err)
+			return 1
+		}
+		newer := false
+		for _, s := range list {
+			switch {
+			case s.Unknown:
+				newer = true
+				fmt.Printf("unknown\t%04d_%s\t%s\n", s.Version, s.Name, s.AppliedAt.Local().Format(time.DateTime))
+			case s.Applied:
+				fmt.Printf("applied\t%04d_%s\t%s\n", s.Version, s.Name, s.AppliedAt.Local().Format(time.DateTime))
+			default:
+				fmt.Printf("pending\t%04d_%s\n", s.Version, s.Name)
+			}
+		}
+		if newer {
+			fmt.Println("The database was migrated by a newer release; this server will not start")
+			return 1
+		}
+		return 0
+	}
+
+	run, verb := database.Migrate, "Applied"
+	if *dryRun {
+		run, verb = database.DryRun, "Would apply"
+	}
+	list, err := run()
+	if err != nil {
+		logger.Error("Migration failed", "error", err)
+		return 1
+	}
+	for _, m := range list {
+		fmt.Printf("%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+	if len(list) == 0 {
+		fmt.Printf("Database schema is up to date (version %d)\n", database.LatestVersion(database.DB.Dialect))
+	}
+	return 0
+}
+
+// sqliteOnly refuses commands that work on the SQLite file when the server
+// is configured for PostgreSQL, whose own tools do the job.
+func sqliteOnly(logger *slog.Logger, command string) bool {
+	if database.Dialect(config.AppConfig.DBDriver) == database.Postgres {
+		logger.Error(command + " only works with SQLite; use pg_dump and pg_restore for PostgreSQL")
+		return false
+	}
+	return true
+}
+
+// backupStorage returns the storage configured for backup archives.
+func backupStorage() (backup.Storage, error) {
+	c := config.AppConfig
+	switch c.BackupStorage {
+	case "", "dir":
+		return &backup.Dir{Path: c.BackupDir}, nil
+	case "s3":
+		if c.BackupS3Endpoint == "" || c.BackupS3Bucket == "" {
+			return nil, errors.New("BACKUP_S3_ENDPOINT and BACKUP_S3_BUCKET are required with BACKUP_STORAGE=s3")
+		}
+		return backup.NewS3(c.BackupS3Endpoint, c.BackupS3Region, c.BackupS3Bucket, c.BackupS3Prefix,
+			c.BackupS3AccessKey, c.BackupS3SecretKey), nil
+	}
+	return nil, fmt.Errorf("unknown BACKUP_STORAGE %q; use dir or s3", c.BackupStorage)
+}
+
+// backupRotation returns the configured rotation of backup archives.
+func backupRotation() backup.Rotation {
+	return backup.Rotation{Keep: config.AppConfig.BackupKeep, MaxAge: config.AppConfig.BackupMaxAge}
+}
+
+// backupDatabase archives a consistent copy of the database, taken with VACUUM INTO
+// while the server keeps running, into the configured storage and prunes
+// the archives the rotation does not keep. Recordings are files under
+// ./storage/recordings and are copied separately; the archive's manifest
+// lists them.
+func backupDatabase(logger *slog.Logger, args []string) int {
+	flags := newFlagSet("backup", "[-o FILE] [-list]")
+	out := flags.String("o", "", "write the archive to this file instead of the configured storage, without pruning")
+	list := flags.Bool("list", false, "list the archives in the configured storage")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if !sqliteOnly(logger, "backup") {
+		return 1
+	}
+	st, err := backupStorage()
+	if err != nil {
+		logger.Error("Invalid backup storage", "error", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	if *list {
+		archives, err := backup.List(ctx, st)
+		if err != nil {
+			logger.Error("Failed to list backups", "error", err)
+			return 1
+		}
+		for _, a := range archives {
+			fmt.Printf("%s\t%s\t%d\n", a.Name, a.Time.Local().Format(time.DateTime), a.Bytes)
+		}
+		return 0
+	}
+
+	// Brings the database up to date first, so the archive is of a schema
+	// this release can restore
+	if !openDatabase(logger) {
+		return 1
+	}
+	database.DB.Close()
+
+	opts := backup.Options{Recordings: config.AppConfig.BackupRecordings, Now: time.Now()}
+	if *out != "" {
+		if _, err := os.Stat(*out); err == nil {
+			logger.Error("Backup file already exists", "path", *out)
+			return 1
+		}
+		st = &backup.Dir{Path: filepath.Dir(*out)}
+		opts.Name = filepath.Base(*out)
+	}
+	name, m, err := backup.Create(ctx, config.AppConfig.DBPath, st, opts)
+	if err != nil {
+		logger.Error("Backup failed", "error", err)
+		return 1
+	}
+	if *out != "" {
+		fmt.Println(*out)
+		return 0
+	}
+	fmt.Printf("%s (%d bytes of database, %d recordings listed)\n", name, m.Database.Bytes, len(m.Recordings))
+
+	removed, err := backup.Prune(ctx, st, backupRotation(), opts.Now)
+	for _, name := range removed {
+		fmt.Printf("Removed %s\n", name)
+	}
+	if err != nil {
+		logger.Error("Failed to prune backups", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// restore replaces the database with a backup: an archive from the
+// configured storage, picked by name or as the newest taken at or before a
+// time, or a local archive or database file. The archive is extracted next
+// to the database and validated, its checksum, integrity and schema
+// version, before it is swapped in. The database it replaces is kept next
+// to it with a .pre-restore suffix. It refuses to run while the server
+// holds the database's lock file.
+func restore(logger *slog.Logger, args []string) int {
+	flags := newFlagSet("restore", "-i FILE | -name ARCHIVE | -at TIME [-dry-run]")
+	in := flags.String("i", "", "local archive, or database file written by older releases")
+	name := flags.String("name", "", "archive in the configured storage")
+	at := flags.String("at", "", `restore the newest archive in the configured storage taken at or before this time, e.g. "2026-01-02 15:04"`)
+	dryRun := flags.Bool("dry-run", false, "validate the backup without restoring it")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	sources := 0
+	for _, s := range []string{*in, *name, *at} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		flags.Usage()
+		return 2
+	}
+	if !sqliteOnly(logger, "restore") {
+		return 1
+	}
+
+	ctx := context.Background()
+	var (
+		src    io.ReadCloser
+		source = *in
+	)
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			logger.Error("Failed to open backup", "error", err)
+			return 1
+		}
+		src = f
+	} else {
+		st, err := backupStorage()
+		if err != nil {
+			logger.Error("Invalid backup storage", "error", err)
+			return 1
+		}
+		if *at != "" {
+			t, err := parseTime(*at)
+			if err != nil {
+				logger.Error("Invalid time", "at", *at, "error", err)
+				return 2
+			}
+			a, err := backup.Latest(ctx, st, t)
+			if err != nil {
+				logger.Error("No backup to restore", "error", err)
+				return 1
+			}
+			*name = a.Name
+		}
+		if src, err = st.Open(ctx, *name); err != nil {
+			logger.Error("Failed to open backup", "archive", *name, "error", err)
+			return 1
+		}
+		source = *name
+	}
+	defer src.Close()
+
+	dbPath := config.AppConfig.DBPath
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		logger.Error("Failed to create database directory", "error", err)
+		return 1
+	}
+	// Extracted next to the database, so it can be renamed into place and
+	// the database is never half written
+	tmp, err := os.MkdirTemp(filepath.Dir(dbPath), ".restore-")
+	if err != nil {
+		logger.Error("Failed to create restore directory", "error", err)
+		return 1
+	}
+	defer os.RemoveAll(tmp)
+
+	r := bufio.NewReader(src)
+	var (
+		manifest *backup.Manifest
+		restored string
+	)
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		m, path, err := backup.Extract(r, tmp)
+		if err != nil {
+			logger.Error("Refusing to restore", "backup", source, "error", err)
+			return 1
+		}
+		manifest, restored = &m, path
+	} else {
+		restored = filepath.Join(tmp, "restore.db")
+		if err := writeFile(restored, r); err != nil {
+			logger.Error("Failed to copy backup", "error", err)
+			return 1
+		}
+		if _, err := backup.Check(restored); err != nil {
+			logger.Error("Refusing to restore", "backup", source, "error", err)
+			return 1
+		}
+	}
+
+	missing := 0
+	if manifest != nil {
+		for _, rec := range manifest.Recordings {
+			if _, err := os.Stat(rec.Path); err != nil {
+				missing++
+			}
+		}
+	}
+	if *dryRun {
+		fmt.Printf("%s is valid\n", source)
+		if manifest != nil {
+			fmt.Printf("Taken %s at schema version %d, %d recordings listed, %d missing on disk\n",
+				manifest.CreatedAt.Local().Format(time.DateTime), manifest.SchemaVersion, len(manifest.Recordings), missing)
+		}
+		return 0
+	}
+
+	lock, err := lockDatabase(dbPath)
+	if errors.Is(err, errDatabaseInUse) {
+		fmt.Fprintf(os.Stderr, "%s is in use by a running server; stop the server first, then run restore again\n", dbPath)
+		return 1
+	}
+	if err != nil {
+		logger.Error("Failed to lock the database", "error", err)
+		return 1
+	}
+	defer lock.Close()
+
+	if _, err := os.Stat(dbPath); err == nil {
+		// Folds the journal of the current database into it first, so the
+		// copy kept aside is complete
+		if err := checkpoint(dbPath); err != nil {
+			logger.Error("Failed to checkpoint the current database", "error", err)
+			return 1
+		}
+		if err := copyFile(dbPath, dbPath+".pre-restore"); err != nil {
+			logger.Error("Failed to keep the current database", "error", err)
+			return 1
+		}
+	}
+	// A journal left by the replaced database must not be applied to the
+	// restored one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Error("Failed to remove journal", "path", dbPath+suffix, "error", err)
+			return 1
+		}
+	}
+	if err := os.Rename(restored, dbPath); err != nil {
+		logger.Error("Failed to replace database", "error", err)
+		return 1
+	}
+	fmt.Printf("Restored %s from %s\n", dbPath, source)
+	if missing > 0 {
+		fmt.Printf("%d of the backup's recordings are missing on disk; run verify-storage -fix to drop them\n", missing)
+	}
+	return 0
+}
+
+// parseTime reads a time given on the command line, in local time unless
+// it says otherwise.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			if layout == time.DateOnly {
+				// The whole day
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("use RFC 3339 or YYYY-MM-DD [HH:MM[:SS]]")
+}
+
+// checkpoint copies the write-ahead log of a stopped server's database
+// into the database file and empties it.
+func checkpoint(path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dst, in)
+}
+
+func writeFile(dst string, r io.Reader) error {
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func verifyStorage(logger *slog.Logger, args []string) int {
+	flags := newFlagSet("verify-storage", "[-fix]")
+	fix := flags.Bool("fix", false, "delete rows of missing files and files without rows, and correct sizes")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if !openDatabase(logger) {
+		return 1
+	}
+
+	problems, err := recordings.Check(context.Background(), st.Recordings)
+	if err != nil {
+		logger.Error("Failed to check storage", "error", err)
+		return 1
+	}
+	failed := 0
+	for _, p := range problems {
+		switch p.Kind {
+		case recordings.ProblemMissing:
+			fmt.Printf("%s\trecording %d\t%s\n", p.Kind, p.RecordingID, p.Path)
+		case recordings.ProblemSize:
+			fmt.Printf("%s\trecording %d\t%s\t%d bytes recorded, %d on disk\n", p.Kind, p.RecordingID, p.Path, p.Bytes, p.FileBytes)
+		case recordings.ProblemOrphan:
+			fmt.Printf("%s\t\t%s\t%d bytes\n", p.Kind, p.Path, p.FileBytes)
+		}
+		if *fix {
+			if err := recordings.Repair(context.Background(), st.Recordings, p); err != nil {
+				logger.Error("Failed to repair", "kind", p.Kind, "path", p.Path, "error", err)
+				failed++
+			}
+		}
+	}
+
+	switch {
+	case len(problems) == 0:
+		fmt.Println("Storage is consistent")
+		return 0
+	case *fix && failed == 0:
+		fmt.Printf("Repaired %d problems\n", len(problems))
+		return 0
+	case *fix:
+		fmt.Printf("Repaired %d of %d problems\n", len(problems)-failed, len(problems))
+		return 1
+	default:
+		fmt.Printf("Found %d problems; run with -fix to repair them\n", len(problems))
+		return 1
+	}
+}
+
+// verifyAudit exits with 0 when the audit log's hash chain is intact and
+// 1 when it is broken.
+func verifyAudit(logger *slog.Logger, args []string) int {
+	if code, ok := parseFlags(newFlagSet("verify-audit", ""), args); !ok {
+		return code
+	}
+	if !openDatabase(logger) {
+		return 1
+	}
+
+	res, err := audit.Verify(context.Background(), st.Audit)
+	if err != nil {
+		logger.Error("Failed to read audit log", "error", err)
+		return 1
+	}
+	if !res.OK() {
+		fmt.Printf("Audit log chain broken at event %d: %s\n", res.BrokenID, res.Reason)
+		return 1
+	}
+	fmt.Printf("Audit log chain intact: %d events, head %s\n", res.Events, res.Head)
+	return 0
+}
+
+// rotateKeys replaces the VAPID key pair in VAPID_KEY_FILE. Push
+// subscriptions are bound to the old public key, so they are deleted;
+// dashboards subscribe again with the new key on their next visit. Restart
+// the server to use the new keys.
+func rotateKeys(logger *slog.Logger, args []string) int {
+	if code, ok := parseFlags(newFlagSet("rotate-keys", ""), args); !ok {
+		return code
+	}
+	if config.AppConfig.VAPIDPrivateKey != "" {
+		logger.Error("VAPID keys are set by VAPID_PRIVATE_KEY; generate new ones and update the environment instead")
+		return 1
+	}
+	if !openDatabase(logger) {
+		return 1
+	}
+
+	keys, err := webpush.GenerateVAPIDKeys(config.AppConfig.VAPIDKeyFile)
+	if err != nil {
+		logger.Error("Failed to generate VAPID keys", "error", err)
+		return 1
+	}
+	n, err := st.Devices.DeleteAll(context.Background())
+	if err != nil {
+		logger.Error("Failed to delete push subscriptions", "error", err)
+		return 1
+	}
+	fmt.Printf("New VAPID public key %s written to %s; %d push subscriptions removed. Restart the server.\n",
+		keys.PublicKey, config.AppConfig.VAPIDKeyFile, n)
+	return 0
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/backup"
+	"github.com/zamibd/a2web/internal/config"
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/handlers"
+	"github.com/zamibd/a2web/internal/middleware"
+	"github.com/zamibd/a2web/internal/mqttbridge"
+	"github.com/zamibd/a2web/internal/notify"
+	"github.com/zamibd/a2web/internal/recordings"
+	"github.com/zamibd/a2web/internal/webhooks"
+	"github.com/zamibd/a2web/internal/webpush"
+)
+
+// serve runs the web server until it is interrupted.
+func serve(logger *slog.Logger, args []string) int {
+	if code, ok := parseFlags(newFlagSet("serve", ""), args); !ok {
+		return code
+	}
+	if database.Dialect(config.AppConfig.DBDriver) != database.Postgres {
+		lock, err := lockDatabase(config.AppConfig.DBPath)
+		if err != nil {
+			logger.Error("Failed to lock the database", "path", config.AppConfig.DBPath, "error", err)
+			return 1
+		}
+		defer lock.Close()
+	}
+	if !openDatabase(logger) {
+		return 1
+	}
+	// Only the server bounds its queries; maintenance commands may take
+	// as long as they need
+	database.DB.Timeout = config.AppConfig.DBQueryTimeout
+
+	if n, err := recordings.FinalizeInterrupted(context.Background(), st.Recordings); err != nil {
+		logger.Error("Failed to finalize interrupted recordings", "error", err)
+	} else if n > 0 {
+		logger.Info("Finalized interrupted recordings", "count", n)
+	}
+
+	// 3. Parse Templates
+	// Parse layout first
+	layoutTmpl, err := template.ParseFiles("web/templates/layout.html")
+	if err != nil {
+		logger.Error("Failed to parse layout", "error", err)
+		return 1
+	}
+
+	// Define pages to pre-build
+	pages := []string{
+		"login.html", "register.html", "dashboard.html",
+		"kids.html", "parent.html", "admin.html", "invite.html",
+	}
+
+	templateMap := make(map[string]*template.Template)
+
+	for _, page := range pages {
+		// Clone the layout for each page to prevent namespace pollution
+		clone, err := layoutTmpl.Clone()
+		if err != nil {
+			logger.Error("Failed to clone layout", "page", page, "error", err)
+			return 1
+		}
+
+		// Parse the specific page file into the clone
+		_, err = clone.ParseFiles("web/templates/" + page)
+		if err != nil {
+			logger.Error("Failed to parse page template", "page", page, "error", err)
+			return 1
+		}
+
+		templateMap[page] = clone
+	}
+
+	// 4. Start background workers
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	alertEngine := alerts.NewEngine(logger, handlers.GlobalHub.Events, alerts.NewStore(database.DB))
+	handlers.GlobalHub.AddObserver(alertEngine)
+	go alertEngine.Run(ctx)
+
+	var vapidKeys *webpush.VAPIDKeys
+	if config.AppConfig.VAPIDPrivateKey != "" {
+		vapidKeys, err = webpush.ParseVAPIDKeys(config.AppConfig.VAPIDPublicKey, config.AppConfig.VAPIDPrivateKey)
+	} else {
+		vapidKeys, err = webpush.LoadOrCreateVAPIDKeys(config.AppConfig.VAPIDKeyFile)
+	}
+	if err != nil {
+		logger.Error("Failed to load VAPID keys", "error", err)
+		return 1
+	}
+	pushWorker, err := webpush.NewWorker(logger, handlers.GlobalHub.Events, database.DB, vapidKeys,
+		config.AppConfig.VAPIDSubject, config.AppConfig.WebPushEndpointOverride)
+	if err != nil {
+		logger.Error("Failed to start push worker", "error", err)
+		return 1
+	}
+	go pushWorker.Run(ctx)
+
+	webhookDispatcher := webhooks.NewDispatcher(logger, handlers.GlobalHub.Events, webhooks.NewStore(database.DB), config.AppConfig.WebhookAllowPrivate)
+	go webhookDispatcher.Run(ctx)
+
+	var channels []notify.Channel
+	if config.AppConfig.SMTPHost != "" {
+		channels = append(channels, &notify.SMTP{
+			Host:     config.AppConfig.SMTPHost,
+			Port:     config.AppConfig.SMTPPort,
+			Username: config.AppConfig.SMTPUsername,
+			Password: config.AppConfig.SMTPPassword,
+			From:     config.AppConfig.SMTPFrom,
+		})
+	}
+	if config.AppConfig.TelegramBotToken != "" {
+		channels = append(channels, notify.NewTelegram(config.AppConfig.TelegramAPIURL, config.AppConfig.TelegramBotToken))
+	}
+	notifier := notify.NewNotifier(logger, handlers.GlobalHub.Events, notify.NewStore(database.DB), config.AppConfig.PublicURL, channels...)
+	go notifier.Run(ctx)
+
+	if config.AppConfig.MQTTBroker != "" {
+		bridge := mqttbridge.New(logger, handlers.GlobalHub.Events, &handlers.GlobalHub, st.Sessions, mqttbridge.Config{
+			Broker:          config.AppConfig.MQTTBroker,
+			ClientID:        config.AppConfig.MQTTClientID,
+			Username:        config.AppConfig.MQTTUsername,
+			Password:        config.AppConfig.MQTTPassword,
+			TopicPrefix:     config.AppConfig.MQTTTopicPrefix,
+			DiscoveryPrefix: config.AppConfig.MQTTDiscoveryPrefix,
+		})
+		go bridge.Run(ctx)
+	}
+
+	if config.AppConfig.BackupInterval > 0 {
+		backups, err := backupStorage()
+		switch {
+		case database.DB.Dialect != database.SQLite:
+			logger.Warn("BACKUP_INTERVAL only applies to SQLite; back up PostgreSQL with pg_dump")
+		case err != nil:
+			logger.Error("Invalid backup storage", "error", err)
+			return 1
+		default:
+			scheduler := &backup.Scheduler{
+				Logger:   logger,
+				DBPath:   config.AppConfig.DBPath,
+				Storage:  backups,
+				Interval: config.AppConfig.BackupInterval,
+				Options:  backup.Options{Recordings: config.AppConfig.BackupRecordings},
+				Rotation: backupRotation(),
+			}
+			go scheduler.Run(ctx)
+		}
+	}
+
+	// 5. Initialize Handlers
+	h := handlers.New(logger, templateMap, st, database.DB)
+	h.Alerts = alertEngine
+	h.Push = pushWorker
+	h.Webhooks = webhookDispatcher
+	h.Notifier = notifier
+
+	// 6. Setup Router & Middleware
+	mw := middleware.New(logger)
+	mux := h.Routes(mw)
+
+	// Wrap mux with global logging middleware
+	finalHandler := mw.Logging(mux)
+
+	srv := &http.Server{
+		Addr:    ":" + config.AppConfig.Port,
+		Handler: finalHandler,
+	}
+
+	// Channel to listen for errors coming from the listener.
+	serverErrors := make(chan error, 1)
+
+	go func() {
+		logger.Info("Server started", "port", config.AppConfig.Port)
+		serverErrors <- srv.ListenAndServe()
+	}()
+
+	// Channel to listen for an interrupt or terminate signal from the OS.
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+
+	// Blocking main and waiting for shutdown.
+	select {
+	case err := <-serverErrors:
+		logger.Error("Error starting server", "error", err)
+		return 1
+
+	case <-shutdown:
+		logger.Info("Starting shutdown...")
+
+		// Give outstanding requests a deadline for completion.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// Asking listener to shut down and shed load.
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("Graceful shutdown did not complete", "error", err)
+			if err := srv.Close(); err != nil {
+				logger.Error("Could not stop http server", "error", err)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/auth"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// readPassword returns A2WEB_PASSWORD or the first line of standard input.
+// Input from a terminal is echoed; pipe the password in or set the variable
+// to keep it off the screen.
+func readPassword() (string, error) {
+	if pw := os.Getenv("A2WEB_PASSWORD"); pw != "" {
+		return pw, nil
+	}
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given: set A2WEB_PASSWORD or pass it on standard input")
+	}
+	pw := strings.TrimRight(line, "\r\n")
+	if pw == "" {
+		return "", errors.New("password must not be empty")
+	}
+	return pw, nil
+}
+
+// recordCLI adds an audit event for a change made from the command line.
+func recordCLI(logger *slog.Logger, e audit.Event) {
+	e.Actor = "cli"
+	e.UserAgent = "server " + os.Args[1]
+	e.Outcome = audit.OutcomeSuccess
+	if err := audit.Record(context.Background(), st.Audit, &e); err != nil {
+		logger.Error("Failed to record audit event", "action", e.Action, "error", err)
+	}
+}
+
+func userID(mobile string) (int64, error) {
+	u, err := st.Users.GetByMobile(context.Background(), mobile)
+	return u.ID, err
+}
+
+func createAdmin(logger *slog.Logger, args []string) int {
+	flags := newFlagSet("create-admin", "-mobile MOBILE")
+	mobile := flags.String("mobile", "", "mobile number of the new admin")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if *mobile == "" {
+		flags.Usage()
+		return 2
+	}
+	password, err := readPassword()
+	if err != nil {
+		logger.Error("Cannot create admin", "error", err)
+		return 2
+	}
+	if !openDatabase(logger) {
+		return 1
+	}
+
+	if _, err := userID(*mobile); err == nil {
+		logger.Error("User already exists; use set-role to promote them", "mobile", *mobile)
+		return 1
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Error("Error hashing password", "error", err)
+		return 1
+	}
+	u := models.User{Mobile: *mobile, PasswordHash: hash, Role: models.RoleAdmin}
+	if err := st.Users.Create(context.Background(), &u); err != nil {
+		logger.Error("Failed to create admin", "error", err)
+		return 1
+	}
+	recordCLI(logger, audit.Event{Action: audit.ActionRegister, TargetType: "user", TargetID: strconv.FormatInt(u.ID, 10), Detail: "role=admin"})
+	fmt.Printf("Created admin %s (id %d)\n", *mobile, u.ID)
+	return 0
+}
+
+func setRole(logger *slog.Logger, args []string) int {
+	flags := newFlagSet("set-role", "-mobile MOBILE -role admin|user")
+	mobile := flags.String("mobile", "", "mobile number of the user")
+	role := flags.String("role", "", "new role: admin or user")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if *mobile == "" || (models.Role(*role) != models.RoleAdmin && models.Role(*role) != models.RoleUser) {
+		flags.Usage()
+		return 2
+	}
+	if !openDatabase(logger) {
+		return 1
+	}
+
+	id, err := userID(*mobile)
+	if err != nil {
+		logger.Error("User not found", "mobile", *mobile, "error", err)
+		return 1
+	}
+	if err := st.Users.SetRole(context.Background(), id, models.Role(*role)); err != nil {
+		logger.Error("Failed to set role", "error", err)
+		return 1
+	}
+	recordCLI(logger, audit.Event{Action: audit.ActionUserRole, TargetType: "user", TargetID: strconv.FormatInt(id, 10), Detail: "role=" + *role})
+	fmt.Printf("%s is now %s\n", *mobile, *role)
+	return 0
+}
+
+func resetPassword(logger *slog.Logger, args []string) int {
+	flags := newFlagSet("reset-password", "-mobile MOBILE")
+	mobile := flags.String("mobile", "", "mobile number of the user")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if *mobile == "" {
+		flags.Usage()
+		return 2
+	}
+	password, err := readPassword()
+	if err != nil {
+		logger.Error("Cannot reset password", "error", err)
+		return 2
+	}
+	if !openDatabase(logger) {
+		return 1
+	}
+
+	id, err := userID(*mobile)
+	if err != nil {
+		logger.Error("User not found", "mobile", *mobile, "error", err)
+		return 1
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Error("Error hashing password", "error", err)
+		return 1
+	}
+	// Browsers signed in with the old password are signed out
+	if err := st.Users.SetPassword(context.Background(), id, hash); err != nil {
+		logger.Error("Failed to reset password", "error", err)
+		return 1
+	}
+	recordCLI(logger, audit.Event{Action: audit.ActionPasswordReset, TargetType: "user", TargetID: strconv.FormatInt(id, 10)})
+	fmt.Printf("Password of %s reset; their browsers were signed out\n", *mobile)
+	return 0
+}
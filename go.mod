@@ -11,4 +11,13 @@ require (
 
 require github.com/joho/godotenv v1.5.1
 
-require golang.org/x/time v0.14.0
+require (
+	github.com/eclipse/paho.mqtt.golang v1.5.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/time v0.14.0
+)
+
+require (
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+)
@@ -0,0 +1,411 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+const (
+	// tickInterval is how often time-based rules (offline, stalled) are checked.
+	tickInterval = 5 * time.Second
+	// stallTimeout is how long a live source may go without sending any
+	// audio before the stream counts as dropped.
+	stallTimeout = 15 * time.Second
+	// levelTimeout is how long a reported level stays valid. A source that
+	// stops reporting levels is treated as quiet.
+	levelTimeout = 3 * time.Second
+	// jobQueue bounds the rule loads and alerts waiting for the worker.
+	jobQueue = 256
+)
+
+// watch is the engine's view of one session.
+type watch struct {
+	userID     int64
+	state      string
+	listeners  int
+	lastPacket time.Time
+	stalled    bool
+
+	level      float64
+	levelAt    time.Time
+	aboveSince map[int64]time.Time // sound rule ID -> when the level first crossed it
+
+	offlineSince time.Time
+	offlineFired map[int64]bool // offline rule ID -> fired for the current outage
+}
+
+// job is database work the engine hands to its worker: loading the rules
+// of a session, or storing and publishing an alert.
+type job struct {
+	load  string // session whose rules to load
+	gen   int    // generation of the session's rules when the load was queued
+	alert *models.Alert
+}
+
+// Engine evaluates alert rules against live stream metadata. It learns about
+// state changes from the event bus and receives levels and packet activity
+// directly from the hub through ObserveLevel and ObservePacket.
+//
+// Evaluation only reads memory: rules are cached per session until they are
+// edited, and loading rules and storing alerts happen on a worker, so the
+// hub's ingest path never waits for the database.
+type Engine struct {
+	logger *slog.Logger
+	bus    *events.Bus
+	store  *Store
+	jobs   chan job
+
+	mu        sync.Mutex
+	watches   map[string]*watch
+	rules     map[string][]models.AlertRule // enabled rules by session
+	loading   map[string]bool               // sessions whose rules are queued for loading
+	gens      map[string]int                // session -> times its rules were invalidated
+	lastFired map[int64]time.Time           // rule ID -> last alert
+	archived  map[string]bool               // sessions archived since the start
+
+	sosMu sync.Mutex // serializes SOS
+}
+
+func NewEngine(logger *slog.Logger, bus *events.Bus, st *Store) *Engine {
+	return &Engine{
+		logger:    logger,
+		bus:       bus,
+		store:     st,
+		jobs:      make(chan job, jobQueue),
+		watches:   make(map[string]*watch),
+		rules:     make(map[string][]models.AlertRule),
+		loading:   make(map[string]bool),
+		gens:      make(map[string]int),
+		lastFired: make(map[int64]time.Time),
+		archived:  make(map[string]bool),
+	}
+}
+
+// Run consumes bus events and checks time-based rules until ctx is done.
+func (e *Engine) Run(ctx context.Context) {
+	go e.work(ctx)
+
+	ch, cancel := e.bus.Subscribe(256)
+	defer cancel()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			e.handleEvent(ev)
+		case now := <-ticker.C:
+			e.tick(now)
+		}
+	}
+}
+
+// Invalidate drops the cached rules of a session after they were edited.
+// They are loaded again when next needed.
+func (e *Engine) Invalidate(sessionID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.invalidate(sessionID)
+}
+
+// invalidate drops the cached rules of a session; a load already queued
+// for them is discarded when it completes. The caller must hold e.mu.
+func (e *Engine) invalidate(sessionID string) {
+	delete(e.rules, sessionID)
+	delete(e.loading, sessionID)
+	e.gens[sessionID]++
+}
+
+// ObserveLevel records a sound level (0-100) reported by the source and
+// evaluates the session's sound rules.
+func (e *Engine) ObserveLevel(sessionID string, userID int64, level float64) {
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := e.watch(sessionID, userID)
+	w.level = level
+	w.levelAt = now
+
+	for _, rule := range e.rulesFor(sessionID) {
+		if rule.Kind != models.AlertSound {
+			continue
+		}
+		if level < rule.Threshold {
+			delete(w.aboveSince, rule.ID)
+			continue
+		}
+		since, ok := w.aboveSince[rule.ID]
+		if !ok {
+			w.aboveSince[rule.ID] = now
+			since = now
+		}
+		if now.Sub(since) >= time.Duration(rule.DurationSeconds)*time.Second {
+			msg := fmt.Sprintf("Sound above %.0f for %ds", rule.Threshold, rule.DurationSeconds)
+			if e.fire(rule, sessionID, userID, msg) {
+				delete(w.aboveSince, rule.ID)
+			}
+		}
+	}
+}
+
+// ObservePacket records that the source sent audio.
+func (e *Engine) ObservePacket(sessionID string, userID int64, size int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := e.watch(sessionID, userID)
+	w.lastPacket = time.Now()
+	w.stalled = false
+}
+
+func (e *Engine) handleEvent(ev events.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch ev.Type {
+	case events.SessionDeleted:
+		delete(e.watches, ev.SessionID)
+		delete(e.archived, ev.SessionID)
+		e.invalidate(ev.SessionID)
+
+	case events.SessionUpdated:
+		// Archiving stops the broadcast for good, which is no outage
+		s, ok := ev.Data.(models.Session)
+		if !ok {
+			return
+		}
+		if s.Archived() {
+			e.archived[ev.SessionID] = true
+			delete(e.watches, ev.SessionID)
+		} else {
+			delete(e.archived, ev.SessionID)
+		}
+
+	case events.StreamStarted:
+		w := e.watch(ev.SessionID, ev.UserID)
+		w.offlineSince = time.Time{}
+		w.offlineFired = make(map[int64]bool)
+		w.lastPacket = time.Now()
+		// Have the rules ready before the first level arrives.
+		e.rulesFor(ev.SessionID)
+
+	case events.StreamStopped:
+		if e.archived[ev.SessionID] {
+			return
+		}
+		w := e.watch(ev.SessionID, ev.UserID)
+		w.offlineSince = time.Now()
+		w.offlineFired = make(map[int64]bool)
+
+	case events.SessionState:
+		st, ok := ev.Data.(models.LiveState)
+		if !ok {
+			return
+		}
+		w := e.watch(ev.SessionID, ev.UserID)
+		prevState, prevListeners := w.state, w.listeners
+		w.state, w.listeners = st.State, st.Listeners
+
+		if st.State == models.StateReconnecting && prevState == models.StateLive {
+			e.fireKind(models.AlertStreamDropped, ev.SessionID, ev.UserID, "Stream dropped unexpectedly")
+		}
+		if st.State == models.StateLive && st.Listeners == 0 && prevListeners > 0 {
+			e.fireKind(models.AlertListenerDisconnected, ev.SessionID, ev.UserID, "Nobody is listening any more")
+		}
+	}
+}
+
+func (e *Engine) tick(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for sessionID, w := range e.watches {
+		if w.state == models.StateLive && !w.stalled && !w.lastPacket.IsZero() && now.Sub(w.lastPacket) >= stallTimeout {
+			w.stalled = true
+			e.fireKind(models.AlertStreamDropped, sessionID, w.userID,
+				fmt.Sprintf("No audio received for %s", stallTimeout))
+		}
+
+		// A source that stops reporting levels while above a threshold must
+		// not keep the timer running.
+		if !w.levelAt.IsZero() && now.Sub(w.levelAt) >= levelTimeout {
+			w.levelAt = time.Time{}
+			w.aboveSince = make(map[int64]time.Time)
+		}
+
+		if w.offlineSince.IsZero() {
+			continue
+		}
+		for _, rule := range e.rulesFor(sessionID) {
+			if rule.Kind != models.AlertOffline || w.offlineFired[rule.ID] {
+				continue
+			}
+			if now.Sub(w.offlineSince) >= time.Duration(rule.DurationSeconds)*time.Second {
+				msg := fmt.Sprintf("Device offline for %s", time.Duration(rule.DurationSeconds)*time.Second)
+				if e.fire(rule, sessionID, w.userID, msg) {
+					w.offlineFired[rule.ID] = true
+				}
+			}
+		}
+	}
+}
+
+// watch returns the entry for sessionID, creating it if needed.
+// The caller must hold e.mu.
+func (e *Engine) watch(sessionID string, userID int64) *watch {
+	w, ok := e.watches[sessionID]
+	if !ok {
+		w = &watch{
+			userID:       userID,
+			state:        models.StateIdle,
+			aboveSince:   make(map[int64]time.Time),
+			offlineFired: make(map[int64]bool),
+		}
+		e.watches[sessionID] = w
+	}
+	return w
+}
+
+// rulesFor returns the cached enabled rules of a session. Rules not cached
+// yet are queued for loading and nil is returned meanwhile.
+// The caller must hold e.mu.
+func (e *Engine) rulesFor(sessionID string) []models.AlertRule {
+	if rules, ok := e.rules[sessionID]; ok {
+		return rules
+	}
+	if e.loading[sessionID] {
+		return nil
+	}
+	select {
+	case e.jobs <- job{load: sessionID, gen: e.gens[sessionID]}:
+		e.loading[sessionID] = true
+	default:
+		e.logger.Warn("Alert engine busy, rules not loaded yet", "session_id", sessionID)
+	}
+	return nil
+}
+
+// fireKind fires every enabled rule of the given kind. The caller must hold e.mu.
+func (e *Engine) fireKind(kind, sessionID string, userID int64, message string) {
+	for _, rule := range e.rulesFor(sessionID) {
+		if rule.Kind == kind {
+			e.fire(rule, sessionID, userID, message)
+		}
+	}
+}
+
+// fire queues an alert for storing and publishing unless the rule is
+// cooling down. It reports whether an alert was raised. The caller must
+// hold e.mu.
+func (e *Engine) fire(rule models.AlertRule, sessionID string, userID int64, message string) bool {
+	now := time.Now()
+	if last, ok := e.lastFired[rule.ID]; ok && now.Sub(last) < time.Duration(rule.CooldownSeconds)*time.Second {
+		return false
+	}
+
+	alert := &models.Alert{
+		RuleID:    rule.ID,
+		SessionID: sessionID,
+		UserID:    userID,
+		Kind:      rule.Kind,
+		Message:   message,
+		CreatedAt: now,
+	}
+	select {
+	case e.jobs <- job{alert: alert}:
+	default:
+		e.logger.Error("Alert engine busy, alert not raised", "session_id", sessionID, "rule_id", rule.ID)
+		return false
+	}
+	e.lastFired[rule.ID] = now
+	return true
+}
+
+// work runs the engine's database jobs until ctx is done.
+func (e *Engine) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-e.jobs:
+			if j.alert != nil {
+				e.raise(j.alert)
+			} else {
+				e.load(j.load, j.gen)
+			}
+		}
+	}
+}
+
+// load reads the rules of a session into the cache, along with when each
+// rule last fired so cooldowns survive a restart.
+func (e *Engine) load(sessionID string, gen int) {
+	rules, err := e.store.LoadRules(sessionID)
+	var enabled []models.AlertRule
+	fired := make(map[int64]time.Time)
+	if err == nil {
+		enabled = make([]models.AlertRule, 0, len(rules))
+		for _, r := range rules {
+			if !r.Enabled {
+				continue
+			}
+			enabled = append(enabled, r)
+			if last, ok := e.store.LastFired(r.ID); ok {
+				fired[r.ID] = last
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.gens[sessionID] != gen {
+		return // edited meanwhile, a fresh load follows
+	}
+	delete(e.loading, sessionID)
+	if err != nil {
+		e.logger.Error("Failed to load alert rules", "session_id", sessionID, "error", err)
+		return
+	}
+	e.rules[sessionID] = enabled
+	for id, last := range fired {
+		if _, ok := e.lastFired[id]; !ok {
+			e.lastFired[id] = last
+		}
+	}
+}
+
+// raise stores and publishes an alert queued by fire. If it cannot be
+// stored the rule may fire again straight away.
+func (e *Engine) raise(alert *models.Alert) {
+	if err := e.store.Add(alert); err != nil {
+		e.logger.Error("Failed to store alert", "session_id", alert.SessionID, "rule_id", alert.RuleID, "error", err)
+		e.mu.Lock()
+		if e.lastFired[alert.RuleID].Equal(alert.CreatedAt) {
+			delete(e.lastFired, alert.RuleID)
+		}
+		e.mu.Unlock()
+		return
+	}
+
+	e.logger.Info("Alert raised", "session_id", alert.SessionID, "kind", alert.Kind, "alert_id", alert.ID)
+	e.bus.Publish(events.Event{
+		Type:      events.AlertRaised,
+		SessionID: alert.SessionID,
+		UserID:    alert.UserID,
+		Data:      *alert,
+	})
+}
@@ -0,0 +1,71 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// PendingSOS returns the unacknowledged SOS alert of a session, or
+// ErrNotFound.
+func (s *Store) PendingSOS(sessionID string) (models.Alert, error) {
+	var a models.Alert
+	err := s.db.QueryRow(`SELECT id, session_id, user_id, kind, message, created_at
+		FROM alerts WHERE session_id = ? AND kind = ? AND acknowledged_at IS NULL ORDER BY id DESC LIMIT 1`,
+		sessionID, models.AlertSOS).Scan(&a.ID, &a.SessionID, &a.UserID, &a.Kind, &a.Message, &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+// sessionName returns the name of a session, empty if it has none.
+func (s *Store) sessionName(sessionID string) string {
+	var name string
+	s.db.QueryRow("SELECT COALESCE(name, '') FROM sessions WHERE id = ?", sessionID).Scan(&name)
+	return name
+}
+
+// SOS raises an SOS alert for a session. While an earlier SOS is still
+// unacknowledged that alert is returned instead and nothing is published,
+// so a child pressing the button repeatedly rings the listeners again
+// without flooding the notification channels. It reports whether a new
+// alert was raised. It does not hold up rule evaluation.
+func (e *Engine) SOS(sessionID string, userID int64) (models.Alert, bool, error) {
+	e.sosMu.Lock()
+	defer e.sosMu.Unlock()
+
+	if pending, err := e.store.PendingSOS(sessionID); err == nil {
+		return pending, false, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return pending, false, err
+	}
+
+	name := e.store.sessionName(sessionID)
+	if name == "" {
+		name = sessionID
+	}
+
+	alert := models.Alert{
+		SessionID: sessionID,
+		UserID:    userID,
+		Kind:      models.AlertSOS,
+		Message:   "SOS: help was requested from " + name,
+		CreatedAt: time.Now(),
+	}
+	if err := e.store.Add(&alert); err != nil {
+		return alert, false, err
+	}
+
+	e.logger.Warn("SOS raised", "session_id", sessionID, "alert_id", alert.ID)
+	e.bus.Publish(events.Event{
+		Type:      events.AlertRaised,
+		SessionID: sessionID,
+		UserID:    userID,
+		Data:      alert,
+	})
+	return alert, true, nil
+}
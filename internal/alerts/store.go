@@ -0,0 +1,205 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// ErrNotFound is returned when an alert or rule does not exist or belongs to
+// another user.
+var ErrNotFound = errors.New("not found")
+
+// Store keeps alert rules and the alerts they raised.
+type Store struct {
+	db *database.Handle
+}
+
+// NewStore returns the alert store of db.
+func NewStore(db *database.Handle) *Store {
+	return &Store{db: db}
+}
+
+// ValidKind reports whether kind is a known rule kind.
+func ValidKind(kind string) bool {
+	switch kind {
+	case models.AlertSound, models.AlertStreamDropped, models.AlertOffline, models.AlertListenerDisconnected:
+		return true
+	}
+	return false
+}
+
+func (s *Store) LoadRules(sessionID string) ([]models.AlertRule, error) {
+	rows, err := s.db.Query(`SELECT id, session_id, kind, threshold, duration_seconds, cooldown_seconds, enabled, created_at
+		FROM alert_rules WHERE session_id = ? ORDER BY id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.AlertRule
+	for rows.Next() {
+		var r models.AlertRule
+		if err := rows.Scan(&r.ID, &r.SessionID, &r.Kind, &r.Threshold, &r.DurationSeconds, &r.CooldownSeconds, &r.Enabled, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Store) CreateRule(r *models.AlertRule) error {
+	err := s.db.QueryRow(`INSERT INTO alert_rules (session_id, kind, threshold, duration_seconds, cooldown_seconds, enabled)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`, r.SessionID, r.Kind, r.Threshold, r.DurationSeconds, r.CooldownSeconds, r.Enabled).
+		Scan(&r.ID)
+	r.CreatedAt = time.Now()
+	return err
+}
+
+// RuleSession returns the session a rule belongs to.
+func (s *Store) RuleSession(ruleID int64) (string, error) {
+	var sessionID string
+	err := s.db.QueryRow("SELECT session_id FROM alert_rules WHERE id = ?", ruleID).Scan(&sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return sessionID, err
+}
+
+// DeleteRule removes a rule. Whether the caller may is the handler's
+// decision.
+func (s *Store) DeleteRule(ruleID int64) error {
+	res, err := s.db.Exec("DELETE FROM alert_rules WHERE id = ?", ruleID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Add inserts a triggered alert and sets its ID.
+func (s *Store) Add(a *models.Alert) error {
+	var ruleID interface{}
+	if a.RuleID != 0 {
+		ruleID = a.RuleID
+	}
+	return s.db.QueryRow(`INSERT INTO alerts (rule_id, session_id, user_id, kind, message, created_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`, ruleID, a.SessionID, a.UserID, a.Kind, a.Message, a.CreatedAt).Scan(&a.ID)
+}
+
+// LastFired returns when a rule last raised an alert.
+func (s *Store) LastFired(ruleID int64) (time.Time, bool) {
+	var last time.Time
+	err := s.db.QueryRow("SELECT created_at FROM alerts WHERE rule_id = ? ORDER BY id DESC LIMIT 1", ruleID).Scan(&last)
+	return last, err == nil
+}
+
+// List returns the most recent alerts of a user's sessions, of the
+// sessions they listen to as a member and of the rooms of the
+// organizations they are an admin or staff of, optionally restricted to one session
+// and to unacknowledged alerts, skipping the first offset.
+func (s *Store) List(userID int64, sessionID string, unacknowledgedOnly bool, limit, offset int) ([]models.Alert, error) {
+	where, args := listFilter(userID, sessionID, unacknowledgedOnly)
+	query := `SELECT id, COALESCE(rule_id, 0), session_id, user_id, kind, message, created_at, acknowledged_at
+		FROM alerts WHERE ` + where + " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.Alert
+	for rows.Next() {
+		var a models.Alert
+		var ackAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.RuleID, &a.SessionID, &a.UserID, &a.Kind, &a.Message, &a.CreatedAt, &ackAt); err != nil {
+			return nil, err
+		}
+		if ackAt.Valid {
+			a.AcknowledgedAt = &ackAt.Time
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// Count returns the number of alerts List would page through.
+func (s *Store) Count(userID int64, sessionID string, unacknowledgedOnly bool) (int, error) {
+	where, args := listFilter(userID, sessionID, unacknowledgedOnly)
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM alerts WHERE "+where, args...).Scan(&n)
+	return n, err
+}
+
+// Recipients returns the users an alert goes to: the owner of its session,
+// the members allowed to listen to it and, for a room, its organization's
+// admins and staff.
+func (s *Store) Recipients(a models.Alert) ([]int64, error) {
+	rows, err := s.db.Query(`SELECT user_id FROM session_members WHERE session_id = ? AND role = 'listener'
+		UNION SELECT o.user_id FROM org_members o JOIN sessions s ON s.org_id = o.org_id
+		WHERE s.id = ? AND o.role IN ('admin', 'staff') AND o.user_id <> ?
+		ORDER BY user_id`, a.SessionID, a.SessionID, a.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []int64{a.UserID}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
+
+// visibleTo matches the alerts of a user's sessions, of the sessions they
+// are a listener of and of the rooms of the organizations they are an
+// admin or staff of. It takes the user's ID three times. A session's
+// owner, not the alert's user, counts: rooms change hands.
+const visibleTo = `(session_id IN (SELECT id FROM sessions WHERE user_id = ?)
+	OR session_id IN (SELECT session_id FROM session_members WHERE user_id = ? AND role = 'listener')
+	OR session_id IN (SELECT s.id FROM sessions s JOIN org_members o ON o.org_id = s.org_id WHERE o.user_id = ? AND o.role IN ('admin', 'staff')))`
+
+func listFilter(userID int64, sessionID string, unacknowledgedOnly bool) (string, []interface{}) {
+	where := visibleTo
+	args := []interface{}{userID, userID, userID}
+	if sessionID != "" {
+		where += " AND session_id = ?"
+		args = append(args, sessionID)
+	}
+	if unacknowledgedOnly {
+		where += " AND acknowledged_at IS NULL"
+	}
+	return where, args
+}
+
+// Acknowledge marks an alert List shows userID as seen and returns it.
+func (s *Store) Acknowledge(alertID, userID int64) (models.Alert, error) {
+	var a models.Alert
+	res, err := s.db.Exec("UPDATE alerts SET acknowledged_at = ? WHERE id = ? AND "+visibleTo+" AND acknowledged_at IS NULL",
+		time.Now(), alertID, userID, userID, userID)
+	if err != nil {
+		return a, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return a, ErrNotFound
+	}
+
+	var ackAt sql.NullTime
+	err = s.db.QueryRow(`SELECT id, COALESCE(rule_id, 0), session_id, user_id, kind, message, created_at, acknowledged_at
+		FROM alerts WHERE id = ?`, alertID).Scan(&a.ID, &a.RuleID, &a.SessionID, &a.UserID, &a.Kind, &a.Message, &a.CreatedAt, &ackAt)
+	if ackAt.Valid {
+		a.AcknowledgedAt = &ackAt.Time
+	}
+	return a, err
+}
@@ -0,0 +1,203 @@
+// Package apitokens implements personal access tokens: long-lived bearer
+// credentials a user creates for scripts and integrations. Each token is
+// limited to a set of scopes and may expire. Only a SHA-256 hash of a token
+// is stored; the token itself is shown once, when it is created.
+package apitokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+)
+
+// Scopes a token can be granted.
+const (
+	ScopeSessionsRead     = "sessions:read"     // list sessions and alerts, listen to live audio
+	ScopeRecordingsRead   = "recordings:read"   // list and download recordings
+	ScopeRecordingsDelete = "recordings:delete" // delete recordings
+	ScopeStreamIngest     = "stream:ingest"     // broadcast audio into a session
+)
+
+// Scopes lists every scope, in display order.
+var Scopes = []string{ScopeSessionsRead, ScopeRecordingsRead, ScopeRecordingsDelete, ScopeStreamIngest}
+
+// tokenPrefix marks a2web personal access tokens, so they are easy to spot in
+// logs and secret scanners.
+const tokenPrefix = "a2w_"
+
+// displayPrefixLength is how much of a token is kept in clear text to help
+// users tell their tokens apart.
+const displayPrefixLength = len(tokenPrefix) + 8
+
+// lastUsedResolution limits how often last_used_at is written.
+const lastUsedResolution = time.Minute
+
+var (
+	// ErrNotFound is returned when a token does not exist or belongs to
+	// another user.
+	ErrNotFound = errors.New("token not found")
+	// ErrInvalid is returned for unknown and malformed tokens.
+	ErrInvalid = errors.New("invalid token")
+	// ErrExpired is returned for tokens past their expiry.
+	ErrExpired = errors.New("token expired")
+)
+
+// Token is a stored personal access token.
+type Token struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the token was granted scope.
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps the tokens of every user.
+type Store struct {
+	db *database.Handle
+}
+
+// NewStore returns the token store of db.
+func NewStore(db *database.Handle) *Store {
+	return &Store{db: db}
+}
+
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create generates a token for t.UserID with t.Name, t.Scopes and
+// t.ExpiresAt, stores its hash and returns the token. It is not retrievable
+// afterwards.
+func (s *Store) Create(t *Token) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := tokenPrefix + hex.EncodeToString(b)
+	t.Prefix = raw[:displayPrefixLength]
+	t.CreatedAt = time.Now()
+
+	var expiresAt sql.NullTime
+	if t.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *t.ExpiresAt, Valid: true}
+	}
+	if err := s.db.QueryRow(`INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		t.UserID, t.Name, hash(raw), t.Prefix, strings.Join(t.Scopes, ","), expiresAt, t.CreatedAt).Scan(&t.ID); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+const tokenColumns = "id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at"
+
+func scan(row interface{ Scan(...interface{}) error }) (Token, error) {
+	var t Token
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return t, err
+}
+
+// List returns a user's tokens, newest first.
+func (s *Store) List(userID int64) ([]Token, error) {
+	rows, err := s.db.Query("SELECT "+tokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Count returns the number of a user's tokens.
+func (s *Store) Count(userID int64) (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE user_id = ?", userID).Scan(&n)
+	return n, err
+}
+
+// Delete revokes one of userID's tokens.
+func (s *Store) Delete(id, userID int64) error {
+	res, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate looks up a presented token and records that it was used.
+func (s *Store) Authenticate(raw string) (Token, error) {
+	if !strings.HasPrefix(raw, tokenPrefix) {
+		return Token{}, ErrInvalid
+	}
+	t, err := scan(s.db.QueryRow("SELECT "+tokenColumns+" FROM api_tokens WHERE token_hash = ?", hash(raw)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, ErrInvalid
+	}
+	if err != nil {
+		return t, err
+	}
+
+	now := time.Now()
+	if t.ExpiresAt != nil && now.After(*t.ExpiresAt) {
+		return t, ErrExpired
+	}
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= lastUsedResolution {
+		if _, err := s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, t.ID); err != nil {
+			return t, err
+		}
+		t.LastUsedAt = &now
+	}
+	return t, nil
+}
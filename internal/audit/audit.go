@@ -0,0 +1,170 @@
+// Package audit keeps a tamper-evident log of security-relevant actions:
+// logins, deletions, role changes, device pairing, listening and the like.
+//
+// Every event stores the SHA-256 hash of its own fields together with the
+// hash of the event before it, so the events form a chain. Editing or
+// deleting an event breaks the chain at that point, which Verify reports.
+// Removing the newest events leaves a valid but shorter chain; compare the
+// head hash Verify returns with a copy kept elsewhere to catch that.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// Actions recorded by the server.
+const (
+	ActionRegister        = "user.register"
+	ActionLogin           = "user.login"
+	ActionLogout          = "user.logout"
+	ActionUserDelete      = "user.delete"
+	ActionUserDisable     = "user.disable"
+	ActionUserEnable      = "user.enable"
+	ActionUserRole        = "user.role"
+	ActionUserForceLogout = "user.force_logout"
+	ActionPasswordReset   = "user.password_reset"
+	ActionAdminDenied     = "admin.denied"
+	ActionSessionCreate   = "session.create"
+	ActionSessionUpdate   = "session.update"
+	ActionSessionArchive  = "session.archive"
+	ActionSessionRestore  = "session.restore"
+	ActionSessionRotate   = "session.rotate_link"
+	ActionSessionDelete   = "session.delete"
+	ActionRecordingDelete = "recording.delete"
+	ActionInviteCreate    = "invite.create"
+	ActionInviteRevoke    = "invite.revoke"
+	ActionInviteAccept    = "invite.accept"
+	ActionMemberRemove    = "member.remove"
+	ActionOrgCreate       = "org.create"
+	ActionOrgUpdate       = "org.update"
+	ActionOrgQuotas       = "org.quotas"
+	ActionOrgDelete       = "org.delete"
+	ActionOrgMemberSet    = "org.member_set"
+	ActionOrgMemberRemove = "org.member_remove"
+	ActionTokenCreate     = "token.create"
+	ActionTokenDelete     = "token.delete"
+	ActionDevicePair      = "device.pair"
+	ActionDeviceRemove    = "device.remove"
+	ActionListenStart     = "listen.start"
+	ActionBroadcastStart  = "broadcast.start"
+)
+
+// Actions lists every action, in display order.
+var Actions = []string{
+	ActionRegister, ActionLogin, ActionLogout,
+	ActionUserDelete, ActionUserDisable, ActionUserEnable, ActionUserRole, ActionUserForceLogout, ActionPasswordReset, ActionAdminDenied,
+	ActionSessionCreate, ActionSessionUpdate, ActionSessionArchive, ActionSessionRestore, ActionSessionRotate, ActionSessionDelete, ActionRecordingDelete,
+	ActionInviteCreate, ActionInviteRevoke, ActionInviteAccept, ActionMemberRemove,
+	ActionOrgCreate, ActionOrgUpdate, ActionOrgQuotas, ActionOrgDelete, ActionOrgMemberSet, ActionOrgMemberRemove,
+	ActionTokenCreate, ActionTokenDelete,
+	ActionDevicePair, ActionDeviceRemove,
+	ActionListenStart, ActionBroadcastStart,
+}
+
+// Outcomes of an action.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure" // bad credentials or input
+	OutcomeDenied  = "denied"  // authenticated but not allowed
+)
+
+// genesis is the previous hash of the first event.
+const genesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Event is one audit log entry.
+type Event = models.AuditEvent
+
+// Filter selects events for List.
+type Filter = store.AuditFilter
+
+// computeHash hashes the event's fields and the previous hash. The fields
+// are encoded as a JSON array, so their order is fixed and no field can run
+// into the next.
+func computeHash(e Event) string {
+	fields, _ := json.Marshal([]string{
+		e.PrevHash,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		strconv.FormatInt(e.ActorID, 10),
+		e.Actor,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IP,
+		e.UserAgent,
+		e.Outcome,
+		e.Detail,
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// mu serializes appends so no two events link to the same predecessor.
+var mu sync.Mutex
+
+// Record appends e to log, setting its ID, time and hashes.
+func Record(ctx context.Context, log store.AuditLog, e *Event) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return log.Append(ctx, e, func() {
+		if e.PrevHash == "" {
+			e.PrevHash = genesis
+		}
+		// Microseconds survive every round trip through the database
+		e.Time = time.Now().UTC().Truncate(time.Microsecond)
+		e.Hash = computeHash(*e)
+	})
+}
+
+// List returns the events matching f, newest first.
+func List(ctx context.Context, log store.AuditLog, f Filter) ([]Event, error) {
+	return log.List(ctx, f)
+}
+
+// Result is the outcome of Verify.
+type Result struct {
+	Events int    `json:"events"`
+	Head   string `json:"head"` // hash of the newest event
+	// BrokenID is the first event whose hash or link does not match, 0 when
+	// the chain is intact.
+	BrokenID int64  `json:"broken_id,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// OK reports whether the chain is intact.
+func (r Result) OK() bool { return r.BrokenID == 0 }
+
+// errBroken stops the walk at the first broken link.
+var errBroken = errors.New("chain broken")
+
+// Verify walks the whole log in order and checks every hash and link.
+func Verify(ctx context.Context, log store.AuditLog) (Result, error) {
+	res := Result{Head: genesis}
+	err := log.Walk(ctx, func(e Event) error {
+		res.Events++
+		switch {
+		case e.PrevHash != res.Head:
+			res.BrokenID, res.Reason = e.ID, "does not link to the event before it; an event was removed or changed"
+		case computeHash(e) != e.Hash:
+			res.BrokenID, res.Reason = e.ID, "hash does not match its contents; the event was changed"
+		default:
+			res.Head = e.Hash
+			return nil
+		}
+		return errBroken
+	})
+	if errors.Is(err, errBroken) {
+		err = nil
+	}
+	return res, err
+}
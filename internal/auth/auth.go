@@ -20,12 +20,15 @@ type Claims struct {
 }
 
 func GenerateJWT(userID int64, role string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+	now := time.Now()
+	expirationTime := now.Add(24 * time.Hour)
 	claims := &Claims{
 		UserID: userID,
 		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			// Compared with the user's tokens_valid_after to end sessions early
+			IssuedAt: jwt.NewNumericDate(now),
 		},
 	}
 
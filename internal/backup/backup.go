@@ -0,0 +1,482 @@
+// Package backup takes online backups of the SQLite database and restores
+// them. A backup is a gzipped tar archive holding a consistent copy of the
+// database, written with VACUUM INTO while the server keeps running, and a
+// manifest with the copy's checksum, its schema version and, optionally,
+// the recording files it refers to. Archives are kept in a Storage, a local
+// directory or an S3 bucket, and rotated by count and age.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+)
+
+// Names of the archive and its entries.
+const (
+	namePrefix   = "a2web-"
+	nameSuffix   = ".tar.gz"
+	nameTime     = "20060102-150405"
+	manifestName = "manifest.json"
+	databaseName = "a2web.db"
+)
+
+// formatVersion is the version of the archive layout written by Create.
+const formatVersion = 1
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	Format        int       `json:"format"`
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int       `json:"schema_version"`
+	Database      File      `json:"database"`
+	// Recordings lists the recording files the database refers to, when
+	// the backup was taken with them. The files themselves are not in the
+	// archive.
+	Recordings []Recording `json:"recordings,omitempty"`
+}
+
+// File is an entry of the archive.
+type File struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Recording is a recording row of the backed up database.
+type Recording struct {
+	ID        int64  `json:"id"`
+	SessionID string `json:"session_id"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// Options tune Create.
+type Options struct {
+	// Recordings adds the recordings of the copy to the manifest.
+	Recordings bool
+	// Now is the time the archive is taken at; time.Now when zero.
+	Now time.Time
+	// Name names the archive; Name(Now) when empty.
+	Name string
+}
+
+// Name returns the name of the archive taken at t.
+func Name(t time.Time) string {
+	return namePrefix + t.UTC().Format(nameTime) + nameSuffix
+}
+
+// archiveTime returns the time an archive was taken at, from its name.
+func archiveTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, namePrefix) || !strings.HasSuffix(name, nameSuffix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(nameTime, strings.TrimSuffix(strings.TrimPrefix(name, namePrefix), nameSuffix))
+	return t, err == nil
+}
+
+// Create backs up the SQLite database at dbPath into a new archive in st
+// and returns the archive's name. It reads through a connection of its own,
+// so on a database in WAL mode the server's writes carry on meanwhile.
+func Create(ctx context.Context, dbPath string, st Storage, opts Options) (string, Manifest, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	name := opts.Name
+	if name == "" {
+		name = Name(opts.Now)
+	}
+
+	tmp, err := os.MkdirTemp("", "a2web-backup")
+	if err != nil {
+		return "", Manifest{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	snapshot := filepath.Join(tmp, databaseName)
+	if err := vacuumInto(ctx, dbPath, snapshot); err != nil {
+		return "", Manifest{}, err
+	}
+	m, err := describe(snapshot, opts)
+	if err != nil {
+		return "", Manifest{}, fmt.Errorf("backup is not usable: %w", err)
+	}
+
+	archive := filepath.Join(tmp, name)
+	if err := writeArchive(archive, snapshot, m); err != nil {
+		return "", Manifest{}, err
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", Manifest{}, err
+	}
+	defer f.Close()
+	if err := st.Put(ctx, name, f); err != nil {
+		return "", Manifest{}, err
+	}
+	return name, m, nil
+}
+
+func vacuumInto(ctx context.Context, dbPath, dst string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_busy_timeout=5000")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", dst); err != nil {
+		return fmt.Errorf("VACUUM INTO failed: %w", err)
+	}
+	return nil
+}
+
+// describe checks a database copy and builds its manifest.
+func describe(path string, opts Options) (Manifest, error) {
+	version, err := Check(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	sum, size, err := hashFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := Manifest{
+		Format:        formatVersion,
+		CreatedAt:     opts.Now.UTC(),
+		SchemaVersion: version,
+		Database:      File{Name: databaseName, Bytes: size, SHA256: sum},
+	}
+	if opts.Recordings {
+		if m.Recordings, err = listRecordings(path); err != nil {
+			return Manifest{}, err
+		}
+	}
+	return m, nil
+}
+
+// listRecordings reads the recordings of a database copy, so the manifest
+// matches the copy rather than the live database.
+func listRecordings(path string) ([]Recording, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, session_id, path, bytes FROM recordings ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Recording{}
+	for rows.Next() {
+		var r Recording
+		if err := rows.Scan(&r.ID, &r.SessionID, &r.Path, &r.Bytes); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, rows.Err()
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// writeArchive writes the manifest, then the database, so a reader knows
+// what to expect before it gets there.
+func writeArchive(path, dbPath string, m Manifest) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: manifestName, Mode: 0644, Size: int64(len(manifest)), ModTime: m.CreatedAt}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	db, err := os.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	hdr = &tar.Header{Name: databaseName, Mode: 0644, Size: m.Database.Bytes, ModTime: m.CreatedAt}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, db); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// Check opens a database file read-only and makes sure it is intact and
+// holds an a2web schema this server can run. It returns the schema version.
+func Check(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return 0, fmt.Errorf("not a SQLite database: %w", err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("integrity check failed: %s", result)
+	}
+	for _, table := range []string{"users", "sessions", "recordings"} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("missing table %s; not an a2web database", table)
+		}
+	}
+	// Older backups are migrated when the server next starts; newer ones
+	// would not start at all
+	version, err := (&database.Handle{DB: db, Dialect: database.SQLite}).SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if latest := database.LatestVersion(database.SQLite); version > latest {
+		return 0, fmt.Errorf("%w: backup is at version %d, this server knows up to %d", database.ErrSchemaTooNew, version, latest)
+	}
+	return version, nil
+}
+
+// Extract unpacks an archive into dir and validates it: the entries must be
+// the manifest and the database it describes, with the right size and
+// checksum, and the database must pass Check. It returns the manifest and
+// the path of the extracted database.
+func Extract(r io.Reader, dir string) (Manifest, string, error) {
+	var m Manifest
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return m, "", fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return m, "", fmt.Errorf("not a backup archive: %w", err)
+	}
+	if hdr.Name != manifestName {
+		return m, "", fmt.Errorf("archive starts with %q instead of %s", hdr.Name, manifestName)
+	}
+	if err := json.NewDecoder(io.LimitReader(tr, 64<<20)).Decode(&m); err != nil {
+		return m, "", fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Format != formatVersion {
+		return m, "", fmt.Errorf("archive format %d is not supported", m.Format)
+	}
+
+	hdr, err = tr.Next()
+	if err != nil {
+		return m, "", fmt.Errorf("archive has no database: %w", err)
+	}
+	if hdr.Name != m.Database.Name || hdr.Name != databaseName {
+		return m, "", fmt.Errorf("unexpected archive entry %q", hdr.Name)
+	}
+	path := filepath.Join(dir, databaseName)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return m, "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), tr)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return m, "", fmt.Errorf("failed to extract database: %w", err)
+	}
+	if n != m.Database.Bytes {
+		return m, "", fmt.Errorf("database is %d bytes, manifest says %d", n, m.Database.Bytes)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.Database.SHA256 {
+		return m, "", errors.New("database checksum does not match the manifest")
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		return m, "", errors.New("archive has unexpected entries after the database")
+	}
+
+	version, err := Check(path)
+	if err != nil {
+		return m, "", err
+	}
+	if version != m.SchemaVersion {
+		return m, "", fmt.Errorf("database is at schema version %d, manifest says %d", version, m.SchemaVersion)
+	}
+	return m, path, nil
+}
+
+// Archive is a backup in a Storage.
+type Archive struct {
+	Name  string
+	Time  time.Time
+	Bytes int64
+}
+
+// List returns the archives in st, newest first. Other objects are ignored.
+func List(ctx context.Context, st Storage) ([]Archive, error) {
+	objects, err := st.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var list []Archive
+	for _, o := range objects {
+		if t, ok := archiveTime(o.Name); ok {
+			list = append(list, Archive{Name: o.Name, Time: t, Bytes: o.Bytes})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+	return list, nil
+}
+
+// Latest returns the newest archive taken at or before t, for restoring
+// the database as it was then.
+func Latest(ctx context.Context, st Storage, t time.Time) (Archive, error) {
+	list, err := List(ctx, st)
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, a := range list {
+		if !a.Time.After(t) {
+			return a, nil
+		}
+	}
+	return Archive{}, fmt.Errorf("no backup taken at or before %s", t.Format(time.RFC3339))
+}
+
+// Rotation says which archives Prune keeps. Zero fields do not limit.
+type Rotation struct {
+	Keep   int           // number of newest archives to keep
+	MaxAge time.Duration // age after which archives are removed
+}
+
+// Prune removes the archives the rotation does not keep and returns their
+// names. The newest archive is always kept.
+func Prune(ctx context.Context, st Storage, rot Rotation, now time.Time) ([]string, error) {
+	list, err := List(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i, a := range list {
+		if i == 0 {
+			continue
+		}
+		tooMany := rot.Keep > 0 && i >= rot.Keep
+		tooOld := rot.MaxAge > 0 && now.Sub(a.Time) > rot.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := st.Delete(ctx, a.Name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, a.Name)
+	}
+	return removed, nil
+}
+
+// Scheduler backs up the database every Interval and prunes the archives
+// after each backup.
+type Scheduler struct {
+	Logger   *slog.Logger
+	DBPath   string
+	Storage  Storage
+	Interval time.Duration
+	Options  Options
+	Rotation Rotation
+}
+
+// Run takes backups until ctx is done. The first one is taken an Interval
+// after start, so restarts do not pile them up.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.backup(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) backup(ctx context.Context) {
+	start := time.Now()
+	opts := s.Options
+	opts.Now = start
+	name, m, err := Create(ctx, s.DBPath, s.Storage, opts)
+	if err != nil {
+		s.Logger.Error("Scheduled backup failed", "error", err)
+		return
+	}
+	s.Logger.Info("Database backed up", "archive", name, "database_bytes", m.Database.Bytes,
+		"recordings", len(m.Recordings), "duration", time.Since(start).Round(time.Millisecond))
+
+	removed, err := Prune(ctx, s.Storage, s.Rotation, start)
+	if err != nil {
+		s.Logger.Error("Failed to prune backups", "error", err)
+	}
+	for _, name := range removed {
+		s.Logger.Info("Removed old backup", "archive", name)
+	}
+}
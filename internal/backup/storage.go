@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Object is a file in a Storage.
+type Object struct {
+	Name  string
+	Bytes int64
+}
+
+// Storage keeps backup archives. Names are plain file names, without
+// directories.
+type Storage interface {
+	// Put stores r under name, replacing nothing: a name is written once.
+	Put(ctx context.Context, name string, r io.Reader) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	List(ctx context.Context) ([]Object, error)
+	Delete(ctx context.Context, name string) error
+}
+
+func checkName(name string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid backup name %q", name)
+	}
+	return nil
+}
+
+// Dir keeps archives in a local directory, which may be a mounted network
+// share.
+type Dir struct {
+	Path string
+}
+
+func (d *Dir) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.Path, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(d.Path, name)
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("backup %s already exists", name)
+	}
+	// Written aside and renamed, so a listed archive is always complete
+	tmp := filepath.Join(d.Path, "."+name+".tmp")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (d *Dir) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(d.Path, name))
+}
+
+func (d *Dir) List(ctx context.Context) ([]Object, error) {
+	entries, err := os.ReadDir(d.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Object
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, Object{Name: e.Name(), Bytes: info.Size()})
+	}
+	return list, nil
+}
+
+func (d *Dir) Delete(ctx context.Context, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(d.Path, name))
+}
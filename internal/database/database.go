@@ -2,56 +2,79 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
+	"runtime"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var DB *sql.DB
+var DB *Handle
 
-func InitDB(filepath string) {
+// sqliteParams are set on every SQLite connection. WAL lets readers run
+// while a recording is written; the busy timeout makes a connection wait
+// for a lock instead of failing at once, which only the migrations and the
+// command line tools running next to the server still need. Foreign keys
+// are enabled per connection so every one of them enforces ON DELETE
+// CASCADE, not just the first.
+const sqliteParams = "_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL"
+
+// Open connects to the database without changing its schema; call Migrate
+// to bring the schema up to date. For SQLite dsn is the file path, for
+// PostgreSQL a connection URL.
+//
+// A SQLite database gets one connection for writes, whose transactions take
+// the write lock when they begin, and a pool of read-only connections, one
+// per CPU but at least four.
+func Open(dialect Dialect, dsn string) error {
+	var h *Handle
 	var err error
-	DB, err = sql.Open("sqlite3", filepath)
+	switch dialect {
+	case SQLite:
+		h, err = openSQLite(dsn)
+	case Postgres:
+		var db *sql.DB
+		if db, err = sql.Open("postgres", dsn); err == nil {
+			h = &Handle{DB: db, Dialect: Postgres}
+		}
+	default:
+		return fmt.Errorf("unknown database driver %q; use sqlite or postgres", dialect)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	if _, err := DB.Exec("PRAGMA foreign_keys = ON;"); err != nil {
-		log.Fatal("Failed to enable foreign keys:", err)
+	if err := h.Ping(); err != nil {
+		h.Close()
+		return err
 	}
-
-	if err = DB.Ping(); err != nil {
-		log.Fatal(err)
+	if h.read != nil {
+		if err := h.read.Ping(); err != nil {
+			h.Close()
+			return err
+		}
 	}
-
-	createTables()
+	DB = h
+	return nil
 }
 
-func createTables() {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		mobile TEXT NOT NULL UNIQUE,
-		password_hash TEXT NOT NULL,
-		role TEXT NOT NULL DEFAULT 'user',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	sessionTable := `
-	CREATE TABLE IF NOT EXISTS sessions (
-		id TEXT PRIMARY KEY,
-		user_id INTEGER NOT NULL,
-		name TEXT,
-		status TEXT DEFAULT 'active',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);`
-
-	if _, err := DB.Exec(userTable); err != nil {
-		log.Fatal("Error creating users table:", err)
+func openSQLite(path string) (*Handle, error) {
+	write, err := sql.Open("sqlite3", path+"?"+sqliteParams+"&_txlock=immediate")
+	if err != nil {
+		return nil, err
 	}
+	write.SetMaxOpenConns(1)
+	// The one connection is kept open; a new one would redo the pragmas
+	write.SetConnMaxIdleTime(0)
+	write.SetConnMaxLifetime(0)
 
-	if _, err := DB.Exec(sessionTable); err != nil {
-		log.Fatal("Error creating sessions table:", err)
+	read, err := sql.Open("sqlite3", path+"?"+sqliteParams+"&_query_only=1")
+	if err != nil {
+		write.Close()
+		return nil, err
 	}
+	readers := max(4, runtime.NumCPU())
+	read.SetMaxOpenConns(readers)
+	read.SetMaxIdleConns(readers)
+
+	return &Handle{DB: write, Dialect: SQLite, read: read}, nil
 }
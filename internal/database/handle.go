@@ -0,0 +1,305 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dialect is the SQL flavour of a database.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// Handle is a database pool that accepts SQLite style queries on either
+// dialect: on PostgreSQL, ? placeholders become $1, $2, ... and bool
+// arguments become the 0/1 integers both schemas use for flags.
+//
+// Statements starting with SELECT run on the read pool, everything else on
+// the embedded *sql.DB. For SQLite that is a single connection, so writers
+// queue in Go instead of failing with "database is locked", while readers
+// run alongside them on the WAL. Without a read pool, as for PostgreSQL,
+// everything runs on the embedded pool.
+type Handle struct {
+	*sql.DB
+	Dialect Dialect
+
+	// Timeout bounds every statement run through the Handle, on top of the
+	// deadline of its context. Zero means no bound. Transactions are bounded
+	// by the context they were begun with only.
+	Timeout time.Duration
+
+	read *sql.DB
+}
+
+// Tx is a transaction of a Handle, rewriting queries the same way.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+// Row is the result of QueryRow. Its Scan ends the statement's timeout.
+type Row struct {
+	row    *sql.Row
+	err    error
+	cancel context.CancelFunc
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	if r.err != nil {
+		return r.err
+	}
+	return r.row.Scan(dest...)
+}
+
+func (r *Row) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.row.Err()
+}
+
+// Rows is the result of Query. Its Close ends the statement's timeout.
+type Rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// rebindQuery rewrites the placeholders of a query for the dialect.
+func rebindQuery(d Dialect, query string) string {
+	if d != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n, quoted := 0, false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// rebindArgs converts the arguments of a query for the dialect.
+func rebindArgs(d Dialect, args []interface{}) []interface{} {
+	if d != Postgres {
+		return args
+	}
+	out := make([]interface{}, len(args))
+	for i, a := range args {
+		out[i] = a
+		if v, ok := a.(bool); ok {
+			out[i] = 0
+			if v {
+				out[i] = 1
+			}
+		}
+	}
+	return out
+}
+
+// isRead reports whether a statement only reads, and may run on the read
+// pool. INSERT ... RETURNING and the like start with their verb and write.
+func isRead(query string) bool {
+	q := strings.TrimSpace(query)
+	return len(q) >= 6 && strings.EqualFold(q[:6], "SELECT")
+}
+
+// pool returns the pool a statement runs on.
+func (h *Handle) pool(query string) *sql.DB {
+	if h.read != nil && isRead(query) {
+		return h.read
+	}
+	return h.DB
+}
+
+func (h *Handle) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, h.Timeout)
+}
+
+// Close closes both pools.
+func (h *Handle) Close() error {
+	if h.read != nil {
+		h.read.Close()
+	}
+	return h.DB.Close()
+}
+
+// PoolStats returns the statistics of the write pool and of the read pool,
+// which are the same for a Handle without one.
+func (h *Handle) PoolStats() (write, read sql.DBStats) {
+	write = h.DB.Stats()
+	if h.read == nil {
+		return write, write
+	}
+	return write, h.read.Stats()
+}
+
+func (h *Handle) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return h.ExecContext(context.Background(), query, args...)
+}
+
+func (h *Handle) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := h.withTimeout(ctx)
+	defer cancel()
+	return h.pool(query).ExecContext(ctx, rebindQuery(h.Dialect, query), rebindArgs(h.Dialect, args)...)
+}
+
+func (h *Handle) Query(query string, args ...interface{}) (*Rows, error) {
+	return h.QueryContext(context.Background(), query, args...)
+}
+
+func (h *Handle) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := h.withTimeout(ctx)
+	rows, err := h.pool(query).QueryContext(ctx, rebindQuery(h.Dialect, query), rebindArgs(h.Dialect, args)...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (h *Handle) QueryRow(query string, args ...interface{}) *Row {
+	return h.QueryRowContext(context.Background(), query, args...)
+}
+
+func (h *Handle) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, cancel := h.withTimeout(ctx)
+	row := h.pool(query).QueryRowContext(ctx, rebindQuery(h.Dialect, query), rebindArgs(h.Dialect, args)...)
+	return &Row{row: row, cancel: cancel}
+}
+
+func (h *Handle) Prepare(query string) (*sql.Stmt, error) {
+	return h.PrepareContext(context.Background(), query)
+}
+
+func (h *Handle) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return h.pool(query).PrepareContext(ctx, rebindQuery(h.Dialect, query))
+}
+
+func (h *Handle) Begin() (*Tx, error) {
+	return h.BeginTx(context.Background(), nil)
+}
+
+func (h *Handle) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := h.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: h.Dialect}, nil
+}
+
+// Stmt is a constant query that is prepared on first use and reused after,
+// for the statements run on every request. It is safe for concurrent use.
+type Stmt struct {
+	h     *Handle
+	query string
+
+	mu   sync.Mutex
+	stmt *sql.Stmt
+}
+
+// Stmt returns query as a statement to prepare on first use. A failed
+// preparation is tried again on the next use.
+func (h *Handle) Stmt(query string) *Stmt {
+	return &Stmt{h: h, query: query}
+}
+
+func (s *Stmt) prepared(ctx context.Context) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stmt == nil {
+		stmt, err := s.h.pool(s.query).PrepareContext(ctx, rebindQuery(s.h.Dialect, s.query))
+		if err != nil {
+			return nil, err
+		}
+		s.stmt = stmt
+	}
+	return s.stmt, nil
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := s.h.withTimeout(ctx)
+	defer cancel()
+	stmt, err := s.prepared(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, rebindArgs(s.h.Dialect, args)...)
+}
+
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	ctx, cancel := s.h.withTimeout(ctx)
+	stmt, err := s.prepared(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, rebindArgs(s.h.Dialect, args)...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *Row {
+	ctx, cancel := s.h.withTimeout(ctx)
+	stmt, err := s.prepared(ctx)
+	if err != nil {
+		return &Row{err: err, cancel: cancel}
+	}
+	return &Row{row: stmt.QueryRowContext(ctx, rebindArgs(s.h.Dialect, args)...), cancel: cancel}
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, rebindQuery(tx.dialect, query), rebindArgs(tx.dialect, args)...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*Rows, error) {
+	return tx.QueryContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	rows, err := tx.Tx.QueryContext(ctx, rebindQuery(tx.dialect, query), rebindArgs(tx.dialect, args)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{Rows: rows, cancel: func() {}}, nil
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	row := tx.Tx.QueryRowContext(ctx, rebindQuery(tx.dialect, query), rebindArgs(tx.dialect, args)...)
+	return &Row{row: row, cancel: func() {}}
+}
@@ -0,0 +1,205 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+	"github.com/zamibd/a2web/internal/store/sqlstore"
+)
+
+// The benchmarks put a SQLite database under the load of a server with
+// live sessions: recordings are started, finalized and deleted again, as
+// source connections and retention do, while pages load a user, a session
+// and a page of recordings. Each runs the measured side with
+// b.RunParallel and the other side in the background, on a database opened
+// one of three ways:
+//
+//   - legacy: the way the server used to, with a rollback journal and one
+//     shared pool with the driver's defaults
+//   - writer: WAL, with every statement on the single writer connection
+//   - read-pool: the way it does now, with reads on the read pool
+//
+// Failed operations, mostly "database is locked" once the busy timeout
+// runs out on the legacy database, are reported as errors/op rather than
+// failing the benchmark:
+//
+//	go test -run - -bench . -benchtime 5s ./internal/database
+var setups = []string{"legacy", "writer", "read-pool"}
+
+// bench is a database with one user and one session of theirs.
+type bench struct {
+	st      *store.Store
+	user    models.User
+	session models.Session
+}
+
+// open creates a database with the current schema in a temporary
+// directory and opens it the way setup names.
+func open(b *testing.B, setup string) *bench {
+	b.Helper()
+	path := filepath.Join(b.TempDir(), "a2web.db")
+	if err := database.Open(database.SQLite, path); err != nil {
+		b.Fatal(err)
+	}
+	tuned := database.DB
+	b.Cleanup(func() { tuned.Close() })
+	if _, err := database.Migrate(); err != nil {
+		b.Fatal(err)
+	}
+
+	db := tuned
+	switch setup {
+	case "legacy":
+		legacy, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { legacy.Close() })
+		tuned.Close()
+		// The journal mode is kept in the file, so it is turned back
+		if _, err := legacy.Exec("PRAGMA journal_mode = DELETE"); err != nil {
+			b.Fatal(err)
+		}
+		db = &database.Handle{DB: legacy, Dialect: database.SQLite}
+	case "writer":
+		// A Handle without a read pool runs everything on its own
+		db = &database.Handle{DB: tuned.DB, Dialect: database.SQLite}
+	}
+
+	ctx := context.Background()
+	bn := &bench{st: sqlstore.New(db)}
+	bn.user = models.User{Mobile: "01700000000", PasswordHash: "-"}
+	if err := bn.st.Users.Create(ctx, &bn.user); err != nil {
+		b.Fatal(err)
+	}
+	bn.session = models.Session{ID: "bench", UserID: bn.user.ID, Name: "Bench", Settings: models.DefaultSessionSettings}
+	if err := bn.st.Sessions.Create(ctx, &bn.session); err != nil {
+		b.Fatal(err)
+	}
+	// Pages list recordings, so there are some from the start
+	for range 20 {
+		if err := bn.record(ctx, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return bn
+}
+
+// record starts and finalizes a recording. Unless keep is set, it then
+// deletes it, as retention does, so the table keeps its size and the
+// timings do not depend on how long a benchmark runs.
+func (bn *bench) record(ctx context.Context, keep bool) error {
+	rec := models.Recording{SessionID: bn.session.ID, Status: "recording", StartedAt: time.Now()}
+	if err := bn.st.Recordings.Create(ctx, &rec); err != nil {
+		return err
+	}
+	if err := bn.st.Recordings.Finish(ctx, rec.ID, 4096, time.Now()); err != nil || keep {
+		return err
+	}
+	return bn.st.Recordings.Delete(ctx, rec.ID)
+}
+
+// page runs the queries of an authenticated page.
+func (bn *bench) page(ctx context.Context) error {
+	if _, err := bn.st.Users.Get(ctx, bn.user.ID); err != nil {
+		return err
+	}
+	if _, err := bn.st.Sessions.Get(ctx, bn.session.ID); err != nil {
+		return err
+	}
+	_, _, err := bn.st.Recordings.ListByUser(ctx, bn.user.ID, "", 20, 0)
+	return err
+}
+
+// background runs n goroutines calling fn until the returned function is
+// called.
+func background(b *testing.B, n int, fn func() error) (stop func()) {
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				if err := fn(); err != nil && !isLocked(err) {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		done.Store(true)
+		wg.Wait()
+	}
+}
+
+// parallel runs fn with b.RunParallel and reports the share of calls that
+// failed because the database was locked.
+func parallel(b *testing.B, fn func() error) {
+	var errs atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := fn(); err != nil {
+				if !isLocked(err) {
+					b.Error(err)
+					return
+				}
+				errs.Add(1)
+			}
+		}
+	})
+	b.ReportMetric(float64(errs.Load())/float64(b.N), "errors/op")
+}
+
+func isLocked(err error) bool {
+	var e sqlite3.Error
+	if errors.As(err, &e) {
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	}
+	return strings.Contains(err.Error(), "database is locked")
+}
+
+// BenchmarkPages loads pages while four sources record.
+func BenchmarkPages(b *testing.B) {
+	for _, setup := range setups {
+		b.Run(setup, func(b *testing.B) {
+			bn := open(b, setup)
+			ctx := context.Background()
+			stop := background(b, 4, func() error { return bn.record(ctx, false) })
+			defer stop()
+
+			b.ResetTimer()
+			parallel(b, func() error { return bn.page(ctx) })
+			b.StopTimer()
+		})
+	}
+}
+
+// BenchmarkRecordings records while sixteen pages load.
+func BenchmarkRecordings(b *testing.B) {
+	for _, setup := range setups {
+		b.Run(setup, func(b *testing.B) {
+			bn := open(b, setup)
+			ctx := context.Background()
+			stop := background(b, 16, func() error { return bn.page(ctx) })
+			defer stop()
+
+			b.ResetTimer()
+			parallel(b, func() error { return bn.record(ctx, false) })
+			b.StopTimer()
+		})
+	}
+}
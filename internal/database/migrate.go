@@ -0,0 +1,320 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are SQL files named NNNN_name.sql, one directory per dialect,
+// applied in order of their number. Each dialect has its own sequence. A
+// released migration is never edited; changes go into a new file.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration is one step of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus is a migration together with when it was applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Unknown is set for a migration the database has but this build does
+	// not, written by a newer release.
+	Unknown bool
+}
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// release. Running against it could corrupt data the older code does not
+// understand.
+var ErrSchemaTooNew = errors.New("database schema is newer than this server")
+
+var migrations = map[Dialect][]Migration{
+	SQLite:   loadMigrations(SQLite),
+	Postgres: loadMigrations(Postgres),
+}
+
+func loadMigrations(d Dialect) []Migration {
+	dir := path.Join("migrations", string(d))
+	files, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		panic(err)
+	}
+	var list []Migration
+	for _, f := range files {
+		num, name, ok := strings.Cut(strings.TrimSuffix(f.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			panic("database: bad migration file name " + path.Join(dir, f.Name()))
+		}
+		body, err := migrationFiles.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		list = append(list, Migration{Version: version, Name: name, SQL: string(body)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	for i := 1; i < len(list); i++ {
+		if list[i].Version == list[i-1].Version {
+			panic(fmt.Sprintf("database: two %s migrations numbered %d", d, list[i].Version))
+		}
+	}
+	return list
+}
+
+// LatestVersion is the schema version this build migrates a database of
+// dialect d to.
+func LatestVersion(d Dialect) int {
+	list := migrations[d]
+	return list[len(list)-1].Version
+}
+
+const migrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+);`
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row
+}
+
+func hasTable(ctx context.Context, q querier, d Dialect, name string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if d == Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var n int
+	err := q.QueryRowContext(ctx, query, name).Scan(&n)
+	return n > 0, err
+}
+
+// SchemaVersion returns the newest migration applied to the database, 0
+// for an empty database or one created before migrations.
+func (h *Handle) SchemaVersion() (int, error) {
+	ctx := context.Background()
+	ok, err := hasTable(ctx, h, h.Dialect, "schema_migrations")
+	if err != nil || !ok {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err = h.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// Status lists every migration this build knows and every one the database
+// has applied, in order. It does not change the database.
+func Status() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	applied := map[int]MigrationStatus{}
+	ok, err := hasTable(ctx, DB, DB.Dialect, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		rows, err := DB.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var s MigrationStatus
+			if err := rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+				return nil, err
+			}
+			s.Applied, s.Unknown = true, true
+			applied[s.Version] = s
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var list []MigrationStatus
+	for _, m := range migrations[DB.Dialect] {
+		s := MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			s.Applied, s.AppliedAt = true, a.AppliedAt
+			delete(applied, m.Version)
+		}
+		list = append(list, s)
+	}
+	for _, s := range applied {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// pending returns the migrations the database has not applied yet, or
+// ErrSchemaTooNew.
+func pending() ([]Migration, error) {
+	version, err := DB.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	latest := LatestVersion(DB.Dialect)
+	if version > latest {
+		return nil, fmt.Errorf("%w: database is at version %d, this server knows up to %d", ErrSchemaTooNew, version, latest)
+	}
+	var list []Migration
+	for _, m := range migrations[DB.Dialect] {
+		if m.Version > version {
+			list = append(list, m)
+		}
+	}
+	return list, nil
+}
+
+// Migrate applies the pending migrations in order, each in its own
+// transaction, and returns the ones it applied. A migration that fails is
+// rolled back and stops the run; the ones before it stay applied.
+func Migrate() ([]Migration, error) {
+	return migrate(false)
+}
+
+// DryRun applies the pending migrations in a single transaction and rolls
+// it back, returning the migrations that would be applied. It fails where
+// Migrate would.
+func DryRun() ([]Migration, error) {
+	return migrate(true)
+}
+
+func migrate(dryRun bool) ([]Migration, error) {
+	list, err := pending()
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if DB.Dialect == SQLite {
+		// Rebuilding a table means dropping it, which with foreign keys on
+		// would cascade into the tables referencing it. SQLite ignores this
+		// pragma inside a transaction, so it is turned off for the whole
+		// connection and the keys are checked before each commit instead.
+		// PostgreSQL needs none of this: its DDL is transactional.
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	if !dryRun {
+		if _, err := conn.ExecContext(ctx, migrationsTable); err != nil {
+			return nil, err
+		}
+	}
+
+	var tx *Tx
+	for _, m := range list {
+		if tx == nil {
+			sqlTx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				return nil, err
+			}
+			tx = &Tx{Tx: sqlTx, dialect: DB.Dialect}
+		}
+		if err := apply(ctx, tx, m, dryRun); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if !dryRun {
+			if err := tx.Commit(); err != nil {
+				return nil, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			tx = nil
+		}
+	}
+	if dryRun {
+		tx.Rollback()
+	}
+	return list, nil
+}
+
+func apply(ctx context.Context, tx *Tx, m Migration, dryRun bool) error {
+	if tx.dialect == SQLite && m.Version == 1 {
+		if err := adoptLegacy(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	if tx.dialect == SQLite {
+		if err := checkForeignKeys(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().UTC())
+	return err
+}
+
+// adoptLegacy prepares a database created before migrations for the first
+// one. Its tables already exist, so 0001 leaves them alone, but users may
+// predate the columns added to it later.
+func adoptLegacy(ctx context.Context, tx *Tx) error {
+	ok, err := hasTable(ctx, tx, SQLite, "users")
+	if err != nil || !ok {
+		return err
+	}
+	for _, c := range []struct{ name, definition string }{
+		{"disabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"tokens_valid_after", "DATETIME"},
+	} {
+		var n int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = ?", c.name).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "ALTER TABLE users ADD COLUMN "+c.name+" "+c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkForeignKeys fails when a row references a missing parent, which the
+// migration would otherwise leave behind with the keys turned off.
+func checkForeignKeys(ctx context.Context, tx *Tx) error {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fk int
+		if err := rows.Scan(&table, &rowid, &parent, &fk); err != nil {
+			return err
+		}
+		return fmt.Errorf("row %d of %s references a missing row of %s", rowid.Int64, table, parent)
+	}
+	return rows.Err()
+}
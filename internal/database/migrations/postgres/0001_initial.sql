@@ -0,0 +1,174 @@
+-- The schema of the SQLite migrations up to 0002, in PostgreSQL types.
+-- Flags stay INTEGER 0/1 so the same queries run on both databases.
+
+CREATE TABLE users (
+	id BIGSERIAL PRIMARY KEY,
+	mobile TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL DEFAULT 'user',
+	disabled INTEGER NOT NULL DEFAULT 0,
+	tokens_valid_after TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	name TEXT,
+	status TEXT DEFAULT 'active',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE recordings (
+	id BIGSERIAL PRIMARY KEY,
+	session_id TEXT NOT NULL,
+	path TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'recording',
+	bytes BIGINT NOT NULL DEFAULT 0,
+	started_at TIMESTAMPTZ NOT NULL,
+	ended_at TIMESTAMPTZ,
+	FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_recordings_session ON recordings(session_id, started_at);
+
+CREATE TABLE webhooks (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL,
+	active INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id BIGINT NOT NULL,
+	event TEXT NOT NULL,
+	payload BYTEA NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	response_status INTEGER,
+	last_error TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+CREATE TABLE alert_rules (
+	id BIGSERIAL PRIMARY KEY,
+	session_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	threshold DOUBLE PRECISION NOT NULL DEFAULT 0,
+	duration_seconds INTEGER NOT NULL DEFAULT 0,
+	cooldown_seconds INTEGER NOT NULL DEFAULT 300,
+	enabled INTEGER NOT NULL DEFAULT 1,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE alerts (
+	id BIGSERIAL PRIMARY KEY,
+	rule_id BIGINT,
+	session_id TEXT NOT NULL,
+	user_id BIGINT NOT NULL,
+	kind TEXT NOT NULL,
+	message TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	acknowledged_at TIMESTAMPTZ,
+	FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alerts_user ON alerts(user_id, created_at);
+
+CREATE TABLE push_subscriptions (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	endpoint TEXT NOT NULL UNIQUE,
+	p256dh TEXT NOT NULL,
+	auth TEXT NOT NULL,
+	device_name TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE push_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	subscription_id BIGINT NOT NULL,
+	payload BYTEA NOT NULL,
+	urgency TEXT NOT NULL DEFAULT 'normal',
+	ttl INTEGER NOT NULL DEFAULT 86400,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(subscription_id) REFERENCES push_subscriptions(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_push_deliveries_due ON push_deliveries(status, next_attempt_at);
+
+CREATE TABLE notification_prefs (
+	user_id BIGINT PRIMARY KEY,
+	email TEXT NOT NULL DEFAULT '',
+	telegram_chat_id TEXT NOT NULL DEFAULT '',
+	quiet_start TEXT NOT NULL DEFAULT '',
+	quiet_end TEXT NOT NULL DEFAULT '',
+	timezone TEXT NOT NULL DEFAULT '',
+	events TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE TABLE notification_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	channel TEXT NOT NULL,
+	address TEXT NOT NULL,
+	event TEXT NOT NULL,
+	priority TEXT NOT NULL DEFAULT 'normal',
+	subject TEXT NOT NULL,
+	body TEXT NOT NULL,
+	url TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_notification_outbox_due ON notification_outbox(status, next_attempt_at);
+
+CREATE TABLE api_tokens (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	prefix TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Audit events outlive the users and sessions they name, so there are no
+-- foreign keys
+CREATE TABLE audit_events (
+	id BIGSERIAL PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	actor_id BIGINT,
+	actor TEXT NOT NULL DEFAULT '',
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL DEFAULT '',
+	target_id TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	outcome TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	prev_hash TEXT NOT NULL,
+	hash TEXT NOT NULL
+);
+CREATE INDEX idx_audit_events_action ON audit_events(action, id);
+CREATE INDEX idx_audit_events_actor ON audit_events(actor_id, id);
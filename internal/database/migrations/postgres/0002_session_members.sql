@@ -0,0 +1,31 @@
+-- Users other than its owner may be given a role in a session: listener or
+-- viewer. The owner stays sessions.user_id. Invitations offer a role to a
+-- mobile number or, through a link, to whoever opens it first; the link's
+-- token is stored hashed.
+
+CREATE TABLE session_members (
+	session_id TEXT NOT NULL,
+	user_id BIGINT NOT NULL,
+	role TEXT NOT NULL,
+	invited_by BIGINT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (session_id, user_id),
+	FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_session_members_user ON session_members(user_id);
+
+CREATE TABLE session_invites (
+	id BIGSERIAL PRIMARY KEY,
+	session_id TEXT NOT NULL,
+	mobile TEXT,
+	token_hash TEXT UNIQUE,
+	role TEXT NOT NULL,
+	invited_by BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE,
+	FOREIGN KEY(invited_by) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_session_invites_session ON session_invites(session_id);
+CREATE INDEX idx_session_invites_mobile ON session_invites(mobile);
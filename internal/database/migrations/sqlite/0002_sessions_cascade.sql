@@ -0,0 +1,19 @@
+-- Sessions are deleted with their owner, and with them their recordings,
+-- alert rules and alerts. SQLite cannot change a foreign key in place, so
+-- the table is rebuilt.
+
+CREATE TABLE sessions_new (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT,
+	status TEXT DEFAULT 'active',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO sessions_new (id, user_id, name, status, created_at)
+	SELECT id, user_id, name, status, created_at FROM sessions;
+
+DROP TABLE sessions;
+
+ALTER TABLE sessions_new RENAME TO sessions;
@@ -0,0 +1,30 @@
+-- Organizations, such as a daycare, own sessions as their rooms. Their
+-- members are admins, who manage the rooms, staff, who use all of them,
+-- and guardians, who use the rooms shared with them through
+-- session_members. A room's user_id is always one of its organization's
+-- admins. Quotas of 0 are unlimited.
+
+CREATE TABLE organizations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	max_rooms INTEGER NOT NULL DEFAULT 0,
+	max_members INTEGER NOT NULL DEFAULT 0,
+	max_storage_bytes INTEGER NOT NULL DEFAULT 0,
+	invite_links INTEGER NOT NULL DEFAULT 1,
+	guardian_recordings INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE org_members (
+	org_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	role TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (org_id, user_id),
+	FOREIGN KEY(org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_org_members_user ON org_members(user_id);
+
+ALTER TABLE sessions ADD COLUMN org_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE;
+CREATE INDEX idx_sessions_org ON sessions(org_id);
@@ -0,0 +1,14 @@
+-- Editable sessions. The kid link carries broadcast_id instead of the
+-- session ID, so it can be rotated while the session, its members and its
+-- recordings stay; existing sessions keep their links. audio_bitrate is in
+-- bits per second, 0 leaves it to the browser.
+
+ALTER TABLE sessions ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN broadcast_id TEXT;
+UPDATE sessions SET broadcast_id = id;
+CREATE UNIQUE INDEX idx_sessions_broadcast ON sessions(broadcast_id);
+ALTER TABLE sessions ADD COLUMN recording INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE sessions ADD COLUMN audio_bitrate INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE sessions ADD COLUMN echo_cancellation INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE sessions ADD COLUMN noise_suppression INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE sessions ADD COLUMN auto_gain_control INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,191 @@
+package events
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types published on the bus.
+const (
+	SessionState   = "session.state"
+	SessionCreated = "session.created"
+	SessionUpdated = "session.updated"
+	SessionDeleted = "session.deleted"
+	StreamStarted  = "stream.started"
+	StreamStopped  = "stream.stopped"
+	AlertRaised    = "alert.raised"
+	AlertAcked     = "alert.acknowledged"
+
+	RecordingFinalized = "recording.finalized"
+	RecordingDeleted   = "recording.deleted"
+
+	// Account events. UserID is the account concerned.
+	UserLogin    = "user.login"
+	DevicePaired = "device.paired"
+
+	// Sharing events. UserID is the user given a role, losing it or
+	// invited. Member events without a SessionID are about a role in an
+	// organization.
+	MemberAdded   = "member.added"
+	MemberRemoved = "member.removed"
+	InviteCreated = "invite.created"
+)
+
+// Event is a single notification published by the hub or the handlers.
+// UserID is the owner of the session the event concerns and is used by
+// subscribers to decide who may see it. The members of a session may see
+// its events too, which subscribers have to look up.
+type Event struct {
+	ID        uint64      `json:"id"`
+	Type      string      `json:"type"`
+	SessionID string      `json:"session_id,omitempty"`
+	UserID    int64       `json:"user_id,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// VisibleTo reports whether a user may see the event. Admins see everything,
+// everyone else only events about their own sessions.
+func (e Event) VisibleTo(userID int64, admin bool) bool {
+	return admin || e.UserID == userID
+}
+
+// Bus is an in-process publish/subscribe fan-out with a bounded replay
+// history. Publishing never blocks: a subscriber whose buffer is full misses
+// the event.
+//
+// Event IDs count up from 1 in every process, so the IDs handed to clients
+// carry the bus's epoch as well (see FormatID): after a restart a client's
+// last ID belongs to another epoch and cannot be mistaken for one of the new
+// events.
+type Bus struct {
+	mu      sync.Mutex
+	epoch   string
+	nextID  uint64
+	subs    map[chan Event]struct{}
+	history []Event // ring buffer of the most recent events
+	head    int     // index of the oldest event once history is full
+}
+
+// NewBus creates a bus that remembers the last historySize events for
+// SubscribeFrom.
+func NewBus(historySize int) *Bus {
+	return &Bus{
+		epoch:   strconv.FormatInt(newEpoch(), 10),
+		subs:    make(map[chan Event]struct{}),
+		history: make([]Event, 0, historySize),
+	}
+}
+
+// lastEpoch is the epoch of the newest bus.
+var lastEpoch atomic.Int64
+
+// newEpoch returns the current time in nanoseconds, or one more than the
+// epoch of the previous bus if the clock has not moved on since.
+func newEpoch() int64 {
+	for {
+		last := lastEpoch.Load()
+		epoch := max(time.Now().UnixNano(), last+1)
+		if lastEpoch.CompareAndSwap(last, epoch) {
+			return epoch
+		}
+	}
+}
+
+// FormatID returns the ID of an event of this bus as handed to clients:
+// "<epoch>-<id>".
+func (b *Bus) FormatID(id uint64) string {
+	return b.epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// Publish assigns the event an ID and timestamp and delivers it to every
+// subscriber.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if cap(b.history) > 0 {
+		if len(b.history) < cap(b.history) {
+			b.history = append(b.history, e)
+		} else {
+			b.history[b.head] = e
+			b.head = (b.head + 1) % len(b.history)
+		}
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all future events and a function that
+// cancels the subscription and closes the channel.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch, cancel, _ := b.SubscribeFrom("", buffer)
+	return ch, cancel
+}
+
+// SubscribeFrom is Subscribe with replay: when lastID, as returned by
+// FormatID, is not empty, events published after it are queued on the
+// channel first. complete is false if some of those events have already
+// fallen out of the history, in which case the caller should resynchronise
+// from its source of truth. An ID of another epoch, such as one from before
+// a restart, or a malformed one replays nothing and is incomplete.
+func (b *Bus) SubscribeFrom(lastID string, buffer int) (ch <-chan Event, cancel func(), complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	complete = true
+	if lastID != "" {
+		epoch, seq, _ := strings.Cut(lastID, "-")
+		id, err := strconv.ParseUint(seq, 10, 64)
+		switch {
+		case epoch != b.epoch || err != nil || id > b.nextID:
+			complete = false
+		case id < b.nextID:
+			replay, complete = b.since(id)
+		}
+	}
+
+	c := make(chan Event, buffer+len(replay))
+	for _, e := range replay {
+		c <- e
+	}
+	b.subs[c] = struct{}{}
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, c)
+			b.mu.Unlock()
+			close(c)
+		})
+	}, complete
+}
+
+// since returns the events in the history published after lastID and
+// whether none are missing. The caller holds b.mu.
+func (b *Bus) since(lastID uint64) ([]Event, bool) {
+	var replay []Event
+	for i := 0; i < len(b.history); i++ {
+		e := b.history[(b.head+i)%len(b.history)]
+		if e.ID > lastID {
+			replay = append(replay, e)
+		}
+	}
+	return replay, len(replay) > 0 && replay[0].ID == lastID+1
+}
@@ -0,0 +1,74 @@
+package events
+
+import (
+	"strings"
+	"testing"
+)
+
+// published returns a bus with a history of four that had n events
+// published.
+func published(n int) *Bus {
+	b := NewBus(4)
+	for range n {
+		b.Publish(Event{Type: SessionState})
+	}
+	return b
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	// before is the bus of the previous process, whose IDs overlap the
+	// ones of the bus under test.
+	before := published(8)
+
+	tests := []struct {
+		name     string
+		lastID   func(b *Bus) string
+		replay   []uint64
+		complete bool
+	}{
+		{"none", func(*Bus) string { return "" }, nil, true},
+		{"up to date", func(b *Bus) string { return b.FormatID(6) }, nil, true},
+		{"in history", func(b *Bus) string { return b.FormatID(3) }, []uint64{4, 5, 6}, true},
+		{"oldest in history", func(b *Bus) string { return b.FormatID(2) }, []uint64{3, 4, 5, 6}, true},
+		{"out of history", func(b *Bus) string { return b.FormatID(1) }, []uint64{3, 4, 5, 6}, false},
+		{"ahead", func(b *Bus) string { return b.FormatID(7) }, nil, false},
+		{"restart", func(*Bus) string { return before.FormatID(4) }, nil, false},
+		{"restart ahead", func(*Bus) string { return before.FormatID(8) }, nil, false},
+		{"no epoch", func(*Bus) string { return "3" }, nil, false},
+		{"malformed", func(b *Bus) string { return b.FormatID(3) + "x" }, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := published(6)
+			ch, cancel, complete := b.SubscribeFrom(tt.lastID(b), 1)
+			defer cancel()
+
+			if complete != tt.complete {
+				t.Errorf("complete = %v, want %v", complete, tt.complete)
+			}
+			var got []uint64
+			for len(ch) > 0 {
+				got = append(got, (<-ch).ID)
+			}
+			if len(got) != len(tt.replay) {
+				t.Fatalf("replayed %v, want %v", got, tt.replay)
+			}
+			for i := range got {
+				if got[i] != tt.replay[i] {
+					t.Fatalf("replayed %v, want %v", got, tt.replay)
+				}
+			}
+		})
+	}
+}
+
+func TestEpochs(t *testing.T) {
+	a, b := NewBus(0), NewBus(0)
+	if a.FormatID(1) == b.FormatID(1) {
+		t.Fatalf("two buses share the epoch of %s", a.FormatID(1))
+	}
+	epoch, seq, ok := strings.Cut(a.FormatID(42), "-")
+	if !ok || epoch == "" || seq != "42" {
+		t.Fatalf("FormatID(42) = %q", a.FormatID(42))
+	}
+}
@@ -1,80 +1,178 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 
-	"github.com/zamibd/a2web/internal/auth"
-	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/events"
 	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/recordings"
+	"github.com/zamibd/a2web/internal/store"
 )
 
+// AdminMiddleware admits the administrators of the server. They rank
+// above the admins of organizations: besides the users and sessions of
+// everyone, they create organizations, set their quotas and may manage
+// every one of them.
 func (h *Handler) AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("token")
-		if err != nil {
+		if _, err := r.Cookie("token"); err != nil {
 			http.Redirect(w, r, "/login-page", http.StatusFound)
 			return
 		}
 
-		claims, err := auth.ValidateJWT(c.Value)
+		// The account is checked against the database, so a disabled,
+		// signed out or demoted admin loses access at once
+		claims, err := h.authenticate(r, "")
 		if err != nil || claims.Role != string(models.RoleAdmin) {
-			h.Logger.Warn("Admin access denied", "error", err, "role", claims.Role)
+			role := ""
+			if claims != nil {
+				role = claims.Role
+			}
+			h.Logger.Warn("Admin access denied", "error", err, "role", role)
+			if err == nil {
+				h.audit(r, audit.Event{ActorID: claims.UserID, Action: audit.ActionAdminDenied, Outcome: audit.OutcomeDenied, Detail: r.URL.Path})
+			}
 			http.Error(w, "Forbidden: Admin access required", http.StatusForbidden)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withClaims(r, claims))
 	}
 }
 
+// adminPageSize is the number of rows per page of the admin tables.
+const adminPageSize = 25
+
 func (h *Handler) AdminDashboardHandler(w http.ResponseWriter, r *http.Request) {
-	// List Users
-	rows, err := database.DB.Query("SELECT id, mobile, role, created_at FROM users ORDER BY created_at DESC")
+	users, err := h.adminUsers(r)
 	if err != nil {
 		h.Logger.Error("DB Error fetching users", "error", err)
 		http.Error(w, "DB Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var u models.User
-		if err := rows.Scan(&u.ID, &u.Mobile, &u.Role, &u.CreatedAt); err != nil {
-			continue
-		}
-		users = append(users, u)
-	}
-
-	// List Sessions
-	rows2, err := database.DB.Query("SELECT id, user_id, name, status, created_at FROM sessions ORDER BY created_at DESC")
+	sessions, err := h.adminSessions(r)
 	if err != nil {
 		h.Logger.Error("DB Error fetching sessions", "error", err)
 		http.Error(w, "DB Error", http.StatusInternalServerError)
 		return
 	}
-	defer rows2.Close()
-
-	var sessions []models.Session
-	for rows2.Next() {
-		var s models.Session
-		if err := rows2.Scan(&s.ID, &s.UserID, &s.Name, &s.Status, &s.CreatedAt); err != nil {
-			continue
-		}
-		sessions = append(sessions, s)
-	}
 
 	if err := h.Templates["admin.html"].ExecuteTemplate(w, "layout", map[string]interface{}{
 		"Title":    "Admin Dashboard",
 		"Users":    users,
 		"Sessions": sessions,
+		"Actions":  audit.Actions,
 	}); err != nil {
 		h.Logger.Error("Template execution error", "template", "admin.html", "error", err)
 		http.Error(w, "Template Error", http.StatusInternalServerError)
 	}
 }
 
+// AdminUsersHandler renders one page of user rows for the search, sort and
+// "load more" controls. GET /admin/users?q=&sort=&cursor=
+func (h *Handler) AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := h.adminUsers(r)
+	if err != nil {
+		h.Logger.Error("DB Error fetching users", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	h.renderAdmin(w, "user-rows", map[string]interface{}{"Users": users})
+}
+
+// AdminSessionsHandler renders one page of session rows. The admin page
+// also reloads the first page on session and stream events.
+// GET /admin/sessions?q=&sort=&cursor=
+func (h *Handler) AdminSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.adminSessions(r)
+	if err != nil {
+		h.Logger.Error("DB Error fetching sessions", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	h.renderAdmin(w, "session-rows", map[string]interface{}{"Sessions": sessions})
+}
+
+func (h *Handler) renderAdmin(w http.ResponseWriter, name string, data interface{}) {
+	if err := h.Templates["admin.html"].ExecuteTemplate(w, name, data); err != nil {
+		h.Logger.Error("Template execution error", "template", name, "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
+
+// AdminUser is a row of the admin user table.
+type AdminUser struct {
+	store.UserSummary
+	Self bool // the admin looking at the table
+}
+
+// adminList is one page of an admin table with what is needed to ask for
+// the next one.
+type adminList[T any] struct {
+	Rows       []T
+	Query      string
+	Sort       string
+	NextCursor string
+}
+
+// adminQuery reads the search, sort and cursor parameters of an admin
+// table. An unknown sort falls back to the first of sorts.
+func adminQuery(r *http.Request, sorts []string) store.ListQuery {
+	q := store.ListQuery{
+		Search: strings.TrimSpace(r.URL.Query().Get("q")),
+		Sort:   r.URL.Query().Get("sort"),
+		Cursor: r.URL.Query().Get("cursor"),
+		Limit:  adminPageSize,
+	}
+	if !slices.Contains(sorts, q.Sort) {
+		q.Sort = sorts[0]
+	}
+	return q
+}
+
+// adminUsers loads one page of users, searched by mobile number.
+func (h *Handler) adminUsers(r *http.Request) (adminList[AdminUser], error) {
+	q := adminQuery(r, store.UserSorts)
+	list := adminList[AdminUser]{Query: q.Search, Sort: q.Sort}
+	users, next, err := h.Store.Users.Search(r.Context(), q)
+	if err != nil {
+		return list, err
+	}
+	for _, u := range users {
+		list.Rows = append(list.Rows, AdminUser{UserSummary: u, Self: u.ID == requestClaims(r).UserID})
+	}
+	list.NextCursor = next
+	return list, nil
+}
+
+// AdminSession is a row of the admin session table.
+type AdminSession struct {
+	store.SessionSummary
+	Live models.LiveState
+}
+
+// adminSessions loads one page of sessions, searched by session name.
+func (h *Handler) adminSessions(r *http.Request) (adminList[AdminSession], error) {
+	q := adminQuery(r, store.SessionSorts)
+	list := adminList[AdminSession]{Query: q.Search, Sort: q.Sort}
+	sessions, next, err := h.Store.Sessions.Search(r.Context(), q)
+	if err != nil {
+		return list, err
+	}
+	for _, s := range sessions {
+		list.Rows = append(list.Rows, AdminSession{SessionSummary: s, Live: GlobalHub.State(s.ID)})
+	}
+	list.NextCursor = next
+	return list, nil
+}
+
 func (h *Handler) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -87,16 +185,25 @@ func (h *Handler) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete from DB
-	_, err := database.DB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
+	session, err := h.Store.Sessions.Get(r.Context(), sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
+		h.Logger.Error("DB Error fetching session", "error", err)
 		http.Error(w, "DB Error", http.StatusInternalServerError)
 		return
 	}
+	ownerID := session.UserID
 
-	// Delete File
-	_ = os.Remove("./storage/" + sessionID + ".webm")
-	h.Logger.Info("Session deleted", "id", sessionID)
+	if err := h.deleteSession(r.Context(), sessionID, ownerID); err != nil {
+		h.Logger.Error("DB Error deleting session", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, audit.Event{Action: audit.ActionSessionDelete, TargetType: "session", TargetID: sessionID,
+		Detail: "owner_id=" + strconv.FormatInt(ownerID, 10)})
 
 	w.Write([]byte("")) // Return empty to remove element or refresh
 }
@@ -113,29 +220,72 @@ func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete from DB (Sessions should cascade or be handled manually if no Foreign Key cascade)
-	// SQLite supports FK but needs PRAGMA foreign_keys = ON; usually.
-	// For safety, let's delete sessions first.
-
-	// Get session IDs to delete files
-	rows, _ := database.DB.Query("SELECT id FROM sessions WHERE user_id = ?", userID)
-	if rows != nil {
-		for rows.Next() {
-			var sid string
-			rows.Scan(&sid)
-			os.Remove("./storage/" + sid + ".webm")
-		}
-		rows.Close()
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if id == requestClaims(r).UserID {
+		http.Error(w, "You cannot do this to your own account", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Store.Users.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
 	}
-
-	database.DB.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
-	_, err := database.DB.Exec("DELETE FROM users WHERE id = ?", userID)
 	if err != nil {
+		h.Logger.Error("DB Error fetching user", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.deleteUser(r.Context(), id); err != nil {
 		h.Logger.Error("DB Error deleting user", "error", err)
 		http.Error(w, "DB Error", http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, audit.Event{Action: audit.ActionUserDelete, TargetType: "user", TargetID: userID, Detail: "mobile=" + user.Mobile})
 
-	h.Logger.Info("User deleted", "id", userID)
 	w.Write([]byte(""))
 }
+
+// deleteSession removes a session with its recordings and announces it.
+func (h *Handler) deleteSession(ctx context.Context, sessionID string, ownerID int64) error {
+	if err := h.Store.Sessions.Delete(ctx, sessionID); err != nil {
+		return err
+	}
+
+	// Delete Files
+	recordings.RemoveSessionFiles(sessionID)
+	h.Logger.Info("Session deleted", "id", sessionID)
+	GlobalHub.Events.Publish(events.Event{Type: events.SessionDeleted, SessionID: sessionID, UserID: ownerID})
+	return nil
+}
+
+// deleteUser removes a user together with their sessions and recordings.
+func (h *Handler) deleteUser(ctx context.Context, userID int64) error {
+	// Rooms of organizations go to another of their admins where there is
+	// one. The database deletes the user's other sessions, recordings,
+	// tokens and the rest with them; the recording files and live
+	// listeners are ours
+	if err := h.Store.Orgs.HandOver(ctx, userID, 0); err != nil {
+		return err
+	}
+	sessions, _, err := h.Store.Sessions.ListByUser(ctx, userID, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		recordings.RemoveSessionFiles(s.ID)
+	}
+
+	if err := h.Store.Users.Delete(ctx, userID); err != nil {
+		return err
+	}
+
+	h.Logger.Info("User deleted", "id", userID)
+	for _, s := range sessions {
+		GlobalHub.Events.Publish(events.Event{Type: events.SessionDeleted, SessionID: s.ID, UserID: userID})
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// AdminOrg is a row of the admin organization table.
+type AdminOrg struct {
+	store.OrgSummary
+}
+
+// Storage formats the bytes an organization's recordings take up.
+func (o AdminOrg) Storage() string { return formatBytes(o.Usage.StorageBytes) }
+
+// StorageQuotaMB is the storage quota in the unit of the quota form.
+func (o AdminOrg) StorageQuotaMB() int64 { return o.Quotas.MaxStorageBytes >> 20 }
+
+// formQuotas reads the quota fields of the admin forms. Storage is given
+// in MiB; empty fields are unlimited.
+func formQuotas(r *http.Request) (models.OrgQuotas, error) {
+	var q models.OrgQuotas
+	var mb int64
+	for name, dst := range map[string]*int64{"max_storage_mb": &mb} {
+		if v := r.FormValue(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return q, errors.New(name + " must be a number")
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*int{"max_rooms": &q.MaxRooms, "max_members": &q.MaxMembers} {
+		if v := r.FormValue(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return q, errors.New(name + " must be a number")
+			}
+			*dst = n
+		}
+	}
+	q.MaxStorageBytes = mb << 20
+	return q, validQuotas(q)
+}
+
+// renderOrgRows renders the organization table, with formError above it.
+func (h *Handler) renderOrgRows(w http.ResponseWriter, r *http.Request, formError string) {
+	list, err := h.Store.Orgs.List(r.Context())
+	if err != nil {
+		h.Logger.Error("DB Error fetching organizations", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	var orgs []AdminOrg
+	for _, o := range list {
+		orgs = append(orgs, AdminOrg{o})
+	}
+	h.renderAdmin(w, "org-rows", map[string]interface{}{"Orgs": orgs, "Error": formError})
+}
+
+// AdminOrgsHandler lists the organizations and creates them.
+//
+//	GET  /admin/orgs    the table rows
+//	POST /admin/orgs    create (form: name, admin_mobile, max_rooms, max_members, max_storage_mb)
+func (h *Handler) AdminOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		quotas, err := formQuotas(r)
+		if err != nil {
+			h.renderOrgRows(w, r, err.Error())
+			return
+		}
+		_, invalid, err := h.createOrg(r, CreateOrgRequest{Name: r.FormValue("name"), AdminMobile: r.FormValue("admin_mobile"), Quotas: quotas})
+		if invalid {
+			h.renderOrgRows(w, r, err.Error())
+			return
+		}
+		if err != nil {
+			h.Logger.Error("DB Error creating organization", "error", err)
+			http.Error(w, "DB Error", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.renderOrgRows(w, r, "")
+}
+
+// AdminOrgQuotasHandler sets the quotas of an organization.
+// POST /admin/org/quotas?id= (form: max_rooms, max_members, max_storage_mb)
+func (h *Handler) AdminOrgQuotasHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	quotas, err := formQuotas(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = h.setOrgQuotas(r, id, quotas)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("DB Error setting quotas", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	h.renderOrgRows(w, r, "")
+}
+
+// AdminDeleteOrgHandler deletes an organization with its rooms and their
+// recordings. DELETE /admin/org/delete?id=
+func (h *Handler) AdminDeleteOrgHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	err = h.deleteOrg(r, id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("DB Error deleting organization", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(""))
+}
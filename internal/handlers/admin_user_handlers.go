@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// adminTargetUser parses the id parameter of a user action and refuses
+// actions an admin must not take on their own account, which could lock
+// everyone out of the panel.
+func (h *Handler) adminTargetUser(w http.ResponseWriter, r *http.Request, allowSelf bool) (int64, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return 0, false
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return 0, false
+	}
+	if !allowSelf && id == requestClaims(r).UserID {
+		http.Error(w, "You cannot do this to your own account", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderUserRow answers a user action with the updated table row.
+func (h *Handler) renderUserRow(w http.ResponseWriter, r *http.Request, id int64) {
+	sum, err := h.Store.Users.Summary(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("DB Error fetching user", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	h.renderAdmin(w, "user-row", AdminUser{UserSummary: sum, Self: sum.ID == requestClaims(r).UserID})
+}
+
+// updateUser answers the error of a user update, 404 if there is no such
+// user, and reports whether it succeeded.
+func (h *Handler) updateUser(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		h.Logger.Error("DB Error updating user", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// disconnectUser closes a user's live listener connections, which
+// reconnect only with valid credentials.
+func (h *Handler) disconnectUser(userID int64) {
+	GlobalHub.DisconnectListener("", userID)
+}
+
+// DisableUserHandler blocks a user from logging in and signs them out
+// everywhere. Their API tokens stop working too. POST /admin/user/disable?id=
+func (h *Handler) DisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.adminTargetUser(w, r, false)
+	if !ok {
+		return
+	}
+	if !h.updateUser(w, h.Store.Users.SetDisabled(r.Context(), id, true)) {
+		return
+	}
+	h.disconnectUser(id)
+	h.Logger.Info("User disabled", "user_id", id, "admin_id", requestClaims(r).UserID)
+	h.audit(r, audit.Event{Action: audit.ActionUserDisable, TargetType: "user", TargetID: strconv.FormatInt(id, 10)})
+	h.renderUserRow(w, r, id)
+}
+
+// EnableUserHandler lets a disabled user log in again.
+// POST /admin/user/enable?id=
+func (h *Handler) EnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.adminTargetUser(w, r, false)
+	if !ok {
+		return
+	}
+	if !h.updateUser(w, h.Store.Users.SetDisabled(r.Context(), id, false)) {
+		return
+	}
+	h.Logger.Info("User enabled", "user_id", id, "admin_id", requestClaims(r).UserID)
+	h.audit(r, audit.Event{Action: audit.ActionUserEnable, TargetType: "user", TargetID: strconv.FormatInt(id, 10)})
+	h.renderUserRow(w, r, id)
+}
+
+// SetUserRoleHandler promotes a user to admin or demotes them.
+// POST /admin/user/role?id=&role=admin|user
+func (h *Handler) SetUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.adminTargetUser(w, r, false)
+	if !ok {
+		return
+	}
+	role := models.Role(r.URL.Query().Get("role"))
+	if role != models.RoleAdmin && role != models.RoleUser {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	if !h.updateUser(w, h.Store.Users.SetRole(r.Context(), id, role)) {
+		return
+	}
+	h.Logger.Info("User role changed", "user_id", id, "role", role, "admin_id", requestClaims(r).UserID)
+	h.audit(r, audit.Event{Action: audit.ActionUserRole, TargetType: "user", TargetID: strconv.FormatInt(id, 10), Detail: "role=" + string(role)})
+	h.renderUserRow(w, r, id)
+}
+
+// ForceLogoutHandler signs a user out of every browser by invalidating the
+// cookies issued so far. API tokens are left alone; they are revoked one by
+// one. POST /admin/user/logout?id=
+func (h *Handler) ForceLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.adminTargetUser(w, r, true)
+	if !ok {
+		return
+	}
+	if !h.updateUser(w, h.Store.Users.SignOut(r.Context(), id, time.Now())) {
+		return
+	}
+	h.disconnectUser(id)
+	h.Logger.Info("User signed out", "user_id", id, "admin_id", requestClaims(r).UserID)
+	h.audit(r, audit.Event{Action: audit.ActionUserForceLogout, TargetType: "user", TargetID: strconv.FormatInt(id, 10)})
+	h.renderUserRow(w, r, id)
+}
+
+// AdminUserSession is a session in the admin user details.
+type AdminUserSession struct {
+	store.SessionSummary
+	Live models.LiveState
+}
+
+// AdminUserHandler renders the details of one user: their sessions with the
+// storage each uses, and their API tokens. GET /admin/user?id=
+func (h *Handler) AdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	sum, err := h.Store.Users.Summary(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("DB Error fetching user", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+
+	summaries, err := h.Store.Sessions.Summaries(r.Context(), id)
+	if err != nil {
+		h.Logger.Error("DB Error fetching sessions", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	var sessions []AdminUserSession
+	for _, s := range summaries {
+		sessions = append(sessions, AdminUserSession{SessionSummary: s, Live: GlobalHub.State(s.ID)})
+	}
+
+	tokens, _ := h.Tokens.Count(id)
+
+	h.renderAdmin(w, "user-detail", map[string]interface{}{
+		"User":     AdminUser{UserSummary: sum, Self: sum.ID == requestClaims(r).UserID},
+		"Sessions": sessions,
+		"Tokens":   tokens,
+	})
+}
+
+// Storage formats the bytes a user's recordings take up.
+func (u AdminUser) Storage() string { return formatBytes(u.StorageBytes) }
+
+// Storage formats the bytes a session's recordings take up.
+func (s AdminUserSession) Storage() string { return formatBytes(s.StorageBytes) }
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
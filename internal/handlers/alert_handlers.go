@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+type AlertRuleRequest struct {
+	Kind            string  `json:"kind"`
+	Threshold       float64 `json:"threshold"`
+	DurationSeconds int     `json:"duration_seconds"`
+	CooldownSeconds *int    `json:"cooldown_seconds"`
+}
+
+// defaultAlertCooldown applies when a rule is created without a cooldown.
+const defaultAlertCooldown = 300
+
+// rule validates the request and turns it into an enabled rule.
+func (req AlertRuleRequest) rule(sessionID string) (models.AlertRule, error) {
+	if !alerts.ValidKind(req.Kind) {
+		return models.AlertRule{}, errors.New("Unknown rule kind")
+	}
+	if req.Threshold < 0 || req.Threshold > 100 || req.DurationSeconds < 0 {
+		return models.AlertRule{}, errors.New("Threshold must be 0-100 and duration non-negative")
+	}
+
+	rule := models.AlertRule{
+		SessionID:       sessionID,
+		Kind:            req.Kind,
+		Threshold:       req.Threshold,
+		DurationSeconds: req.DurationSeconds,
+		CooldownSeconds: defaultAlertCooldown,
+		Enabled:         true,
+	}
+	if req.CooldownSeconds != nil && *req.CooldownSeconds >= 0 {
+		rule.CooldownSeconds = *req.CooldownSeconds
+	}
+	return rule, nil
+}
+
+// AlertsHandler lists the caller's alerts as JSON.
+// URL: /alerts?session_id={id}&unacknowledged=1&limit=50
+func (h *Handler) AlertsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	list, err := h.AlertStore.List(claims.UserID, r.URL.Query().Get("session_id"), r.URL.Query().Get("unacknowledged") == "1", limit, 0)
+	if err != nil {
+		h.Logger.Error("Database error fetching alerts", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.Alert{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DashboardAlertsHandler renders the unacknowledged alerts card of the
+// dashboard, which reloads it whenever an alert is raised or acknowledged.
+func (h *Handler) DashboardAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	list, err := h.AlertStore.List(claims.UserID, "", true, 20, 0)
+	if err != nil {
+		h.Logger.Error("Database error fetching alerts", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, "alert-list", map[string]interface{}{
+		"Alerts": list,
+	}); err != nil {
+		h.Logger.Error("Template execution error", "template", "alert-list", "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
+
+// AcknowledgeAlertHandler marks an alert as seen. URL: /alerts/ack?id={alert_id}
+func (h *Handler) AcknowledgeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := requestClaims(r)
+
+	alertID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "ID required", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.acknowledgeAlert(alertID, claims.UserID)
+	if errors.Is(err, alerts.ErrNotFound) {
+		http.Error(w, "Alert not found or already acknowledged", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Database error acknowledging alert", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("")) // Return empty to remove the alert from the list
+}
+
+// acknowledgeAlert marks an alert as seen and announces it. Acknowledging an
+// SOS also stops the ringing on every listener and tells the kid device.
+func (h *Handler) acknowledgeAlert(alertID, userID int64) (models.Alert, error) {
+	alert, err := h.AlertStore.Acknowledge(alertID, userID)
+	if err != nil {
+		return alert, err
+	}
+
+	GlobalHub.Events.Publish(events.Event{
+		Type:      events.AlertAcked,
+		SessionID: alert.SessionID,
+		UserID:    alert.UserID,
+		Data:      alert,
+	})
+
+	if alert.Kind == models.AlertSOS {
+		ack := ControlMessage{Type: MsgSOSAck, AlertID: alert.ID}
+		GlobalHub.NotifyListeners(alert.SessionID, ack)
+		GlobalHub.NotifySource(alert.SessionID, ack)
+	}
+	return alert, nil
+}
+
+// deleteAlertRule deletes a rule of a session userID manages. Rules of
+// other sessions are alerts.ErrNotFound, those of archived ones
+// errArchived.
+func (h *Handler) deleteAlertRule(ctx context.Context, ruleID, userID int64) error {
+	sessionID, err := h.AlertStore.RuleSession(ruleID)
+	if err != nil {
+		return err
+	}
+	s, _, err := h.sessionFor(ctx, sessionID, userID, permManage)
+	if errors.Is(err, errNoSession) || errors.Is(err, errNoPermission) {
+		return alerts.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if s.Archived() {
+		return errArchived
+	}
+	if err := h.AlertStore.DeleteRule(ruleID); err != nil {
+		return err
+	}
+	if h.Alerts != nil {
+		h.Alerts.Invalidate(sessionID)
+	}
+	return nil
+}
+
+// AlertRulesHandler manages the alert rules of a session the caller
+// manages.
+//
+//	GET    /session/rules?id={session_id}      list rules
+//	POST   /session/rules?id={session_id}      create a rule (JSON AlertRuleRequest)
+//	DELETE /session/rules?rule_id={rule_id}    delete a rule
+func (h *Handler) AlertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	if r.Method == http.MethodDelete {
+		ruleID, err := strconv.ParseInt(r.URL.Query().Get("rule_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Rule ID required", http.StatusBadRequest)
+			return
+		}
+		err = h.deleteAlertRule(r.Context(), ruleID, claims.UserID)
+		if errors.Is(err, alerts.ErrNotFound) {
+			http.Error(w, "Rule not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errArchived) {
+			http.Error(w, "Session is archived", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error deleting alert rule", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("id")
+	s, _, ok := h.webSession(w, r, sessionID, permManage)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := h.AlertStore.LoadRules(sessionID)
+		if err != nil {
+			h.Logger.Error("Database error fetching alert rules", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if rules == nil {
+			rules = []models.AlertRule{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+
+	case http.MethodPost:
+		if s.Archived() {
+			http.Error(w, "Session is archived", http.StatusConflict)
+			return
+		}
+		var req AlertRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		rule, err := req.rule(sessionID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.AlertStore.CreateRule(&rule); err != nil {
+			h.Logger.Error("Database error creating alert rule", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if h.Alerts != nil {
+			h.Alerts.Invalidate(sessionID)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
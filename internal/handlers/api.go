@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zamibd/a2web/internal/apitokens"
+	"github.com/zamibd/a2web/internal/auth"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// APIPrefix is the path every versioned JSON endpoint lives under.
+const APIPrefix = "/api/v1"
+
+// Error codes of the JSON API, returned in the "code" field of every error
+// object so clients do not have to parse messages.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	// CodeInsufficientScope is returned when a personal API token is used on
+	// a route its scopes do not cover.
+	CodeInsufficientScope = "insufficient_scope"
+	CodeNotFound          = "not_found"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeConflict          = "conflict"
+	CodeInternal          = "internal_error"
+)
+
+// Pagination defaults for list endpoints.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// APIError is the body of every failed API response:
+//
+//	{"error": {"code": "not_found", "message": "Session not found"}}
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiErrorBody struct {
+	Error APIError `json:"error"`
+}
+
+// Pagination describes the page returned by a list endpoint. NextOffset is
+// omitted on the last page.
+type Pagination struct {
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	Total      int  `json:"total"`
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// Page is the body of every list endpoint.
+type Page[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+func newPage[T any](items []T, limit, offset, total int) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	p := Page[T]{Data: items, Pagination: Pagination{Limit: limit, Offset: offset, Total: total}}
+	if next := offset + len(items); next < total {
+		p.Pagination.NextOffset = &next
+	}
+	return p
+}
+
+// slicePage pages through a list that is loaded whole, such as the few
+// devices or alert rules of a user.
+func slicePage[T any](items []T, limit, offset int) Page[T] {
+	total := len(items)
+	start := min(offset, total)
+	end := min(start+limit, total)
+	return newPage(items[start:end], limit, offset, total)
+}
+
+// pageParams reads ?limit= and ?offset=, applying the defaults.
+func pageParams(r *http.Request) (limit, offset int, ok bool) {
+	limit, offset = defaultPageLimit, 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		limit = min(n, maxPageLimit)
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// parsePage is pageParams for handlers: it answers 400 on invalid values.
+func parsePage(w http.ResponseWriter, r *http.Request) (limit, offset int, ok bool) {
+	limit, offset, ok = pageParams(r)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "limit must be a positive integer and offset a non-negative integer")
+	}
+	return limit, offset, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiErrorBody{Error: APIError{Code: code, Message: message}})
+}
+
+// internalError logs err and answers with an opaque 500.
+func (h *Handler) internalError(w http.ResponseWriter, msg string, err error) {
+	h.Logger.Error(msg, "error", err)
+	writeAPIError(w, http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
+
+// apiAuth is ScopedAuthMiddleware for the JSON API: failures are error
+// objects. Routes without a scope only accept the token cookie.
+func (h *Handler) apiAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := h.apiAuthenticate(w, r, scope)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, withClaims(r, claims))
+	}
+}
+
+// apiAuthenticate is authenticate answering failures with error objects, for
+// handlers whose scope depends on the request.
+func (h *Handler) apiAuthenticate(w http.ResponseWriter, r *http.Request, scope string) (*auth.Claims, bool) {
+	claims, err := h.authenticate(r, scope)
+	switch {
+	case err == nil:
+		return claims, true
+	case errors.Is(err, errNoCredentials):
+		writeAPIError(w, http.StatusUnauthorized, CodeUnauthorized, "Authentication required")
+	case errors.Is(err, errTokenNotAccepted), errors.Is(err, errAccountDisabled):
+		writeAPIError(w, http.StatusForbidden, CodeForbidden, err.Error())
+	case errors.Is(err, errInsufficientScope):
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+		writeAPIError(w, http.StatusForbidden, CodeInsufficientScope, err.Error())
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeAPIError(w, http.StatusUnauthorized, CodeUnauthorized, "Invalid or expired credentials")
+	}
+	return nil, false
+}
+
+// apiAdmin is apiAuth restricted to administrators signed in with the
+// cookie.
+func (h *Handler) apiAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return h.apiAuth("", func(w http.ResponseWriter, r *http.Request) {
+		if requestClaims(r).Role != string(models.RoleAdmin) {
+			writeAPIError(w, http.StatusForbidden, CodeForbidden, "Admin access required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRouter registers method-aware routes under APIPrefix and answers
+// unknown paths and methods with error objects instead of the plain text
+// the ServeMux would write.
+type apiRouter struct {
+	mux     *http.ServeMux
+	methods map[string][]string // path pattern -> allowed methods
+}
+
+func (rt *apiRouter) handle(method, path string, h http.HandlerFunc) {
+	rt.mux.HandleFunc(method+" "+APIPrefix+path, h)
+	rt.methods[path] = append(rt.methods[path], method)
+}
+
+// finish registers the fallbacks. A pattern without a method is less
+// specific than the same pattern with one, so it only sees the methods no
+// route handles.
+func (rt *apiRouter) finish() {
+	for path, methods := range rt.methods {
+		allow := append([]string(nil), methods...)
+		for _, m := range methods {
+			if m == http.MethodGet {
+				allow = append(allow, http.MethodHead)
+			}
+		}
+		sort.Strings(allow)
+		allowHeader := strings.Join(allow, ", ")
+		rt.mux.HandleFunc(APIPrefix+path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", allowHeader)
+			writeAPIError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method "+r.Method+" is not allowed")
+		})
+	}
+	rt.mux.HandleFunc(APIPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "No such endpoint")
+	})
+}
+
+// RegisterAPI adds the versioned JSON API to mux. The OpenAPI document
+// describing it is served at /api/v1/openapi.json.
+func (h *Handler) RegisterAPI(mux *http.ServeMux) {
+	rt := &apiRouter{mux: mux, methods: make(map[string][]string)}
+
+	rt.handle(http.MethodGet, "/openapi.json", h.APIOpenAPIHandler)
+
+	rt.handle(http.MethodGet, "/me", h.apiAuth("", h.APIMeHandler))
+	rt.handle(http.MethodGet, "/users", h.apiAdmin(h.APIListUsersHandler))
+	rt.handle(http.MethodGet, "/users/{id}", h.apiAdmin(h.APIGetUserHandler))
+	rt.handle(http.MethodDelete, "/users/{id}", h.apiAdmin(h.APIDeleteUserHandler))
+
+	rt.handle(http.MethodGet, "/sessions", h.apiAuth(apitokens.ScopeSessionsRead, h.APIListSessionsHandler))
+	rt.handle(http.MethodPost, "/sessions", h.apiAuth("", h.APICreateSessionHandler))
+	rt.handle(http.MethodGet, "/sessions/{id}", h.apiAuth(apitokens.ScopeSessionsRead, h.APIGetSessionHandler))
+	rt.handle(http.MethodPatch, "/sessions/{id}", h.apiAuth("", h.APIUpdateSessionHandler))
+	rt.handle(http.MethodDelete, "/sessions/{id}", h.apiAuth("", h.APIDeleteSessionHandler))
+	rt.handle(http.MethodPost, "/sessions/{id}/rotate-kid-link", h.apiAuth("", h.APIRotateKidLinkHandler))
+	rt.handle(http.MethodGet, "/sessions/{id}/alert-rules", h.apiAuth(apitokens.ScopeSessionsRead, h.APIListAlertRulesHandler))
+	rt.handle(http.MethodPost, "/sessions/{id}/alert-rules", h.apiAuth("", h.APICreateAlertRuleHandler))
+	// Authenticated inside: the scope depends on the requested role
+	rt.handle(http.MethodPost, "/sessions/{id}/ws-ticket", h.APICreateWSTicketHandler)
+	rt.handle(http.MethodDelete, "/alert-rules/{id}", h.apiAuth("", h.APIDeleteAlertRuleHandler))
+
+	rt.handle(http.MethodGet, "/sessions/{id}/members", h.apiAuth("", h.APIListMembersHandler))
+	rt.handle(http.MethodDelete, "/sessions/{id}/members/{user_id}", h.apiAuth("", h.APIRemoveMemberHandler))
+	rt.handle(http.MethodGet, "/sessions/{id}/invites", h.apiAuth("", h.APIListSessionInvitesHandler))
+	rt.handle(http.MethodPost, "/sessions/{id}/invites", h.apiAuth("", h.APICreateInviteHandler))
+	rt.handle(http.MethodGet, "/invites", h.apiAuth("", h.APIListInvitesHandler))
+	rt.handle(http.MethodDelete, "/invites/{id}", h.apiAuth("", h.APIDeleteInviteHandler))
+	rt.handle(http.MethodPost, "/invites/{id}/accept", h.apiAuth("", h.APIAcceptInviteHandler))
+	rt.handle(http.MethodPost, "/invite-links/accept", h.apiAuth("", h.APIAcceptInviteLinkHandler))
+
+	rt.handle(http.MethodGet, "/orgs", h.apiAuth("", h.APIListOrgsHandler))
+	rt.handle(http.MethodPost, "/orgs", h.apiAdmin(h.APICreateOrgHandler))
+	rt.handle(http.MethodGet, "/orgs/{id}", h.apiAuth("", h.APIGetOrgHandler))
+	rt.handle(http.MethodPut, "/orgs/{id}", h.apiAuth("", h.APIUpdateOrgHandler))
+	rt.handle(http.MethodDelete, "/orgs/{id}", h.apiAdmin(h.APIDeleteOrgHandler))
+	rt.handle(http.MethodPut, "/orgs/{id}/quotas", h.apiAdmin(h.APISetOrgQuotasHandler))
+	rt.handle(http.MethodGet, "/orgs/{id}/members", h.apiAuth("", h.APIListOrgMembersHandler))
+	rt.handle(http.MethodPost, "/orgs/{id}/members", h.apiAuth("", h.APISetOrgMemberHandler))
+	rt.handle(http.MethodDelete, "/orgs/{id}/members/{user_id}", h.apiAuth("", h.APIRemoveOrgMemberHandler))
+	rt.handle(http.MethodGet, "/orgs/{id}/rooms", h.apiAuth(apitokens.ScopeSessionsRead, h.APIListOrgRoomsHandler))
+	rt.handle(http.MethodPost, "/orgs/{id}/rooms", h.apiAuth("", h.APICreateOrgRoomHandler))
+
+	rt.handle(http.MethodGet, "/recordings", h.apiAuth(apitokens.ScopeRecordingsRead, h.APIListRecordingsHandler))
+	rt.handle(http.MethodGet, "/recordings/{id}", h.apiAuth(apitokens.ScopeRecordingsRead, h.APIGetRecordingHandler))
+	rt.handle(http.MethodGet, "/recordings/{id}/audio", h.apiAuth(apitokens.ScopeRecordingsRead, h.APIRecordingAudioHandler))
+	rt.handle(http.MethodDelete, "/recordings/{id}", h.apiAuth(apitokens.ScopeRecordingsDelete, h.APIDeleteRecordingHandler))
+
+	rt.handle(http.MethodGet, "/devices", h.apiAuth("", h.APIListDevicesHandler))
+	rt.handle(http.MethodDelete, "/devices/{id}", h.apiAuth("", h.APIDeleteDeviceHandler))
+
+	rt.handle(http.MethodGet, "/alerts", h.apiAuth(apitokens.ScopeSessionsRead, h.APIListAlertsHandler))
+	rt.handle(http.MethodPost, "/alerts/{id}/ack", h.apiAuth("", h.APIAcknowledgeAlertHandler))
+
+	rt.handle(http.MethodGet, "/tokens", h.apiAuth("", h.APIListTokensHandler))
+	rt.handle(http.MethodPost, "/tokens", h.apiAuth("", h.APICreateTokenHandler))
+	rt.handle(http.MethodDelete, "/tokens/{id}", h.apiAuth("", h.APIDeleteTokenHandler))
+
+	rt.finish()
+}
+
+// APIOpenAPIHandler serves the OpenAPI 3 description of the API.
+func (h *Handler) APIOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// pathID parses the {id} wildcard of a route as an integer ID.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid ID")
+		return 0, false
+	}
+	return id, true
+}
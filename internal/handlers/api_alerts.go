@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/audit"
+)
+
+// APIListDevicesHandler pages through the caller's push notification
+// devices. GET /api/v1/devices?limit=&offset=
+func (h *Handler) APIListDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	subs, err := h.Store.Devices.ListByUser(r.Context(), requestClaims(r).UserID)
+	if err != nil {
+		h.internalError(w, "Database error fetching push subscriptions", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slicePage(subs, limit, offset))
+}
+
+// APIDeleteDeviceHandler unpairs one of the caller's devices.
+// DELETE /api/v1/devices/{id}
+func (h *Handler) APIDeleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := h.Store.Devices.DeleteByID(r.Context(), requestClaims(r).UserID, id)
+	if err != nil {
+		h.internalError(w, "Database error deleting push subscription", err)
+		return
+	}
+	if !deleted {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Device not found")
+		return
+	}
+	h.audit(r, audit.Event{Action: audit.ActionDeviceRemove, TargetType: "device", TargetID: strconv.FormatInt(id, 10)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListAlertsHandler pages through the caller's alerts, newest first.
+// GET /api/v1/alerts?session_id=&unacknowledged=true&limit=&offset=
+func (h *Handler) APIListAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	userID := requestClaims(r).UserID
+	sessionID := r.URL.Query().Get("session_id")
+	unacked := r.URL.Query().Get("unacknowledged")
+	unackedOnly := unacked == "true" || unacked == "1"
+
+	total, err := h.AlertStore.Count(userID, sessionID, unackedOnly)
+	if err != nil {
+		h.internalError(w, "Database error counting alerts", err)
+		return
+	}
+	list, err := h.AlertStore.List(userID, sessionID, unackedOnly, limit, offset)
+	if err != nil {
+		h.internalError(w, "Database error fetching alerts", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(list, limit, offset, total))
+}
+
+// APIAcknowledgeAlertHandler marks one of the caller's alerts as seen and
+// returns it. POST /api/v1/alerts/{id}/ack
+func (h *Handler) APIAcknowledgeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	alert, err := h.acknowledgeAlert(id, requestClaims(r).UserID)
+	if errors.Is(err, alerts.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Alert not found or already acknowledged")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error acknowledging alert", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, alert)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// InviteResponse is a created invite. URL is set for link invites only and
+// is not retrievable afterwards.
+type InviteResponse struct {
+	models.Invite
+	URL string `json:"url,omitempty"`
+}
+
+// AcceptLinkRequest is the body of POST /api/v1/invite-links/accept.
+type AcceptLinkRequest struct {
+	Token string `json:"token"`
+}
+
+// APIListMembersHandler lists who a session is shared with, without its
+// owner. GET /api/v1/sessions/{id}/members?limit=&offset=
+func (h *Handler) APIListMembersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	members, err := h.Store.Members.List(r.Context(), s.ID)
+	if err != nil {
+		h.internalError(w, "Database error fetching members", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slicePage(members, limit, offset))
+}
+
+// APIRemoveMemberHandler revokes a member's access to a session. The owner
+// may remove anyone, members only themselves.
+// DELETE /api/v1/sessions/{id}/members/{user_id}
+func (h *Handler) APIRemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid user ID")
+		return
+	}
+	perm := permManage
+	if userID == requestClaims(r).UserID {
+		perm = permView
+	}
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), perm)
+	if !ok {
+		return
+	}
+	err = h.removeMember(r, s.ID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Member not found")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error removing member", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListSessionInvitesHandler lists the pending invites to a session.
+// GET /api/v1/sessions/{id}/invites?limit=&offset=
+func (h *Handler) APIListSessionInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	invites, err := h.Store.Members.Invites(r.Context(), s.ID)
+	if err != nil {
+		h.internalError(w, "Database error fetching invites", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slicePage(invites, limit, offset))
+}
+
+// APICreateInviteHandler invites a mobile number, or creates an invite
+// link, to a session. POST /api/v1/sessions/{id}/invites
+func (h *Handler) APICreateInviteHandler(w http.ResponseWriter, r *http.Request) {
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	if s.Archived() {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Session is archived")
+		return
+	}
+	var req InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	settings, err := h.roomSettings(r.Context(), s)
+	if err != nil {
+		h.internalError(w, "Database error fetching organization", err)
+		return
+	}
+	inv, err := req.invite(s, requestClaims(r).UserID, settings)
+	inv.InviterMobile = h.requestMobile(r)
+	if err == nil && inv.Mobile == inv.InviterMobile {
+		err = errOwnSession
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	token, err := h.createInvite(r, &inv)
+	if err != nil {
+		h.internalError(w, "Database error creating invite", err)
+		return
+	}
+	resp := InviteResponse{Invite: inv}
+	if token != "" {
+		resp.URL = inviteURL(r, token)
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// APIListInvitesHandler lists the pending invites to the caller's mobile
+// number. GET /api/v1/invites?limit=&offset=
+func (h *Handler) APIListInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	invites, err := h.Store.Members.InvitesFor(r.Context(), h.requestMobile(r))
+	if err != nil {
+		h.internalError(w, "Database error fetching invites", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slicePage(invites, limit, offset))
+}
+
+// APIDeleteInviteHandler revokes an invite, as the session's owner, or
+// declines it, as the invited user. DELETE /api/v1/invites/{id}
+func (h *Handler) APIDeleteInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	inv, err := h.Store.Members.GetInvite(r.Context(), id)
+	if err == nil {
+		var allowed bool
+		if allowed, err = h.mayRevoke(r.Context(), inv, requestClaims(r).UserID); err == nil && !allowed {
+			err = store.ErrNotFound
+		}
+	}
+	if err == nil {
+		err = h.revokeInvite(r, inv)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Invite not found")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error deleting invite", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIAcceptInviteHandler accepts an invite to the caller's mobile number
+// and returns the session. POST /api/v1/invites/{id}/accept
+func (h *Handler) APIAcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	inv, err := h.Store.Members.GetInvite(r.Context(), id)
+	if err == nil && inv.Mobile == "" {
+		err = store.ErrNotFound // links are accepted with their token
+	}
+	h.apiAccept(w, r, inv, err)
+}
+
+// APIAcceptInviteLinkHandler accepts an invite link by its token and
+// returns the session. POST /api/v1/invite-links/accept
+func (h *Handler) APIAcceptInviteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req AcceptLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "token is required")
+		return
+	}
+	inv, err := h.Store.Members.GetInviteByToken(r.Context(), hashInviteToken(req.Token))
+	h.apiAccept(w, r, inv, err)
+}
+
+// apiAccept accepts inv, loaded with err, for the caller.
+func (h *Handler) apiAccept(w http.ResponseWriter, r *http.Request, inv models.Invite, err error) {
+	userID := requestClaims(r).UserID
+	if err == nil {
+		err = h.acceptableBy(r.Context(), inv, userID)
+	}
+	if err == nil {
+		err = h.acceptInvite(r, inv, userID)
+	}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Invite not found or expired")
+		return
+	case errors.Is(err, errOwnSession):
+		writeAPIError(w, http.StatusConflict, CodeConflict, "You own this session")
+		return
+	case errors.Is(err, errMemberQuota):
+		writeAPIError(w, http.StatusConflict, CodeConflict, "The organization has reached its member quota")
+		return
+	case err != nil:
+		h.internalError(w, "Database error accepting invite", err)
+		return
+	}
+	s, role, ok := h.apiSession(w, r, inv.SessionID, permView)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, APISession{Session: s, Role: role, Live: GlobalHub.State(s.ID)})
+}
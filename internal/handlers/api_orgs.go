@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// APIOrg is an organization with the caller's role in it, empty for
+// administrators who are not members, and its usage.
+type APIOrg struct {
+	models.Organization
+	Role  models.OrgRole   `json:"role,omitempty"`
+	Usage *models.OrgUsage `json:"usage,omitempty"`
+}
+
+// apiOrg is orgAccess for the API, for the organization in the {id}
+// wildcard: it answers 404 when the organization does not exist or the
+// caller is not a member, and 403 when manage is asked of a non-admin.
+func (h *Handler) apiOrg(w http.ResponseWriter, r *http.Request, manage bool) (models.Organization, models.OrgRole, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return models.Organization{}, "", false
+	}
+	o, role, err := h.orgAccess(r.Context(), id, requestClaims(r), manage)
+	switch {
+	case err == nil:
+		return o, role, true
+	case errors.Is(err, errNoOrg):
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Organization not found")
+	case errors.Is(err, errNoPermission):
+		writeAPIError(w, http.StatusForbidden, CodeForbidden, "Only the organization's admins may do this")
+	default:
+		h.internalError(w, "Database error fetching organization", err)
+	}
+	return o, role, false
+}
+
+// writeOrgError answers a refused organization change with 409 for
+// quotas and the last admin, and 400 otherwise.
+func writeOrgError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRoomQuota) || errors.Is(err, errMemberQuota) || errors.Is(err, errLastAdmin) {
+		writeAPIError(w, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+}
+
+// APIListOrgsHandler lists the organizations the caller belongs to, and
+// for administrators every organization with its usage.
+// GET /api/v1/orgs?limit=&offset=
+func (h *Handler) APIListOrgsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	claims := requestClaims(r)
+	mine, err := h.Store.Orgs.ListByUser(r.Context(), claims.UserID)
+	if err != nil {
+		h.internalError(w, "Database error fetching organizations", err)
+		return
+	}
+	var orgs []APIOrg
+	if claims.Role == string(models.RoleAdmin) {
+		roles := make(map[int64]models.OrgRole)
+		for _, uo := range mine {
+			roles[uo.ID] = uo.Role
+		}
+		all, err := h.Store.Orgs.List(r.Context())
+		if err != nil {
+			h.internalError(w, "Database error fetching organizations", err)
+			return
+		}
+		for _, sum := range all {
+			orgs = append(orgs, APIOrg{Organization: sum.Organization, Role: roles[sum.ID], Usage: &sum.Usage})
+		}
+	} else {
+		for _, uo := range mine {
+			orgs = append(orgs, APIOrg{Organization: uo.Organization, Role: uo.Role})
+		}
+	}
+	writeJSON(w, http.StatusOK, slicePage(orgs, limit, offset))
+}
+
+// APICreateOrgHandler creates an organization with its first admin.
+// Administrators only. POST /api/v1/orgs
+func (h *Handler) APICreateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	o, invalid, err := h.createOrg(r, req)
+	if invalid {
+		writeOrgError(w, err)
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error creating organization", err)
+		return
+	}
+	w.Header().Set("Location", APIPrefix+"/orgs/"+strconv.FormatInt(o.ID, 10))
+	writeJSON(w, http.StatusCreated, APIOrg{Organization: o, Usage: &models.OrgUsage{Members: 1}})
+}
+
+// APIGetOrgHandler returns an organization the caller belongs to with its
+// usage. GET /api/v1/orgs/{id}
+func (h *Handler) APIGetOrgHandler(w http.ResponseWriter, r *http.Request) {
+	o, role, ok := h.apiOrg(w, r, false)
+	if !ok {
+		return
+	}
+	usage, err := h.Store.Orgs.Usage(r.Context(), o.ID)
+	if err != nil {
+		h.internalError(w, "Database error fetching organization usage", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, APIOrg{Organization: o, Role: role, Usage: &usage})
+}
+
+// APIUpdateOrgHandler changes the name and settings of an organization.
+// PUT /api/v1/orgs/{id}
+func (h *Handler) APIUpdateOrgHandler(w http.ResponseWriter, r *http.Request) {
+	o, role, ok := h.apiOrg(w, r, true)
+	if !ok {
+		return
+	}
+	var req UpdateOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	invalid, err := h.updateOrg(r, &o, req)
+	if invalid {
+		writeOrgError(w, err)
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error updating organization", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, APIOrg{Organization: o, Role: role})
+}
+
+// APISetOrgQuotasHandler replaces the quotas of an organization; 0 is
+// unlimited. Administrators only. PUT /api/v1/orgs/{id}/quotas
+func (h *Handler) APISetOrgQuotasHandler(w http.ResponseWriter, r *http.Request) {
+	o, role, ok := h.apiOrg(w, r, true)
+	if !ok {
+		return
+	}
+	var q models.OrgQuotas
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	if err := validQuotas(q); err != nil {
+		writeOrgError(w, err)
+		return
+	}
+	if err := h.setOrgQuotas(r, o.ID, q); err != nil {
+		h.internalError(w, "Database error setting quotas", err)
+		return
+	}
+	o.Quotas = q
+	writeJSON(w, http.StatusOK, APIOrg{Organization: o, Role: role})
+}
+
+// APIDeleteOrgHandler deletes an organization with its rooms and their
+// recordings. Administrators only. DELETE /api/v1/orgs/{id}
+func (h *Handler) APIDeleteOrgHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := h.deleteOrg(r, id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Organization not found")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error deleting organization", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListOrgMembersHandler lists the members of an organization, admins
+// first. GET /api/v1/orgs/{id}/members?limit=&offset=
+func (h *Handler) APIListOrgMembersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	o, _, ok := h.apiOrg(w, r, true)
+	if !ok {
+		return
+	}
+	members, err := h.Store.Orgs.Members(r.Context(), o.ID)
+	if err != nil {
+		h.internalError(w, "Database error fetching organization members", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slicePage(members, limit, offset))
+}
+
+// APISetOrgMemberHandler adds a registered user to an organization or
+// changes their role. POST /api/v1/orgs/{id}/members
+func (h *Handler) APISetOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	o, _, ok := h.apiOrg(w, r, true)
+	if !ok {
+		return
+	}
+	var req OrgMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	m, invalid, err := h.setOrgMember(r, o, req)
+	if invalid {
+		writeOrgError(w, err)
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error setting organization member", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// APIRemoveOrgMemberHandler removes a member from an organization and
+// from the rooms they were invited to. DELETE /api/v1/orgs/{id}/members/{user_id}
+func (h *Handler) APIRemoveOrgMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid user ID")
+		return
+	}
+	o, _, ok := h.apiOrg(w, r, true)
+	if !ok {
+		return
+	}
+	err = h.removeOrgMember(r, o.ID, userID)
+	switch {
+	case errors.Is(err, errLastAdmin):
+		writeOrgError(w, err)
+	case errors.Is(err, store.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Member not found")
+	case err != nil:
+		h.internalError(w, "Database error removing organization member", err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// APIListOrgRoomsHandler lists the rooms of an organization the caller
+// has a role in: every room for admins and staff, the rooms they were
+// invited to for guardians. Administrators who are not members see every
+// room without a role. GET /api/v1/orgs/{id}/rooms?limit=&offset=
+func (h *Handler) APIListOrgRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	o, role, ok := h.apiOrg(w, r, false)
+	if !ok {
+		return
+	}
+
+	var rooms []APISession
+	if role == "" {
+		list, err := h.Store.Orgs.Rooms(r.Context(), o.ID)
+		if err != nil {
+			h.internalError(w, "Database error fetching rooms", err)
+			return
+		}
+		for _, s := range list {
+			rooms = append(rooms, APISession{Session: s, Live: GlobalHub.State(s.ID)})
+		}
+		writeJSON(w, http.StatusOK, slicePage(rooms, limit, offset))
+		return
+	}
+
+	list, total, err := h.Store.Members.Sessions(r.Context(), requestClaims(r).UserID, o.ID, limit, offset)
+	if err != nil {
+		h.internalError(w, "Database error fetching rooms", err)
+		return
+	}
+	for _, s := range list {
+		rooms = append(rooms, APISession{Session: s.Session, Role: s.Role, Live: GlobalHub.State(s.ID)})
+	}
+	writeJSON(w, http.StatusOK, newPage(rooms, limit, offset, total))
+}
+
+// APICreateOrgRoomHandler creates a room of an organization, owned by the
+// calling admin. POST /api/v1/orgs/{id}/rooms
+func (h *Handler) APICreateOrgRoomHandler(w http.ResponseWriter, r *http.Request) {
+	o, role, ok := h.apiOrg(w, r, true)
+	if !ok {
+		return
+	}
+	if role != models.OrgAdmin {
+		writeAPIError(w, http.StatusForbidden, CodeForbidden, "Rooms belong to one of the organization's admins")
+		return
+	}
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > maxSessionNameLength {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Name must be at most "+strconv.Itoa(maxSessionNameLength)+" characters")
+		return
+	}
+
+	session, err := h.createSession(r.Context(), requestClaims(r).UserID, o.ID, req.Name)
+	if errors.Is(err, errRoomQuota) {
+		writeOrgError(w, err)
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error creating room", err)
+		return
+	}
+	h.audit(r, audit.Event{Action: audit.ActionSessionCreate, TargetType: "session", TargetID: session.ID})
+	w.Header().Set("Location", APIPrefix+"/sessions/"+session.ID)
+	writeJSON(w, http.StatusCreated, APISession{Session: session, Role: models.MemberOwner, Live: GlobalHub.State(session.ID)})
+}
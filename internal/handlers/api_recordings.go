@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/recordings"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// apiRecording loads a recording of a session the caller holds perm on,
+// answering 404 when it does not exist or the caller has no role in its
+// session. It also returns the session.
+func (h *Handler) apiRecording(w http.ResponseWriter, r *http.Request, perm permission) (models.Recording, models.Session, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return models.Recording{}, models.Session{}, false
+	}
+	rec, err := h.Store.Recordings.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Recording not found")
+		return rec, models.Session{}, false
+	}
+	if err != nil {
+		h.internalError(w, "Database error fetching recording", err)
+		return rec, models.Session{}, false
+	}
+	session, _, err := h.sessionFor(r.Context(), rec.SessionID, requestClaims(r).UserID, perm)
+	switch {
+	case err == nil:
+		return rec, session, true
+	case errors.Is(err, errNoSession):
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Recording not found")
+	case errors.Is(err, errNoPermission):
+		writeAPIError(w, http.StatusForbidden, CodeForbidden, "Your role on this session does not allow this")
+	default:
+		h.internalError(w, "Database error fetching session", err)
+	}
+	return rec, session, false
+}
+
+// APIListRecordingsHandler pages through the recordings of the sessions the
+// caller owns or is a viewer of, newest first. GET /api/v1/recordings?session_id=&limit=&offset=
+func (h *Handler) APIListRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	recs, total, err := h.Store.Recordings.ListByUser(r.Context(), requestClaims(r).UserID, r.URL.Query().Get("session_id"), limit, offset)
+	if err != nil {
+		h.internalError(w, "Database error fetching recordings", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(recs, limit, offset, total))
+}
+
+// APIGetRecordingHandler returns one recording. GET /api/v1/recordings/{id}
+func (h *Handler) APIGetRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	rec, _, ok := h.apiRecording(w, r, permRecordings)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// APIRecordingAudioHandler streams the WebM file of a recording, with range
+// support. GET /api/v1/recordings/{id}/audio
+func (h *Handler) APIRecordingAudioHandler(w http.ResponseWriter, r *http.Request) {
+	rec, _, ok := h.apiRecording(w, r, permRecordings)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "audio/webm")
+	w.Header().Set("Content-Disposition", `attachment; filename="recording-`+strconv.FormatInt(rec.ID, 10)+`.webm"`)
+	http.ServeFile(w, r, rec.Path)
+}
+
+// APIDeleteRecordingHandler deletes a finished recording.
+// DELETE /api/v1/recordings/{id}
+func (h *Handler) APIDeleteRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	rec, session, ok := h.apiRecording(w, r, permManage)
+	if !ok {
+		return
+	}
+	if session.Archived() {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Session is archived")
+		return
+	}
+	if rec.Status == "recording" {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Recording is still in progress")
+		return
+	}
+	if err := recordings.Delete(r.Context(), h.Store.Recordings, rec); err != nil {
+		h.internalError(w, "Failed to delete recording", err)
+		return
+	}
+	h.audit(r, audit.Event{Action: audit.ActionRecordingDelete, TargetType: "recording", TargetID: strconv.FormatInt(rec.ID, 10),
+		Detail: "session_id=" + rec.SessionID})
+
+	h.Logger.Info("Recording deleted", "recording_id", rec.ID, "user_id", session.UserID)
+	GlobalHub.Events.Publish(events.Event{
+		Type:      events.RecordingDeleted,
+		SessionID: rec.SessionID,
+		UserID:    session.UserID,
+		Data:      rec,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/apitokens"
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/wstickets"
+)
+
+// maxSessionNameLength bounds session names set through the API.
+const maxSessionNameLength = 100
+
+// APISession is a session together with its live state and the caller's
+// role in it. The kid link is shown to those who manage the session only.
+type APISession struct {
+	models.Session
+	Role    models.MemberRole `json:"role"`
+	Live    models.LiveState  `json:"live"`
+	KidLink string            `json:"kid_link,omitempty"`
+}
+
+// newAPISession is s as seen by a caller with role.
+func newAPISession(r *http.Request, s models.Session, role models.MemberRole) APISession {
+	as := APISession{Session: s, Role: role, Live: GlobalHub.State(s.ID)}
+	if can(role, permManage) {
+		as.KidLink = kidLink(r, s)
+	}
+	return as
+}
+
+// CreateSessionRequest is the body of POST /api/v1/sessions. The name is
+// optional.
+type CreateSessionRequest struct {
+	Name string `json:"name"`
+}
+
+// apiSession is sessionFor for the API: it answers 404 when the session
+// does not exist or the caller has no role in it, and 403 when their role
+// lacks perm.
+func (h *Handler) apiSession(w http.ResponseWriter, r *http.Request, sessionID string, perm permission) (models.Session, models.MemberRole, bool) {
+	s, role, err := h.sessionFor(r.Context(), sessionID, requestClaims(r).UserID, perm)
+	switch {
+	case err == nil:
+		return s, role, true
+	case errors.Is(err, errNoSession):
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Session not found")
+	case errors.Is(err, errNoPermission):
+		writeAPIError(w, http.StatusForbidden, CodeForbidden, "Your role on this session does not allow this")
+	default:
+		h.internalError(w, "Database error fetching session", err)
+	}
+	return s, role, false
+}
+
+// APIListSessionsHandler pages through the sessions the caller owns or is a
+// member of, newest first. GET /api/v1/sessions?limit=&offset=
+func (h *Handler) APIListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	userID := requestClaims(r).UserID
+
+	list, total, err := h.Store.Members.Sessions(r.Context(), userID, 0, limit, offset)
+	if err != nil {
+		h.internalError(w, "Database error fetching sessions", err)
+		return
+	}
+
+	var sessions []APISession
+	for _, s := range list {
+		sessions = append(sessions, newAPISession(r, s.Session, s.Role))
+	}
+	writeJSON(w, http.StatusOK, newPage(sessions, limit, offset, total))
+}
+
+// APICreateSessionHandler creates a session for the caller.
+// POST /api/v1/sessions
+func (h *Handler) APICreateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) > maxSessionNameLength {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Name must be at most "+strconv.Itoa(maxSessionNameLength)+" characters")
+		return
+	}
+
+	session, err := h.createSession(r.Context(), requestClaims(r).UserID, 0, req.Name)
+	if err != nil {
+		h.internalError(w, "Database error creating session", err)
+		return
+	}
+	h.audit(r, audit.Event{Action: audit.ActionSessionCreate, TargetType: "session", TargetID: session.ID})
+	w.Header().Set("Location", APIPrefix+"/sessions/"+session.ID)
+	writeJSON(w, http.StatusCreated, newAPISession(r, session, models.MemberOwner))
+}
+
+// APIGetSessionHandler returns a session the caller owns or is a member
+// of. GET /api/v1/sessions/{id}
+func (h *Handler) APIGetSessionHandler(w http.ResponseWriter, r *http.Request) {
+	s, role, ok := h.apiSession(w, r, r.PathValue("id"), permView)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPISession(r, s, role))
+}
+
+// APIUpdateSessionHandler renames, describes, archives or restores a
+// session the caller manages, or changes its settings. Settings fields
+// left out keep their values. PATCH /api/v1/sessions/{id}
+func (h *Handler) APIUpdateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	s, role, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	settings := s.Settings
+	req := UpdateSessionRequest{Settings: &settings}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	invalid, err := h.updateSession(r, &s, req)
+	switch {
+	case invalid:
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	case errors.Is(err, errArchived):
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Session is archived; restore it first")
+		return
+	case err != nil:
+		h.internalError(w, "Database error updating session", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPISession(r, s, role))
+}
+
+// APIRotateKidLinkHandler replaces the kid link of a session the caller
+// manages; the old one stops working.
+// POST /api/v1/sessions/{id}/rotate-kid-link
+func (h *Handler) APIRotateKidLinkHandler(w http.ResponseWriter, r *http.Request) {
+	s, role, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	err := h.rotateKidLink(r, &s)
+	if errors.Is(err, errArchived) {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Session is archived")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error rotating kid link", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPISession(r, s, role))
+}
+
+// APIDeleteSessionHandler deletes one of the caller's sessions with its
+// recordings. DELETE /api/v1/sessions/{id}
+func (h *Handler) APIDeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	if err := h.deleteSession(r.Context(), s.ID, s.UserID); err != nil {
+		h.internalError(w, "Database error deleting session", err)
+		return
+	}
+	h.audit(r, audit.Event{Action: audit.ActionSessionDelete, TargetType: "session", TargetID: s.ID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIListAlertRulesHandler lists the alert rules of one of the caller's
+// sessions. GET /api/v1/sessions/{id}/alert-rules?limit=&offset=
+func (h *Handler) APIListAlertRulesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	rules, err := h.AlertStore.LoadRules(s.ID)
+	if err != nil {
+		h.internalError(w, "Database error fetching alert rules", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slicePage(rules, limit, offset))
+}
+
+// APICreateAlertRuleHandler adds an alert rule to one of the caller's
+// sessions. POST /api/v1/sessions/{id}/alert-rules
+func (h *Handler) APICreateAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), permManage)
+	if !ok {
+		return
+	}
+	if s.Archived() {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Session is archived")
+		return
+	}
+	var req AlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	rule, err := req.rule(s.ID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if err := h.AlertStore.CreateRule(&rule); err != nil {
+		h.internalError(w, "Database error creating alert rule", err)
+		return
+	}
+	if h.Alerts != nil {
+		h.Alerts.Invalidate(s.ID)
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// APIDeleteAlertRuleHandler deletes an alert rule of a session the caller
+// manages.
+// DELETE /api/v1/alert-rules/{id}
+func (h *Handler) APIDeleteAlertRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := h.deleteAlertRule(r.Context(), id, requestClaims(r).UserID)
+	if errors.Is(err, alerts.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Rule not found")
+		return
+	}
+	if errors.Is(err, errArchived) {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Session is archived")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error deleting alert rule", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WSTicketRequest is the body of POST /api/v1/sessions/{id}/ws-ticket.
+type WSTicketRequest struct {
+	Role string `json:"role"` // "listener" or "source"
+}
+
+// WSTicketResponse carries a ticket and the WebSocket URL to use it with.
+type WSTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	Role      string    `json:"role"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// APICreateWSTicketHandler issues a single-use ticket for one WebSocket
+// connection to a session: listener tickets to the members allowed to
+// listen, source tickets to the owner. API tokens need sessions:read for
+// listener tickets and stream:ingest for source tickets.
+// POST /api/v1/sessions/{id}/ws-ticket
+func (h *Handler) APICreateWSTicketHandler(w http.ResponseWriter, r *http.Request) {
+	var req WSTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	var scope, path string
+	var perm permission
+	switch req.Role {
+	case wstickets.RoleListener:
+		scope, path, perm = apitokens.ScopeSessionsRead, "/ws/parent/", permListen
+	case wstickets.RoleSource:
+		scope, path, perm = apitokens.ScopeStreamIngest, "/ws/kid/", permManage
+	default:
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, `role must be "listener" or "source"`)
+		return
+	}
+
+	claims, ok := h.apiAuthenticate(w, r, scope)
+	if !ok {
+		return
+	}
+	r = withClaims(r, claims)
+	s, _, ok := h.apiSession(w, r, r.PathValue("id"), perm)
+	if !ok {
+		return
+	}
+	if req.Role == wstickets.RoleSource && s.Archived() {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "Session is archived")
+		return
+	}
+
+	ticket, t, err := h.Tickets.Issue(s.ID, claims.UserID, req.Role)
+	if err != nil {
+		h.internalError(w, "Error generating WebSocket ticket", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, WSTicketResponse{
+		Ticket:    ticket,
+		Role:      req.Role,
+		URL:       path + s.ID + "?ticket=" + url.QueryEscape(ticket),
+		ExpiresAt: t.ExpiresAt,
+	})
+}
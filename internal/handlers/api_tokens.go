@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/apitokens"
+	"github.com/zamibd/a2web/internal/audit"
+)
+
+// maxTokenLifetimeDays bounds the expiry of new personal API tokens.
+const maxTokenLifetimeDays = 365
+
+// CreateTokenRequest is the body of POST /api/v1/tokens. Without
+// expires_in_days the token does not expire.
+type CreateTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreatedToken is a new token together with its secret, which is returned
+// only once.
+type CreatedToken struct {
+	apitokens.Token
+	Secret string `json:"token"`
+}
+
+// APIListTokensHandler lists the caller's personal API tokens.
+// GET /api/v1/tokens?limit=&offset=
+func (h *Handler) APIListTokensHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := h.Tokens.List(requestClaims(r).UserID)
+	if err != nil {
+		h.internalError(w, "Database error fetching API tokens", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, slicePage(tokens, limit, offset))
+}
+
+// APICreateTokenHandler creates a personal API token for the caller.
+// POST /api/v1/tokens
+func (h *Handler) APICreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxSessionNameLength {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Name is required and must be at most 100 characters")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "At least one scope is required")
+		return
+	}
+	for _, s := range req.Scopes {
+		if !apitokens.ValidScope(s) {
+			writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "Unknown scope "+s)
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxTokenLifetimeDays {
+		writeAPIError(w, http.StatusBadRequest, CodeBadRequest, "expires_in_days must be between 0 and 365")
+		return
+	}
+
+	t := apitokens.Token{UserID: requestClaims(r).UserID, Name: req.Name, Scopes: req.Scopes}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		t.ExpiresAt = &expiresAt
+	}
+	raw, err := h.Tokens.Create(&t)
+	if err != nil {
+		h.internalError(w, "Database error creating API token", err)
+		return
+	}
+	h.Logger.Info("API token created", "token_id", t.ID, "user_id", t.UserID, "scopes", t.Scopes)
+	h.audit(r, audit.Event{Action: audit.ActionTokenCreate, TargetType: "token", TargetID: strconv.FormatInt(t.ID, 10),
+		Detail: "scopes=" + strings.Join(t.Scopes, ",")})
+	writeJSON(w, http.StatusCreated, CreatedToken{Token: t, Secret: raw})
+}
+
+// APIDeleteTokenHandler revokes one of the caller's tokens.
+// DELETE /api/v1/tokens/{id}
+func (h *Handler) APIDeleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	err := h.Tokens.Delete(id, requestClaims(r).UserID)
+	if errors.Is(err, apitokens.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "Token not found")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error deleting API token", err)
+		return
+	}
+	h.Logger.Info("API token revoked", "token_id", id, "user_id", requestClaims(r).UserID)
+	h.audit(r, audit.Event{Action: audit.ActionTokenDelete, TargetType: "token", TargetID: strconv.FormatInt(id, 10)})
+	w.WriteHeader(http.StatusNoContent)
+}
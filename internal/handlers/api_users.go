@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// APIMeHandler returns the authenticated user. GET /api/v1/me
+func (h *Handler) APIMeHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := h.Store.Users.Get(r.Context(), requestClaims(r).UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusUnauthorized, CodeUnauthorized, "User no longer exists")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error fetching user", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// APIListUsersHandler pages through all users, newest first. Admin only.
+// GET /api/v1/users?limit=&offset=
+func (h *Handler) APIListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	users, total, err := h.Store.Users.List(r.Context(), limit, offset)
+	if err != nil {
+		h.internalError(w, "Database error fetching users", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPage(users, limit, offset, total))
+}
+
+// APIGetUserHandler returns one user. Admin only. GET /api/v1/users/{id}
+func (h *Handler) APIGetUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	u, err := h.Store.Users.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Database error fetching user", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// APIDeleteUserHandler deletes a user with all their sessions and
+// recordings. Admin only. DELETE /api/v1/users/{id}
+func (h *Handler) APIDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if id == requestClaims(r).UserID {
+		writeAPIError(w, http.StatusConflict, CodeConflict, "You cannot delete your own account")
+		return
+	}
+	user, err := h.Store.Users.Get(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, CodeNotFound, "User not found")
+		return
+	} else if err != nil {
+		h.internalError(w, "Database error fetching user", err)
+		return
+	}
+	if err := h.deleteUser(r.Context(), id); err != nil {
+		h.internalError(w, "Database error deleting user", err)
+		return
+	}
+	h.audit(r, audit.Event{Action: audit.ActionUserDelete, TargetType: "user", TargetID: strconv.FormatInt(id, 10), Detail: "mobile=" + user.Mobile})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/audit"
+)
+
+// audit records a security event for r. The actor defaults to the caller
+// and the outcome to success. A failure to record is logged; it does not
+// fail the request.
+func (h *Handler) audit(r *http.Request, e audit.Event) {
+	if e.ActorID == 0 {
+		if claims := requestClaims(r); claims != nil {
+			e.ActorID = claims.UserID
+		}
+	}
+	if e.Actor == "" && e.ActorID != 0 {
+		if u, err := h.Store.Users.Get(r.Context(), e.ActorID); err == nil {
+			e.Actor = u.Mobile
+		}
+	}
+	if e.Outcome == "" {
+		e.Outcome = audit.OutcomeSuccess
+	}
+	e.IP = clientIP(r)
+	e.UserAgent = r.UserAgent()
+	// Recorded even when the client has gone away meanwhile
+	if err := audit.Record(context.WithoutCancel(r.Context()), h.Store.Audit, &e); err != nil {
+		h.Logger.Error("Failed to record audit event", "action", e.Action, "error", err)
+	}
+}
+
+const auditPageSize = 50
+
+// AdminAuditHandler renders the audit log, newest first, filtered by
+// action, actor, target and outcome. GET /admin/audit
+func (h *Handler) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := audit.Filter{
+		Action:  q.Get("action"),
+		Actor:   q.Get("actor"),
+		Target:  q.Get("target"),
+		Outcome: q.Get("outcome"),
+		Limit:   auditPageSize + 1,
+	}
+	if before := q.Get("before"); before != "" {
+		id, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		f.Before = id
+	}
+
+	list, err := audit.List(r.Context(), h.Store.Audit, f)
+	if err != nil {
+		h.Logger.Error("DB Error fetching audit events", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	var next int64
+	if len(list) > auditPageSize {
+		list = list[:auditPageSize]
+		next = list[auditPageSize-1].ID
+	}
+	h.renderAdmin(w, "audit-rows", map[string]interface{}{
+		"Events": list,
+		"Next":   next,
+	})
+}
+
+// AdminAuditVerifyHandler checks the hash chain of the audit log.
+// GET /admin/audit/verify
+func (h *Handler) AdminAuditVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := audit.Verify(r.Context(), h.Store.Audit)
+	if err != nil {
+		h.Logger.Error("DB Error verifying audit log", "error", err)
+		http.Error(w, "DB Error", http.StatusInternalServerError)
+		return
+	}
+	if !res.OK() {
+		h.Logger.Warn("Audit log chain broken", "event_id", res.BrokenID, "reason", res.Reason)
+	}
+	h.renderAdmin(w, "audit-verify", res)
+}
@@ -1,13 +1,20 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/zamibd/a2web/internal/audit"
 	"github.com/zamibd/a2web/internal/auth"
-	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/events"
 	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
 )
 
 type RegisterRequest struct {
@@ -45,14 +52,17 @@ func (h *Handler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = database.DB.Exec("INSERT INTO users (mobile, password_hash) VALUES (?, ?)", req.Mobile, hash)
-	if err != nil {
+	user := models.User{Mobile: req.Mobile, PasswordHash: hash}
+	if err := h.Store.Users.Create(r.Context(), &user); err != nil {
 		h.Logger.Warn("User registration failed", "mobile", req.Mobile, "error", err)
+		h.audit(r, audit.Event{Actor: req.Mobile, Action: audit.ActionRegister, Outcome: audit.OutcomeFailure})
 		http.Error(w, "User already exists or database error", http.StatusConflict)
 		return
 	}
 
 	h.Logger.Info("User registered successfully", "mobile", req.Mobile)
+	h.audit(r, audit.Event{ActorID: user.ID, Actor: req.Mobile, Action: audit.ActionRegister,
+		TargetType: "user", TargetID: strconv.FormatInt(user.ID, 10)})
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("User registered successfully"))
 }
@@ -69,20 +79,29 @@ func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	err := database.DB.QueryRow("SELECT id, password_hash, role FROM users WHERE mobile = ?", req.Mobile).Scan(&user.ID, &user.PasswordHash, &user.Role)
+	user, err := h.Store.Users.GetByMobile(r.Context(), req.Mobile)
 	if err != nil {
 		h.Logger.Warn("Login failed: user not found", "mobile", req.Mobile)
+		h.audit(r, audit.Event{Actor: req.Mobile, Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "unknown user"})
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	if !auth.CheckPasswordHash(req.Password, user.PasswordHash) {
 		h.Logger.Warn("Login failed: invalid password", "mobile", req.Mobile)
+		h.audit(r, audit.Event{ActorID: user.ID, Actor: req.Mobile, Action: audit.ActionLogin, Outcome: audit.OutcomeFailure, Detail: "invalid password"})
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	// Checked after the password so the answer does not reveal the account
+	if user.Disabled {
+		h.Logger.Warn("Login failed: account disabled", "user_id", user.ID)
+		h.audit(r, audit.Event{ActorID: user.ID, Actor: req.Mobile, Action: audit.ActionLogin, Outcome: audit.OutcomeDenied, Detail: "account disabled"})
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+
 	token, err := auth.GenerateJWT(user.ID, string(user.Role))
 	if err != nil {
 		h.Logger.Error("Error generating JWT", "error", err)
@@ -100,10 +119,28 @@ func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	h.Logger.Info("User logged in", "user_id", user.ID)
+	h.audit(r, audit.Event{ActorID: user.ID, Actor: req.Mobile, Action: audit.ActionLogin})
+	GlobalHub.Events.Publish(events.Event{
+		Type:   events.UserLogin,
+		UserID: user.ID,
+		Data:   map[string]string{"ip": clientIP(r), "user_agent": r.UserAgent()},
+	})
 	w.Write([]byte("Logged in successfully"))
 }
 
+// clientIP returns the host part of the request's remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if claims, err := h.authenticate(r, ""); err == nil {
+		h.audit(r, audit.Event{ActorID: claims.UserID, Action: audit.ActionLogout})
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     "token",
 		Value:    "",
@@ -114,27 +151,123 @@ func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Logged out"))
 }
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("token")
+var (
+	errNoCredentials     = errors.New("authentication required")
+	errTokenNotAccepted  = errors.New("API tokens are not accepted here")
+	errInsufficientScope = errors.New("token lacks the required scope")
+	errAccountDisabled   = errors.New("account disabled")
+	errSessionRevoked    = errors.New("session was signed out")
+)
+
+// bearerToken returns the credential of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticate identifies the caller by a personal API token sent as
+// "Authorization: Bearer", or else by the token cookie. Tokens are only
+// accepted when scope is set and the token was granted it.
+func (h *Handler) authenticate(r *http.Request, scope string) (*auth.Claims, error) {
+	if raw, ok := bearerToken(r); ok {
+		if scope == "" {
+			return nil, errTokenNotAccepted
+		}
+		t, err := h.Tokens.Authenticate(raw)
 		if err != nil {
-			if err == http.ErrNoCookie {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
-			return
+			return nil, err
 		}
+		if !t.HasScope(scope) {
+			return nil, errInsufficientScope
+		}
+		claims := &auth.Claims{UserID: t.UserID}
+		if err := h.checkAccount(r.Context(), claims, false); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}
 
-		claims, err := auth.ValidateJWT(c.Value)
+	c, err := r.Cookie("token")
+	if err != nil {
+		return nil, errNoCredentials
+	}
+	claims, err := auth.ValidateJWT(c.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkAccount(r.Context(), claims, true); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkAccount refuses disabled users and, for logins, cookies issued before
+// an admin signed the user out. The role is taken from the database, so
+// promotions and demotions apply at once.
+func (h *Handler) checkAccount(ctx context.Context, claims *auth.Claims, login bool) error {
+	u, err := h.Store.Users.Get(ctx, claims.UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errNoCredentials
+	}
+	if err != nil {
+		return err
+	}
+	claims.Role = string(u.Role)
+	if u.Disabled {
+		return errAccountDisabled
+	}
+	// JWT times have a resolution of one second, so a cookie issued in the
+	// same second as the sign out is refused too
+	if login && u.TokensValidAfter != nil && (claims.IssuedAt == nil || claims.IssuedAt.Unix() <= u.TokensValidAfter.Unix()) {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+// authStatus is the HTTP status for an authenticate error.
+func authStatus(err error) int {
+	if errors.Is(err, errTokenNotAccepted) || errors.Is(err, errInsufficientScope) || errors.Is(err, errAccountDisabled) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+type claimsKey struct{}
+
+// requestClaims returns the claims stored by AuthMiddleware.
+func requestClaims(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(claimsKey{}).(*auth.Claims)
+	return claims
+}
+
+func withClaims(r *http.Request, claims *auth.Claims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+}
+
+// AuthMiddleware lets through requests carrying a valid token cookie.
+// Personal API tokens are refused; routes that accept them use
+// ScopedAuthMiddleware.
+func (h *Handler) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return h.ScopedAuthMiddleware("", next)
+}
+
+// ScopedAuthMiddleware is AuthMiddleware that also accepts a personal API
+// token granted scope. The caller's claims are passed on in the request
+// context.
+func (h *Handler) ScopedAuthMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := h.authenticate(r, scope)
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			if status := authStatus(err); status == http.StatusForbidden {
+				http.Error(w, "Forbidden: "+err.Error(), status)
+			} else {
+				http.Error(w, "Unauthorized", status)
+			}
 			return
 		}
-
-		// Check role if needed, or set context
-		// For now just pass
-		_ = claims
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withClaims(r, claims))
 	}
 }
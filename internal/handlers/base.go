@@ -3,16 +3,54 @@ package handlers
 import (
 	"html/template"
 	"log/slog"
+
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/apitokens"
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/notify"
+	"github.com/zamibd/a2web/internal/store"
+	"github.com/zamibd/a2web/internal/webhooks"
+	"github.com/zamibd/a2web/internal/webpush"
+	"github.com/zamibd/a2web/internal/wstickets"
 )
 
 type Handler struct {
 	Logger    *slog.Logger
 	Templates map[string]*template.Template
+	// Store holds users, sessions, recordings, devices and the audit log.
+	Store *store.Store
+	// AlertStore holds alert rules and the alerts they raised.
+	AlertStore *alerts.Store
+	// WebhookStore holds webhooks and their deliveries.
+	WebhookStore *webhooks.Store
+	// NotifyStore holds notification preferences.
+	NotifyStore *notify.Store
+	// Tokens holds personal access tokens.
+	Tokens *apitokens.Store
+
+	// Alerts is notified when alert rules change. Optional.
+	Alerts *alerts.Engine
+	// Push delivers Web Push notifications. Optional.
+	Push *webpush.Worker
+	// Webhooks delivers outbound webhooks. Optional.
+	Webhooks *webhooks.Dispatcher
+	// Notifier sends email and Telegram notifications. Optional.
+	Notifier *notify.Notifier
+	// Tickets authorizes WebSocket connections of clients without the cookie.
+	Tickets *wstickets.Store
 }
 
-func New(logger *slog.Logger, tmpl map[string]*template.Template) *Handler {
+// New creates the handlers. The tables of packages that keep their own,
+// such as alerts and webhooks, are reached through db.
+func New(logger *slog.Logger, tmpl map[string]*template.Template, st *store.Store, db *database.Handle) *Handler {
 	return &Handler{
-		Logger:    logger,
-		Templates: tmpl,
+		Logger:       logger,
+		Templates:    tmpl,
+		Store:        st,
+		AlertStore:   alerts.NewStore(db),
+		WebhookStore: webhooks.NewStore(db),
+		NotifyStore:  notify.NewStore(db),
+		Tokens:       apitokens.NewStore(db),
+		Tickets:      wstickets.NewStore(wstickets.DefaultTTL),
 	}
 }
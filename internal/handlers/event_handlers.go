@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// sseHeartbeat keeps idle event streams from being closed by proxies.
+const sseHeartbeat = 30 * time.Second
+
+// EventsHandler streams bus events the caller may see as server-sent events.
+// Every event is sent under its type name (e.g. "session.created") with the
+// JSON-encoded event as data and the bus ID, prefixed with the bus's epoch,
+// as the SSE id, so browsers resume from Last-Event-ID after a reconnect.
+// When the requested position is no longer in the bus history, or is from
+// before a restart, a "resync" event is sent first.
+//
+// Members of a shared session see its events their role allows: session
+// and stream events, alerts when they may listen and recordings when they
+// may view them.
+//
+// For session.state events a second event named "session-{id}" carries the
+// rendered status fragment, which the dashboard swaps in with the HTMX SSE
+// extension.
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+	admin := claims.Role == string(models.RoleAdmin)
+
+	shared, err := h.sharedRoles(r.Context(), claims.UserID)
+	if err != nil {
+		h.Logger.Error("Database error fetching shared sessions", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch, cancel, complete := GlobalHub.Events.SubscribeFrom(lastID, 64)
+	defer cancel()
+
+	if !complete {
+		writeSSE(w, "", "resync", "{}")
+	}
+	if err := rc.Flush(); err != nil {
+		h.Logger.Error("Streaming unsupported", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if (e.Type == events.MemberAdded || e.Type == events.MemberRemoved) && e.UserID == claims.UserID || orgRoomCreated(e) {
+				if roles, err := h.sharedRoles(r.Context(), claims.UserID); err == nil {
+					shared = roles
+				}
+			}
+			if !e.VisibleTo(claims.UserID, admin) && !memberSees(shared[e.SessionID], e.Type) {
+				continue
+			}
+
+			payload, err := json.Marshal(e)
+			if err != nil {
+				h.Logger.Error("Event encoding error", "type", e.Type, "error", err)
+				continue
+			}
+			writeSSE(w, GlobalHub.Events.FormatID(e.ID), e.Type, string(payload))
+
+			if e.Type == events.SessionState {
+				var buf bytes.Buffer
+				if err := h.Templates["dashboard.html"].ExecuteTemplate(&buf, "session-status", e.Data); err != nil {
+					h.Logger.Error("Template execution error", "template", "session-status", "error", err)
+					continue
+				}
+				writeSSE(w, "", "session-"+e.SessionID, strings.TrimSpace(buf.String()))
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sharedRoles maps the sessions shared with a user to their role in them,
+// including the rooms of their organizations that another admin owns.
+func (h *Handler) sharedRoles(ctx context.Context, userID int64) (map[string]models.MemberRole, error) {
+	sessions, _, err := h.Store.Members.Sessions(ctx, userID, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string]models.MemberRole)
+	for _, s := range sessions {
+		if s.UserID != userID {
+			roles[s.ID] = s.Role
+		}
+	}
+	return roles, nil
+}
+
+// orgRoomCreated reports whether e announces a new room of an
+// organization, which its admins and staff see without being its owner.
+func orgRoomCreated(e events.Event) bool {
+	s, ok := e.Data.(models.Session)
+	return e.Type == events.SessionCreated && ok && s.OrgID != 0
+}
+
+// memberSees reports whether a member with role may see an event of type
+// about the session.
+func memberSees(role models.MemberRole, typ string) bool {
+	switch {
+	case role == "":
+		return false
+	case strings.HasPrefix(typ, "session."), strings.HasPrefix(typ, "stream."):
+		return can(role, permView)
+	case strings.HasPrefix(typ, "alert."):
+		return can(role, permListen)
+	case strings.HasPrefix(typ, "recording."):
+		return can(role, permRecordings)
+	}
+	return false
+}
+
+// writeSSE writes one event in text/event-stream framing. Multi-line data is
+// split into several data fields as the format requires.
+func writeSSE(w io.Writer, id, event, data string) {
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	io.WriteString(w, "\n")
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zamibd/a2web/internal/auth"
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store/sqlstore"
+)
+
+func TestMemberSees(t *testing.T) {
+	tests := []struct {
+		role models.MemberRole
+		typ  string
+		want bool
+	}{
+		{"", events.StreamStarted, false},
+		{models.MemberListener, events.SessionState, true},
+		{models.MemberListener, events.StreamStarted, true},
+		{models.MemberListener, events.StreamStopped, true},
+		{models.MemberListener, events.AlertRaised, true},
+		{models.MemberListener, events.RecordingFinalized, false},
+		{models.MemberViewer, events.StreamStarted, true},
+		{models.MemberViewer, events.AlertRaised, false},
+		{models.MemberViewer, events.RecordingFinalized, true},
+		{models.MemberStaff, events.StreamStopped, true},
+		{models.MemberListener, events.MemberAdded, false},
+	}
+	for _, tt := range tests {
+		if got := memberSees(tt.role, tt.typ); got != tt.want {
+			t.Errorf("memberSees(%q, %q) = %v, want %v", tt.role, tt.typ, got, tt.want)
+		}
+	}
+}
+
+// newTestHandler returns handlers on a fresh SQLite database.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	if err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "a2web.db")); err != nil {
+		t.Fatal(err)
+	}
+	db := database.DB
+	t.Cleanup(func() { db.Close() })
+	if _, err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), map[string]*template.Template{}, sqlstore.New(db), db)
+}
+
+// streamEvents serves EventsHandler to claims and requests the stream with
+// a Last-Event-ID of lastID, if set. The stream is closed with the test.
+func streamEvents(t *testing.T, h *Handler, claims *auth.Claims, lastID string) *bufio.Scanner {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.EventsHandler(w, withClaims(r, claims))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	return bufio.NewScanner(resp.Body)
+}
+
+// TestEventsHandlerMember streams the events of a listener member of
+// another user's session.
+func TestEventsHandlerMember(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	st := h.Store
+
+	owner := models.User{Mobile: "01700000001", PasswordHash: "-"}
+	member := models.User{Mobile: "01700000002", PasswordHash: "-"}
+	for _, u := range []*models.User{&owner, &member} {
+		if err := st.Users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared := models.Session{ID: "shared", UserID: owner.ID, Name: "Shared", Settings: models.DefaultSessionSettings}
+	private := models.Session{ID: "private", UserID: owner.ID, Name: "Private", Settings: models.DefaultSessionSettings}
+	for _, s := range []*models.Session{&shared, &private} {
+		if err := st.Sessions.Create(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inv := models.Invite{SessionID: shared.ID, Mobile: member.Mobile, Role: models.MemberListener, InvitedBy: owner.ID,
+		ExpiresAt: time.Now().Add(time.Hour)}
+	if err := st.Members.CreateInvite(ctx, &inv, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Members.Accept(ctx, inv.ID, member.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := streamEvents(t, h, &auth.Claims{UserID: member.ID, Role: string(models.RoleUser)}, "")
+
+	// The member must skip the event of the session not shared with them
+	// and get the one of the shared session.
+	GlobalHub.Events.Publish(events.Event{Type: events.StreamStarted, SessionID: private.ID, UserID: owner.ID})
+	GlobalHub.Events.Publish(events.Event{Type: events.StreamStarted, SessionID: shared.ID, UserID: owner.ID})
+
+	var event string
+	for sc.Scan() {
+		line := sc.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || event != events.StreamStarted {
+			continue
+		}
+		if !strings.Contains(data, `"session_id":"shared"`) {
+			t.Fatalf("member received %s", data)
+		}
+		return
+	}
+	t.Fatalf("no stream.started event: %v", sc.Err())
+}
+
+// TestEventsHandlerRestart resumes from the ID of an event of a previous
+// process, which must not be taken for the event of this one with the same
+// number.
+func TestEventsHandlerRestart(t *testing.T) {
+	h := newTestHandler(t)
+	before := events.NewBus(0)
+
+	GlobalHub.Events.Publish(events.Event{Type: events.SessionState})
+	sc := streamEvents(t, h, &auth.Claims{UserID: 1, Role: string(models.RoleAdmin)}, before.FormatID(1))
+	for sc.Scan() {
+		if strings.HasPrefix(sc.Text(), "event: ") {
+			if sc.Text() != "event: resync" {
+				t.Fatalf("first event %q, want resync", sc.Text())
+			}
+			return
+		}
+	}
+	t.Fatalf("no resync event: %v", sc.Err())
+}
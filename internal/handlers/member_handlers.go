@@ -0,0 +1,602 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/config"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// permission is something a user may be allowed to do with a session. The
+// owner, and the admins of the organization a room belongs to, hold every
+// permission, members and staff those of their role.
+type permission int
+
+const (
+	permView       permission = iota // see the session and its live state
+	permListen                       // listen live, see and acknowledge alerts
+	permRecordings                   // list and play recordings
+	permManage                       // share, delete, edit alert rules and broadcast with credentials
+)
+
+func can(role models.MemberRole, p permission) bool {
+	switch role {
+	case models.MemberOwner:
+		return true
+	case models.MemberListener:
+		return p == permView || p == permListen
+	case models.MemberViewer:
+		return p == permView || p == permRecordings
+	case models.MemberStaff:
+		return p != permManage
+	}
+	return false
+}
+
+var (
+	// errNoSession is returned for sessions that do not exist and for those
+	// the user has no role in, so their existence is not revealed.
+	errNoSession = errors.New("session not found")
+	// errNoPermission is returned to members whose role lacks a permission.
+	errNoPermission = errors.New("your role on this session does not allow this")
+	// errOwnSession refuses invites to a session's own owner.
+	errOwnSession = errors.New("you own this session")
+)
+
+// sessionAccess loads a session and the role userID holds in it.
+func (h *Handler) sessionAccess(ctx context.Context, sessionID string, userID int64) (models.Session, models.MemberRole, error) {
+	s, err := h.Store.Sessions.Get(ctx, sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return s, "", errNoSession
+	}
+	if err != nil {
+		return s, "", err
+	}
+	if s.UserID == userID {
+		return s, models.MemberOwner, nil
+	}
+	if s.OrgID != 0 {
+		orgRole, err := h.Store.Orgs.Role(ctx, s.OrgID, userID)
+		switch {
+		case err != nil && !errors.Is(err, store.ErrNotFound):
+			return s, "", err
+		case orgRole == models.OrgAdmin:
+			return s, models.MemberOwner, nil
+		case orgRole == models.OrgStaff:
+			return s, models.MemberStaff, nil
+		}
+	}
+	role, err := h.Store.Members.Role(ctx, sessionID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return s, "", errNoSession
+	}
+	return s, role, err
+}
+
+// sessionFor is sessionAccess for users who need perm.
+func (h *Handler) sessionFor(ctx context.Context, sessionID string, userID int64, perm permission) (models.Session, models.MemberRole, error) {
+	s, role, err := h.sessionAccess(ctx, sessionID, userID)
+	if err == nil && !can(role, perm) {
+		err = errNoPermission
+	}
+	return s, role, err
+}
+
+// webSession is sessionFor for pages and fragments: failures are answered
+// with plain-text errors.
+func (h *Handler) webSession(w http.ResponseWriter, r *http.Request, sessionID string, perm permission) (models.Session, models.MemberRole, bool) {
+	s, role, err := h.sessionFor(r.Context(), sessionID, requestClaims(r).UserID, perm)
+	switch {
+	case err == nil:
+		return s, role, true
+	case errors.Is(err, errNoSession):
+		http.Error(w, "Session not found", http.StatusNotFound)
+	case errors.Is(err, errNoPermission):
+		http.Error(w, "Unauthorized", http.StatusForbidden)
+	default:
+		h.Logger.Error("Database error fetching session", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+	return s, role, false
+}
+
+// Invite lifetimes.
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+// InviteRequest is the body of POST /api/v1/sessions/{id}/invites and the
+// form of the dashboard's share panel. Without a mobile number the invite
+// is a link for whoever opens it first.
+type InviteRequest struct {
+	Mobile         string            `json:"mobile"`
+	Role           models.MemberRole `json:"role"`
+	ExpiresInHours int               `json:"expires_in_hours"` // default 168, at most 720
+}
+
+// invite validates the request and turns it into an invite to s, which is
+// shared under settings.
+func (req InviteRequest) invite(s models.Session, invitedBy int64, settings models.OrgSettings) (models.Invite, error) {
+	inv := models.Invite{SessionID: s.ID, SessionName: s.Name, Mobile: strings.TrimSpace(req.Mobile), Role: req.Role, InvitedBy: invitedBy}
+	if inv.Role != models.MemberListener && inv.Role != models.MemberViewer {
+		return inv, errors.New(`role must be "listener" or "viewer"`)
+	}
+	if inv.Mobile == "" && !settings.InviteLinks {
+		return inv, errors.New("this organization shares rooms with mobile numbers only")
+	}
+	if inv.Role == models.MemberViewer && !settings.GuardianRecordings {
+		return inv, errors.New("this organization does not share recordings with guardians")
+	}
+	ttl := time.Duration(req.ExpiresInHours) * time.Hour
+	if ttl == 0 {
+		ttl = defaultInviteTTL
+	}
+	if ttl < 0 || ttl > maxInviteTTL {
+		return inv, fmt.Errorf("expires_in_hours must be between 1 and %d", int(maxInviteTTL.Hours()))
+	}
+	inv.ExpiresAt = time.Now().Add(ttl).UTC()
+	return inv, nil
+}
+
+// hashInviteToken is what is stored of an invite link's token.
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// inviteURL is the link an invite token is accepted at.
+func inviteURL(r *http.Request, token string) string {
+	return publicURL(r, "/invite/"+token)
+}
+
+// publicURL makes path absolute, on PUBLIC_URL or else the host the
+// request came to.
+func publicURL(r *http.Request, path string) string {
+	base := config.AppConfig.PublicURL
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+// createInvite stores inv and announces it to the invited user. Link
+// invites get a token, returned here and nowhere else.
+func (h *Handler) createInvite(r *http.Request, inv *models.Invite) (string, error) {
+	var token, tokenHash string
+	if inv.Mobile == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		token = base64.RawURLEncoding.EncodeToString(b)
+		tokenHash = hashInviteToken(token)
+	}
+	if err := h.Store.Members.CreateInvite(r.Context(), inv, tokenHash); err != nil {
+		return "", err
+	}
+
+	detail := "role=" + string(inv.Role)
+	if inv.Mobile != "" {
+		detail += " mobile=" + inv.Mobile
+	} else {
+		detail += " via=link"
+	}
+	h.audit(r, audit.Event{Action: audit.ActionInviteCreate, TargetType: "session", TargetID: inv.SessionID, Detail: detail})
+	h.Logger.Info("Session invite created", "session_id", inv.SessionID, "invite_id", inv.ID, "role", inv.Role)
+
+	if inv.Mobile != "" {
+		if u, err := h.Store.Users.GetByMobile(r.Context(), inv.Mobile); err == nil {
+			GlobalHub.Events.Publish(events.Event{Type: events.InviteCreated, SessionID: inv.SessionID, UserID: u.ID, Data: *inv})
+		}
+	}
+	return token, nil
+}
+
+// acceptInvite makes userID a member with the invite's role, and a
+// guardian of the organization of a room.
+func (h *Handler) acceptInvite(r *http.Request, inv models.Invite, userID int64) error {
+	s, err := h.Store.Sessions.Get(r.Context(), inv.SessionID)
+	if err != nil {
+		return err
+	}
+	join, err := h.mustJoinOrg(r.Context(), s, userID)
+	if err != nil {
+		return err
+	}
+	if err := h.Store.Members.Accept(r.Context(), inv.ID, userID); err != nil {
+		return err
+	}
+	if join {
+		if err := h.Store.Orgs.SetMember(r.Context(), &models.OrgMember{OrgID: s.OrgID, UserID: userID, Role: models.OrgGuardian}); err != nil {
+			return err
+		}
+	}
+	h.audit(r, audit.Event{ActorID: userID, Action: audit.ActionInviteAccept, TargetType: "session", TargetID: inv.SessionID,
+		Detail: "role=" + string(inv.Role)})
+	h.Logger.Info("Session invite accepted", "session_id", inv.SessionID, "invite_id", inv.ID, "user_id", userID)
+
+	// A listener who becomes a viewer must not keep listening
+	if inv.Role != models.MemberListener {
+		GlobalHub.DisconnectListener(inv.SessionID, userID)
+	}
+	GlobalHub.Events.Publish(events.Event{
+		Type:      events.MemberAdded,
+		SessionID: inv.SessionID,
+		UserID:    userID,
+		Data:      models.Member{SessionID: inv.SessionID, UserID: userID, Role: inv.Role},
+	})
+	return nil
+}
+
+// acceptableBy checks that userID may accept inv: invites to a mobile
+// number only by its user, and none by the session's owner.
+func (h *Handler) acceptableBy(ctx context.Context, inv models.Invite, userID int64) error {
+	s, err := h.Store.Sessions.Get(ctx, inv.SessionID)
+	if err != nil {
+		return err
+	}
+	if s.UserID == userID {
+		return errOwnSession
+	}
+	if inv.Mobile == "" {
+		return nil
+	}
+	u, err := h.Store.Users.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if u.Mobile != inv.Mobile {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// removeMember revokes a member's role, dropping their live connections
+// at once.
+func (h *Handler) removeMember(r *http.Request, sessionID string, userID int64) error {
+	if err := h.Store.Members.Remove(r.Context(), sessionID, userID); err != nil {
+		return err
+	}
+	GlobalHub.DisconnectListener(sessionID, userID)
+	h.audit(r, audit.Event{Action: audit.ActionMemberRemove, TargetType: "session", TargetID: sessionID,
+		Detail: "user_id=" + strconv.FormatInt(userID, 10)})
+	h.Logger.Info("Session member removed", "session_id", sessionID, "user_id", userID, "by", requestClaims(r).UserID)
+	GlobalHub.Events.Publish(events.Event{
+		Type:      events.MemberRemoved,
+		SessionID: sessionID,
+		UserID:    userID,
+		Data:      models.Member{SessionID: sessionID, UserID: userID},
+	})
+	return nil
+}
+
+// mayRevoke reports whether userID may delete inv: the session's owner,
+// and the invited user of an invite to a mobile number, to decline it.
+func (h *Handler) mayRevoke(ctx context.Context, inv models.Invite, userID int64) (bool, error) {
+	_, _, err := h.sessionFor(ctx, inv.SessionID, userID, permManage)
+	if err == nil || (!errors.Is(err, errNoSession) && !errors.Is(err, errNoPermission)) {
+		return err == nil, err
+	}
+	if inv.Mobile == "" {
+		return false, nil
+	}
+	err = h.acceptableBy(ctx, inv, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// revokeInvite deletes an invite the caller may revoke.
+func (h *Handler) revokeInvite(r *http.Request, inv models.Invite) error {
+	if err := h.Store.Members.DeleteInvite(r.Context(), inv.ID); err != nil {
+		return err
+	}
+	h.audit(r, audit.Event{Action: audit.ActionInviteRevoke, TargetType: "session", TargetID: inv.SessionID,
+		Detail: "invite_id=" + strconv.FormatInt(inv.ID, 10)})
+	return nil
+}
+
+// renderSharePanel renders the members and pending invites of a session
+// with the form to invite more. link is the URL of an invite just created.
+func (h *Handler) renderSharePanel(w http.ResponseWriter, r *http.Request, s models.Session, link, formError string) {
+	members, err := h.Store.Members.List(r.Context(), s.ID)
+	if err != nil {
+		h.Logger.Error("Database error fetching members", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	invites, err := h.Store.Members.Invites(r.Context(), s.ID)
+	if err != nil {
+		h.Logger.Error("Database error fetching invites", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, "share-panel", map[string]interface{}{
+		"Session": s,
+		"Members": members,
+		"Invites": invites,
+		"Link":    link,
+		"Error":   formError,
+	}); err != nil {
+		h.Logger.Error("Template execution error", "template", "share-panel", "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
+
+// SharePanelHandler shows and changes who a session is shared with. Only
+// its owner may.
+//
+//	GET    /session/share?id={session_id}                          the share panel
+//	POST   /session/share?id={session_id}                          invite (form: mobile, role, expires_in_hours)
+//	DELETE /session/share?id={session_id}&invite_id={invite_id}    revoke an invite
+//	DELETE /session/share?id={session_id}&user_id={user_id}        revoke a member's access
+func (h *Handler) SharePanelHandler(w http.ResponseWriter, r *http.Request) {
+	s, _, ok := h.webSession(w, r, r.URL.Query().Get("id"), permManage)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.renderSharePanel(w, r, s, "", "")
+
+	case http.MethodPost:
+		if s.Archived() {
+			h.renderSharePanel(w, r, s, "", "Archived sessions cannot be shared")
+			return
+		}
+		settings, err := h.roomSettings(r.Context(), s)
+		if err != nil {
+			h.Logger.Error("Database error fetching organization", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		hours, _ := strconv.Atoi(r.FormValue("expires_in_hours"))
+		req := InviteRequest{Mobile: r.FormValue("mobile"), Role: models.MemberRole(r.FormValue("role")), ExpiresInHours: hours}
+		inv, err := req.invite(s, requestClaims(r).UserID, settings)
+		inv.InviterMobile = h.requestMobile(r)
+		if err == nil && inv.Mobile == inv.InviterMobile {
+			err = errOwnSession
+		}
+		if err != nil {
+			h.renderSharePanel(w, r, s, "", err.Error())
+			return
+		}
+		token, err := h.createInvite(r, &inv)
+		if err != nil {
+			h.Logger.Error("Database error creating invite", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		link := ""
+		if token != "" {
+			link = inviteURL(r, token)
+		}
+		h.renderSharePanel(w, r, s, link, "")
+
+	case http.MethodDelete:
+		var err error
+		if v := r.URL.Query().Get("invite_id"); v != "" {
+			id, perr := strconv.ParseInt(v, 10, 64)
+			if perr != nil {
+				http.Error(w, "Invalid invite ID", http.StatusBadRequest)
+				return
+			}
+			var inv models.Invite
+			if inv, err = h.Store.Members.GetInvite(r.Context(), id); err == nil && inv.SessionID != s.ID {
+				err = store.ErrNotFound
+			}
+			if err == nil {
+				err = h.revokeInvite(r, inv)
+			}
+		} else {
+			userID, perr := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+			if perr != nil {
+				http.Error(w, "Invalid user ID", http.StatusBadRequest)
+				return
+			}
+			err = h.removeMember(r, s.ID, userID)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error revoking access", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		h.renderSharePanel(w, r, s, "", "")
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requestMobile returns the mobile number of the caller, or "" when it
+// cannot be loaded.
+func (h *Handler) requestMobile(r *http.Request) string {
+	u, err := h.Store.Users.Get(r.Context(), requestClaims(r).UserID)
+	if err != nil {
+		return ""
+	}
+	return u.Mobile
+}
+
+// LeaveSessionHandler gives up the caller's role in a session shared with
+// them. POST /session/leave?id={session_id}
+func (h *Handler) LeaveSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := h.removeMember(r, r.URL.Query().Get("id"), requestClaims(r).UserID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Database error leaving session", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DashboardInvitesHandler renders the invites to the caller's mobile
+// number, which the dashboard reloads when one arrives or is accepted.
+func (h *Handler) DashboardInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.Store.Members.InvitesFor(r.Context(), h.requestMobile(r))
+	if err != nil {
+		h.Logger.Error("Database error fetching invites", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, "invite-list", map[string]interface{}{
+		"Invites": invites,
+	}); err != nil {
+		h.Logger.Error("Template execution error", "template", "invite-list", "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
+
+// RespondInviteHandler accepts or declines an invite to the caller's
+// mobile number.
+//
+//	POST   /invites?id={invite_id}   accept
+//	DELETE /invites?id={invite_id}   decline
+func (h *Handler) RespondInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid invite ID", http.StatusBadRequest)
+		return
+	}
+	userID := requestClaims(r).UserID
+
+	switch r.Method {
+	case http.MethodPost:
+		inv, err := h.Store.Members.GetInvite(r.Context(), id)
+		if err == nil && inv.Mobile == "" {
+			err = store.ErrNotFound // links are accepted with their token
+		}
+		if err == nil {
+			err = h.acceptableBy(r.Context(), inv, userID)
+		}
+		if err == nil {
+			err = h.acceptInvite(r, inv, userID)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Invite not found or expired", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errMemberQuota) {
+			http.Error(w, "The organization has reached its member quota", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Error accepting invite", "invite_id", id, "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		inv, err := h.Store.Members.GetInvite(r.Context(), id)
+		if err == nil && inv.Mobile == "" {
+			err = store.ErrNotFound
+		}
+		if err == nil {
+			err = h.acceptableBy(r.Context(), inv, userID)
+		}
+		if err == nil {
+			err = h.revokeInvite(r, inv)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Invite not found or expired", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error declining invite", "invite_id", id, "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.DashboardInvitesHandler(w, r)
+}
+
+// InvitePageHandler shows an invite link and accepts it. It works without
+// signing in, as the cookie is not sent when the link is opened from
+// another site; accepting sends anyone signed out to the login page first.
+//
+//	GET  /invite/{token}
+//	POST /invite/{token}
+func (h *Handler) InvitePageHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Path[len("/invite/"):]
+	inv, err := h.Store.Members.GetInviteByToken(r.Context(), hashInviteToken(token))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		h.Logger.Error("Database error fetching invite", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]interface{}{"Title": "Invitation", "Token": token}
+	if err == nil {
+		data["Invite"] = inv
+	}
+
+	if r.Method == http.MethodPost && err == nil {
+		claims, aerr := h.authenticate(r, "")
+		if aerr != nil {
+			http.Redirect(w, r, "/login-page?next="+r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		r = withClaims(r, claims)
+		err = h.acceptableBy(r.Context(), inv, claims.UserID)
+		if err == nil {
+			err = h.acceptInvite(r, inv, claims.UserID)
+		}
+		if err == nil {
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			return
+		}
+		if !errors.Is(err, store.ErrNotFound) && !errors.Is(err, errOwnSession) && !errors.Is(err, errMemberQuota) {
+			h.Logger.Error("Error accepting invite", "invite_id", inv.ID, "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		data["Error"] = err.Error()
+		if errors.Is(err, store.ErrNotFound) {
+			delete(data, "Invite")
+		}
+	} else if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := data["Invite"]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	if err := h.Templates["invite.html"].ExecuteTemplate(w, "layout", data); err != nil {
+		h.Logger.Error("Template execution error", "template", "invite.html", "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
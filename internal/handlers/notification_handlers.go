@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zamibd/a2web/internal/notify"
+)
+
+// NotificationPreferencesHandler reads and replaces the caller's email and
+// Telegram notification settings.
+//
+//	GET /notifications/preferences   current settings and available channels
+//	PUT /notifications/preferences   replace settings (notify.Preferences)
+func (h *Handler) NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		prefs, err := h.NotifyStore.LoadPreferences(claims.UserID)
+		if err != nil {
+			h.Logger.Error("Database error fetching notification preferences", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		channels := []string{}
+		if h.Notifier != nil {
+			channels = append(channels, h.Notifier.Channels()...)
+		}
+		if prefs.Events == nil {
+			prefs.Events = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"preferences": prefs,
+			"channels":    channels,
+			"events":      notify.Events,
+		})
+
+	case http.MethodPut, http.MethodPost:
+		var prefs notify.Preferences
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := prefs.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.NotifyStore.SavePreferences(claims.UserID, prefs); err != nil {
+			h.Logger.Error("Database error saving notification preferences", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		h.Logger.Info("Notification preferences saved", "user_id", claims.UserID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/auth"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/recordings"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// maxOrgNameLength bounds organization names.
+const maxOrgNameLength = 100
+
+var (
+	// errNoOrg is returned for organizations that do not exist and for
+	// those the user is not a member of, so their existence is not
+	// revealed.
+	errNoOrg = errors.New("organization not found")
+	// errNoUser refuses members who have not registered yet.
+	errNoUser = errors.New("no user with this mobile number")
+	// errLastAdmin refuses removing or demoting an organization's only
+	// admin, which would leave its rooms without a manager.
+	errLastAdmin = errors.New("an organization needs at least one admin")
+	// Quota errors.
+	errRoomQuota   = errors.New("the organization has reached its room quota")
+	errMemberQuota = errors.New("the organization has reached its member quota")
+)
+
+// orgAccess loads an organization and the role userID holds in it. With
+// manage, only its admins may. Administrators of the server rank above
+// them: they may manage every organization, members or not, in which case
+// the role is empty. They get no role in its rooms though.
+func (h *Handler) orgAccess(ctx context.Context, orgID int64, claims *auth.Claims, manage bool) (models.Organization, models.OrgRole, error) {
+	o, err := h.Store.Orgs.Get(ctx, orgID)
+	if errors.Is(err, store.ErrNotFound) {
+		return o, "", errNoOrg
+	}
+	if err != nil {
+		return o, "", err
+	}
+	superAdmin := claims.Role == string(models.RoleAdmin)
+	role, err := h.Store.Orgs.Role(ctx, orgID, claims.UserID)
+	switch {
+	case errors.Is(err, store.ErrNotFound) && superAdmin:
+	case errors.Is(err, store.ErrNotFound):
+		return o, "", errNoOrg
+	case err != nil:
+		return o, "", err
+	}
+	if manage && role != models.OrgAdmin && !superAdmin {
+		return o, role, errNoPermission
+	}
+	return o, role, nil
+}
+
+// webOrg is orgAccess for pages and fragments, for the organization in
+// the query parameter param. Failures are answered with plain-text errors.
+func (h *Handler) webOrg(w http.ResponseWriter, r *http.Request, param string, manage bool) (models.Organization, models.OrgRole, bool) {
+	id, err := strconv.ParseInt(r.URL.Query().Get(param), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid organization ID", http.StatusBadRequest)
+		return models.Organization{}, "", false
+	}
+	o, role, err := h.orgAccess(r.Context(), id, requestClaims(r), manage)
+	switch {
+	case err == nil:
+		return o, role, true
+	case errors.Is(err, errNoOrg):
+		http.Error(w, "Organization not found", http.StatusNotFound)
+	case errors.Is(err, errNoPermission):
+		http.Error(w, "Unauthorized", http.StatusForbidden)
+	default:
+		h.Logger.Error("Database error fetching organization", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+	return o, role, false
+}
+
+// roomSettings returns the settings a session is shared under: those of
+// its organization, and for personal sessions no restriction.
+func (h *Handler) roomSettings(ctx context.Context, s models.Session) (models.OrgSettings, error) {
+	if s.OrgID == 0 {
+		return models.OrgSettings{InviteLinks: true, GuardianRecordings: true}, nil
+	}
+	o, err := h.Store.Orgs.Get(ctx, s.OrgID)
+	return o.Settings, err
+}
+
+// checkRoomQuota refuses a new room of an organization at its quota.
+func (h *Handler) checkRoomQuota(ctx context.Context, orgID int64) error {
+	o, err := h.Store.Orgs.Get(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	usage, err := h.Store.Orgs.Usage(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if o.Quotas.MaxRooms > 0 && usage.Rooms >= o.Quotas.MaxRooms {
+		return errRoomQuota
+	}
+	return nil
+}
+
+// checkMemberQuota refuses a new member of an organization at its quota.
+func (h *Handler) checkMemberQuota(ctx context.Context, o models.Organization) error {
+	if o.Quotas.MaxMembers == 0 {
+		return nil
+	}
+	usage, err := h.Store.Orgs.Usage(ctx, o.ID)
+	if err != nil {
+		return err
+	}
+	if usage.Members >= o.Quotas.MaxMembers {
+		return errMemberQuota
+	}
+	return nil
+}
+
+// mayRecord reports whether a session's broadcasts are recorded: rooms of
+// organizations over their storage quota are only relayed.
+func (h *Handler) mayRecord(ctx context.Context, s models.Session) (bool, error) {
+	if s.OrgID == 0 {
+		return true, nil
+	}
+	o, err := h.Store.Orgs.Get(ctx, s.OrgID)
+	if err != nil || o.Quotas.MaxStorageBytes == 0 {
+		return err == nil, err
+	}
+	usage, err := h.Store.Orgs.Usage(ctx, s.OrgID)
+	if err != nil {
+		return false, err
+	}
+	return usage.StorageBytes < o.Quotas.MaxStorageBytes, nil
+}
+
+// mustJoinOrg reports whether userID must become a guardian of the
+// organization a room belongs to, as they are not a member yet, and
+// refuses them at its member quota. Accepting an invite to a room joins.
+func (h *Handler) mustJoinOrg(ctx context.Context, s models.Session, userID int64) (bool, error) {
+	if s.OrgID == 0 {
+		return false, nil
+	}
+	_, err := h.Store.Orgs.Role(ctx, s.OrgID, userID)
+	if !errors.Is(err, store.ErrNotFound) {
+		return false, err
+	}
+	o, err := h.Store.Orgs.Get(ctx, s.OrgID)
+	if err != nil {
+		return false, err
+	}
+	return true, h.checkMemberQuota(ctx, o)
+}
+
+// keepsAdmin refuses taking the admin role from userID when no other
+// member of the organization holds it.
+func (h *Handler) keepsAdmin(ctx context.Context, orgID, userID int64) error {
+	members, err := h.Store.Orgs.Members(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.Role == models.OrgAdmin && m.UserID != userID {
+			return nil
+		}
+	}
+	return errLastAdmin
+}
+
+// disconnectFromOrg closes a user's live connections to the rooms of an
+// organization. They reconnect only if still allowed to.
+func (h *Handler) disconnectFromOrg(ctx context.Context, orgID, userID int64) {
+	rooms, err := h.Store.Orgs.Rooms(ctx, orgID)
+	if err != nil {
+		h.Logger.Error("Database error fetching rooms", "org_id", orgID, "error", err)
+		return
+	}
+	for _, s := range rooms {
+		GlobalHub.DisconnectListener(s.ID, userID)
+	}
+}
+
+// OrgMemberRequest is the body of POST /api/v1/orgs/{id}/members and the
+// form of the organization panel. It adds a registered user or changes the
+// role of a member.
+type OrgMemberRequest struct {
+	Mobile string         `json:"mobile"`
+	Role   models.OrgRole `json:"role"`
+}
+
+// setOrgMember adds a member to an organization or changes their role.
+// invalid reports that err is a refusal to show the caller rather than a
+// database error; the other organization changes report the same.
+func (h *Handler) setOrgMember(r *http.Request, o models.Organization, req OrgMemberRequest) (m models.OrgMember, invalid bool, err error) {
+	ctx := r.Context()
+	m = models.OrgMember{OrgID: o.ID, Mobile: strings.TrimSpace(req.Mobile), Role: req.Role}
+	if m.Role != models.OrgAdmin && m.Role != models.OrgStaff && m.Role != models.OrgGuardian {
+		return m, true, errors.New(`role must be "admin", "staff" or "guardian"`)
+	}
+	u, err := h.Store.Users.GetByMobile(ctx, m.Mobile)
+	if errors.Is(err, store.ErrNotFound) {
+		return m, true, errNoUser
+	}
+	if err != nil {
+		return m, false, err
+	}
+	m.UserID = u.ID
+
+	current, err := h.Store.Orgs.Role(ctx, o.ID, u.ID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		if err := h.checkMemberQuota(ctx, o); err != nil {
+			return m, errors.Is(err, errMemberQuota), err
+		}
+	case err != nil:
+		return m, false, err
+	case current == models.OrgAdmin && m.Role != models.OrgAdmin:
+		if err := h.keepsAdmin(ctx, o.ID, u.ID); err != nil {
+			return m, errors.Is(err, errLastAdmin), err
+		}
+	}
+
+	if err := h.Store.Orgs.SetMember(ctx, &m); err != nil {
+		return m, false, err
+	}
+	// Rooms stay with an admin
+	if current == models.OrgAdmin && m.Role != models.OrgAdmin {
+		if err := h.Store.Orgs.HandOver(ctx, u.ID, o.ID); err != nil {
+			return m, false, err
+		}
+	}
+	if current != "" && m.Role == models.OrgGuardian {
+		h.disconnectFromOrg(ctx, o.ID, u.ID)
+	}
+
+	h.audit(r, audit.Event{Action: audit.ActionOrgMemberSet, TargetType: "org", TargetID: strconv.FormatInt(o.ID, 10),
+		Detail: "mobile=" + m.Mobile + " role=" + string(m.Role)})
+	h.Logger.Info("Organization member set", "org_id", o.ID, "user_id", u.ID, "role", m.Role, "by", requestClaims(r).UserID)
+	GlobalHub.Events.Publish(events.Event{Type: events.MemberAdded, UserID: u.ID, Data: m})
+	return m, false, nil
+}
+
+// removeOrgMember removes a member from an organization and its rooms,
+// dropping their live connections at once.
+func (h *Handler) removeOrgMember(r *http.Request, orgID, userID int64) error {
+	ctx := r.Context()
+	role, err := h.Store.Orgs.Role(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if role == models.OrgAdmin {
+		if err := h.keepsAdmin(ctx, orgID, userID); err != nil {
+			return err
+		}
+		if err := h.Store.Orgs.HandOver(ctx, userID, orgID); err != nil {
+			return err
+		}
+	}
+	if err := h.Store.Orgs.RemoveMember(ctx, orgID, userID); err != nil {
+		return err
+	}
+	h.disconnectFromOrg(ctx, orgID, userID)
+
+	h.audit(r, audit.Event{Action: audit.ActionOrgMemberRemove, TargetType: "org", TargetID: strconv.FormatInt(orgID, 10),
+		Detail: "user_id=" + strconv.FormatInt(userID, 10)})
+	h.Logger.Info("Organization member removed", "org_id", orgID, "user_id", userID, "by", requestClaims(r).UserID)
+	GlobalHub.Events.Publish(events.Event{
+		Type:   events.MemberRemoved,
+		UserID: userID,
+		Data:   models.OrgMember{OrgID: orgID, UserID: userID},
+	})
+	return nil
+}
+
+// UpdateOrgRequest is the body of PUT /api/v1/orgs/{id} and the settings
+// form of the organization panel.
+type UpdateOrgRequest struct {
+	Name     string             `json:"name"`
+	Settings models.OrgSettings `json:"settings"`
+}
+
+// updateOrg stores the name and settings of an organization.
+func (h *Handler) updateOrg(r *http.Request, o *models.Organization, req UpdateOrgRequest) (invalid bool, err error) {
+	name := strings.TrimSpace(req.Name)
+	if err := validOrgName(name); err != nil {
+		return true, err
+	}
+	o.Name, o.Settings = name, req.Settings
+	if err := h.Store.Orgs.Update(r.Context(), *o); err != nil {
+		return false, err
+	}
+	h.audit(r, audit.Event{Action: audit.ActionOrgUpdate, TargetType: "org", TargetID: strconv.FormatInt(o.ID, 10),
+		Detail: "invite_links=" + strconv.FormatBool(o.Settings.InviteLinks) + " guardian_recordings=" + strconv.FormatBool(o.Settings.GuardianRecordings)})
+	return false, nil
+}
+
+// CreateOrgRequest is the body of POST /api/v1/orgs and the form of the
+// admin panel. The organization starts with one admin, who must have
+// registered.
+type CreateOrgRequest struct {
+	Name        string           `json:"name"`
+	AdminMobile string           `json:"admin_mobile"`
+	Quotas      models.OrgQuotas `json:"quotas"`
+}
+
+// createOrg creates an organization with its first admin.
+func (h *Handler) createOrg(r *http.Request, req CreateOrgRequest) (o models.Organization, invalid bool, err error) {
+	o = models.Organization{
+		Name:     strings.TrimSpace(req.Name),
+		Quotas:   req.Quotas,
+		Settings: models.OrgSettings{InviteLinks: true, GuardianRecordings: true},
+	}
+	if err := validOrgName(o.Name); err != nil {
+		return o, true, err
+	}
+	if err := validQuotas(o.Quotas); err != nil {
+		return o, true, err
+	}
+	admin, err := h.Store.Users.GetByMobile(r.Context(), strings.TrimSpace(req.AdminMobile))
+	if errors.Is(err, store.ErrNotFound) {
+		return o, true, errNoUser
+	}
+	if err != nil {
+		return o, false, err
+	}
+	if err := h.Store.Orgs.Create(r.Context(), &o); err != nil {
+		return o, false, err
+	}
+	m := models.OrgMember{OrgID: o.ID, UserID: admin.ID, Mobile: admin.Mobile, Role: models.OrgAdmin}
+	if err := h.Store.Orgs.SetMember(r.Context(), &m); err != nil {
+		return o, false, err
+	}
+	h.audit(r, audit.Event{Action: audit.ActionOrgCreate, TargetType: "org", TargetID: strconv.FormatInt(o.ID, 10),
+		Detail: "name=" + o.Name + " admin=" + admin.Mobile})
+	h.Logger.Info("Organization created", "org_id", o.ID, "admin_id", admin.ID)
+	GlobalHub.Events.Publish(events.Event{Type: events.MemberAdded, UserID: admin.ID, Data: m})
+	return o, false, nil
+}
+
+func validOrgName(name string) error {
+	if name == "" || len(name) > maxOrgNameLength {
+		return errors.New("name must be 1 to " + strconv.Itoa(maxOrgNameLength) + " characters")
+	}
+	return nil
+}
+
+func validQuotas(q models.OrgQuotas) error {
+	if q.MaxRooms < 0 || q.MaxMembers < 0 || q.MaxStorageBytes < 0 {
+		return errors.New("quotas must not be negative")
+	}
+	return nil
+}
+
+// setOrgQuotas replaces the quotas of an organization.
+func (h *Handler) setOrgQuotas(r *http.Request, orgID int64, q models.OrgQuotas) error {
+	if err := h.Store.Orgs.SetQuotas(r.Context(), orgID, q); err != nil {
+		return err
+	}
+	h.audit(r, audit.Event{Action: audit.ActionOrgQuotas, TargetType: "org", TargetID: strconv.FormatInt(orgID, 10),
+		Detail: "max_rooms=" + strconv.Itoa(q.MaxRooms) + " max_members=" + strconv.Itoa(q.MaxMembers) +
+			" max_storage_bytes=" + strconv.FormatInt(q.MaxStorageBytes, 10)})
+	return nil
+}
+
+// deleteOrg removes an organization with its rooms and their recordings.
+func (h *Handler) deleteOrg(r *http.Request, orgID int64) error {
+	rooms, err := h.Store.Orgs.Rooms(r.Context(), orgID)
+	if err != nil {
+		return err
+	}
+	if err := h.Store.Orgs.Delete(r.Context(), orgID); err != nil {
+		return err
+	}
+	for _, s := range rooms {
+		recordings.RemoveSessionFiles(s.ID)
+		GlobalHub.Events.Publish(events.Event{Type: events.SessionDeleted, SessionID: s.ID, UserID: s.UserID})
+	}
+	h.audit(r, audit.Event{Action: audit.ActionOrgDelete, TargetType: "org", TargetID: strconv.FormatInt(orgID, 10),
+		Detail: "rooms=" + strconv.Itoa(len(rooms))})
+	h.Logger.Info("Organization deleted", "org_id", orgID, "rooms", len(rooms))
+	return nil
+}
+
+// OrgView is an organization as its dashboard shows it.
+type OrgView struct {
+	models.Organization
+	Role      models.OrgRole // empty for administrators who are not members
+	Usage     models.OrgUsage
+	CanManage bool
+}
+
+func (o OrgView) Storage() string      { return formatBytes(o.Usage.StorageBytes) }
+func (o OrgView) StorageQuota() string { return formatBytes(o.Quotas.MaxStorageBytes) }
+
+// orgView loads what the dashboard shows of an organization.
+func (h *Handler) orgView(r *http.Request, o models.Organization, role models.OrgRole) (OrgView, error) {
+	usage, err := h.Store.Orgs.Usage(r.Context(), o.ID)
+	return OrgView{
+		Organization: o,
+		Role:         role,
+		Usage:        usage,
+		CanManage:    role == models.OrgAdmin || requestClaims(r).Role == string(models.RoleAdmin),
+	}, err
+}
+
+// renderOrgPanel renders the members and settings of an organization with
+// the forms to change them.
+func (h *Handler) renderOrgPanel(w http.ResponseWriter, r *http.Request, o models.Organization, role models.OrgRole, formError string) {
+	view, err := h.orgView(r, o, role)
+	if err != nil {
+		h.Logger.Error("Database error fetching organization usage", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	members, err := h.Store.Orgs.Members(r.Context(), o.ID)
+	if err != nil {
+		h.Logger.Error("Database error fetching organization members", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, "org-panel", map[string]interface{}{
+		"Org":     view,
+		"Members": members,
+		"Error":   formError,
+	}); err != nil {
+		h.Logger.Error("Template execution error", "template", "org-panel", "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
+
+// OrgPanelHandler shows and changes the members and settings of an
+// organization. Only its admins, and administrators, may.
+//
+//	GET    /org/panel?id={org_id}                         the panel
+//	POST   /org/panel?id={org_id}                         add a member or change a role (form: mobile, role)
+//	DELETE /org/panel?id={org_id}&user_id={user_id}       remove a member
+//	PUT    /org/panel?id={org_id}                         settings (form: name, invite_links, guardian_recordings)
+func (h *Handler) OrgPanelHandler(w http.ResponseWriter, r *http.Request) {
+	o, role, ok := h.webOrg(w, r, "id", true)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		_, invalid, err := h.setOrgMember(r, o, OrgMemberRequest{Mobile: r.FormValue("mobile"), Role: models.OrgRole(r.FormValue("role"))})
+		if invalid {
+			h.renderOrgPanel(w, r, o, role, err.Error())
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error setting organization member", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		err = h.removeOrgMember(r, o.ID, userID)
+		if errors.Is(err, errLastAdmin) {
+			h.renderOrgPanel(w, r, o, role, err.Error())
+			return
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error removing organization member", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodPut:
+		invalid, err := h.updateOrg(r, &o, UpdateOrgRequest{
+			Name: r.FormValue("name"),
+			Settings: models.OrgSettings{
+				InviteLinks:        r.FormValue("invite_links") != "",
+				GuardianRecordings: r.FormValue("guardian_recordings") != "",
+			},
+		})
+		if invalid {
+			h.renderOrgPanel(w, r, o, role, err.Error())
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error updating organization", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.renderOrgPanel(w, r, o, role, "")
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/store"
+	"github.com/zamibd/a2web/internal/webpush"
+)
+
+// PushSubscribeRequest mirrors PushSubscription.toJSON() in the browser, plus
+// an optional device label.
+type PushSubscribeRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	DeviceName string `json:"device_name"`
+}
+
+// PushKeyHandler returns the VAPID public key browsers need to subscribe.
+func (h *Handler) PushKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if h.Push == nil {
+		http.Error(w, "Push notifications are not configured", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"public_key": h.Push.Keys.PublicKey})
+}
+
+// PushSubscriptionsHandler lists, adds and removes the caller's push
+// subscriptions.
+//
+//	GET    /push/subscriptions   list devices
+//	POST   /push/subscriptions   store a subscription (PushSubscribeRequest)
+//	DELETE /push/subscriptions   remove a subscription ({"endpoint": "..."})
+func (h *Handler) PushSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.Push == nil {
+		http.Error(w, "Push notifications are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	claims := requestClaims(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := h.Store.Devices.ListByUser(r.Context(), claims.UserID)
+		if err != nil {
+			h.Logger.Error("Database error fetching push subscriptions", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if subs == nil {
+			subs = []webpush.Subscription{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subs)
+
+	case http.MethodPost:
+		var req PushSubscribeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Endpoint == "" || req.Keys.P256dh == "" || req.Keys.Auth == "" {
+			http.Error(w, "Endpoint and keys required", http.StatusBadRequest)
+			return
+		}
+		// Reject keys we could never encrypt to before storing them.
+		if _, err := webpush.Encrypt(nil, req.Keys.P256dh, req.Keys.Auth); err != nil {
+			http.Error(w, "Invalid subscription keys", http.StatusBadRequest)
+			return
+		}
+		if err := h.Push.CheckEndpoint(r.Context(), req.Endpoint); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sub := webpush.Subscription{
+			UserID:     claims.UserID,
+			Endpoint:   req.Endpoint,
+			P256dh:     req.Keys.P256dh,
+			Auth:       req.Keys.Auth,
+			DeviceName: req.DeviceName,
+		}
+		if sub.DeviceName == "" {
+			sub.DeviceName = r.UserAgent()
+		}
+		created, err := h.Store.Devices.Save(r.Context(), &sub)
+		if errors.Is(err, store.ErrConflict) {
+			http.Error(w, "Endpoint belongs to another account", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error saving push subscription", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		h.Logger.Info("Push subscription saved", "user_id", claims.UserID)
+		if created {
+			h.audit(r, audit.Event{Action: audit.ActionDevicePair, TargetType: "device", Detail: "device_name=" + sub.DeviceName})
+			GlobalHub.Events.Publish(events.Event{
+				Type:   events.DevicePaired,
+				UserID: claims.UserID,
+				Data:   map[string]string{"device_name": sub.DeviceName},
+			})
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		var req PushSubscribeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Endpoint == "" {
+			http.Error(w, "Endpoint required", http.StatusBadRequest)
+			return
+		}
+		if err := h.Store.Devices.Delete(r.Context(), claims.UserID, req.Endpoint); err != nil {
+			h.Logger.Error("Database error deleting push subscription", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, audit.Event{Action: audit.ActionDeviceRemove, TargetType: "device"})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
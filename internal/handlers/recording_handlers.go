@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/recordings"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// RecordingsHandler lists the recordings of a session to its owner and
+// viewers, and lets the owner delete them.
+//
+//	GET    /recordings?session_id={id}   list a session's recordings
+//	DELETE /recordings?id={id}           delete one recording
+func (h *Handler) RecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		sessionID := r.URL.Query().Get("session_id")
+		if _, _, ok := h.webSession(w, r, sessionID, permRecordings); !ok {
+			return
+		}
+		recs, err := h.Store.Recordings.ListBySession(r.Context(), sessionID)
+		if err != nil {
+			h.Logger.Error("Database error fetching recordings", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if recs == nil {
+			recs = []models.Recording{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recs)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+			return
+		}
+		rec, err := h.Store.Recordings.Get(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Recording not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error fetching recording", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		session, _, ok := h.webSession(w, r, rec.SessionID, permManage)
+		if !ok {
+			return
+		}
+		if session.Archived() {
+			http.Error(w, "Session is archived", http.StatusConflict)
+			return
+		}
+		if rec.Status == "recording" {
+			http.Error(w, "Recording is still in progress", http.StatusConflict)
+			return
+		}
+		if err := recordings.Delete(r.Context(), h.Store.Recordings, rec); err != nil {
+			h.Logger.Error("Failed to delete recording", "recording_id", rec.ID, "error", err)
+			http.Error(w, "Failed to delete recording", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, audit.Event{Action: audit.ActionRecordingDelete, TargetType: "recording", TargetID: strconv.FormatInt(rec.ID, 10),
+			Detail: "session_id=" + rec.SessionID})
+
+		h.Logger.Info("Recording deleted", "recording_id", rec.ID, "user_id", claims.UserID)
+		GlobalHub.Events.Publish(events.Event{
+			Type:      events.RecordingDeleted,
+			SessionID: rec.SessionID,
+			UserID:    session.UserID,
+			Data:      rec,
+		})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/zamibd/a2web/internal/middleware"
+	"golang.org/x/time/rate"
+)
+
+// Routes returns the server's router: pages, the JSON API, WebSockets and
+// the static files under web/. mw supplies the login rate limit; request
+// logging is left to the caller.
+func (h *Handler) Routes(mw *middleware.Middleware) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Admin Routes
+	mux.HandleFunc("/admin", h.AdminMiddleware(h.AdminDashboardHandler))
+	mux.HandleFunc("/admin/user/delete", h.AdminMiddleware(h.DeleteUserHandler))
+	mux.HandleFunc("/admin/session/delete", h.AdminMiddleware(h.DeleteSessionHandler))
+	mux.HandleFunc("/admin/sessions", h.AdminMiddleware(h.AdminSessionsHandler))
+	mux.HandleFunc("/admin/users", h.AdminMiddleware(h.AdminUsersHandler))
+	mux.HandleFunc("/admin/user", h.AdminMiddleware(h.AdminUserHandler))
+	mux.HandleFunc("/admin/user/disable", h.AdminMiddleware(h.DisableUserHandler))
+	mux.HandleFunc("/admin/user/enable", h.AdminMiddleware(h.EnableUserHandler))
+	mux.HandleFunc("/admin/user/role", h.AdminMiddleware(h.SetUserRoleHandler))
+	mux.HandleFunc("/admin/user/logout", h.AdminMiddleware(h.ForceLogoutHandler))
+	mux.HandleFunc("/admin/audit", h.AdminMiddleware(h.AdminAuditHandler))
+	mux.HandleFunc("/admin/audit/verify", h.AdminMiddleware(h.AdminAuditVerifyHandler))
+	mux.HandleFunc("/admin/orgs", h.AdminMiddleware(h.AdminOrgsHandler))
+	mux.HandleFunc("/admin/org/quotas", h.AdminMiddleware(h.AdminOrgQuotasHandler))
+	mux.HandleFunc("/admin/org/delete", h.AdminMiddleware(h.AdminDeleteOrgHandler))
+
+	// Public Routes (Auth)
+	// Apply rate limiting to login
+	loginLimiter := mw.RateLimit(rate.Every(1*time.Minute/5), 5) // 5 requests per minute
+	mux.Handle("/login", loginLimiter(http.HandlerFunc(h.LoginHandler)))
+	mux.HandleFunc("/register", h.RegisterHandler)
+	mux.HandleFunc("/logout", h.LogoutHandler)
+
+	// Protected Routes
+	mux.HandleFunc("/dashboard", h.AuthMiddleware(h.DashboardHandler))
+	mux.HandleFunc("/session/create", h.AuthMiddleware(h.CreateSessionHandler))
+	mux.HandleFunc("/session/status", h.AuthMiddleware(h.SessionStatusHandler))
+	mux.HandleFunc("/session/settings", h.AuthMiddleware(h.SessionSettingsHandler))
+	mux.HandleFunc("/session/archive", h.AuthMiddleware(h.ArchiveSessionHandler))
+	mux.HandleFunc("/session/rotate", h.AuthMiddleware(h.RotateKidLinkHandler))
+	mux.HandleFunc("/dashboard/sessions", h.AuthMiddleware(h.DashboardSessionsHandler))
+	mux.HandleFunc("/events", h.AuthMiddleware(h.EventsHandler))
+	mux.HandleFunc("/session/rules", h.AuthMiddleware(h.AlertRulesHandler))
+	mux.HandleFunc("/alerts", h.AuthMiddleware(h.AlertsHandler))
+	mux.HandleFunc("/alerts/ack", h.AuthMiddleware(h.AcknowledgeAlertHandler))
+	mux.HandleFunc("/dashboard/alerts", h.AuthMiddleware(h.DashboardAlertsHandler))
+	mux.HandleFunc("/push/key", h.AuthMiddleware(h.PushKeyHandler))
+	mux.HandleFunc("/push/subscriptions", h.AuthMiddleware(h.PushSubscriptionsHandler))
+	mux.HandleFunc("/webhooks", h.AuthMiddleware(h.WebhooksHandler))
+	mux.HandleFunc("/webhooks/deliveries", h.AuthMiddleware(h.WebhookDeliveriesHandler))
+	mux.HandleFunc("/webhooks/redeliver", h.AuthMiddleware(h.RedeliverWebhookHandler))
+	mux.HandleFunc("/recordings", h.AuthMiddleware(h.RecordingsHandler))
+	mux.HandleFunc("/notifications/preferences", h.AuthMiddleware(h.NotificationPreferencesHandler))
+	mux.HandleFunc("/user/", h.AuthMiddleware(h.ParentPageHandler))
+	mux.HandleFunc("/session/share", h.AuthMiddleware(h.SharePanelHandler))
+	mux.HandleFunc("/session/leave", h.AuthMiddleware(h.LeaveSessionHandler))
+	mux.HandleFunc("/dashboard/invites", h.AuthMiddleware(h.DashboardInvitesHandler))
+	mux.HandleFunc("/invites", h.AuthMiddleware(h.RespondInviteHandler))
+	mux.HandleFunc("/org/panel", h.AuthMiddleware(h.OrgPanelHandler))
+
+	// Versioned JSON API
+	h.RegisterAPI(mux)
+
+	// Public Routes (Pages)
+	mux.HandleFunc("/login-page", h.LoginPageHandler)
+	mux.HandleFunc("/register-page", h.RegisterPageHandler)
+	mux.HandleFunc("/kids/", h.KidsPageHandler)
+	mux.HandleFunc("/invite/", h.InvitePageHandler) // Asks to sign in on accepting
+
+	// Static Files (CSS/JS)
+	fs := http.FileServer(http.Dir("web/static"))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	// The push service worker must be served from the root to control every page
+	mux.HandleFunc("/sw.js", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "web/static/sw.js")
+	})
+
+	// WebSocket Routes
+	mux.HandleFunc("/ws/kid/", h.KidWSHandler)       // Public, maybe protect with simple token later?
+	mux.HandleFunc("/ws/parent/", h.ParentWSHandler) // Protected by cookie check inside
+
+	// Health Check
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Audio Streamer Backend Running"))
+	})
+
+	return mux
+}
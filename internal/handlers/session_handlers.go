@@ -1,78 +1,211 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/zamibd/a2web/internal/audit"
 	"github.com/zamibd/a2web/internal/auth"
-	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/events"
 	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
 )
 
+// DashboardSession is a card of the dashboard's session list.
+type DashboardSession struct {
+	store.MemberSession
+	Live models.LiveState
+}
+
+// DashboardHandler renders the caller's personal sessions, or with
+// ?org={org_id} the rooms of one of their organizations.
 func (h *Handler) DashboardHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract user ID from token (Middleware should have validated it, but we need the claims)
-	c, _ := r.Cookie("token")
-	claims, _ := auth.ValidateJWT(c.Value)
+	h.renderDashboard(w, r, "layout")
+}
+
+// DashboardSessionsHandler renders only the session list, which the dashboard
+// reloads when sessions are created or deleted.
+func (h *Handler) DashboardSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	h.renderDashboard(w, r, "session-list")
+}
+
+func (h *Handler) renderDashboard(w http.ResponseWriter, r *http.Request, name string) {
+	claims := requestClaims(r)
 
-	rows, err := database.DB.Query("SELECT id, name, status, created_at FROM sessions WHERE user_id = ? ORDER BY created_at DESC", claims.UserID)
+	data := map[string]interface{}{"Title": "Dashboard"}
+	var orgID int64
+	if r.URL.Query().Has("org") {
+		o, role, ok := h.webOrg(w, r, "org", false)
+		if !ok {
+			return
+		}
+		view, err := h.orgView(r, o, role)
+		if err != nil {
+			h.Logger.Error("Database error fetching organization usage", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		data["Title"], data["Org"], orgID = o.Name, view, o.ID
+	} else {
+		orgs, err := h.Store.Orgs.ListByUser(r.Context(), claims.UserID)
+		if err != nil {
+			h.Logger.Error("Database error fetching organizations", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		data["Orgs"] = orgs
+	}
+
+	// Sessions shared with the user are listed with their role; the rooms
+	// of organizations on their dashboards
+	all, _, err := h.Store.Members.Sessions(r.Context(), claims.UserID, orgID, 0, 0)
 	if err != nil {
 		h.Logger.Error("Database error fetching sessions", "error", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var sessions []models.Session
-	for rows.Next() {
-		var s models.Session
-		if err := rows.Scan(&s.ID, &s.Name, &s.Status, &s.CreatedAt); err != nil {
-			h.Logger.Error("Row scan error", "error", err)
+	var sessions []DashboardSession
+	active := 0
+	for _, s := range all {
+		if orgID == 0 && s.OrgID != 0 {
 			continue
 		}
-		sessions = append(sessions, s)
+		sessions = append(sessions, DashboardSession{s, GlobalHub.State(s.ID)})
+		if !s.Archived() {
+			active++
+		}
 	}
+	data["Sessions"], data["Active"] = sessions, active
 
-	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, "layout", map[string]interface{}{
-		"Title":    "Dashboard",
-		"Sessions": sessions,
-	}); err != nil {
+	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, name, data); err != nil {
 		h.Logger.Error("Template execution error", "error", err)
 		http.Error(w, "Template Error", http.StatusInternalServerError)
 	}
 }
 
+// CreateSessionHandler creates a session for the caller or, with
+// ?org={org_id}, a room of an organization they are an admin of, and
+// renders its card. The name is the form's name or the answer to the
+// dashboard's prompt; without one the session is numbered.
 func (h *Handler) CreateSessionHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	c, _ := r.Cookie("token")
-	claims, _ := auth.ValidateJWT(c.Value)
+	claims := requestClaims(r)
 
-	sessionID, err := auth.GenerateSessionID()
+	var orgID int64
+	if r.URL.Query().Has("org") {
+		o, role, ok := h.webOrg(w, r, "org", true)
+		if !ok {
+			return
+		}
+		// Rooms belong to one of the organization's admins
+		if role != models.OrgAdmin {
+			http.Error(w, "Only the organization's admins may create rooms", http.StatusForbidden)
+			return
+		}
+		orgID = o.ID
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = r.Header.Get("HX-Prompt")
+	}
+	name = strings.TrimSpace(name)
+	if len(name) > maxSessionNameLength {
+		http.Error(w, "Name must be at most "+strconv.Itoa(maxSessionNameLength)+" characters", http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.createSession(r.Context(), claims.UserID, orgID, name)
+	if errors.Is(err, errRoomQuota) {
+		http.Error(w, "The organization has reached its room quota", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		h.Logger.Error("Error generating session ID", "error", err)
-		http.Error(w, "Error generating ID", http.StatusInternalServerError)
+		h.Logger.Error("Error creating session", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, audit.Event{ActorID: claims.UserID, Action: audit.ActionSessionCreate, TargetType: "session", TargetID: session.ID})
 
-	// Simple name for now, or get from request
-	name := "Session " + sessionID[:8]
+	// HTMX prepends the card to the list
+	card := DashboardSession{store.MemberSession{Session: session, Role: models.MemberOwner}, GlobalHub.State(session.ID)}
+	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, "session-card", card); err != nil {
+		h.Logger.Error("Template execution error", "template", "session-card", "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
 
-	_, err = database.DB.Exec("INSERT INTO sessions (id, user_id, name) VALUES (?, ?, ?)", sessionID, claims.UserID, name)
+// createSession stores a new session for userID, as a room of orgID unless
+// it is 0, and announces it. An empty name is replaced by "Session N" or
+// "Room N", numbering the user's sessions or the organization's rooms.
+func (h *Handler) createSession(ctx context.Context, userID, orgID int64, name string) (models.Session, error) {
+	if orgID != 0 {
+		if err := h.checkRoomQuota(ctx, orgID); err != nil {
+			return models.Session{}, err
+		}
+	}
+	sessionID, err := auth.GenerateSessionID()
 	if err != nil {
-		h.Logger.Error("Database error creating session", "error", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		return models.Session{}, err
+	}
+	if name == "" {
+		if name, err = h.defaultSessionName(ctx, userID, orgID); err != nil {
+			return models.Session{}, err
+		}
+	}
+
+	session := models.Session{ID: sessionID, UserID: userID, Name: name, OrgID: orgID, Settings: models.DefaultSessionSettings}
+	if err := h.Store.Sessions.Create(ctx, &session); err != nil {
+		return models.Session{}, err
+	}
+
+	GlobalHub.Events.Publish(events.Event{
+		Type:      events.SessionCreated,
+		SessionID: sessionID,
+		UserID:    userID,
+		Data:      session,
+	})
+	return session, nil
+}
+
+// defaultSessionName numbers a new session after the user's personal
+// sessions, or a new room after the organization's rooms.
+func (h *Handler) defaultSessionName(ctx context.Context, userID, orgID int64) (string, error) {
+	if orgID != 0 {
+		usage, err := h.Store.Orgs.Usage(ctx, orgID)
+		return "Room " + strconv.Itoa(usage.Rooms+1), err
+	}
+	sessions, _, err := h.Store.Sessions.ListByUser(ctx, userID, 0, 0)
+	n := 1
+	for _, s := range sessions {
+		if s.OrgID == 0 {
+			n++
+		}
+	}
+	return "Session " + strconv.Itoa(n), err
+}
+
+// SessionStatusHandler returns the live state of a session the caller owns
+// or is a member of as JSON. URL: /session/status?id={session_id}
+func (h *Handler) SessionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("id")
+	if sessionID == "" {
+		http.Error(w, "ID required", http.StatusBadRequest)
 		return
 	}
 
-	// Return just the new row for HTMX to prepend
-	// For now, let's just redirect or return a simple fragment
-	// Let's create a partial template or just return HTML string for simplicity if "HX-Request" header exists
-	// But let's stick to full page reload or simple fragment for now.
+	if _, _, ok := h.webSession(w, r, sessionID, permView); !ok {
+		return
+	}
 
-	// HTMX response: render just the list item
-	w.Header().Set("Content-Type", "text/html")
-	// TODO: Use a proper template fragment
-	w.Write([]byte(`<li><a href="/user/` + sessionID + `">` + name + `</a> (Active)</li>`))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GlobalHub.State(sessionID))
 }
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zamibd/a2web/internal/audit"
+	"github.com/zamibd/a2web/internal/auth"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// Bounds of the session settings.
+const (
+	maxSessionDescriptionLength = 500
+	minAudioBitrate             = 6000
+	maxAudioBitrate             = 510000
+)
+
+// errArchived refuses changes to archived sessions, which are read-only
+// until they are restored.
+var errArchived = errors.New("the session is archived")
+
+// UpdateSessionRequest is the body of PATCH /api/v1/sessions/{id} and the
+// form of the dashboard's settings panel. Fields left out keep their
+// values. An archived session only takes a new status.
+type UpdateSessionRequest struct {
+	Name        *string                 `json:"name"`
+	Description *string                 `json:"description"`
+	Status      *string                 `json:"status"` // active or archived
+	Settings    *models.SessionSettings `json:"settings"`
+}
+
+// validSessionSettings checks the audio settings a kid device is given.
+func validSessionSettings(st models.SessionSettings) error {
+	if st.AudioBitrate != 0 && (st.AudioBitrate < minAudioBitrate || st.AudioBitrate > maxAudioBitrate) {
+		return errors.New("audio_bitrate must be 0 or " + strconv.Itoa(minAudioBitrate) + "-" + strconv.Itoa(maxAudioBitrate) + " bits/s")
+	}
+	return nil
+}
+
+// updateSession applies req to s and announces the change. Archiving stops
+// the broadcast; the recordings and members stay.
+func (h *Handler) updateSession(r *http.Request, s *models.Session, req UpdateSessionRequest) (invalid bool, err error) {
+	n := *s
+	if req.Name != nil {
+		n.Name = strings.TrimSpace(*req.Name)
+		if n.Name == "" || len(n.Name) > maxSessionNameLength {
+			return true, errors.New("Name must be 1-" + strconv.Itoa(maxSessionNameLength) + " characters")
+		}
+	}
+	if req.Description != nil {
+		n.Description = strings.TrimSpace(*req.Description)
+		if len(n.Description) > maxSessionDescriptionLength {
+			return true, errors.New("Description must be at most " + strconv.Itoa(maxSessionDescriptionLength) + " characters")
+		}
+	}
+	if req.Status != nil {
+		if *req.Status != models.SessionActive && *req.Status != models.SessionArchived {
+			return true, errors.New("Status must be active or archived")
+		}
+		n.Status = *req.Status
+	}
+	if req.Settings != nil {
+		if err := validSessionSettings(*req.Settings); err != nil {
+			return true, err
+		}
+		n.Settings = *req.Settings
+	}
+
+	edited := n.Name != s.Name || n.Description != s.Description || n.Settings != s.Settings
+	if edited && s.Archived() && n.Archived() {
+		return false, errArchived
+	}
+	if !edited && n.Status == s.Status {
+		return false, nil
+	}
+	if err := h.Store.Sessions.Update(r.Context(), n); err != nil {
+		return false, err
+	}
+
+	if edited {
+		h.audit(r, audit.Event{Action: audit.ActionSessionUpdate, TargetType: "session", TargetID: s.ID,
+			Detail: "recording=" + strconv.FormatBool(n.Settings.Recording) + " audio_bitrate=" + strconv.Itoa(n.Settings.AudioBitrate)})
+	}
+	if n.Status != s.Status {
+		action := audit.ActionSessionRestore
+		if n.Archived() {
+			action = audit.ActionSessionArchive
+			if err := GlobalHub.Command(s.ID, MsgStop); err != nil && !errors.Is(err, ErrNoSource) {
+				h.Logger.Warn("Failed to stop the broadcast of an archived session", "session_id", s.ID, "error", err)
+			}
+		}
+		h.audit(r, audit.Event{Action: action, TargetType: "session", TargetID: s.ID})
+	}
+	*s = n
+	GlobalHub.Events.Publish(events.Event{
+		Type:      events.SessionUpdated,
+		SessionID: s.ID,
+		UserID:    s.UserID,
+		Data:      *s,
+	})
+	return false, nil
+}
+
+// rotateKidLink gives s a new kid link. The old one stops working at once,
+// and a device broadcasting through it is disconnected; the session keeps
+// its ID, members and recordings.
+func (h *Handler) rotateKidLink(r *http.Request, s *models.Session) error {
+	if s.Archived() {
+		return errArchived
+	}
+	broadcastID, err := auth.GenerateSessionID()
+	if err != nil {
+		return err
+	}
+	if err := h.Store.Sessions.SetBroadcastID(r.Context(), s.ID, broadcastID); err != nil {
+		return err
+	}
+	s.BroadcastID = broadcastID
+	if err := GlobalHub.Command(s.ID, MsgStop); err != nil && !errors.Is(err, ErrNoSource) {
+		h.Logger.Warn("Failed to stop the broadcast of a rotated kid link", "session_id", s.ID, "error", err)
+	}
+	h.audit(r, audit.Event{Action: audit.ActionSessionRotate, TargetType: "session", TargetID: s.ID})
+
+	GlobalHub.Events.Publish(events.Event{
+		Type:      events.SessionUpdated,
+		SessionID: s.ID,
+		UserID:    s.UserID,
+		Data:      *s,
+	})
+	return nil
+}
+
+// kidLink is the address a kid device broadcasts from.
+func kidLink(r *http.Request, s models.Session) string {
+	return publicURL(r, "/kids/"+s.BroadcastID)
+}
+
+// renderSessionSettings renders the settings panel of a session, with
+// formError above the form.
+func (h *Handler) renderSessionSettings(w http.ResponseWriter, r *http.Request, s models.Session, formError string) {
+	if err := h.Templates["dashboard.html"].ExecuteTemplate(w, "settings-panel", map[string]interface{}{
+		"Session": s,
+		"KidLink": kidLink(r, s),
+		"Error":   formError,
+	}); err != nil {
+		h.Logger.Error("Template execution error", "template", "settings-panel", "error", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
+}
+
+// SessionSettingsHandler shows and edits the name, description and audio
+// settings of a session the caller manages.
+//
+//	GET  /session/settings?id={session_id}    the panel
+//	POST /session/settings?id={session_id}    save (form: name, description, recording,
+//	                                          audio_bitrate, echo_cancellation,
+//	                                          noise_suppression, auto_gain_control)
+func (h *Handler) SessionSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	s, _, ok := h.webSession(w, r, r.URL.Query().Get("id"), permManage)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		bitrate, err := strconv.Atoi(r.FormValue("audio_bitrate"))
+		if err != nil {
+			h.renderSessionSettings(w, r, s, "audio_bitrate must be a number")
+			return
+		}
+		name, description := r.FormValue("name"), r.FormValue("description")
+		invalid, err := h.updateSession(r, &s, UpdateSessionRequest{
+			Name:        &name,
+			Description: &description,
+			Settings: &models.SessionSettings{
+				Recording:        r.FormValue("recording") != "",
+				AudioBitrate:     bitrate,
+				EchoCancellation: r.FormValue("echo_cancellation") != "",
+				NoiseSuppression: r.FormValue("noise_suppression") != "",
+				AutoGainControl:  r.FormValue("auto_gain_control") != "",
+			},
+		})
+		if invalid || errors.Is(err, errArchived) {
+			h.renderSessionSettings(w, r, s, err.Error())
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Database error updating session", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.renderSessionSettings(w, r, s, "")
+}
+
+// ArchiveSessionHandler archives a session the caller manages, or restores
+// it. POST /session/archive?id={session_id}&archived=true|false
+func (h *Handler) ArchiveSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	archived, err := strconv.ParseBool(r.URL.Query().Get("archived"))
+	if err != nil {
+		http.Error(w, "archived must be true or false", http.StatusBadRequest)
+		return
+	}
+	s, _, ok := h.webSession(w, r, r.URL.Query().Get("id"), permManage)
+	if !ok {
+		return
+	}
+	status := models.SessionActive
+	if archived {
+		status = models.SessionArchived
+	}
+	if _, err := h.updateSession(r, &s, UpdateSessionRequest{Status: &status}); err != nil {
+		h.Logger.Error("Database error archiving session", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.renderSessionSettings(w, r, s, "")
+}
+
+// RotateKidLinkHandler replaces the kid link of a session the caller
+// manages. POST /session/rotate?id={session_id}
+func (h *Handler) RotateKidLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s, _, ok := h.webSession(w, r, r.URL.Query().Get("id"), permManage)
+	if !ok {
+		return
+	}
+	err := h.rotateKidLink(r, &s)
+	if errors.Is(err, errArchived) {
+		http.Error(w, "Session is archived", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Database error rotating kid link", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	h.renderSessionSettings(w, r, s, "")
+}
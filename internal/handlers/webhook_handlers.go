@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zamibd/a2web/internal/config"
+	"github.com/zamibd/a2web/internal/webhooks"
+)
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhooksHandler lists, registers and removes the caller's webhooks.
+//
+//	GET    /webhooks          list webhooks (secrets omitted)
+//	POST   /webhooks          register a webhook (WebhookRequest); the
+//	                          response carries the signing secret, once
+//	DELETE /webhooks?id=...   remove a webhook
+func (h *Handler) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := h.WebhookStore.List(claims.UserID)
+		if err != nil {
+			h.Logger.Error("Database error fetching webhooks", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if hooks == nil {
+			hooks = []webhooks.Webhook{}
+		}
+		for i := range hooks {
+			hooks[i].Secret = ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+
+	case http.MethodPost:
+		var req WebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := webhooks.CheckURL(r.Context(), req.URL, config.AppConfig.WebhookAllowPrivate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Events) == 0 {
+			req.Events = webhooks.Events
+		}
+		for _, e := range req.Events {
+			if !webhooks.ValidEvent(e) {
+				http.Error(w, "Unknown event: "+e, http.StatusBadRequest)
+				return
+			}
+		}
+
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			h.Logger.Error("Failed to generate webhook secret", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		hook := webhooks.Webhook{UserID: claims.UserID, URL: req.URL, Secret: secret, Events: req.Events}
+		if err := h.WebhookStore.Create(&hook); err != nil {
+			h.Logger.Error("Database error creating webhook", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		h.Logger.Info("Webhook created", "webhook_id", hook.ID, "user_id", claims.UserID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(hook)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+		if err := h.WebhookStore.Delete(id, claims.UserID); err != nil {
+			if errors.Is(err, webhooks.ErrNotFound) {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			h.Logger.Error("Database error deleting webhook", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// WebhookDeliveriesHandler returns the delivery log of one of the caller's
+// webhooks: GET /webhooks/deliveries?webhook_id=...
+func (h *Handler) WebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	claims := requestClaims(r)
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("webhook_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	list, err := h.WebhookStore.Deliveries(id, claims.UserID, 100)
+	if err != nil {
+		h.Logger.Error("Database error fetching webhook deliveries", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []webhooks.Delivery{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// RedeliverWebhookHandler queues an earlier delivery again with the same
+// payload: POST /webhooks/redeliver?id=...
+func (h *Handler) RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := requestClaims(r)
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+	if err := h.WebhookStore.Redeliver(id, claims.UserID); err != nil {
+		if errors.Is(err, webhooks.ErrNotFound) {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		h.Logger.Error("Database error redelivering webhook", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if h.Webhooks != nil {
+		h.Webhooks.Wake()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
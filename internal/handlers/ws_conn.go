@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zamibd/a2web/internal/config"
+)
+
+// wsConn wraps a WebSocket connection with keepalive pings, read/write
+// deadlines and round-trip time measurement. gorilla/websocket allows only one
+// concurrent writer, so all data writes go through writeMu.
+type wsConn struct {
+	*websocket.Conn
+
+	// userID is the user a listener connection was authorized for, so it
+	// can be dropped when they lose access.
+	userID int64
+
+	writeMu sync.Mutex
+	rtt     atomic.Int64 // last measured round trip, in nanoseconds
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newWSConn(conn *websocket.Conn) *wsConn {
+	c := &wsConn{Conn: conn, done: make(chan struct{})}
+
+	// Every pong (and every data message, see ReadMessage) pushes the read
+	// deadline forward. A peer that stops answering pings hits the deadline
+	// and the blocked ReadMessage returns a timeout error.
+	conn.SetReadDeadline(time.Now().Add(config.AppConfig.WSPongTimeout))
+	conn.SetPongHandler(func(appData string) error {
+		if len(appData) == 8 {
+			sent := int64(binary.BigEndian.Uint64([]byte(appData)))
+			if rtt := time.Now().UnixNano() - sent; rtt >= 0 {
+				c.rtt.Store(rtt)
+			}
+		}
+		return conn.SetReadDeadline(time.Now().Add(config.AppConfig.WSPongTimeout))
+	})
+
+	go c.keepalive()
+	return c
+}
+
+// keepalive sends a ping every WSPingInterval until the connection is closed.
+// The ping payload carries the send time so the pong handler can compute RTT.
+func (c *wsConn) keepalive() {
+	ticker := time.NewTicker(config.AppConfig.WSPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			payload := make([]byte, 8)
+			binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+			deadline := time.Now().Add(config.AppConfig.WSWriteTimeout)
+			if err := c.WriteControl(websocket.PingMessage, payload, deadline); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// ReadMessage extends the read deadline on any inbound traffic, not just pongs,
+// so a busy source is never timed out between pings.
+func (c *wsConn) ReadMessage() (int, []byte, error) {
+	mt, p, err := c.Conn.ReadMessage()
+	if err == nil {
+		c.Conn.SetReadDeadline(time.Now().Add(config.AppConfig.WSPongTimeout))
+	}
+	return mt, p, err
+}
+
+func (c *wsConn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeHeld(messageType, data)
+}
+
+// writeHeld writes a message; the caller must hold c.writeMu.
+func (c *wsConn) writeHeld(messageType int, data []byte) error {
+	c.Conn.SetWriteDeadline(time.Now().Add(config.AppConfig.WSWriteTimeout))
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+func (c *wsConn) WriteJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(config.AppConfig.WSWriteTimeout))
+	return c.Conn.WriteJSON(v)
+}
+
+// Close stops the keepalive loop and closes the underlying connection. It is
+// safe to call more than once.
+func (c *wsConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// RTT returns the most recent ping round-trip time, or zero if no pong has
+// been received yet.
+func (c *wsConn) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+// isTimeout reports whether a read error was caused by the read deadline,
+// i.e. the peer stopped answering pings.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
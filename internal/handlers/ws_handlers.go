@@ -1,12 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"strings"
 
-	"github.com/zamibd/a2web/internal/auth"
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/apitokens"
+	"github.com/zamibd/a2web/internal/audit"
 	"github.com/zamibd/a2web/internal/config"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/recordings"
+	"github.com/zamibd/a2web/internal/store"
+	"github.com/zamibd/a2web/internal/wstickets"
 
 	"github.com/gorilla/websocket"
 )
@@ -18,42 +28,160 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// ticketUpgrader upgrades connections authorized by a WebSocket ticket. The
+// origin check guards the cookie against cross-site use; a ticket is not
+// sent by the browser on its own, so native apps and webviews on other
+// origins may connect with one.
+var ticketUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
 func (h *Handler) KidWSHandler(w http.ResponseWriter, r *http.Request) {
-	// URL: /ws/kid/{session_id}
+	// URL: /ws/kid/{broadcast_id}, the ID of the kid link. Broadcasting with
+	// credentials works with the session ID too.
 	pathParts := strings.Split(r.URL.Path, "/")
 	if len(pathParts) < 4 {
 		http.Error(w, "Invalid URL", http.StatusBadRequest)
 		return
 	}
-	sessionID := pathParts[3]
+	_, withToken := bearerToken(r)
+	withCredentials := withToken || r.URL.Query().Get("ticket") != ""
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// The owner is needed to attribute live state events to the right user
+	session, err := h.Store.Sessions.GetByBroadcastID(r.Context(), pathParts[3])
+	if errors.Is(err, store.ErrNotFound) && withCredentials {
+		session, err = h.Store.Sessions.Get(r.Context(), pathParts[3])
+	}
 	if err != nil {
-		h.Logger.Error("Upgrade error", "error", err)
+		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
-	defer conn.Close()
+	if session.Archived() {
+		http.Error(w, "Session is archived", http.StatusConflict)
+		return
+	}
+	sessionID, ownerID := session.ID, session.UserID
 
-	// Open file for appending audio
-	// Ensure directory exists
-	// os.MkdirAll("./storage", 0755) // Already done in main
+	// The kid link works without credentials. Scripts broadcasting with a
+	// personal API token must hold stream:ingest, and tickets must have been
+	// issued for this session as a source, in both cases by a user who
+	// manages it: its owner or an admin of its organization.
+	up := &upgrader
+	event := audit.Event{Action: audit.ActionBroadcastStart, TargetType: "session", TargetID: sessionID, Detail: "via=link"}
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		t, err := h.Tickets.Redeem(ticket, sessionID, wstickets.RoleSource)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		event.ActorID, event.Detail = t.UserID, "via=ticket"
+		if !h.maySource(r, session, t.UserID) {
+			event.Outcome = audit.OutcomeDenied
+			h.audit(r, event)
+			http.Error(w, "Unauthorized", http.StatusForbidden)
+			return
+		}
+		up = &ticketUpgrader
+	} else if withToken {
+		claims, err := h.authenticate(r, apitokens.ScopeStreamIngest)
+		if err != nil {
+			http.Error(w, err.Error(), authStatus(err))
+			return
+		}
+		event.ActorID, event.Detail = claims.UserID, "via=token"
+		if !h.maySource(r, session, claims.UserID) {
+			event.Outcome = audit.OutcomeDenied
+			h.audit(r, event)
+			http.Error(w, "Unauthorized", http.StatusForbidden)
+			return
+		}
+	}
 
-	filePath := "./storage/" + sessionID + ".webm"
-	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	ws, err := up.Upgrade(w, r, nil)
 	if err != nil {
-		h.Logger.Error("File open error", "error", err)
-		return // Should probably close conn too
+		h.Logger.Error("Upgrade error", "error", err)
+		return
+	}
+	conn := newWSConn(ws)
+	h.audit(r, event)
+	GlobalHub.RegisterSource(sessionID, ownerID, conn)
+	GlobalHub.NotifyListeners(sessionID, ControlMessage{Type: MsgSourceStarted})
+	lost := false
+	defer func() { GlobalHub.UnregisterSource(sessionID, conn, lost) }()
+
+	// Each source connection is recorded to its own file, so every
+	// recording starts with its own init segment and plays on its own. The
+	// recording is finalized even if the request was cancelled. Sessions
+	// with recording turned off and rooms of organizations over their
+	// storage quota are relayed only.
+	ctx := context.WithoutCancel(r.Context())
+	record := session.Settings.Recording
+	if record {
+		record, err = h.mayRecord(ctx, session)
+		if err != nil {
+			h.Logger.Error("Database error checking storage quota", "session_id", sessionID, "error", err)
+			return
+		}
+		if !record {
+			h.Logger.Warn("Storage quota reached, not recording", "session_id", sessionID, "org_id", session.OrgID)
+		}
+	}
+	var f *os.File
+	var written int64
+	if record {
+		var rec *models.Recording
+		rec, f, err = recordings.Start(ctx, h.Store.Recordings, sessionID)
+		if err != nil {
+			h.Logger.Error("File open error", "error", err)
+			return
+		}
+		defer func() {
+			f.Close()
+			if err := recordings.Finalize(ctx, h.Store.Recordings, rec, written); err != nil {
+				h.Logger.Error("Failed to finalize recording", "recording_id", rec.ID, "error", err)
+				return
+			}
+			GlobalHub.Events.Publish(events.Event{
+				Type:      events.RecordingFinalized,
+				SessionID: sessionID,
+				UserID:    ownerID,
+				Data:      *rec,
+			})
+		}()
 	}
-	defer f.Close()
 
 	isFirstChunk := true
 	for {
 		messageType, p, err := conn.ReadMessage()
 		if err != nil {
-			h.Logger.Warn("Read error", "error", err)
+			switch {
+			case isTimeout(err):
+				h.Logger.Warn("Kid connection timed out", "session_id", sessionID, "rtt", conn.RTT())
+				GlobalHub.SourceLost(sessionID, "timeout")
+				lost = true
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+				h.Logger.Warn("Read error", "session_id", sessionID, "error", err)
+				GlobalHub.SourceLost(sessionID, "disconnected")
+				lost = true
+			}
 			break
 		}
 
+		if messageType == websocket.TextMessage {
+			var msg ControlMessage
+			if err := json.Unmarshal(p, &msg); err != nil {
+				h.Logger.Warn("Invalid control message", "session_id", sessionID, "error", err)
+				continue
+			}
+			switch msg.Type {
+			case MsgLevel:
+				GlobalHub.ReportLevel(sessionID, msg.Level)
+			case MsgSOS:
+				h.raiseSOS(sessionID, ownerID)
+			}
+			continue
+		}
+
 		if messageType == websocket.BinaryMessage {
 			// 0. Cache Init Segment (First Chunk)
 			if isFirstChunk {
@@ -62,39 +190,31 @@ func (h *Handler) KidWSHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// 1. Save to disk
-			if _, err := f.Write(p); err != nil {
-				h.Logger.Error("File write error", "error", err)
-			}
-
-			// 2. Relay to parent
-			parentConn := GlobalHub.GetParent(sessionID)
-			if parentConn != nil {
-				// Prevent blocking if parent is slow
-				// For now simple write, in production use channel/buffer
-				if err := parentConn.WriteMessage(websocket.BinaryMessage, p); err != nil {
-					h.Logger.Error("Relay error", "error", err)
-					// Maybe unregister parent if write fails?
+			if f != nil {
+				n, err := f.Write(p)
+				written += int64(n)
+				if err != nil {
+					h.Logger.Error("File write error", "error", err)
 				}
 			}
+
+			// 2. Relay to listeners
+			GlobalHub.Broadcast(sessionID, p)
 		}
 	}
 }
 
-func (h *Handler) ParentWSHandler(w http.ResponseWriter, r *http.Request) {
-	// URL: /ws/parent/{session_id}
-	// Auth check should be done before or via cookie check here
-	c, err := r.Cookie("token")
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	// Validate token... (skipping full validation for brevity, assuming middleware protected or simple check)
-	// Actually, WS upgrade happens before middleware can wrapp properly sometimes, so let's valid here.
-	if _, err := auth.ValidateJWT(c.Value); err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+// maySource reports whether userID may broadcast to s with credentials.
+func (h *Handler) maySource(r *http.Request, s models.Session, userID int64) bool {
+	_, _, err := h.sessionFor(r.Context(), s.ID, userID, permManage)
+	if err != nil && !errors.Is(err, errNoSession) && !errors.Is(err, errNoPermission) {
+		h.Logger.Error("Database error checking source access", "session_id", s.ID, "error", err)
 	}
+	return err == nil
+}
 
+func (h *Handler) ParentWSHandler(w http.ResponseWriter, r *http.Request) {
+	// URL: /ws/parent/{session_id}
 	pathParts := strings.Split(r.URL.Path, "/")
 	if len(pathParts) < 4 {
 		http.Error(w, "Invalid URL", http.StatusBadRequest)
@@ -102,28 +222,110 @@ func (h *Handler) ParentWSHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	sessionID := pathParts[3]
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// WS upgrade happens before middleware can wrap properly sometimes, so validate here.
+	// Besides the cookie, a listener ticket for this session or a personal
+	// API token with sessions:read may listen. Either way the user must be
+	// allowed to listen now, so tickets issued before a revocation fail.
+	var userID int64
+	up := &upgrader
+	event := audit.Event{Action: audit.ActionListenStart, TargetType: "session", TargetID: sessionID}
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		t, err := h.Tickets.Redeem(ticket, sessionID, wstickets.RoleListener)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		userID = t.UserID
+		up = &ticketUpgrader
+		event.Detail = "via=ticket"
+	} else {
+		claims, err := h.authenticate(r, apitokens.ScopeSessionsRead)
+		if err != nil {
+			http.Error(w, "Unauthorized", authStatus(err))
+			return
+		}
+		userID = claims.UserID
+		if _, ok := bearerToken(r); ok {
+			event.Detail = "via=token"
+		}
+	}
+	event.ActorID = userID
+
+	session, _, err := h.sessionFor(r.Context(), sessionID, userID, permListen)
+	switch {
+	case errors.Is(err, errNoSession), errors.Is(err, errNoPermission):
+		event.Outcome = audit.OutcomeDenied
+		h.audit(r, event)
+		if errors.Is(err, errNoSession) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusForbidden)
+		}
+		return
+	case err != nil:
+		h.Logger.Error("Database error fetching session", "error", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	ws, err := up.Upgrade(w, r, nil)
 	if err != nil {
 		h.Logger.Error("Upgrade error", "error", err)
 		return
 	}
+	conn := newWSConn(ws)
+	conn.userID = userID
+	h.audit(r, event)
 
-	GlobalHub.RegisterParent(sessionID, conn)
-	defer GlobalHub.UnregisterParent(sessionID)
+	// Registering sends the init segment of the current source (critical
+	// for late joiners) before any media chunk.
+	err = GlobalHub.RegisterParent(sessionID, session.UserID, conn)
+	defer GlobalHub.UnregisterParent(sessionID, conn)
+	if err != nil {
+		h.Logger.Error("Init segment write error", "error", err)
+		return
+	}
 
-	// Send Init Segment if available (Critical for late joiners)
-	initSeg := GlobalHub.GetInitSegment(sessionID)
-	if initSeg != nil {
-		if err := conn.WriteMessage(websocket.BinaryMessage, initSeg); err != nil {
-			h.Logger.Error("Init segment write error", "error", err)
-			return
-		}
+	// A listener joining while an SOS is unanswered rings straight away
+	if sos, err := h.AlertStore.PendingSOS(sessionID); err == nil {
+		conn.WriteJSON(ControlMessage{Type: MsgSOS, AlertID: sos.ID, Message: sos.Message})
 	}
 
-	// Keep connection alive, maybe read control messages?
+	// Keep reading so pong and close frames are processed; the read deadline
+	// set by newWSConn drops listeners that stop answering pings.
 	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			if isTimeout(err) {
+				h.Logger.Warn("Parent connection timed out", "session_id", sessionID, "rtt", conn.RTT())
+			}
 			break
 		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var msg ControlMessage
+		if err := json.Unmarshal(p, &msg); err != nil || msg.Type != MsgAck {
+			continue
+		}
+		if _, err := h.acknowledgeAlert(msg.AlertID, userID); err != nil && !errors.Is(err, alerts.ErrNotFound) {
+			h.Logger.Error("Failed to acknowledge alert", "alert_id", msg.AlertID, "error", err)
+		}
+	}
+}
+
+// raiseSOS turns an SOS from the kid device into a high-priority alert and
+// rings every listener of the session. Notification channels are reached
+// through the alert.raised event.
+func (h *Handler) raiseSOS(sessionID string, ownerID int64) {
+	if h.Alerts == nil {
+		h.Logger.Warn("SOS received but alerts are not configured", "session_id", sessionID)
+		return
+	}
+	alert, _, err := h.Alerts.SOS(sessionID, ownerID)
+	if err != nil {
+		h.Logger.Error("Failed to raise SOS", "session_id", sessionID, "error", err)
+		return
 	}
+	GlobalHub.NotifyListeners(sessionID, ControlMessage{Type: MsgSOS, AlertID: alert.ID, Message: alert.Message})
 }
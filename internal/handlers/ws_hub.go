@@ -1,67 +1,436 @@
 package handlers
 
 import (
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/zamibd/a2web/internal/config"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to the parent.
-type Hub struct {
-	// Registered clients.
-	// Map sessionID -> Parent Connection (User)
-	parents map[string]*websocket.Conn
+// ControlMessage is a JSON text frame exchanged on the WebSocket alongside the
+// binary audio frames.
+type ControlMessage struct {
+	Type    string  `json:"type"`
+	Reason  string  `json:"reason,omitempty"`
+	Level   float64 `json:"level,omitempty"`
+	AlertID int64   `json:"alert_id,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+const (
+	// MsgSourceLost tells listeners the kid device went away.
+	MsgSourceLost = "source_lost"
+	// MsgSourceStarted tells listeners a kid device connected. Its first
+	// binary frame that follows is a new init segment.
+	MsgSourceStarted = "source_started"
+	// MsgLevel is sent by the kid device with its current sound level (0-100).
+	MsgLevel = "level"
+
+	// Commands sent to the kid device.
+	MsgMute   = "mute"
+	MsgUnmute = "unmute"
+	MsgStop   = "stop"
+
+	// MsgSOS is sent by the kid device to ask for help and relayed to every
+	// listener. MsgAck is sent back by a listener with the alert ID, and
+	// MsgSOSAck tells the kid device and the other listeners it was seen.
+	MsgSOS    = "sos"
+	MsgAck    = "ack"
+	MsgSOSAck = "sos_ack"
+)
+
+var (
+	ErrNoSource       = errors.New("no source connected")
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+// StreamObserver receives stream metadata from the hub, e.g. to evaluate
+// alert rules. Calls are made outside the hub lock from the source's
+// goroutine, so implementations must not block for long.
+type StreamObserver interface {
+	ObserveLevel(sessionID string, userID int64, level float64)
+	ObservePacket(sessionID string, userID int64, size int)
+}
+
+// bitrateWindow is how often the measured bitrate is refreshed.
+const bitrateWindow = 5 * time.Second
+
+// eventHistory is how many events the bus keeps for Last-Event-ID resume.
+const eventHistory = 1024
+
+// liveSession is the hub's bookkeeping for one session with a source or
+// listeners attached.
+type liveSession struct {
+	userID    int64
+	source    *wsConn
+	listeners map[*wsConn]struct{}
 	// Cache for the initialization segment (header) of the audio stream
-	initSegments map[string][]byte
+	initSegment []byte
+
+	state     string
+	startedAt time.Time
+	bitrate   int
+	muted     bool
+	idleTimer *time.Timer
+
+	windowStart time.Time
+	windowBytes int
+}
+
+func (s *liveSession) snapshot(sessionID string) models.LiveState {
+	st := models.LiveState{
+		SessionID: sessionID,
+		State:     s.state,
+		Listeners: len(s.listeners),
+		Bitrate:   s.bitrate,
+		Muted:     s.muted,
+	}
+	if s.state != models.StateIdle {
+		startedAt := s.startedAt
+		st.StartedAt = &startedAt
+	}
+	return st
+}
+
+// Hub maintains the set of active clients, relays audio from each session's
+// source to its listeners and tracks live state per session.
+type Hub struct {
+	// Events receives a session.state event on every state change.
+	Events *events.Bus
 
-	// Map sessionID -> Kid Connection (just for tracking if needed, but mainly we just read from it)
-	// Actually we might handle kids in a simple handler that looks up the parent in this Hub.
+	sessions  map[string]*liveSession
+	observers []StreamObserver
+	mu        sync.RWMutex
+}
 
-	mu sync.RWMutex
+// AddObserver registers o for stream metadata. It must be called before the
+// server starts accepting connections.
+func (h *Hub) AddObserver(o StreamObserver) {
+	h.observers = append(h.observers, o)
+}
+
+// ReportLevel forwards a sound level reported by the source to the observers.
+func (h *Hub) ReportLevel(sessionID string, level float64) {
+	h.mu.RLock()
+	s, ok := h.sessions[sessionID]
+	h.mu.RUnlock()
+	if !ok {
+		return
+	}
+	for _, o := range h.observers {
+		o.ObserveLevel(sessionID, s.userID, level)
+	}
 }
 
 var GlobalHub = Hub{
-	parents:      make(map[string]*websocket.Conn),
-	initSegments: make(map[string][]byte),
+	Events:   events.NewBus(eventHistory),
+	sessions: make(map[string]*liveSession),
+}
+
+// session returns the bookkeeping entry for sessionID, creating it if needed.
+// The caller must hold h.mu for writing.
+func (h *Hub) session(sessionID string, userID int64) *liveSession {
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		s = &liveSession{
+			userID:    userID,
+			listeners: make(map[*wsConn]struct{}),
+			state:     models.StateIdle,
+		}
+		h.sessions[sessionID] = s
+	}
+	return s
+}
+
+// release drops the entry once nothing references it any more.
+// The caller must hold h.mu for writing.
+func (h *Hub) release(sessionID string, s *liveSession) {
+	if s.state == models.StateIdle && s.source == nil && len(s.listeners) == 0 {
+		delete(h.sessions, sessionID)
+	}
+}
+
+// publish emits the current state of s. The caller must hold h.mu.
+func (h *Hub) publish(sessionID string, s *liveSession) {
+	h.emit(events.SessionState, sessionID, s)
+}
+
+// emit publishes an event of the given type carrying the state of s.
+// The caller must hold h.mu.
+func (h *Hub) emit(eventType, sessionID string, s *liveSession) {
+	h.Events.Publish(events.Event{
+		Type:      eventType,
+		SessionID: sessionID,
+		UserID:    s.userID,
+		Data:      s.snapshot(sessionID),
+	})
+}
+
+// RegisterParent adds a listener and sends it the init segment of the
+// current source, if there is one. The connection's writes are held from
+// registering until the segment is out, so neither media nor the init
+// segment of a source started meanwhile can overtake it, and the write
+// itself happens outside h.mu.
+func (h *Hub) RegisterParent(sessionID string, userID int64, conn *wsConn) error {
+	h.mu.Lock()
+	s := h.session(sessionID, userID)
+	s.listeners[conn] = struct{}{}
+	initSeg := s.initSegment
+	h.publish(sessionID, s)
+	conn.writeMu.Lock()
+	h.mu.Unlock()
+
+	defer conn.writeMu.Unlock()
+	if initSeg == nil {
+		return nil
+	}
+	return conn.writeHeld(websocket.BinaryMessage, initSeg)
+}
+
+func (h *Hub) UnregisterParent(sessionID string, conn *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer conn.Close()
+
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		return
+	}
+	if _, ok := s.listeners[conn]; !ok {
+		return
+	}
+	delete(s.listeners, conn)
+	h.publish(sessionID, s)
+	h.release(sessionID, s)
+}
+
+// DisconnectListener closes a user's listener connections to one session
+// or, with an empty sessionID, to every session. They reconnect only with
+// credentials still allowing them to listen.
+func (h *Hub) DisconnectListener(sessionID string, userID int64) {
+	h.mu.RLock()
+	var conns []*wsConn
+	for id, s := range h.sessions {
+		if sessionID != "" && id != sessionID {
+			continue
+		}
+		for c := range s.listeners {
+			if c.userID == userID {
+				conns = append(conns, c)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
 }
 
-func (h *Hub) RegisterParent(sessionID string, conn *websocket.Conn) {
+// RegisterSource attaches the kid connection and marks the session live. A
+// source reconnecting within the grace period keeps its original start time.
+func (h *Hub) RegisterSource(sessionID string, userID int64, conn *wsConn) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	// Close existing if any (single parent per session for simplicity as per requirements "logged-in user hears")
-	if existing, ok := h.parents[sessionID]; ok {
-		existing.Close()
+	s := h.session(sessionID, userID)
+	if s.source != nil {
+		s.source.Close()
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	started := s.state == models.StateIdle
+	if started {
+		s.startedAt = time.Now()
+	}
+	s.source = conn
+	s.state = models.StateLive
+	s.initSegment = nil
+	s.bitrate = 0
+	s.muted = false
+	s.windowStart = time.Now()
+	s.windowBytes = 0
+	h.publish(sessionID, s)
+	if started {
+		h.emit(events.StreamStarted, sessionID, s)
 	}
-	h.parents[sessionID] = conn
 }
 
-func (h *Hub) UnregisterParent(sessionID string) {
+// UnregisterSource detaches the kid connection. After a clean close the
+// session goes idle straight away; after a lost connection it is reported
+// as reconnecting until WSReconnectGrace passes without a new source.
+func (h *Hub) UnregisterSource(sessionID string, conn *wsConn, lost bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	if conn, ok := h.parents[sessionID]; ok {
-		conn.Close()
-		delete(h.parents, sessionID)
+	defer conn.Close()
+
+	s, ok := h.sessions[sessionID]
+	if !ok || s.source != conn {
+		return
+	}
+	s.source = nil
+	s.bitrate = 0
+	s.muted = false
+
+	if !lost {
+		s.state = models.StateIdle
+		h.publish(sessionID, s)
+		h.emit(events.StreamStopped, sessionID, s)
+		h.release(sessionID, s)
+		return
+	}
+
+	s.state = models.StateReconnecting
+	h.publish(sessionID, s)
+	s.idleTimer = time.AfterFunc(config.AppConfig.WSReconnectGrace, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if s.state != models.StateReconnecting || h.sessions[sessionID] != s {
+			return
+		}
+		s.state = models.StateIdle
+		s.idleTimer = nil
+		h.publish(sessionID, s)
+		h.emit(events.StreamStopped, sessionID, s)
+		h.release(sessionID, s)
+	})
+}
+
+// SourceLost notifies the listeners that the kid connection dropped without a
+// clean close, e.g. because it stopped answering pings.
+func (h *Hub) SourceLost(sessionID, reason string) {
+	h.NotifyListeners(sessionID, ControlMessage{Type: MsgSourceLost, Reason: reason})
+}
+
+// NotifyListeners sends a control message to every listener of the session.
+func (h *Hub) NotifyListeners(sessionID string, msg ControlMessage) {
+	for _, conn := range h.Listeners(sessionID) {
+		conn.WriteJSON(msg)
+	}
+}
+
+// NotifySource sends a control message to the kid device of the session.
+func (h *Hub) NotifySource(sessionID string, msg ControlMessage) error {
+	h.mu.RLock()
+	s, ok := h.sessions[sessionID]
+	var source *wsConn
+	if ok {
+		source = s.source
+	}
+	h.mu.RUnlock()
+	if source == nil {
+		return ErrNoSource
+	}
+	return source.WriteJSON(msg)
+}
+
+// Command sends a control command (MsgMute, MsgUnmute or MsgStop) to the kid
+// device of a session. Stop also closes the connection, which ends the
+// stream cleanly.
+func (h *Hub) Command(sessionID, command string) error {
+	h.mu.Lock()
+	s, ok := h.sessions[sessionID]
+	if !ok || s.source == nil {
+		h.mu.Unlock()
+		return ErrNoSource
+	}
+	switch command {
+	case MsgMute, MsgUnmute:
+		s.muted = command == MsgMute
+		h.publish(sessionID, s)
+	case MsgStop:
+	default:
+		h.mu.Unlock()
+		return ErrUnknownCommand
+	}
+	source := s.source
+	h.mu.Unlock()
+
+	err := source.WriteJSON(ControlMessage{Type: command})
+	if command == MsgStop {
+		source.Close()
+	}
+	return err
+}
+
+// Broadcast relays an audio chunk to every listener of the session and
+// accounts for it in the bitrate measurement.
+func (h *Hub) Broadcast(sessionID string, data []byte) {
+	h.mu.Lock()
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	s.windowBytes += len(data)
+	if elapsed := time.Since(s.windowStart); elapsed >= bitrateWindow {
+		s.bitrate = int(float64(s.windowBytes*8) / elapsed.Seconds())
+		s.windowStart = time.Now()
+		s.windowBytes = 0
+		h.publish(sessionID, s)
+	}
+	listeners := make([]*wsConn, 0, len(s.listeners))
+	for conn := range s.listeners {
+		listeners = append(listeners, conn)
+	}
+	userID := s.userID
+	h.mu.Unlock()
+
+	for _, o := range h.observers {
+		o.ObservePacket(sessionID, userID, len(data))
+	}
+
+	// The write deadline stops a stalled parent from blocking the source; a
+	// failed write leaves the connection unusable, so drop it and let the
+	// parent reconnect.
+	for _, conn := range listeners {
+		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			conn.Close()
+		}
 	}
 }
 
 func (h *Hub) SetInitSegment(sessionID string, data []byte) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		return
+	}
 	// Make a copy to be safe
 	segment := make([]byte, len(data))
 	copy(segment, data)
-	h.initSegments[sessionID] = segment
+	s.initSegment = segment
 }
 
-func (h *Hub) GetInitSegment(sessionID string) []byte {
+// Listeners returns the parent connections currently attached to the session.
+func (h *Hub) Listeners(sessionID string) []*wsConn {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return h.initSegments[sessionID]
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	conns := make([]*wsConn, 0, len(s.listeners))
+	for conn := range s.listeners {
+		conns = append(conns, conn)
+	}
+	return conns
 }
 
-func (h *Hub) GetParent(sessionID string) *websocket.Conn {
+// State returns the live state of a session. Sessions the hub knows nothing
+// about are idle.
+func (h *Hub) State(sessionID string) models.LiveState {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return h.parents[sessionID]
+	if s, ok := h.sessions[sessionID]; ok {
+		return s.snapshot(sessionID)
+	}
+	return models.LiveState{SessionID: sessionID, State: models.StateIdle}
 }
@@ -14,13 +14,238 @@ type User struct {
 	Mobile       string    `json:"mobile"`
 	PasswordHash string    `json:"-"`
 	Role         Role      `json:"role"`
+	Disabled     bool      `json:"disabled"`
 	CreatedAt    time.Time `json:"created_at"`
+	// TokensValidAfter invalidates the browser cookies issued before it.
+	TokensValidAfter *time.Time `json:"-"`
 }
 
 type Session struct {
-	ID        string    `json:"id"`
+	ID          string          `json:"id"`
+	UserID      int64           `json:"user_id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Status      string          `json:"status"`           // SessionActive or SessionArchived
+	OrgID       int64           `json:"org_id,omitempty"` // the organization the session is a room of; 0 if personal
+	BroadcastID string          `json:"-"`                // the ID in the kid link, only shown to whoever manages the session
+	Settings    SessionSettings `json:"settings"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Session statuses. An archived session is read-only: nothing can be
+// broadcast into it and it cannot be changed until it is restored, but
+// its recordings and members stay.
+const (
+	SessionActive   = "active"
+	SessionArchived = "archived"
+)
+
+// Archived reports whether the session is read-only.
+func (s Session) Archived() bool { return s.Status == SessionArchived }
+
+// SessionSettings are how a session is broadcast and kept. The audio
+// settings are applied by the kid page when it starts streaming.
+type SessionSettings struct {
+	Recording        bool `json:"recording"`
+	AudioBitrate     int  `json:"audio_bitrate"` // bits per second; 0 leaves it to the browser
+	EchoCancellation bool `json:"echo_cancellation"`
+	NoiseSuppression bool `json:"noise_suppression"`
+	AutoGainControl  bool `json:"auto_gain_control"`
+}
+
+// DefaultSessionSettings are the settings of a new session: recorded, with
+// the browser's usual audio processing.
+var DefaultSessionSettings = SessionSettings{Recording: true, EchoCancellation: true, NoiseSuppression: true, AutoGainControl: true}
+
+// MemberRole is what a user may do with a session.
+type MemberRole string
+
+const (
+	// MemberOwner is the session's user: everything, including sharing,
+	// broadcasting with credentials and deleting.
+	MemberOwner MemberRole = "owner"
+	// MemberListener listens live and sees and acknowledges the session's
+	// alerts.
+	MemberListener MemberRole = "listener"
+	// MemberViewer lists and plays the session's recordings.
+	MemberViewer MemberRole = "viewer"
+	// MemberStaff is a staff member of the organization the session is a
+	// room of: listens live, sees its alerts and plays its recordings.
+	MemberStaff MemberRole = "staff"
+)
+
+// Member is a user's role in a session.
+type Member struct {
+	SessionID string     `json:"session_id"`
+	UserID    int64      `json:"user_id"`
+	Mobile    string     `json:"mobile"`
+	Role      MemberRole `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Invite offers a role in a session to the user with Mobile or, when it
+// is empty, to whoever opens the invite link first.
+type Invite struct {
+	ID            int64      `json:"id"`
+	SessionID     string     `json:"session_id"`
+	SessionName   string     `json:"session_name"`
+	Mobile        string     `json:"mobile,omitempty"`
+	Role          MemberRole `json:"role"`
+	InvitedBy     int64      `json:"invited_by"`
+	InviterMobile string     `json:"invited_by_mobile"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+}
+
+// OrgRole is what a user may do in an organization.
+type OrgRole string
+
+const (
+	// OrgAdmin manages the organization's rooms, members and settings and
+	// has the owner's role in every room.
+	OrgAdmin OrgRole = "admin"
+	// OrgStaff has the staff role in every room.
+	OrgStaff OrgRole = "staff"
+	// OrgGuardian uses only the rooms shared with them.
+	OrgGuardian OrgRole = "guardian"
+)
+
+// Organization owns sessions as its rooms, such as the groups of a daycare.
+type Organization struct {
+	ID        int64       `json:"id"`
+	Name      string      `json:"name"`
+	Quotas    OrgQuotas   `json:"quotas"`
+	Settings  OrgSettings `json:"settings"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// OrgQuotas limit an organization. They are set by administrators; 0 is
+// unlimited.
+type OrgQuotas struct {
+	MaxRooms        int   `json:"max_rooms"`
+	MaxMembers      int   `json:"max_members"`
+	MaxStorageBytes int64 `json:"max_storage_bytes"` // beyond it, rooms broadcast without recording
+}
+
+// OrgSettings are chosen by an organization's admins.
+type OrgSettings struct {
+	// InviteLinks allows sharing rooms through invite links, not only with
+	// mobile numbers.
+	InviteLinks bool `json:"invite_links"`
+	// GuardianRecordings allows giving guardians the viewer role.
+	GuardianRecordings bool `json:"guardian_recordings"`
+}
+
+// OrgUsage is what an organization uses of its quotas.
+type OrgUsage struct {
+	Rooms        int   `json:"rooms"`
+	Members      int   `json:"members"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+// OrgMember is a user's role in an organization.
+type OrgMember struct {
+	OrgID     int64     `json:"org_id"`
 	UserID    int64     `json:"user_id"`
-	Name      string    `json:"name"`
-	Status    string    `json:"status"` // "active", "archived"
+	Mobile    string    `json:"mobile"`
+	Role      OrgRole   `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// Recording is the audio of one source connection, stored as a WebM file.
+type Recording struct {
+	ID        int64      `json:"id"`
+	SessionID string     `json:"session_id"`
+	Path      string     `json:"-"`
+	Status    string     `json:"status"` // "recording", "finalized"
+	Bytes     int64      `json:"bytes"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// Device is a browser push subscription of one of a user's devices.
+type Device struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Endpoint   string    `json:"endpoint"`
+	P256dh     string    `json:"-"`
+	Auth       string    `json:"-"`
+	DeviceName string    `json:"device_name"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AuditEvent is one entry of the audit log. Hash covers the other fields
+// and PrevHash, the hash of the entry before it.
+type AuditEvent struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	ActorID    int64     `json:"actor_id,omitempty"` // 0 when nobody was signed in
+	Actor      string    `json:"actor"`              // mobile number at the time
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type,omitempty"` // user, session, org, recording, token, device
+	TargetID   string    `json:"target_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Detail     string    `json:"detail,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// Live states of a session, as tracked by the hub.
+const (
+	StateIdle         = "idle"
+	StateLive         = "live"
+	StateReconnecting = "reconnecting"
+)
+
+// LiveState is the authoritative live state of a session. It is kept in
+// memory by the hub and never stored.
+type LiveState struct {
+	SessionID string     `json:"session_id"`
+	State     string     `json:"state"`
+	Listeners int        `json:"listeners"`
+	Bitrate   int        `json:"bitrate"` // bits per second over the last window
+	Muted     bool       `json:"muted"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// Kbps is the bitrate in kilobits per second, for display.
+func (s LiveState) Kbps() int {
+	return s.Bitrate / 1000
+}
+
+// Alert rule kinds.
+const (
+	AlertSound                = "sound"                 // level >= Threshold for DurationSeconds
+	AlertStreamDropped        = "stream_dropped"        // source lost or stalled while live
+	AlertOffline              = "offline"               // no source for DurationSeconds
+	AlertListenerDisconnected = "listener_disconnected" // last listener left a live stream
+
+	// AlertSOS is raised by the kid device asking for help. It has no rule.
+	AlertSOS = "sos"
+)
+
+// AlertRule is a per-session condition evaluated by the alert engine.
+type AlertRule struct {
+	ID              int64     `json:"id"`
+	SessionID       string    `json:"session_id"`
+	Kind            string    `json:"kind"`
+	Threshold       float64   `json:"threshold"`        // sound level 0-100, sound rules only
+	DurationSeconds int       `json:"duration_seconds"` // how long the condition must hold
+	CooldownSeconds int       `json:"cooldown_seconds"` // minimum gap between two alerts
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Alert is a triggered rule, stored until acknowledged.
+type Alert struct {
+	ID             int64      `json:"id"`
+	RuleID         int64      `json:"rule_id,omitempty"`
+	SessionID      string     `json:"session_id"`
+	UserID         int64      `json:"user_id"`
+	Kind           string     `json:"kind"`
+	Message        string     `json:"message"`
+	CreatedAt      time.Time  `json:"created_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+}
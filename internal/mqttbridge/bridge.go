@@ -0,0 +1,228 @@
+// Package mqttbridge publishes live session state and alerts to an MQTT
+// broker for home automation systems such as Home Assistant, and maps
+// command topics onto the kid control path.
+//
+// Topics, under the configured prefix:
+//
+//	{prefix}/status                    "online" / "offline" (retained, last will)
+//	{prefix}/{session}/state           idle, live or reconnecting (retained)
+//	{prefix}/{session}/listeners       listener count (retained)
+//	{prefix}/{session}/muted           ON / OFF (retained)
+//	{prefix}/{session}/attributes      live state as JSON (retained)
+//	{prefix}/{session}/alert           alert as JSON
+//	{prefix}/{session}/mute/set        command: ON mutes, OFF unmutes
+//	{prefix}/{session}/stop/set        command: any payload stops the stream
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// Commands understood by the controller, matching the kid control messages.
+const (
+	CommandMute   = "mute"
+	CommandUnmute = "unmute"
+	CommandStop   = "stop"
+)
+
+// Controller is the part of the hub the bridge needs: the current state of
+// a session and a way to send commands to its kid device.
+type Controller interface {
+	State(sessionID string) models.LiveState
+	Command(sessionID, command string) error
+}
+
+type Config struct {
+	Broker   string // e.g. tcp://localhost:1883
+	ClientID string
+	Username string
+	Password string
+	// TopicPrefix is the root of every state and command topic.
+	TopicPrefix string
+	// DiscoveryPrefix is Home Assistant's discovery prefix. Empty disables
+	// discovery payloads.
+	DiscoveryPrefix string
+}
+
+// Bridge keeps the broker in sync with the event bus.
+type Bridge struct {
+	cfg      Config
+	logger   *slog.Logger
+	bus      *events.Bus
+	ctl      Controller
+	sessions store.Sessions
+	client   mqtt.Client
+}
+
+func New(logger *slog.Logger, bus *events.Bus, ctl Controller, sessions store.Sessions, cfg Config) *Bridge {
+	b := &Bridge{cfg: cfg, logger: logger, bus: bus, ctl: ctl, sessions: sessions}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetWill(b.topic("status"), "offline", 1, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn("MQTT connection lost", "error", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	return b
+}
+
+// Run connects to the broker and publishes until ctx is done. Connection
+// failures are retried in the background.
+func (b *Bridge) Run(ctx context.Context) {
+	// Subscribe before connecting so nothing published while the initial
+	// snapshot goes out is missed.
+	ch, cancel := b.bus.Subscribe(256)
+	defer cancel()
+
+	b.client.Connect()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if b.client.IsConnected() {
+				b.client.Publish(b.topic("status"), 1, true, "offline").WaitTimeout(time.Second)
+			}
+			b.client.Disconnect(250)
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			b.handleEvent(e)
+		}
+	}
+}
+
+func (b *Bridge) topic(parts ...string) string {
+	return b.cfg.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+// onConnect runs on every (re)connect: it announces availability,
+// subscribes to the command topics and republishes every session, since
+// updates made while disconnected were dropped.
+func (b *Bridge) onConnect(c mqtt.Client) {
+	b.logger.Info("MQTT connected", "broker", b.cfg.Broker)
+	c.Publish(b.topic("status"), 1, true, "online")
+	c.Subscribe(b.topic("+", "mute", "set"), 1, b.onCommand)
+	c.Subscribe(b.topic("+", "stop", "set"), 1, b.onCommand)
+
+	sessions, err := b.sessions.All(context.Background())
+	if err != nil {
+		b.logger.Error("Failed to load sessions for MQTT", "error", err)
+		return
+	}
+	for _, s := range sessions {
+		b.publishDiscovery(s)
+		b.publishState(b.ctl.State(s.ID))
+	}
+}
+
+func (b *Bridge) onCommand(_ mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), b.cfg.TopicPrefix+"/"), "/")
+	if len(parts) != 3 {
+		return
+	}
+	sessionID, action := parts[0], parts[1]
+
+	var command string
+	switch payload := strings.ToUpper(strings.TrimSpace(string(msg.Payload()))); {
+	case action == "stop":
+		command = CommandStop
+	case action == "mute" && payload == "ON":
+		command = CommandMute
+	case action == "mute" && payload == "OFF":
+		command = CommandUnmute
+	default:
+		b.logger.Warn("Ignoring MQTT command", "topic", msg.Topic(), "payload", payload)
+		return
+	}
+
+	if err := b.ctl.Command(sessionID, command); err != nil {
+		b.logger.Warn("MQTT command failed", "session_id", sessionID, "command", command, "error", err)
+		return
+	}
+	b.logger.Info("MQTT command sent", "session_id", sessionID, "command", command)
+}
+
+func (b *Bridge) handleEvent(e events.Event) {
+	switch e.Type {
+	case events.SessionState:
+		if st, ok := e.Data.(models.LiveState); ok {
+			b.publishState(st)
+		}
+	case events.SessionCreated, events.SessionUpdated:
+		if s, ok := e.Data.(models.Session); ok {
+			b.publishDiscovery(s)
+			b.publishState(b.ctl.State(s.ID))
+		}
+	case events.SessionDeleted:
+		b.clearSession(e.SessionID)
+	case events.AlertRaised:
+		if a, ok := e.Data.(models.Alert); ok {
+			b.publishAlert(a)
+		}
+	}
+}
+
+func (b *Bridge) publishState(st models.LiveState) {
+	if !b.client.IsConnected() {
+		return
+	}
+	muted := "OFF"
+	if st.Muted {
+		muted = "ON"
+	}
+	attrs, _ := json.Marshal(st)
+
+	b.client.Publish(b.topic(st.SessionID, "state"), 1, true, st.State)
+	b.client.Publish(b.topic(st.SessionID, "listeners"), 1, true, strconv.Itoa(st.Listeners))
+	b.client.Publish(b.topic(st.SessionID, "muted"), 1, true, muted)
+	b.client.Publish(b.topic(st.SessionID, "attributes"), 1, true, attrs)
+}
+
+func (b *Bridge) publishAlert(a models.Alert) {
+	if !b.client.IsConnected() {
+		return
+	}
+	// event_type lets Home Assistant's MQTT event entity pick the alert kind.
+	payload, _ := json.Marshal(struct {
+		EventType string `json:"event_type"`
+		models.Alert
+	}{a.Kind, a})
+	b.client.Publish(b.topic(a.SessionID, "alert"), 1, false, payload)
+}
+
+// clearSession removes the retained state and discovery payloads of a
+// deleted session so it disappears from the broker and from Home Assistant.
+func (b *Bridge) clearSession(sessionID string) {
+	if !b.client.IsConnected() {
+		return
+	}
+	for _, t := range []string{"state", "listeners", "muted", "attributes"} {
+		b.client.Publish(b.topic(sessionID, t), 1, true, "")
+	}
+	if b.cfg.DiscoveryPrefix == "" {
+		return
+	}
+	for _, e := range entities {
+		b.client.Publish(b.discoveryTopic(e, sessionID), 1, true, "")
+	}
+}
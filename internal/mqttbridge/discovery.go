@@ -0,0 +1,83 @@
+package mqttbridge
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// entity is one Home Assistant entity announced for every session.
+type entity struct {
+	component string // sensor, switch, button, event
+	key       string
+	name      string
+}
+
+var entities = []entity{
+	{"sensor", "state", "Stream"},
+	{"sensor", "listeners", "Listeners"},
+	{"switch", "mute", "Mute"},
+	{"button", "stop", "Stop stream"},
+	{"event", "alert", "Alert"},
+}
+
+// objectID turns a session ID into an identifier Home Assistant accepts.
+func objectID(sessionID string) string {
+	return "a2web_" + strings.TrimRight(sessionID, "=")
+}
+
+func (b *Bridge) discoveryTopic(e entity, sessionID string) string {
+	return b.cfg.DiscoveryPrefix + "/" + e.component + "/" + objectID(sessionID) + "/" + e.key + "/config"
+}
+
+// publishDiscovery announces a session as a Home Assistant device.
+func (b *Bridge) publishDiscovery(s models.Session) {
+	if b.cfg.DiscoveryPrefix == "" || !b.client.IsConnected() {
+		return
+	}
+
+	name := s.Name
+	if name == "" {
+		name = s.ID
+	}
+	device := map[string]interface{}{
+		"identifiers":  []string{objectID(s.ID)},
+		"name":         "a2web " + name,
+		"manufacturer": "a2web",
+		"model":        "Audio session",
+	}
+
+	for _, e := range entities {
+		cfg := map[string]interface{}{
+			"name":               e.name,
+			"unique_id":          objectID(s.ID) + "_" + e.key,
+			"device":             device,
+			"availability_topic": b.topic("status"),
+		}
+		switch e.key {
+		case "state":
+			cfg["state_topic"] = b.topic(s.ID, "state")
+			cfg["json_attributes_topic"] = b.topic(s.ID, "attributes")
+			cfg["device_class"] = "enum"
+			cfg["options"] = []string{models.StateIdle, models.StateLive, models.StateReconnecting}
+		case "listeners":
+			cfg["state_topic"] = b.topic(s.ID, "listeners")
+			cfg["state_class"] = "measurement"
+		case "mute":
+			cfg["state_topic"] = b.topic(s.ID, "muted")
+			cfg["command_topic"] = b.topic(s.ID, "mute", "set")
+		case "stop":
+			cfg["command_topic"] = b.topic(s.ID, "stop", "set")
+		case "alert":
+			cfg["state_topic"] = b.topic(s.ID, "alert")
+			cfg["event_types"] = []string{models.AlertSound, models.AlertStreamDropped, models.AlertOffline, models.AlertListenerDisconnected, models.AlertSOS}
+		}
+
+		payload, err := json.Marshal(cfg)
+		if err != nil {
+			continue
+		}
+		b.client.Publish(b.discoveryTopic(e, s.ID), 1, true, payload)
+	}
+}
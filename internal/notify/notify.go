@@ -0,0 +1,340 @@
+// Package notify delivers notifications to users outside the browser through
+// pluggable channels such as email and Telegram. Messages are written to a
+// persistent outbox first, so a restart never drops them, and are filtered
+// by each user's preferences: per-event opt-in and quiet hours.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// Event types a user can opt in to.
+const (
+	EventAlert            = "alert"
+	EventLogin            = "login"
+	EventDevicePaired     = "device_paired"
+	EventRecordingDeleted = "recording_deleted"
+)
+
+// Events lists every event type, in display order.
+var Events = []string{EventAlert, EventLogin, EventDevicePaired, EventRecordingDeleted}
+
+// Message priorities. High priority messages, such as an SOS, are delivered
+// during quiet hours and regardless of the user's event opt-in.
+const (
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// Message is a channel-independent notification.
+type Message struct {
+	Event    string
+	Subject  string
+	Body     string
+	URL      string // absolute link, optional
+	Priority string
+}
+
+// Channel delivers a message to one address, e.g. an email address or a
+// Telegram chat ID.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, address string, msg Message) error
+}
+
+// PermanentError wraps a delivery error that retrying will not fix, such
+// as a rejected address.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+func permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+const (
+	pollInterval = 2 * time.Second
+	batchSize    = 20
+	maxAttempts  = 8
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = 2 * time.Hour
+	sendTimeout  = 30 * time.Second
+	// keepFinished is how long sent and failed rows stay in the outbox.
+	keepFinished = 7 * 24 * time.Hour
+)
+
+// Notifier queues messages for every channel a user has configured and
+// delivers the outbox in the background.
+type Notifier struct {
+	logger   *slog.Logger
+	bus      *events.Bus
+	store    *Store
+	alerts   *alerts.Store
+	baseURL  string
+	channels map[string]Channel
+	wake     chan struct{}
+}
+
+// NewNotifier creates a notifier with its outbox in st. baseURL is the public address of the
+// server, used to turn the relative links of bus events into absolute ones;
+// links are left out when it is empty.
+func NewNotifier(logger *slog.Logger, bus *events.Bus, st *Store, baseURL string, channels ...Channel) *Notifier {
+	n := &Notifier{
+		logger:   logger,
+		bus:      bus,
+		store:    st,
+		alerts:   alerts.NewStore(st.db),
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		channels: make(map[string]Channel),
+		wake:     make(chan struct{}, 1),
+	}
+	for _, c := range channels {
+		n.channels[c.Name()] = c
+	}
+	return n
+}
+
+// Channels returns the names of the configured channels.
+func (n *Notifier) Channels() []string {
+	var names []string
+	for _, name := range []string{ChannelEmail, ChannelTelegram} {
+		if _, ok := n.channels[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Notify queues msg for every configured channel of the user that has an
+// address, unless the user opted out of the event type. During quiet hours
+// normal priority messages are held until the quiet period ends. High
+// priority messages ignore both. It returns the number of queued messages.
+func (n *Notifier) Notify(userID int64, msg Message) (int, error) {
+	prefs, err := n.store.LoadPreferences(userID)
+	if err != nil {
+		return 0, err
+	}
+	if msg.Priority == "" {
+		msg.Priority = PriorityNormal
+	}
+	if msg.Priority != PriorityHigh && !prefs.Wants(msg.Event) {
+		return 0, nil
+	}
+
+	notBefore := time.Now()
+	if msg.Priority != PriorityHigh {
+		if until, quiet := prefs.QuietUntil(notBefore); quiet {
+			notBefore = until
+		}
+	}
+
+	queued := 0
+	for name := range n.channels {
+		address := prefs.Address(name)
+		if address == "" {
+			continue
+		}
+		if err := n.store.enqueue(userID, name, address, msg, notBefore); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	if queued > 0 {
+		select {
+		case n.wake <- struct{}{}:
+		default:
+		}
+	}
+	return queued, nil
+}
+
+// link turns a path into an absolute URL, or "" when no base URL is set.
+func (n *Notifier) link(path string) string {
+	if n.baseURL == "" {
+		return ""
+	}
+	return n.baseURL + path
+}
+
+// Run turns bus events into notifications and delivers the outbox until ctx
+// is done. Events are queued on their own goroutine, so a slow channel never
+// leaves bus events unread until they are dropped.
+func (n *Notifier) Run(ctx context.Context) {
+	ch, cancel := n.bus.Subscribe(64)
+	defer cancel()
+	go n.queueEvents(ctx, ch)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.wake:
+			n.deliverDue(ctx)
+		case <-ticker.C:
+			n.deliverDue(ctx)
+		case <-purge.C:
+			if err := n.store.purgeOutbox(time.Now().Add(-keepFinished)); err != nil {
+				n.logger.Error("Failed to purge notification outbox", "error", err)
+			}
+		}
+	}
+}
+
+// queueEvents writes a notification to the outbox for every bus event read
+// from ch until ctx is done.
+func (n *Notifier) queueEvents(ctx context.Context, ch <-chan events.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			msg, ok := n.message(e)
+			if !ok {
+				continue
+			}
+			for _, userID := range n.recipients(e) {
+				if _, err := n.Notify(userID, msg); err != nil {
+					n.logger.Error("Failed to queue notification", "event", e.Type, "user_id", userID, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// recipients returns the users notified of an event: alerts also go to the
+// members listening to the session, everything else only to e.UserID.
+func (n *Notifier) recipients(e events.Event) []int64 {
+	alert, ok := e.Data.(models.Alert)
+	if e.Type != events.AlertRaised || !ok {
+		return []int64{e.UserID}
+	}
+	users, err := n.alerts.Recipients(alert)
+	if err != nil {
+		n.logger.Error("Failed to load alert recipients", "alert_id", alert.ID, "error", err)
+		return []int64{e.UserID}
+	}
+	return users
+}
+
+// message maps a bus event to a notification.
+func (n *Notifier) message(e events.Event) (Message, bool) {
+	switch e.Type {
+	case events.AlertRaised:
+		alert, ok := e.Data.(models.Alert)
+		if !ok {
+			return Message{}, false
+		}
+		msg := Message{
+			Event:   EventAlert,
+			Subject: "a2web alert: " + alert.Kind,
+			Body:    alert.Message,
+			URL:     n.link("/user/" + alert.SessionID),
+		}
+		if alert.Kind == models.AlertSOS {
+			msg.Subject = "SOS from a2web"
+			msg.Priority = PriorityHigh
+		}
+		return msg, true
+
+	case events.UserLogin:
+		info, _ := e.Data.(map[string]string)
+		return Message{
+			Event:   EventLogin,
+			Subject: "New sign-in to your a2web account",
+			Body: fmt.Sprintf("Your account was signed in to at %s from %s (%s). If this wasn't you, change your password.",
+				e.Time.Format(time.RFC1123), info["ip"], info["user_agent"]),
+			URL: n.link("/dashboard"),
+		}, true
+
+	case events.DevicePaired:
+		info, _ := e.Data.(map[string]string)
+		return Message{
+			Event:   EventDevicePaired,
+			Subject: "New device paired",
+			Body:    fmt.Sprintf("%q will now receive a2web notifications.", info["device_name"]),
+			URL:     n.link("/dashboard"),
+		}, true
+
+	case events.RecordingDeleted:
+		rec, ok := e.Data.(models.Recording)
+		if !ok {
+			return Message{}, false
+		}
+		return Message{
+			Event:   EventRecordingDeleted,
+			Subject: "Recording deleted",
+			Body: fmt.Sprintf("The recording of session %s started at %s was deleted.",
+				rec.SessionID, rec.StartedAt.Format(time.RFC1123)),
+			URL: n.link("/dashboard"),
+		}, true
+	}
+	return Message{}, false
+}
+
+func (n *Notifier) deliverDue(ctx context.Context) {
+	due, err := n.store.dueMessages(time.Now(), batchSize)
+	if err != nil {
+		n.logger.Error("Failed to load notification outbox", "error", err)
+		return
+	}
+	for _, m := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		n.deliver(ctx, m)
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, m outboxMessage) {
+	ch, ok := n.channels[m.channel]
+	if !ok {
+		n.store.markFailed(m.id, "channel "+m.channel+" is not configured")
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	err := ch.Send(sendCtx, m.address, m.msg)
+	cancel()
+
+	var perm *PermanentError
+	switch {
+	case err == nil:
+		n.store.markSent(m.id)
+	case errors.As(err, &perm):
+		n.logger.Warn("Notification rejected", "id", m.id, "channel", m.channel, "error", err)
+		n.store.markFailed(m.id, err.Error())
+	case m.attempts+1 >= maxAttempts:
+		n.logger.Warn("Giving up on notification", "id", m.id, "channel", m.channel, "error", err)
+		n.store.markFailed(m.id, err.Error())
+	default:
+		n.store.scheduleRetry(m.id, time.Now().Add(backoff(m.attempts)), err.Error())
+	}
+}
+
+func backoff(attempts int) time.Duration {
+	d := baseBackoff << attempts
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,64 @@
+package notify
+
+import "time"
+
+type outboxMessage struct {
+	id       int64
+	channel  string
+	address  string
+	msg      Message
+	attempts int
+}
+
+func (s *Store) enqueue(userID int64, channel, address string, msg Message, notBefore time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO notification_outbox (user_id, channel, address, event, priority, subject, body, url, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, channel, address, msg.Event, msg.Priority, msg.Subject, msg.Body, msg.URL, notBefore.UTC())
+	return err
+}
+
+// dueMessages returns pending messages whose next attempt is due, high
+// priority first.
+func (s *Store) dueMessages(now time.Time, limit int) ([]outboxMessage, error) {
+	rows, err := s.db.Query(`SELECT id, channel, address, event, priority, subject, body, url, attempts
+		FROM notification_outbox WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY priority = 'high' DESC, next_attempt_at LIMIT ?`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []outboxMessage
+	for rows.Next() {
+		var m outboxMessage
+		if err := rows.Scan(&m.id, &m.channel, &m.address, &m.msg.Event, &m.msg.Priority, &m.msg.Subject,
+			&m.msg.Body, &m.msg.URL, &m.attempts); err != nil {
+			return nil, err
+		}
+		due = append(due, m)
+	}
+	return due, rows.Err()
+}
+
+func (s *Store) markSent(id int64) error {
+	_, err := s.db.Exec("UPDATE notification_outbox SET status = 'sent', attempts = attempts + 1, last_error = NULL, updated_at = ? WHERE id = ?",
+		time.Now().UTC(), id)
+	return err
+}
+
+func (s *Store) markFailed(id int64, reason string) error {
+	_, err := s.db.Exec("UPDATE notification_outbox SET status = 'failed', attempts = attempts + 1, last_error = ?, updated_at = ? WHERE id = ?",
+		reason, time.Now().UTC(), id)
+	return err
+}
+
+func (s *Store) scheduleRetry(id int64, next time.Time, reason string) error {
+	_, err := s.db.Exec("UPDATE notification_outbox SET attempts = attempts + 1, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		next.UTC(), reason, time.Now().UTC(), id)
+	return err
+}
+
+func (s *Store) purgeOutbox(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM notification_outbox WHERE status != 'pending' AND updated_at < ?", before.UTC())
+	return err
+}
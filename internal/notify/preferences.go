@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+)
+
+// Store keeps notification preferences and the outbox.
+type Store struct {
+	db *database.Handle
+}
+
+// NewStore returns the notification store of db.
+func NewStore(db *database.Handle) *Store {
+	return &Store{db: db}
+}
+
+// Channel names, also used as keys in the outbox.
+const (
+	ChannelEmail    = "email"
+	ChannelTelegram = "telegram"
+)
+
+// Preferences are a user's notification settings. A channel is used when
+// its address is set.
+type Preferences struct {
+	Email          string `json:"email"`
+	TelegramChatID string `json:"telegram_chat_id"`
+	// QuietStart and QuietEnd ("HH:MM", in Timezone) bound the daily quiet
+	// period. The period may wrap past midnight; equal or empty values
+	// disable it.
+	QuietStart string `json:"quiet_start"`
+	QuietEnd   string `json:"quiet_end"`
+	// Timezone is an IANA name; empty means the server's local time.
+	Timezone string   `json:"timezone"`
+	Events   []string `json:"events"`
+}
+
+// DefaultPreferences opts in to every event with no channel configured.
+func DefaultPreferences() Preferences {
+	return Preferences{Events: append([]string(nil), Events...)}
+}
+
+// LoadPreferences returns a user's preferences, or the defaults if they
+// never saved any.
+func (s *Store) LoadPreferences(userID int64) (Preferences, error) {
+	p := DefaultPreferences()
+	var evs string
+	err := s.db.QueryRow(`SELECT email, telegram_chat_id, quiet_start, quiet_end, timezone, events
+		FROM notification_prefs WHERE user_id = ?`, userID).
+		Scan(&p.Email, &p.TelegramChatID, &p.QuietStart, &p.QuietEnd, &p.Timezone, &evs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	p.Events = nil
+	if evs != "" {
+		p.Events = strings.Split(evs, ",")
+	}
+	return p, nil
+}
+
+// SavePreferences replaces a user's preferences.
+func (s *Store) SavePreferences(userID int64, p Preferences) error {
+	_, err := s.db.Exec(`INSERT INTO notification_prefs (user_id, email, telegram_chat_id, quiet_start, quiet_end, timezone, events, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET email = excluded.email, telegram_chat_id = excluded.telegram_chat_id,
+			quiet_start = excluded.quiet_start, quiet_end = excluded.quiet_end, timezone = excluded.timezone,
+			events = excluded.events, updated_at = excluded.updated_at`,
+		userID, p.Email, p.TelegramChatID, p.QuietStart, p.QuietEnd, p.Timezone, strings.Join(p.Events, ","))
+	return err
+}
+
+// Validate checks addresses, times, the timezone and event names.
+func (p Preferences) Validate() error {
+	if p.Email != "" {
+		if addr, err := mail.ParseAddress(p.Email); err != nil || addr.Address != p.Email {
+			return fmt.Errorf("invalid email address")
+		}
+	}
+	if strings.ContainsAny(p.TelegramChatID, " \r\n") {
+		return fmt.Errorf("invalid telegram chat id")
+	}
+	for _, t := range []string{p.QuietStart, p.QuietEnd} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", t); err != nil {
+			return fmt.Errorf("quiet hours must be HH:MM")
+		}
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", p.Timezone)
+		}
+	}
+	for _, e := range p.Events {
+		if !validEvent(e) {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	return nil
+}
+
+func validEvent(name string) bool {
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Wants reports whether the user opted in to the event type.
+func (p Preferences) Wants(event string) bool {
+	for _, e := range p.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Address returns the user's address for a channel, or "".
+func (p Preferences) Address(channel string) string {
+	switch channel {
+	case ChannelEmail:
+		return p.Email
+	case ChannelTelegram:
+		return p.TelegramChatID
+	}
+	return ""
+}
+
+// QuietUntil reports whether now falls in the quiet period and, if so,
+// when it ends.
+func (p Preferences) QuietUntil(now time.Time) (time.Time, bool) {
+	start, err1 := time.Parse("15:04", p.QuietStart)
+	end, err2 := time.Parse("15:04", p.QuietEnd)
+	if err1 != nil || err2 != nil {
+		return time.Time{}, false
+	}
+	loc := time.Local
+	if p.Timezone != "" {
+		if l, err := time.LoadLocation(p.Timezone); err == nil {
+			loc = l
+		}
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	s := start.Hour()*60 + start.Minute()
+	e := end.Hour()*60 + end.Minute()
+	endToday := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+
+	switch {
+	case s == e:
+		return time.Time{}, false
+	case s < e && minute >= s && minute < e:
+		return endToday, true
+	case s > e && minute >= s:
+		return endToday.AddDate(0, 0, 1), true
+	case s > e && minute < e:
+		return endToday, true
+	}
+	return time.Time{}, false
+}
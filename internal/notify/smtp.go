@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// SMTP sends notifications as plain text email. Port 465 uses implicit TLS;
+// on other ports STARTTLS is used when the server offers it.
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTP) Name() string { return ChannelEmail }
+
+func (s *SMTP) Send(ctx context.Context, address string, msg Message) error {
+	addr := net.JoinHostPort(s.Host, s.Port)
+	dialer := &net.Dialer{}
+
+	var conn net.Conn
+	var err error
+	if s.Port == "465" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && s.Port != "465" {
+		if err := c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return classifySMTP(err)
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return classifySMTP(err)
+	}
+	if err := c.Rcpt(address); err != nil {
+		return classifySMTP(err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return classifySMTP(err)
+	}
+	if _, err := w.Write(s.compose(address, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return classifySMTP(err)
+	}
+	return c.Quit()
+}
+
+func (s *SMTP) compose(to string, msg Message) []byte {
+	body := msg.Body
+	if msg.URL != "" {
+		body += "\n\n" + msg.URL
+	}
+	body = strings.ReplaceAll(body, "\n", "\r\n")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if msg.Priority == PriorityHigh {
+		b.WriteString("Importance: high\r\nX-Priority: 1\r\n")
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(body)
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// classifySMTP marks 5xx replies as permanent; 4xx replies are transient.
+func classifySMTP(err error) error {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Telegram sends notifications through the Telegram Bot API. The address is
+// the chat ID the user gets by messaging the bot. BaseURL defaults to the
+// public API and can point at a local Bot API server or a test double.
+type Telegram struct {
+	BaseURL string
+	Token   string
+
+	client *http.Client
+}
+
+func NewTelegram(baseURL, token string) *Telegram {
+	if baseURL == "" {
+		baseURL = "https://api.telegram.org"
+	}
+	return &Telegram{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (t *Telegram) Name() string { return ChannelTelegram }
+
+func (t *Telegram) Send(ctx context.Context, address string, msg Message) error {
+	text := msg.Subject + "\n\n" + msg.Body
+	if msg.URL != "" {
+		text += "\n" + msg.URL
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  address,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.BaseURL+"/bot"+t.Token+"/sendMessage", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// The error text contains the URL and with it the token.
+		return fmt.Errorf("telegram request failed: %w", redact(err, t.Token))
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode == http.StatusOK && result.OK {
+		return nil
+	}
+
+	err = fmt.Errorf("telegram: HTTP %d: %s", resp.StatusCode, result.Description)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanent(err)
+	}
+	return err
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+func redact(err error, secret string) error {
+	if secret == "" {
+		return err
+	}
+	return &redactedError{msg: strings.ReplaceAll(err.Error(), secret, "<token>"), err: err}
+}
@@ -0,0 +1,171 @@
+package recordings
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// Root is the directory recordings are written to, one subdirectory per
+// session.
+const Root = "./storage/recordings"
+
+// SessionDir returns the directory holding a session's recordings.
+func SessionDir(sessionID string) string {
+	return filepath.Join(Root, sessionID)
+}
+
+// Start creates the row and the file for a new recording and returns the
+// open file.
+func Start(ctx context.Context, st store.Recordings, sessionID string) (*models.Recording, *os.File, error) {
+	if err := os.MkdirAll(SessionDir(sessionID), 0755); err != nil {
+		return nil, nil, err
+	}
+
+	rec := &models.Recording{SessionID: sessionID, Status: "recording", StartedAt: time.Now()}
+	if err := st.Create(ctx, rec); err != nil {
+		return nil, nil, err
+	}
+
+	rec.Path = filepath.Join(SessionDir(sessionID), strconv.FormatInt(rec.ID, 10)+".webm")
+	if err := st.SetPath(ctx, rec.ID, rec.Path); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(rec.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		st.Delete(ctx, rec.ID)
+		return nil, nil, err
+	}
+	return rec, f, nil
+}
+
+// Finalize records the end time and size of a recording.
+func Finalize(ctx context.Context, st store.Recordings, rec *models.Recording, bytes int64) error {
+	now := time.Now()
+	rec.Status = "finalized"
+	rec.Bytes = bytes
+	rec.EndedAt = &now
+	return st.Finish(ctx, rec.ID, rec.Bytes, now)
+}
+
+// FinalizeInterrupted closes out recordings left open by a crash or restart,
+// taking the size from the file on disk.
+func FinalizeInterrupted(ctx context.Context, st store.Recordings) (int, error) {
+	open, err := st.ListOpen(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, rec := range open {
+		var size int64
+		if fi, err := os.Stat(rec.Path); err == nil {
+			size = fi.Size()
+		}
+		if err := st.Finish(ctx, rec.ID, size, time.Now()); err != nil {
+			return 0, err
+		}
+	}
+	return len(open), nil
+}
+
+// Delete removes a recording's row and file.
+func Delete(ctx context.Context, st store.Recordings, rec models.Recording) error {
+	if err := st.Delete(ctx, rec.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	if err := os.Remove(rec.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// RemoveSessionFiles deletes every recording file of a session, including the
+// single appended file written by earlier versions. Rows go with the session
+// through ON DELETE CASCADE.
+func RemoveSessionFiles(sessionID string) {
+	os.RemoveAll(SessionDir(sessionID))
+	os.Remove("./storage/" + sessionID + ".webm")
+}
+
+// Kinds of storage problems found by Check.
+const (
+	ProblemMissing = "missing"       // the row's file does not exist
+	ProblemSize    = "size_mismatch" // a finalized file's size differs from its row
+	ProblemOrphan  = "orphan"        // a file under Root has no row
+)
+
+// Problem is a mismatch between the recordings table and the files on disk.
+type Problem struct {
+	Kind        string
+	RecordingID int64 // 0 for orphans
+	Path        string
+	Bytes       int64 // size in the database
+	FileBytes   int64 // size on disk
+}
+
+// Check compares every recording row with its file and looks for files
+// under Root that no row refers to. Recordings in progress are only checked
+// for their file.
+func Check(ctx context.Context, st store.Recordings) ([]Problem, error) {
+	all, err := st.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	known := make(map[string]bool)
+	for _, rec := range all {
+		p := Problem{RecordingID: rec.ID, Path: rec.Path, Bytes: rec.Bytes}
+		known[filepath.Clean(p.Path)] = true
+		fi, err := os.Stat(p.Path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			p.Kind = ProblemMissing
+		case err != nil:
+			return nil, err
+		case rec.Status == "finalized" && fi.Size() != p.Bytes:
+			p.Kind, p.FileBytes = ProblemSize, fi.Size()
+		default:
+			continue
+		}
+		problems = append(problems, p)
+	}
+
+	err = filepath.WalkDir(Root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && path == Root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || known[filepath.Clean(path)] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		problems = append(problems, Problem{Kind: ProblemOrphan, Path: path, FileBytes: info.Size()})
+		return nil
+	})
+	return problems, err
+}
+
+// Repair fixes a problem found by Check: the row of a missing file is
+// deleted, a wrong size is corrected and an orphaned file is deleted.
+func Repair(ctx context.Context, st store.Recordings, p Problem) error {
+	switch p.Kind {
+	case ProblemMissing:
+		return st.Delete(ctx, p.RecordingID)
+	case ProblemSize:
+		return st.SetBytes(ctx, p.RecordingID, p.FileBytes)
+	case ProblemOrphan:
+		return os.Remove(p.Path)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+type auditLog struct {
+	db *database.Handle
+}
+
+const eventColumns = "id, created_at, actor_id, actor, action, target_type, target_id, ip, user_agent, outcome, detail, prev_hash, hash"
+
+func scanEvent(row scanner) (models.AuditEvent, error) {
+	var e models.AuditEvent
+	var actorID sql.NullInt64
+	err := row.Scan(&e.ID, &e.Time, &actorID, &e.Actor, &e.Action, &e.TargetType, &e.TargetID,
+		&e.IP, &e.UserAgent, &e.Outcome, &e.Detail, &e.PrevHash, &e.Hash)
+	e.ActorID = actorID.Int64
+	return e, err
+}
+
+func (s *auditLog) Append(ctx context.Context, e *models.AuditEvent, seal func()) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Two servers on one PostgreSQL database must not both link to the
+	// same predecessor. SQLite allows a single writer anyway.
+	if s.db.Dialect == database.Postgres {
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE audit_events IN EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+	}
+	err = tx.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&e.PrevHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		e.PrevHash = ""
+	} else if err != nil {
+		return err
+	}
+	seal()
+
+	var actorID sql.NullInt64
+	if e.ActorID != 0 {
+		actorID = sql.NullInt64{Int64: e.ActorID, Valid: true}
+	}
+	if err := tx.QueryRowContext(ctx, `INSERT INTO audit_events
+		(created_at, actor_id, actor, action, target_type, target_id, ip, user_agent, outcome, detail, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		e.Time, actorID, e.Actor, e.Action, e.TargetType, e.TargetID, e.IP, e.UserAgent, e.Outcome, e.Detail, e.PrevHash, e.Hash).
+		Scan(&e.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *auditLog) List(ctx context.Context, f store.AuditFilter) ([]models.AuditEvent, error) {
+	query := "SELECT " + eventColumns + " FROM audit_events WHERE 1 = 1"
+	var args []interface{}
+	if f.Action != "" {
+		query += " AND action = ?"
+		args = append(args, f.Action)
+	}
+	if f.Actor != "" {
+		query += ` AND actor LIKE ? ESCAPE '\'`
+		args = append(args, likePattern(f.Actor))
+	}
+	if f.ActorID != 0 {
+		query += " AND actor_id = ?"
+		args = append(args, f.ActorID)
+	}
+	if f.Target != "" {
+		query += " AND target_id = ?"
+		args = append(args, f.Target)
+	}
+	if f.Outcome != "" {
+		query += " AND outcome = ?"
+		args = append(args, f.Outcome)
+	}
+	if f.Before > 0 {
+		query += " AND id < ?"
+		args = append(args, f.Before)
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []models.AuditEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+func (s *auditLog) Walk(ctx context.Context, fn func(models.AuditEvent) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+eventColumns+" FROM audit_events ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,77 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+type devices struct {
+	db *database.Handle
+}
+
+func (s *devices) Save(ctx context.Context, d *models.Device) (bool, error) {
+	var known int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM push_subscriptions WHERE endpoint = ? AND user_id = ?",
+		d.Endpoint, d.UserID).Scan(&known); err != nil {
+		return false, err
+	}
+	// Browsers subscribe the same endpoint again with rotated keys. Another
+	// user's subscription is left alone and returns no row.
+	err := s.db.QueryRowContext(ctx, `INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, device_name)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET p256dh = excluded.p256dh,
+			auth = excluded.auth, device_name = excluded.device_name
+		WHERE push_subscriptions.user_id = excluded.user_id
+		RETURNING id`,
+		d.UserID, d.Endpoint, d.P256dh, d.Auth, d.DeviceName).Scan(&d.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, store.ErrConflict
+	}
+	return known == 0 && err == nil, err
+}
+
+func (s *devices) ListByUser(ctx context.Context, userID int64) ([]models.Device, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, user_id, endpoint, p256dh, auth, device_name, created_at
+		FROM push_subscriptions WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Device
+	for rows.Next() {
+		var d models.Device
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Endpoint, &d.P256dh, &d.Auth, &d.DeviceName, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func (s *devices) Delete(ctx context.Context, userID int64, endpoint string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE user_id = ? AND endpoint = ?", userID, endpoint)
+	return err
+}
+
+func (s *devices) DeleteByID(ctx context.Context, userID, id int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE user_id = ? AND id = ?", userID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *devices) DeleteAll(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM push_subscriptions")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,181 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+type members struct {
+	db   *database.Handle
+	role *database.Stmt // checks the access of every page and stream of a shared session
+}
+
+func (st *members) Role(ctx context.Context, sessionID string, userID int64) (models.MemberRole, error) {
+	var role models.MemberRole
+	err := st.role.QueryRowContext(ctx, sessionID, userID).Scan(&role)
+	return role, notFound(err)
+}
+
+func (st *members) List(ctx context.Context, sessionID string) ([]models.Member, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT m.session_id, m.user_id, u.mobile, m.role, m.created_at
+		FROM session_members m JOIN users u ON u.id = m.user_id WHERE m.session_id = ? ORDER BY m.created_at, m.user_id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Member
+	for rows.Next() {
+		var m models.Member
+		if err := rows.Scan(&m.SessionID, &m.UserID, &m.Mobile, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (st *members) Sessions(ctx context.Context, userID, orgID int64, limit, offset int) ([]store.MemberSession, int, error) {
+	// Admins and staff of an organization have a role in all its rooms
+	from := ` FROM sessions s
+		LEFT JOIN session_members m ON m.session_id = s.id AND m.user_id = ?
+		LEFT JOIN org_members o ON o.org_id = s.org_id AND o.user_id = ? AND o.role IN ('admin', 'staff')
+		LEFT JOIN organizations g ON g.id = s.org_id
+		LEFT JOIN users u ON u.id = s.user_id
+		WHERE (s.user_id = ? OR m.user_id IS NOT NULL OR o.user_id IS NOT NULL)`
+	args := []interface{}{userID, userID, userID}
+	if orgID != 0 {
+		from += " AND s.org_id = ?"
+		args = append(args, orgID)
+	}
+	var total int
+	if err := st.db.QueryRowContext(ctx, "SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := "SELECT " + sessionColumns + `,
+		CASE WHEN s.user_id = ? OR o.role = 'admin' THEN 'owner' WHEN o.role = 'staff' THEN 'staff' ELSE m.role END,
+		COALESCE(u.mobile, ''), COALESCE(g.name, '')` + from + " ORDER BY s.created_at DESC, s.id"
+	args = append([]interface{}{userID}, args...)
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var list []store.MemberSession
+	for rows.Next() {
+		var ms store.MemberSession
+		if ms.Session, err = scanSession(rows, &ms.Role, &ms.OwnerMobile, &ms.OrgName); err != nil {
+			return nil, 0, err
+		}
+		list = append(list, ms)
+	}
+	return list, total, rows.Err()
+}
+
+func (st *members) Remove(ctx context.Context, sessionID string, userID int64) error {
+	return affected(st.db.ExecContext(ctx, "DELETE FROM session_members WHERE session_id = ? AND user_id = ?", sessionID, userID))
+}
+
+func (st *members) CreateInvite(ctx context.Context, inv *models.Invite, tokenHash string) error {
+	inv.CreatedAt = time.Now().UTC()
+	var mobile, hash interface{}
+	if inv.Mobile != "" {
+		mobile = inv.Mobile
+	}
+	if tokenHash != "" {
+		hash = tokenHash
+	}
+	return st.db.QueryRowContext(ctx, `INSERT INTO session_invites (session_id, mobile, token_hash, role, invited_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		inv.SessionID, mobile, hash, inv.Role, inv.InvitedBy, inv.CreatedAt, inv.ExpiresAt.UTC()).Scan(&inv.ID)
+}
+
+const inviteColumns = `i.id, i.session_id, COALESCE(s.name, ''), COALESCE(i.mobile, ''), i.role, i.invited_by,
+	COALESCE(u.mobile, ''), i.created_at, i.expires_at`
+
+const inviteFrom = ` FROM session_invites i JOIN sessions s ON s.id = i.session_id LEFT JOIN users u ON u.id = i.invited_by
+	WHERE i.expires_at > ?`
+
+func scanInvite(row scanner) (models.Invite, error) {
+	var inv models.Invite
+	err := row.Scan(&inv.ID, &inv.SessionID, &inv.SessionName, &inv.Mobile, &inv.Role, &inv.InvitedBy,
+		&inv.InviterMobile, &inv.CreatedAt, &inv.ExpiresAt)
+	return inv, err
+}
+
+func (st *members) invites(ctx context.Context, cond string, arg interface{}) ([]models.Invite, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT "+inviteColumns+inviteFrom+" AND "+cond+" ORDER BY i.id DESC",
+		time.Now().UTC(), arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Invite
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+func (st *members) Invites(ctx context.Context, sessionID string) ([]models.Invite, error) {
+	return st.invites(ctx, "i.session_id = ?", sessionID)
+}
+
+func (st *members) InvitesFor(ctx context.Context, mobile string) ([]models.Invite, error) {
+	return st.invites(ctx, "i.mobile = ?", mobile)
+}
+
+func (st *members) GetInvite(ctx context.Context, id int64) (models.Invite, error) {
+	inv, err := scanInvite(st.db.QueryRowContext(ctx, "SELECT "+inviteColumns+inviteFrom+" AND i.id = ?", time.Now().UTC(), id))
+	return inv, notFound(err)
+}
+
+func (st *members) GetInviteByToken(ctx context.Context, tokenHash string) (models.Invite, error) {
+	inv, err := scanInvite(st.db.QueryRowContext(ctx, "SELECT "+inviteColumns+inviteFrom+" AND i.token_hash = ?", time.Now().UTC(), tokenHash))
+	return inv, notFound(err)
+}
+
+func (st *members) Accept(ctx context.Context, inviteID, userID int64) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var sessionID string
+	var role models.MemberRole
+	var invitedBy sql.NullInt64
+	if err := tx.QueryRowContext(ctx, "SELECT session_id, role, invited_by FROM session_invites WHERE id = ? AND expires_at > ?",
+		inviteID, time.Now().UTC()).Scan(&sessionID, &role, &invitedBy); err != nil {
+		return notFound(err)
+	}
+	// Deleted first, so two users racing for one link cannot both get in
+	if err := affected(tx.ExecContext(ctx, "DELETE FROM session_invites WHERE id = ?", inviteID)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO session_members (session_id, user_id, role, invited_by, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (session_id, user_id) DO UPDATE SET role = excluded.role`,
+		sessionID, userID, role, invitedBy, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (st *members) DeleteInvite(ctx context.Context, id int64) error {
+	return affected(st.db.ExecContext(ctx, "DELETE FROM session_invites WHERE id = ?", id))
+}
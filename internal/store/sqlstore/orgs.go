@@ -0,0 +1,189 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+type orgs struct {
+	db   *database.Handle
+	role *database.Stmt // checks the access to every room of an organization
+}
+
+const orgColumns = "g.id, g.name, g.max_rooms, g.max_members, g.max_storage_bytes, g.invite_links, g.guardian_recordings, g.created_at"
+
+// orgUsage are the columns of an organization's usage, for rows of
+// organizations g.
+const orgUsage = `(SELECT COUNT(*) FROM sessions s WHERE s.org_id = g.id),
+	(SELECT COUNT(*) FROM org_members m WHERE m.org_id = g.id),
+	(SELECT COALESCE(SUM(r.bytes), 0) FROM recordings r JOIN sessions s ON s.id = r.session_id WHERE s.org_id = g.id)`
+
+func scanOrg(row scanner, extra ...interface{}) (models.Organization, error) {
+	var o models.Organization
+	err := row.Scan(append([]interface{}{&o.ID, &o.Name, &o.Quotas.MaxRooms, &o.Quotas.MaxMembers, &o.Quotas.MaxStorageBytes,
+		&o.Settings.InviteLinks, &o.Settings.GuardianRecordings, &o.CreatedAt}, extra...)...)
+	return o, err
+}
+
+// flag is the INTEGER 0 or 1 flags are stored as on both databases.
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (st *orgs) Create(ctx context.Context, o *models.Organization) error {
+	o.CreatedAt = time.Now().UTC()
+	return st.db.QueryRowContext(ctx, `INSERT INTO organizations (name, max_rooms, max_members, max_storage_bytes, invite_links, guardian_recordings, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		o.Name, o.Quotas.MaxRooms, o.Quotas.MaxMembers, o.Quotas.MaxStorageBytes,
+		flag(o.Settings.InviteLinks), flag(o.Settings.GuardianRecordings), o.CreatedAt).Scan(&o.ID)
+}
+
+func (st *orgs) Get(ctx context.Context, id int64) (models.Organization, error) {
+	o, err := scanOrg(st.db.QueryRowContext(ctx, "SELECT "+orgColumns+" FROM organizations g WHERE g.id = ?", id))
+	return o, notFound(err)
+}
+
+func (st *orgs) List(ctx context.Context) ([]store.OrgSummary, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT "+orgColumns+", "+orgUsage+" FROM organizations g ORDER BY g.name, g.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []store.OrgSummary
+	for rows.Next() {
+		var sum store.OrgSummary
+		if sum.Organization, err = scanOrg(rows, &sum.Usage.Rooms, &sum.Usage.Members, &sum.Usage.StorageBytes); err != nil {
+			return nil, err
+		}
+		list = append(list, sum)
+	}
+	return list, rows.Err()
+}
+
+func (st *orgs) ListByUser(ctx context.Context, userID int64) ([]store.UserOrg, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT "+orgColumns+`, m.role
+		FROM organizations g JOIN org_members m ON m.org_id = g.id WHERE m.user_id = ? ORDER BY g.name, g.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []store.UserOrg
+	for rows.Next() {
+		var uo store.UserOrg
+		if uo.Organization, err = scanOrg(rows, &uo.Role); err != nil {
+			return nil, err
+		}
+		list = append(list, uo)
+	}
+	return list, rows.Err()
+}
+
+func (st *orgs) Usage(ctx context.Context, id int64) (models.OrgUsage, error) {
+	var u models.OrgUsage
+	err := st.db.QueryRowContext(ctx, "SELECT "+orgUsage+" FROM organizations g WHERE g.id = ?", id).
+		Scan(&u.Rooms, &u.Members, &u.StorageBytes)
+	return u, notFound(err)
+}
+
+func (st *orgs) Update(ctx context.Context, o models.Organization) error {
+	return affected(st.db.ExecContext(ctx, "UPDATE organizations SET name = ?, invite_links = ?, guardian_recordings = ? WHERE id = ?",
+		o.Name, flag(o.Settings.InviteLinks), flag(o.Settings.GuardianRecordings), o.ID))
+}
+
+func (st *orgs) SetQuotas(ctx context.Context, id int64, q models.OrgQuotas) error {
+	return affected(st.db.ExecContext(ctx, "UPDATE organizations SET max_rooms = ?, max_members = ?, max_storage_bytes = ? WHERE id = ?",
+		q.MaxRooms, q.MaxMembers, q.MaxStorageBytes, id))
+}
+
+func (st *orgs) Rooms(ctx context.Context, id int64) ([]models.Session, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT "+sessionColumns+" FROM sessions s WHERE s.org_id = ? ORDER BY s.created_at DESC, s.id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (st *orgs) Delete(ctx context.Context, id int64) error {
+	return affected(st.db.ExecContext(ctx, "DELETE FROM organizations WHERE id = ?", id))
+}
+
+func (st *orgs) Role(ctx context.Context, orgID, userID int64) (models.OrgRole, error) {
+	var role models.OrgRole
+	err := st.role.QueryRowContext(ctx, orgID, userID).Scan(&role)
+	return role, notFound(err)
+}
+
+func (st *orgs) Members(ctx context.Context, orgID int64) ([]models.OrgMember, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT m.org_id, m.user_id, u.mobile, m.role, m.created_at
+		FROM org_members m JOIN users u ON u.id = m.user_id WHERE m.org_id = ?
+		ORDER BY CASE m.role WHEN 'admin' THEN 0 WHEN 'staff' THEN 1 ELSE 2 END, m.created_at, m.user_id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.OrgMember
+	for rows.Next() {
+		var m models.OrgMember
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Mobile, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (st *orgs) SetMember(ctx context.Context, m *models.OrgMember) error {
+	return st.db.QueryRowContext(ctx, `INSERT INTO org_members (org_id, user_id, role, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET role = excluded.role RETURNING created_at`,
+		m.OrgID, m.UserID, m.Role, time.Now().UTC()).Scan(&m.CreatedAt)
+}
+
+func (st *orgs) RemoveMember(ctx context.Context, orgID, userID int64) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := affected(tx.ExecContext(ctx, "DELETE FROM org_members WHERE org_id = ? AND user_id = ?", orgID, userID)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM session_members WHERE user_id = ? AND session_id IN (SELECT id FROM sessions WHERE org_id = ?)",
+		userID, orgID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (st *orgs) HandOver(ctx context.Context, userID, orgID int64) error {
+	const otherAdmin = `FROM org_members a WHERE a.org_id = sessions.org_id AND a.role = 'admin' AND a.user_id <> ?`
+	query := `UPDATE sessions SET user_id = (SELECT a.user_id ` + otherAdmin + ` ORDER BY a.created_at, a.user_id LIMIT 1)
+		WHERE user_id = ? AND org_id IS NOT NULL AND EXISTS (SELECT 1 ` + otherAdmin + `)`
+	args := []interface{}{userID, userID, userID}
+	if orgID != 0 {
+		query += " AND org_id = ?"
+		args = append(args, orgID)
+	}
+	_, err := st.db.ExecContext(ctx, query, args...)
+	return err
+}
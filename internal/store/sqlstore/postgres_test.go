@@ -0,0 +1,66 @@
+package sqlstore_test
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// TestPostgres runs against the database of TEST_POSTGRES_URL, or of
+// DATABASE_URL, in a schema of its own for every subtest, which is dropped
+// afterwards.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		dsn = os.Getenv("DATABASE_URL")
+	}
+	if dsn == "" {
+		t.Skip("set TEST_POSTGRES_URL or DATABASE_URL to test on PostgreSQL")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	testStore(t, func(t *testing.T) *store.Store {
+		b := make([]byte, 8)
+		rand.Read(b)
+		schema := "a2web_test_" + hex.EncodeToString(b)
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Error(err)
+			}
+		})
+		if err := database.Open(database.Postgres, withSearchPath(dsn, schema)); err != nil {
+			t.Fatal(err)
+		}
+		return migrated(t)
+	})
+}
+
+// withSearchPath makes every connection of dsn, a URL or a list of
+// key=value pairs, use schema. lib/pq sends unknown parameters to the
+// server as run-time settings.
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
@@ -0,0 +1,101 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+type recordings struct {
+	db             *database.Handle
+	create, finish *database.Stmt // run for every source connection
+}
+
+const recordingColumns = "r.id, r.session_id, r.path, r.status, r.bytes, r.started_at, r.ended_at"
+
+func scanRecording(row scanner) (models.Recording, error) {
+	var rec models.Recording
+	var endedAt sql.NullTime
+	err := row.Scan(&rec.ID, &rec.SessionID, &rec.Path, &rec.Status, &rec.Bytes, &rec.StartedAt, &endedAt)
+	if endedAt.Valid {
+		rec.EndedAt = &endedAt.Time
+	}
+	return rec, err
+}
+
+func (s *recordings) Create(ctx context.Context, rec *models.Recording) error {
+	return s.create.QueryRowContext(ctx, rec.SessionID, rec.Status, rec.StartedAt).Scan(&rec.ID)
+}
+
+func (s *recordings) SetPath(ctx context.Context, id int64, path string) error {
+	return affected(s.db.ExecContext(ctx, "UPDATE recordings SET path = ? WHERE id = ?", path, id))
+}
+
+func (s *recordings) Finish(ctx context.Context, id, bytes int64, endedAt time.Time) error {
+	return affected(s.finish.ExecContext(ctx, bytes, endedAt, id))
+}
+
+func (s *recordings) SetBytes(ctx context.Context, id, bytes int64) error {
+	return affected(s.db.ExecContext(ctx, "UPDATE recordings SET bytes = ? WHERE id = ?", bytes, id))
+}
+
+func (s *recordings) Get(ctx context.Context, id int64) (models.Recording, error) {
+	rec, err := scanRecording(s.db.QueryRowContext(ctx, "SELECT "+recordingColumns+" FROM recordings r WHERE r.id = ?", id))
+	return rec, notFound(err)
+}
+
+func (s *recordings) ListBySession(ctx context.Context, sessionID string) ([]models.Recording, error) {
+	return s.list(ctx, "SELECT "+recordingColumns+" FROM recordings r WHERE r.session_id = ? ORDER BY r.started_at DESC", sessionID)
+}
+
+func (s *recordings) ListByUser(ctx context.Context, userID int64, sessionID string, limit, offset int) ([]models.Recording, int, error) {
+	where := `(s.user_id = ? OR s.id IN (SELECT session_id FROM session_members WHERE user_id = ? AND role = ?)
+		OR s.org_id IN (SELECT org_id FROM org_members WHERE user_id = ? AND role IN ('admin', 'staff')))`
+	args := []interface{}{userID, userID, models.MemberViewer, userID}
+	if sessionID != "" {
+		where += " AND r.session_id = ?"
+		args = append(args, sessionID)
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM recordings r JOIN sessions s ON s.id = r.session_id WHERE "+where,
+		args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	list, err := s.list(ctx, "SELECT "+recordingColumns+` FROM recordings r JOIN sessions s ON s.id = r.session_id
+		WHERE `+where+" ORDER BY r.started_at DESC, r.id DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	return list, total, err
+}
+
+func (s *recordings) ListOpen(ctx context.Context) ([]models.Recording, error) {
+	return s.list(ctx, "SELECT "+recordingColumns+" FROM recordings r WHERE r.status = 'recording' ORDER BY r.id")
+}
+
+func (s *recordings) All(ctx context.Context) ([]models.Recording, error) {
+	return s.list(ctx, "SELECT "+recordingColumns+" FROM recordings r ORDER BY r.id")
+}
+
+func (s *recordings) list(ctx context.Context, query string, args ...interface{}) ([]models.Recording, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Recording
+	for rows.Next() {
+		rec, err := scanRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rec)
+	}
+	return list, rows.Err()
+}
+
+func (s *recordings) Delete(ctx context.Context, id int64) error {
+	return affected(s.db.ExecContext(ctx, "DELETE FROM recordings WHERE id = ?", id))
+}
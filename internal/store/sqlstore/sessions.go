@@ -0,0 +1,168 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+type sessions struct {
+	db          *database.Handle
+	get         *database.Stmt // checks ownership on every page and stream
+	byBroadcast *database.Stmt // on every kid page and stream
+}
+
+const sessionColumns = `s.id, s.user_id, COALESCE(s.name, ''), s.description, COALESCE(s.status, 'active'), COALESCE(s.org_id, 0),
+	COALESCE(s.broadcast_id, s.id), s.recording, s.audio_bitrate, s.echo_cancellation, s.noise_suppression, s.auto_gain_control, s.created_at`
+
+func scanSession(row scanner, extra ...interface{}) (models.Session, error) {
+	var s models.Session
+	err := row.Scan(append([]interface{}{&s.ID, &s.UserID, &s.Name, &s.Description, &s.Status, &s.OrgID,
+		&s.BroadcastID, &s.Settings.Recording, &s.Settings.AudioBitrate, &s.Settings.EchoCancellation,
+		&s.Settings.NoiseSuppression, &s.Settings.AutoGainControl, &s.CreatedAt}, extra...)...)
+	return s, err
+}
+
+func (st *sessions) Create(ctx context.Context, s *models.Session) error {
+	s.Status, s.CreatedAt = models.SessionActive, time.Now()
+	if s.BroadcastID == "" {
+		s.BroadcastID = s.ID
+	}
+	var orgID interface{}
+	if s.OrgID != 0 {
+		orgID = s.OrgID
+	}
+	_, err := st.db.ExecContext(ctx, `INSERT INTO sessions (id, user_id, name, description, org_id, broadcast_id,
+		recording, audio_bitrate, echo_cancellation, noise_suppression, auto_gain_control) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.ID, s.UserID, s.Name, s.Description, orgID, s.BroadcastID, flag(s.Settings.Recording), s.Settings.AudioBitrate,
+		flag(s.Settings.EchoCancellation), flag(s.Settings.NoiseSuppression), flag(s.Settings.AutoGainControl))
+	return conflict(err)
+}
+
+func (st *sessions) Get(ctx context.Context, id string) (models.Session, error) {
+	s, err := scanSession(st.get.QueryRowContext(ctx, id))
+	return s, notFound(err)
+}
+
+func (st *sessions) GetByBroadcastID(ctx context.Context, broadcastID string) (models.Session, error) {
+	s, err := scanSession(st.byBroadcast.QueryRowContext(ctx, broadcastID))
+	return s, notFound(err)
+}
+
+func (st *sessions) Update(ctx context.Context, s models.Session) error {
+	return affected(st.db.ExecContext(ctx, `UPDATE sessions SET name = ?, description = ?, status = ?, recording = ?,
+		audio_bitrate = ?, echo_cancellation = ?, noise_suppression = ?, auto_gain_control = ? WHERE id = ?`,
+		s.Name, s.Description, s.Status, flag(s.Settings.Recording), s.Settings.AudioBitrate,
+		flag(s.Settings.EchoCancellation), flag(s.Settings.NoiseSuppression), flag(s.Settings.AutoGainControl), s.ID))
+}
+
+func (st *sessions) SetBroadcastID(ctx context.Context, id, broadcastID string) error {
+	return affected(st.db.ExecContext(ctx, "UPDATE sessions SET broadcast_id = ? WHERE id = ?", broadcastID, id))
+}
+
+func (st *sessions) ListByUser(ctx context.Context, userID int64, limit, offset int) ([]models.Session, int, error) {
+	var total int
+	if err := st.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sessions WHERE user_id = ?", userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := "SELECT " + sessionColumns + " FROM sessions s WHERE s.user_id = ? ORDER BY s.created_at DESC, s.id"
+	args := []interface{}{userID}
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+	list, err := st.list(ctx, query, args...)
+	return list, total, err
+}
+
+func (st *sessions) All(ctx context.Context) ([]models.Session, error) {
+	return st.list(ctx, "SELECT "+sessionColumns+" FROM sessions s ORDER BY s.created_at, s.id")
+}
+
+func (st *sessions) list(ctx context.Context, query string, args ...interface{}) ([]models.Session, error) {
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (st *sessions) Summaries(ctx context.Context, userID int64) ([]store.SessionSummary, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT `+sessionColumns+`, COALESCE(u.mobile, ''), COUNT(r.id), COALESCE(SUM(r.bytes), 0)
+		FROM sessions s LEFT JOIN users u ON u.id = s.user_id LEFT JOIN recordings r ON r.session_id = s.id
+		WHERE s.user_id = ? GROUP BY s.id, u.mobile ORDER BY s.created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []store.SessionSummary
+	for rows.Next() {
+		var sum store.SessionSummary
+		if sum.Session, err = scanSession(rows, &sum.OwnerMobile, &sum.Recordings, &sum.StorageBytes); err != nil {
+			return nil, err
+		}
+		list = append(list, sum)
+	}
+	return list, rows.Err()
+}
+
+func (st *sessions) sorts() map[string]listSort {
+	return map[string]listSort{
+		"newest": {timeKey(st.db.Dialect, "s.created_at"), true},
+		"oldest": {timeKey(st.db.Dialect, "s.created_at"), false},
+		"name":   {"COALESCE(s.name, '')", false},
+	}
+}
+
+func (st *sessions) Search(ctx context.Context, q store.ListQuery) ([]store.SessionSummary, string, error) {
+	sort, ok := st.sorts()[q.Sort]
+	if !ok {
+		sort = st.sorts()[store.SessionSorts[0]]
+	}
+	conds, args := listQuery(q, sort, "s.name", "s.id", func(id string) interface{} { return id })
+	query := "SELECT " + sessionColumns + ", COALESCE(u.mobile, ''), " + sort.key +
+		" FROM sessions s LEFT JOIN users u ON u.id = s.user_id" +
+		where(conds) + " ORDER BY " + sort.orderBy("s.id") + " LIMIT ?"
+	args = append(args, q.Limit+1)
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var list []store.SessionSummary
+	var next, lastKey string
+	for rows.Next() {
+		var sum store.SessionSummary
+		var key string
+		if sum.Session, err = scanSession(rows, &sum.OwnerMobile, &key); err != nil {
+			return nil, "", err
+		}
+		if len(list) == q.Limit {
+			next = cursor{Key: lastKey, ID: list[len(list)-1].ID}.encode()
+			break
+		}
+		list = append(list, sum)
+		lastKey = key
+	}
+	return list, next, rows.Err()
+}
+
+func (st *sessions) Delete(ctx context.Context, id string) error {
+	return affected(st.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id))
+}
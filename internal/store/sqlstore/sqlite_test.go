@@ -0,0 +1,18 @@
+package sqlstore_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+func TestSQLite(t *testing.T) {
+	testStore(t, func(t *testing.T) *store.Store {
+		if err := database.Open(database.SQLite, filepath.Join(t.TempDir(), "a2web.db")); err != nil {
+			t.Fatal(err)
+		}
+		return migrated(t)
+	})
+}
@@ -0,0 +1,173 @@
+// Package sqlstore implements the store interfaces on SQLite and
+// PostgreSQL. The queries are shared: database.Handle rewrites their
+// placeholders, and the few expressions the two dialects spell differently
+// are chosen per dialect here. Each dialect has its own schema migrations
+// in the database package.
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+// New returns the stores of db. The queries run on every request and for
+// every recording are prepared on first use.
+func New(db *database.Handle) *store.Store {
+	return &store.Store{
+		Users: &users{db: db,
+			get: db.Stmt("SELECT " + userColumns + " FROM users u WHERE u.id = ?"),
+		},
+		Sessions: &sessions{db: db,
+			get:         db.Stmt("SELECT " + sessionColumns + " FROM sessions s WHERE s.id = ?"),
+			byBroadcast: db.Stmt("SELECT " + sessionColumns + " FROM sessions s WHERE s.broadcast_id = ?"),
+		},
+		Members: &members{db: db,
+			role: db.Stmt("SELECT role FROM session_members WHERE session_id = ? AND user_id = ?"),
+		},
+		Orgs: &orgs{db: db,
+			role: db.Stmt("SELECT role FROM org_members WHERE org_id = ? AND user_id = ?"),
+		},
+		Recordings: &recordings{db: db,
+			create: db.Stmt("INSERT INTO recordings (session_id, path, status, started_at) VALUES (?, '', ?, ?) RETURNING id"),
+			finish: db.Stmt("UPDATE recordings SET status = 'finalized', bytes = ?, ended_at = ? WHERE id = ?"),
+		},
+		Devices: &devices{db},
+		Audit:   &auditLog{db},
+	}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// notFound turns the "no rows" error of a single-row query into
+// store.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// affected turns an UPDATE or DELETE that matched no row into
+// store.ErrNotFound.
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// conflict turns a unique constraint violation into store.ErrConflict.
+func conflict(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return store.ErrConflict
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return store.ErrConflict
+	}
+	return err
+}
+
+// timeKey is an expression sorting like the timestamp column, as text so
+// it can be a cursor key.
+func timeKey(d database.Dialect, column string) string {
+	if d == database.Postgres {
+		return "to_char(" + column + " AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS.US')"
+	}
+	return "CAST(" + column + " AS TEXT)"
+}
+
+// numberKey is an expression sorting like the non-negative integer column,
+// as zero-padded text so it can be a cursor key.
+func numberKey(d database.Dialect, column string) string {
+	if d == database.Postgres {
+		return "lpad(CAST(" + column + " AS TEXT), 20, '0')"
+	}
+	return "printf('%020d', " + column + ")"
+}
+
+// listSort is an order of an admin listing: the expression rows are sorted
+// by, which is also the cursor key, and its direction. The primary key
+// breaks ties.
+type listSort struct {
+	key  string
+	desc bool
+}
+
+func (s listSort) orderBy(idColumn string) string {
+	if s.desc {
+		return s.key + " DESC, " + idColumn + " DESC"
+	}
+	return s.key + " ASC, " + idColumn + " ASC"
+}
+
+// cursor is the position after the last row of a page.
+type cursor struct {
+	Key string `json:"k"`
+	ID  string `json:"i"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, bool) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return c, false
+	}
+	return c, true
+}
+
+// listQuery builds the conditions of an admin listing: the search on
+// searchColumn and the keyset condition for the cursor. id converts the
+// cursor's ID to the primary key's type.
+func listQuery(q store.ListQuery, sort listSort, searchColumn, idColumn string, id func(string) interface{}) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if q.Search != "" {
+		conds = append(conds, searchColumn+` LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(q.Search))
+	}
+	if c, ok := decodeCursor(q.Cursor); ok {
+		op := ">"
+		if sort.desc {
+			op = "<"
+		}
+		conds = append(conds, "("+sort.key+", "+idColumn+") "+op+" (?, ?)")
+		args = append(args, c.Key, id(c.ID))
+	}
+	return conds, args
+}
+
+// likePattern matches q anywhere, treating its characters literally.
+func likePattern(q string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return "%" + r.Replace(q) + "%"
+}
+
+func where(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
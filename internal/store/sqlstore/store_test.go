@@ -0,0 +1,766 @@
+package sqlstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+	"github.com/zamibd/a2web/internal/store/sqlstore"
+)
+
+// migrated returns the stores of the database just opened with
+// database.Open, after bringing its schema up to date. The database is
+// closed when the test ends.
+func migrated(t *testing.T) *store.Store {
+	t.Helper()
+	db := database.DB
+	t.Cleanup(func() { db.Close() })
+	if _, err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return sqlstore.New(db)
+}
+
+// testStore checks the contracts of the store interfaces against the
+// stores open returns, which must be of an empty database of their own for
+// every call. The subtests run one after the other, since database.Migrate
+// works on the one database.DB.
+func testStore(t *testing.T, open func(t *testing.T) *store.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st *store.Store)
+	}{
+		{"Users", testUsers},
+		{"Sessions", testSessions},
+		{"Members", testMembers},
+		{"Invites", testInvites},
+		{"Orgs", testOrgs},
+		{"Recordings", testRecordings},
+		{"Devices", testDevices},
+		{"Audit", testAudit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { tt.fn(t, open(t)) })
+	}
+}
+
+func newUser(t *testing.T, st *store.Store, mobile string) models.User {
+	t.Helper()
+	u := models.User{Mobile: mobile, PasswordHash: "hash"}
+	if err := st.Users.Create(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newSession(t *testing.T, st *store.Store, id string, userID, orgID int64) models.Session {
+	t.Helper()
+	s := models.Session{ID: id, UserID: userID, OrgID: orgID, Name: "Room " + id, Settings: models.DefaultSessionSettings}
+	if err := st.Sessions.Create(context.Background(), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newRecording(t *testing.T, st *store.Store, sessionID string, startedAt time.Time, bytes int64) models.Recording {
+	t.Helper()
+	ctx := context.Background()
+	rec := models.Recording{SessionID: sessionID, Status: "recording", StartedAt: startedAt.UTC()}
+	if err := st.Recordings.Create(ctx, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if bytes > 0 {
+		if err := st.Recordings.Finish(ctx, rec.ID, bytes, startedAt.Add(time.Minute).UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec
+}
+
+func wantErr(t *testing.T, what string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: %v, want %v", what, err, want)
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUsers(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	a := newUser(t, st, "01700000001")
+	b := newUser(t, st, "01700000002")
+	if a.ID == 0 || a.Role != models.RoleUser {
+		t.Fatalf("Create: %+v", a)
+	}
+	wantErr(t, "Create with a taken mobile", st.Users.Create(ctx, &models.User{Mobile: a.Mobile}), store.ErrConflict)
+
+	got, err := st.Users.Get(ctx, a.ID)
+	check(t, err)
+	if got.Mobile != a.Mobile || got.Role != models.RoleUser || got.Disabled {
+		t.Fatalf("Get: %+v", got)
+	}
+	got, err = st.Users.GetByMobile(ctx, b.Mobile)
+	check(t, err)
+	if got.ID != b.ID {
+		t.Fatalf("GetByMobile: user %d, want %d", got.ID, b.ID)
+	}
+	_, err = st.Users.Get(ctx, 9999)
+	wantErr(t, "Get of an unknown user", err, store.ErrNotFound)
+	_, err = st.Users.GetByMobile(ctx, "01799999999")
+	wantErr(t, "GetByMobile of an unknown user", err, store.ErrNotFound)
+
+	list, total, err := st.Users.List(ctx, 1, 0)
+	check(t, err)
+	if total != 2 || len(list) != 1 || list[0].ID != b.ID {
+		t.Fatalf("List: %d users of %d, want the newest of 2", len(list), total)
+	}
+
+	check(t, st.Users.SetRole(ctx, a.ID, models.RoleAdmin))
+	wantErr(t, "SetRole of an unknown user", st.Users.SetRole(ctx, 9999, models.RoleAdmin), store.ErrNotFound)
+	check(t, st.Users.SetDisabled(ctx, a.ID, true))
+	got, err = st.Users.Get(ctx, a.ID)
+	check(t, err)
+	if got.Role != models.RoleAdmin || !got.Disabled || got.TokensValidAfter == nil {
+		t.Fatalf("after SetRole and SetDisabled: %+v", got)
+	}
+	check(t, st.Users.SetDisabled(ctx, a.ID, false))
+	check(t, st.Users.SetPassword(ctx, b.ID, "new hash"))
+	got, err = st.Users.Get(ctx, b.ID)
+	check(t, err)
+	if got.PasswordHash != "new hash" || got.TokensValidAfter == nil {
+		t.Fatalf("after SetPassword: %+v", got)
+	}
+	check(t, st.Users.SignOut(ctx, a.ID, time.Now()))
+	wantErr(t, "SignOut of an unknown user", st.Users.SignOut(ctx, 9999, time.Now()), store.ErrNotFound)
+
+	newSession(t, st, "s1", a.ID, 0)
+	newRecording(t, st, "s1", time.Now(), 100)
+	sum, err := st.Users.Summary(ctx, a.ID)
+	check(t, err)
+	if sum.Sessions != 1 || sum.StorageBytes != 100 {
+		t.Fatalf("Summary: %d sessions, %d bytes; want 1, 100", sum.Sessions, sum.StorageBytes)
+	}
+
+	found, next, err := st.Users.Search(ctx, store.ListQuery{Search: "0001", Limit: 10})
+	check(t, err)
+	if len(found) != 1 || found[0].ID != a.ID || next != "" {
+		t.Fatalf("Search by mobile: %d users, cursor %q", len(found), next)
+	}
+	first, next, err := st.Users.Search(ctx, store.ListQuery{Sort: "mobile", Limit: 1})
+	check(t, err)
+	if len(first) != 1 || first[0].ID != a.ID || next == "" {
+		t.Fatalf("Search, first page: %d users, cursor %q", len(first), next)
+	}
+	second, next, err := st.Users.Search(ctx, store.ListQuery{Sort: "mobile", Cursor: next, Limit: 1})
+	check(t, err)
+	if len(second) != 1 || second[0].ID != b.ID || next != "" {
+		t.Fatalf("Search, last page: %d users, cursor %q", len(second), next)
+	}
+
+	check(t, st.Users.Delete(ctx, a.ID))
+	_, err = st.Users.Get(ctx, a.ID)
+	wantErr(t, "Get of a deleted user", err, store.ErrNotFound)
+	_, err = st.Sessions.Get(ctx, "s1")
+	wantErr(t, "Get of a deleted user's session", err, store.ErrNotFound)
+	wantErr(t, "Delete of a deleted user", st.Users.Delete(ctx, a.ID), store.ErrNotFound)
+}
+
+func testSessions(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	u := newUser(t, st, "01700000001")
+	s := newSession(t, st, "s1", u.ID, 0)
+	if s.Status != models.SessionActive || s.BroadcastID != s.ID || s.CreatedAt.IsZero() {
+		t.Fatalf("Create: %+v", s)
+	}
+	wantErr(t, "Create with a taken ID", st.Sessions.Create(ctx, &models.Session{ID: "s1", UserID: u.ID}), store.ErrConflict)
+
+	got, err := st.Sessions.Get(ctx, s.ID)
+	check(t, err)
+	if got.Name != s.Name || got.UserID != u.ID || got.Settings != models.DefaultSessionSettings {
+		t.Fatalf("Get: %+v", got)
+	}
+	_, err = st.Sessions.Get(ctx, "none")
+	wantErr(t, "Get of an unknown session", err, store.ErrNotFound)
+
+	s.Name, s.Description, s.Status = "Nursery", "upstairs", models.SessionArchived
+	s.Settings = models.SessionSettings{AudioBitrate: 32000}
+	check(t, st.Sessions.Update(ctx, s))
+	got, err = st.Sessions.Get(ctx, s.ID)
+	check(t, err)
+	if got.Name != s.Name || got.Description != s.Description || !got.Archived() || got.Settings != s.Settings {
+		t.Fatalf("after Update: %+v", got)
+	}
+	wantErr(t, "Update of an unknown session", st.Sessions.Update(ctx, models.Session{ID: "none"}), store.ErrNotFound)
+
+	check(t, st.Sessions.SetBroadcastID(ctx, s.ID, "kid"))
+	got, err = st.Sessions.GetByBroadcastID(ctx, "kid")
+	check(t, err)
+	if got.ID != s.ID {
+		t.Fatalf("GetByBroadcastID: session %q, want %q", got.ID, s.ID)
+	}
+	_, err = st.Sessions.GetByBroadcastID(ctx, s.ID)
+	wantErr(t, "GetByBroadcastID of a replaced broadcast ID", err, store.ErrNotFound)
+
+	newSession(t, st, "s2", u.ID, 0)
+	newSession(t, st, "s3", u.ID, 0)
+	other := newUser(t, st, "01700000002")
+	newSession(t, st, "s4", other.ID, 0)
+	list, total, err := st.Sessions.ListByUser(ctx, u.ID, 2, 0)
+	check(t, err)
+	if total != 3 || len(list) != 2 {
+		t.Fatalf("ListByUser: %d sessions of %d, want 2 of 3", len(list), total)
+	}
+	list, _, err = st.Sessions.ListByUser(ctx, u.ID, 0, 0)
+	check(t, err)
+	if len(list) != 3 {
+		t.Fatalf("ListByUser without a limit: %d sessions, want 3", len(list))
+	}
+	all, err := st.Sessions.All(ctx)
+	check(t, err)
+	if len(all) != 4 {
+		t.Fatalf("All: %d sessions, want 4", len(all))
+	}
+
+	newRecording(t, st, "s1", time.Now(), 10)
+	newRecording(t, st, "s1", time.Now(), 20)
+	sums, err := st.Sessions.Summaries(ctx, u.ID)
+	check(t, err)
+	if len(sums) != 3 {
+		t.Fatalf("Summaries: %d sessions, want 3", len(sums))
+	}
+	for _, sum := range sums {
+		if sum.ID == "s1" && (sum.Recordings != 2 || sum.StorageBytes != 30 || sum.OwnerMobile != u.Mobile) {
+			t.Fatalf("Summaries of s1: %+v", sum)
+		}
+	}
+	found, next, err := st.Sessions.Search(ctx, store.ListQuery{Search: "Nursery", Limit: 10})
+	check(t, err)
+	if len(found) != 1 || found[0].ID != "s1" || next != "" {
+		t.Fatalf("Search by name: %d sessions, cursor %q", len(found), next)
+	}
+	var seen []string
+	q := store.ListQuery{Sort: "name", Limit: 3}
+	for {
+		page, next, err := st.Sessions.Search(ctx, q)
+		check(t, err)
+		for _, s := range page {
+			seen = append(seen, s.ID)
+		}
+		if next == "" {
+			break
+		}
+		q.Cursor = next
+	}
+	if fmt.Sprint(seen) != "[s1 s2 s3 s4]" {
+		t.Fatalf("Search by name, all pages: %v", seen)
+	}
+
+	check(t, st.Sessions.Delete(ctx, "s1"))
+	_, err = st.Sessions.Get(ctx, "s1")
+	wantErr(t, "Get of a deleted session", err, store.ErrNotFound)
+	recs, err := st.Recordings.ListBySession(ctx, "s1")
+	check(t, err)
+	if len(recs) != 0 {
+		t.Fatalf("recordings of a deleted session: %d, want 0", len(recs))
+	}
+	wantErr(t, "Delete of a deleted session", st.Sessions.Delete(ctx, "s1"), store.ErrNotFound)
+}
+
+func testMembers(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	owner := newUser(t, st, "01700000001")
+	listener := newUser(t, st, "01700000002")
+	viewer := newUser(t, st, "01700000003")
+	s := newSession(t, st, "s1", owner.ID, 0)
+	newSession(t, st, "s2", listener.ID, 0)
+
+	_, err := st.Members.Role(ctx, s.ID, listener.ID)
+	wantErr(t, "Role of a user without one", err, store.ErrNotFound)
+	for _, m := range []struct {
+		user models.User
+		role models.MemberRole
+	}{{listener, models.MemberListener}, {viewer, models.MemberViewer}} {
+		inv := models.Invite{SessionID: s.ID, Mobile: m.user.Mobile, Role: m.role, InvitedBy: owner.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		check(t, st.Members.CreateInvite(ctx, &inv, ""))
+		check(t, st.Members.Accept(ctx, inv.ID, m.user.ID))
+	}
+	role, err := st.Members.Role(ctx, s.ID, listener.ID)
+	check(t, err)
+	if role != models.MemberListener {
+		t.Fatalf("Role: %q, want %q", role, models.MemberListener)
+	}
+	_, err = st.Members.Role(ctx, s.ID, owner.ID)
+	wantErr(t, "Role of the owner", err, store.ErrNotFound)
+
+	members, err := st.Members.List(ctx, s.ID)
+	check(t, err)
+	if len(members) != 2 || members[0].UserID != listener.ID || members[0].Mobile != listener.Mobile || members[1].UserID != viewer.ID {
+		t.Fatalf("List: %+v", members)
+	}
+
+	sessions, total, err := st.Members.Sessions(ctx, listener.ID, 0, 0, 0)
+	check(t, err)
+	if total != 2 || len(sessions) != 2 {
+		t.Fatalf("Sessions: %d of %d, want 2 of 2", len(sessions), total)
+	}
+	for _, ms := range sessions {
+		want := map[string]models.MemberRole{"s1": models.MemberListener, "s2": models.MemberOwner}[ms.ID]
+		if ms.Role != want {
+			t.Fatalf("Sessions: role %q in %s, want %q", ms.Role, ms.ID, want)
+		}
+		if ms.ID == "s1" && ms.OwnerMobile != owner.Mobile {
+			t.Fatalf("Sessions: owner %q of s1, want %q", ms.OwnerMobile, owner.Mobile)
+		}
+	}
+	_, total, err = st.Members.Sessions(ctx, listener.ID, 0, 1, 0)
+	check(t, err)
+	if total != 2 {
+		t.Fatalf("Sessions with a limit: total %d, want 2", total)
+	}
+
+	check(t, st.Members.Remove(ctx, s.ID, listener.ID))
+	_, err = st.Members.Role(ctx, s.ID, listener.ID)
+	wantErr(t, "Role of a removed member", err, store.ErrNotFound)
+	wantErr(t, "Remove of a removed member", st.Members.Remove(ctx, s.ID, listener.ID), store.ErrNotFound)
+
+	check(t, st.Users.Delete(ctx, viewer.ID))
+	members, err = st.Members.List(ctx, s.ID)
+	check(t, err)
+	if len(members) != 0 {
+		t.Fatalf("List after the last member was deleted: %+v", members)
+	}
+}
+
+func testInvites(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	owner := newUser(t, st, "01700000001")
+	guest := newUser(t, st, "01700000002")
+	s := newSession(t, st, "s1", owner.ID, 0)
+	later := time.Now().Add(time.Hour)
+
+	byMobile := models.Invite{SessionID: s.ID, Mobile: guest.Mobile, Role: models.MemberViewer, InvitedBy: owner.ID, ExpiresAt: later}
+	check(t, st.Members.CreateInvite(ctx, &byMobile, ""))
+	byLink := models.Invite{SessionID: s.ID, Role: models.MemberListener, InvitedBy: owner.ID, ExpiresAt: later}
+	check(t, st.Members.CreateInvite(ctx, &byLink, "token hash"))
+	expired := models.Invite{SessionID: s.ID, Mobile: guest.Mobile, Role: models.MemberListener, InvitedBy: owner.ID,
+		ExpiresAt: time.Now().Add(-time.Minute)}
+	check(t, st.Members.CreateInvite(ctx, &expired, ""))
+	if byMobile.ID == 0 || byMobile.CreatedAt.IsZero() {
+		t.Fatalf("CreateInvite: %+v", byMobile)
+	}
+
+	invites, err := st.Members.Invites(ctx, s.ID)
+	check(t, err)
+	if len(invites) != 2 || invites[0].ID != byLink.ID || invites[1].ID != byMobile.ID {
+		t.Fatalf("Invites: %+v, want the link invite, then the mobile one", invites)
+	}
+	invites, err = st.Members.InvitesFor(ctx, guest.Mobile)
+	check(t, err)
+	if len(invites) != 1 || invites[0].ID != byMobile.ID || invites[0].SessionName != s.Name || invites[0].InviterMobile != owner.Mobile {
+		t.Fatalf("InvitesFor: %+v", invites)
+	}
+	got, err := st.Members.GetInviteByToken(ctx, "token hash")
+	check(t, err)
+	if got.ID != byLink.ID || got.Role != models.MemberListener {
+		t.Fatalf("GetInviteByToken: %+v", got)
+	}
+	_, err = st.Members.GetInvite(ctx, expired.ID)
+	wantErr(t, "GetInvite of an expired invite", err, store.ErrNotFound)
+	wantErr(t, "Accept of an expired invite", st.Members.Accept(ctx, expired.ID, guest.ID), store.ErrNotFound)
+
+	// A second invite replaces the role of the first
+	check(t, st.Members.Accept(ctx, byMobile.ID, guest.ID))
+	check(t, st.Members.Accept(ctx, byLink.ID, guest.ID))
+	role, err := st.Members.Role(ctx, s.ID, guest.ID)
+	check(t, err)
+	if role != models.MemberListener {
+		t.Fatalf("Role after two invites: %q, want %q", role, models.MemberListener)
+	}
+	_, err = st.Members.GetInvite(ctx, byLink.ID)
+	wantErr(t, "GetInvite of an accepted invite", err, store.ErrNotFound)
+	wantErr(t, "Accept of an accepted invite", st.Members.Accept(ctx, byLink.ID, owner.ID), store.ErrNotFound)
+
+	check(t, st.Members.DeleteInvite(ctx, expired.ID))
+	wantErr(t, "DeleteInvite of a deleted invite", st.Members.DeleteInvite(ctx, expired.ID), store.ErrNotFound)
+}
+
+func testOrgs(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	admin := newUser(t, st, "01700000001")
+	second := newUser(t, st, "01700000002")
+	staff := newUser(t, st, "01700000003")
+	guardian := newUser(t, st, "01700000004")
+
+	o := models.Organization{Name: "Sunflower", Quotas: models.OrgQuotas{MaxRooms: 5}, Settings: models.OrgSettings{InviteLinks: true}}
+	check(t, st.Orgs.Create(ctx, &o))
+	if o.ID == 0 || o.CreatedAt.IsZero() {
+		t.Fatalf("Create: %+v", o)
+	}
+	other := models.Organization{Name: "Acorn"}
+	check(t, st.Orgs.Create(ctx, &other))
+
+	got, err := st.Orgs.Get(ctx, o.ID)
+	check(t, err)
+	if got.Name != o.Name || got.Quotas != o.Quotas || got.Settings != o.Settings {
+		t.Fatalf("Get: %+v", got)
+	}
+	_, err = st.Orgs.Get(ctx, 9999)
+	wantErr(t, "Get of an unknown organization", err, store.ErrNotFound)
+
+	o.Name, o.Settings = "Sunflowers", models.OrgSettings{GuardianRecordings: true}
+	check(t, st.Orgs.Update(ctx, o))
+	q := models.OrgQuotas{MaxRooms: 1, MaxMembers: 2, MaxStorageBytes: 3}
+	check(t, st.Orgs.SetQuotas(ctx, o.ID, q))
+	got, err = st.Orgs.Get(ctx, o.ID)
+	check(t, err)
+	if got.Name != o.Name || got.Settings != o.Settings || got.Quotas != q {
+		t.Fatalf("after Update and SetQuotas: %+v", got)
+	}
+	wantErr(t, "Update of an unknown organization", st.Orgs.Update(ctx, models.Organization{ID: 9999}), store.ErrNotFound)
+	wantErr(t, "SetQuotas of an unknown organization", st.Orgs.SetQuotas(ctx, 9999, q), store.ErrNotFound)
+
+	for _, m := range []models.OrgMember{
+		{OrgID: o.ID, UserID: guardian.ID, Role: models.OrgGuardian},
+		{OrgID: o.ID, UserID: staff.ID, Role: models.OrgStaff},
+		{OrgID: o.ID, UserID: admin.ID, Role: models.OrgAdmin},
+		{OrgID: o.ID, UserID: second.ID, Role: models.OrgStaff},
+		{OrgID: other.ID, UserID: admin.ID, Role: models.OrgGuardian},
+	} {
+		check(t, st.Orgs.SetMember(ctx, &m))
+		if m.CreatedAt.IsZero() {
+			t.Fatalf("SetMember: %+v", m)
+		}
+	}
+	joined, err := st.Orgs.Members(ctx, o.ID)
+	check(t, err)
+	promoted := models.OrgMember{OrgID: o.ID, UserID: second.ID, Role: models.OrgAdmin}
+	check(t, st.Orgs.SetMember(ctx, &promoted))
+	for _, m := range joined {
+		if m.UserID == second.ID && !m.CreatedAt.Equal(promoted.CreatedAt) {
+			t.Fatalf("SetMember of a member: joined at %v, want %v", promoted.CreatedAt, m.CreatedAt)
+		}
+	}
+	role, err := st.Orgs.Role(ctx, o.ID, second.ID)
+	check(t, err)
+	if role != models.OrgAdmin {
+		t.Fatalf("Role: %q, want %q", role, models.OrgAdmin)
+	}
+	_, err = st.Orgs.Role(ctx, other.ID, staff.ID)
+	wantErr(t, "Role of a user who is not a member", err, store.ErrNotFound)
+
+	members, err := st.Orgs.Members(ctx, o.ID)
+	check(t, err)
+	if len(members) != 4 || members[0].UserID != admin.ID || members[1].UserID != second.ID ||
+		members[2].UserID != staff.ID || members[3].UserID != guardian.ID {
+		t.Fatalf("Members: %+v, want the admins first", members)
+	}
+	orgs, err := st.Orgs.ListByUser(ctx, admin.ID)
+	check(t, err)
+	if len(orgs) != 2 || orgs[0].ID != other.ID || orgs[0].Role != models.OrgGuardian || orgs[1].Role != models.OrgAdmin {
+		t.Fatalf("ListByUser: %+v, want both by name", orgs)
+	}
+
+	room := newSession(t, st, "r1", admin.ID, o.ID)
+	newSession(t, st, "r2", admin.ID, o.ID)
+	newSession(t, st, "p1", admin.ID, 0)
+	newRecording(t, st, room.ID, time.Now(), 50)
+	usage, err := st.Orgs.Usage(ctx, o.ID)
+	check(t, err)
+	if usage != (models.OrgUsage{Rooms: 2, Members: 4, StorageBytes: 50}) {
+		t.Fatalf("Usage: %+v", usage)
+	}
+	_, err = st.Orgs.Usage(ctx, 9999)
+	wantErr(t, "Usage of an unknown organization", err, store.ErrNotFound)
+	list, err := st.Orgs.List(ctx)
+	check(t, err)
+	if len(list) != 2 || list[0].ID != other.ID || list[1].Usage != usage {
+		t.Fatalf("List: %+v", list)
+	}
+	rooms, err := st.Orgs.Rooms(ctx, o.ID)
+	check(t, err)
+	if len(rooms) != 2 {
+		t.Fatalf("Rooms: %d, want 2", len(rooms))
+	}
+
+	// Staff and admins have a role in every room, and see its recordings
+	sessions, _, err := st.Members.Sessions(ctx, staff.ID, o.ID, 0, 0)
+	check(t, err)
+	if len(sessions) != 2 || sessions[0].Role != models.MemberStaff || sessions[0].OrgName != o.Name {
+		t.Fatalf("Members.Sessions of staff: %+v", sessions)
+	}
+	recs, total, err := st.Recordings.ListByUser(ctx, second.ID, "", 10, 0)
+	check(t, err)
+	if total != 1 || len(recs) != 1 {
+		t.Fatalf("Recordings.ListByUser of an admin: %d of %d, want 1", len(recs), total)
+	}
+
+	// The rooms go to the admin who joined first after admin
+	check(t, st.Orgs.HandOver(ctx, admin.ID, o.ID))
+	for id, want := range map[string]int64{"r1": second.ID, "r2": second.ID, "p1": admin.ID} {
+		s, err := st.Sessions.Get(ctx, id)
+		check(t, err)
+		if s.UserID != want {
+			t.Fatalf("HandOver: %s is user %d's, want %d's", id, s.UserID, want)
+		}
+	}
+
+	inv := models.Invite{SessionID: room.ID, Mobile: guardian.Mobile, Role: models.MemberListener, InvitedBy: second.ID,
+		ExpiresAt: time.Now().Add(time.Hour)}
+	check(t, st.Members.CreateInvite(ctx, &inv, ""))
+	check(t, st.Members.Accept(ctx, inv.ID, guardian.ID))
+	check(t, st.Orgs.RemoveMember(ctx, o.ID, guardian.ID))
+	_, err = st.Members.Role(ctx, room.ID, guardian.ID)
+	wantErr(t, "room role of a removed member", err, store.ErrNotFound)
+	wantErr(t, "RemoveMember of a removed member", st.Orgs.RemoveMember(ctx, o.ID, guardian.ID), store.ErrNotFound)
+
+	check(t, st.Orgs.Delete(ctx, o.ID))
+	_, err = st.Sessions.Get(ctx, room.ID)
+	wantErr(t, "Get of a deleted organization's room", err, store.ErrNotFound)
+	all, err := st.Recordings.All(ctx)
+	check(t, err)
+	if len(all) != 0 {
+		t.Fatalf("recordings of a deleted organization: %d, want 0", len(all))
+	}
+	wantErr(t, "Delete of a deleted organization", st.Orgs.Delete(ctx, o.ID), store.ErrNotFound)
+}
+
+func testRecordings(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	owner := newUser(t, st, "01700000001")
+	viewer := newUser(t, st, "01700000002")
+	listener := newUser(t, st, "01700000003")
+	s := newSession(t, st, "s1", owner.ID, 0)
+	newSession(t, st, "s2", owner.ID, 0)
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first := newRecording(t, st, "s1", start, 0)
+	second := newRecording(t, st, "s1", start.Add(time.Minute), 0)
+	third := newRecording(t, st, "s2", start.Add(2*time.Minute), 0)
+	if first.ID == 0 || second.ID == first.ID {
+		t.Fatalf("Create: IDs %d and %d", first.ID, second.ID)
+	}
+
+	check(t, st.Recordings.SetPath(ctx, first.ID, "s1/1.webm"))
+	end := start.Add(30 * time.Second)
+	check(t, st.Recordings.Finish(ctx, first.ID, 1000, end))
+	got, err := st.Recordings.Get(ctx, first.ID)
+	check(t, err)
+	if got.Path != "s1/1.webm" || got.Status != "finalized" || got.Bytes != 1000 || got.EndedAt == nil || !got.EndedAt.Equal(end) ||
+		!got.StartedAt.Equal(start) {
+		t.Fatalf("after SetPath and Finish: %+v", got)
+	}
+	check(t, st.Recordings.SetBytes(ctx, first.ID, 900))
+	got, err = st.Recordings.Get(ctx, first.ID)
+	check(t, err)
+	if got.Bytes != 900 {
+		t.Fatalf("after SetBytes: %d bytes, want 900", got.Bytes)
+	}
+	_, err = st.Recordings.Get(ctx, 9999)
+	wantErr(t, "Get of an unknown recording", err, store.ErrNotFound)
+	wantErr(t, "SetPath of an unknown recording", st.Recordings.SetPath(ctx, 9999, ""), store.ErrNotFound)
+	wantErr(t, "Finish of an unknown recording", st.Recordings.Finish(ctx, 9999, 0, end), store.ErrNotFound)
+	wantErr(t, "SetBytes of an unknown recording", st.Recordings.SetBytes(ctx, 9999, 0), store.ErrNotFound)
+
+	list, err := st.Recordings.ListBySession(ctx, s.ID)
+	check(t, err)
+	if len(list) != 2 || list[0].ID != second.ID || list[1].ID != first.ID {
+		t.Fatalf("ListBySession: %+v, want the newest first", list)
+	}
+	open, err := st.Recordings.ListOpen(ctx)
+	check(t, err)
+	if len(open) != 2 || open[0].ID != second.ID || open[1].ID != third.ID {
+		t.Fatalf("ListOpen: %+v", open)
+	}
+	all, err := st.Recordings.All(ctx)
+	check(t, err)
+	if len(all) != 3 || all[0].ID != first.ID || all[2].ID != third.ID {
+		t.Fatalf("All: %+v, want the oldest first", all)
+	}
+
+	list, total, err := st.Recordings.ListByUser(ctx, owner.ID, "", 2, 0)
+	check(t, err)
+	if total != 3 || len(list) != 2 || list[0].ID != third.ID {
+		t.Fatalf("ListByUser: %d of %d, want the newest 2 of 3", len(list), total)
+	}
+	_, total, err = st.Recordings.ListByUser(ctx, owner.ID, s.ID, 10, 0)
+	check(t, err)
+	if total != 2 {
+		t.Fatalf("ListByUser of one session: %d, want 2", total)
+	}
+
+	// Viewers see the recordings of the session, listeners do not
+	for _, m := range []struct {
+		user models.User
+		role models.MemberRole
+		want int
+	}{{viewer, models.MemberViewer, 2}, {listener, models.MemberListener, 0}} {
+		inv := models.Invite{SessionID: s.ID, Mobile: m.user.Mobile, Role: m.role, InvitedBy: owner.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		check(t, st.Members.CreateInvite(ctx, &inv, ""))
+		check(t, st.Members.Accept(ctx, inv.ID, m.user.ID))
+		_, total, err := st.Recordings.ListByUser(ctx, m.user.ID, "", 10, 0)
+		check(t, err)
+		if total != m.want {
+			t.Fatalf("ListByUser of a %s: %d recordings, want %d", m.role, total, m.want)
+		}
+	}
+
+	check(t, st.Recordings.Delete(ctx, first.ID))
+	wantErr(t, "Delete of a deleted recording", st.Recordings.Delete(ctx, first.ID), store.ErrNotFound)
+}
+
+func testDevices(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	a := newUser(t, st, "01700000001")
+	b := newUser(t, st, "01700000002")
+
+	d := models.Device{UserID: a.ID, Endpoint: "https://push.example/1", P256dh: "key", Auth: "auth", DeviceName: "Phone"}
+	isNew, err := st.Devices.Save(ctx, &d)
+	check(t, err)
+	if !isNew || d.ID == 0 {
+		t.Fatalf("Save of a new endpoint: new %v, ID %d", isNew, d.ID)
+	}
+	rotated := d
+	rotated.P256dh = "rotated"
+	isNew, err = st.Devices.Save(ctx, &rotated)
+	check(t, err)
+	if isNew || rotated.ID != d.ID {
+		t.Fatalf("Save of a known endpoint: new %v, ID %d, want %d", isNew, rotated.ID, d.ID)
+	}
+	// Another user cannot take over the subscription
+	taken := models.Device{UserID: b.ID, Endpoint: d.Endpoint, P256dh: "key", Auth: "auth"}
+	_, err = st.Devices.Save(ctx, &taken)
+	wantErr(t, "Save of another user's endpoint", err, store.ErrConflict)
+	list, err := st.Devices.ListByUser(ctx, a.ID)
+	check(t, err)
+	if len(list) != 1 || list[0].ID != d.ID || list[0].P256dh != "rotated" {
+		t.Fatalf("ListByUser after another user saved the endpoint: %+v", list)
+	}
+	list, err = st.Devices.ListByUser(ctx, b.ID)
+	check(t, err)
+	if len(list) != 0 {
+		t.Fatalf("ListByUser of the other user: %+v", list)
+	}
+
+	tablet := models.Device{UserID: a.ID, Endpoint: "https://push.example/2", DeviceName: "Tablet"}
+	laptop := models.Device{UserID: a.ID, Endpoint: "https://push.example/3", DeviceName: "Laptop"}
+	for _, d := range []*models.Device{&tablet, &laptop} {
+		_, err := st.Devices.Save(ctx, d)
+		check(t, err)
+	}
+	list, err = st.Devices.ListByUser(ctx, a.ID)
+	check(t, err)
+	if len(list) != 3 || list[1].ID != tablet.ID || list[2].ID != laptop.ID || list[1].DeviceName != "Tablet" {
+		t.Fatalf("ListByUser: %+v, want the oldest first", list)
+	}
+
+	found, err := st.Devices.DeleteByID(ctx, b.ID, tablet.ID)
+	check(t, err)
+	if found {
+		t.Fatal("DeleteByID removed another user's device")
+	}
+	found, err = st.Devices.DeleteByID(ctx, a.ID, tablet.ID)
+	check(t, err)
+	if !found {
+		t.Fatal("DeleteByID did not find the device")
+	}
+	check(t, st.Devices.Delete(ctx, b.ID, laptop.Endpoint))
+	check(t, st.Devices.Delete(ctx, a.ID, laptop.Endpoint))
+	list, err = st.Devices.ListByUser(ctx, a.ID)
+	check(t, err)
+	if len(list) != 1 || list[0].ID != d.ID {
+		t.Fatalf("ListByUser after deleting two devices: %+v", list)
+	}
+
+	n, err := st.Devices.DeleteAll(ctx)
+	check(t, err)
+	if n != 1 {
+		t.Fatalf("DeleteAll: %d devices, want 1", n)
+	}
+}
+
+func testAudit(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	events := []models.AuditEvent{
+		{ActorID: 1, Actor: "01700000001", Action: "login", Outcome: "success"},
+		{Actor: "01700000002", Action: "login", Outcome: "failure"},
+		{ActorID: 1, Actor: "01700000001", Action: "session.delete", TargetType: "session", TargetID: "s1", Outcome: "success"},
+	}
+	for i := range events {
+		e := &events[i]
+		sealed := false
+		check(t, st.Audit.Append(ctx, e, func() {
+			sealed = true
+			e.Time = time.Now().UTC().Truncate(time.Second)
+			e.Hash = fmt.Sprintf("hash-%d", i)
+		}))
+		want := ""
+		if i > 0 {
+			want = events[i-1].Hash
+		}
+		if !sealed || e.ID == 0 || e.PrevHash != want {
+			t.Fatalf("Append %d: sealed %v, ID %d, previous hash %q, want %q", i, sealed, e.ID, e.PrevHash, want)
+		}
+	}
+
+	list, err := st.Audit.List(ctx, store.AuditFilter{})
+	check(t, err)
+	if len(list) != 3 || list[0].ID != events[2].ID || list[0].Hash != "hash-2" || list[0].TargetID != "s1" ||
+		!list[0].Time.Equal(events[2].Time) {
+		t.Fatalf("List: %+v, want the newest first", list)
+	}
+	for _, f := range []struct {
+		filter store.AuditFilter
+		want   []int
+	}{
+		{store.AuditFilter{Action: "login"}, []int{1, 0}},
+		{store.AuditFilter{Actor: "0002"}, []int{1}},
+		{store.AuditFilter{Actor: "%"}, nil},
+		{store.AuditFilter{ActorID: 1}, []int{2, 0}},
+		{store.AuditFilter{Target: "s1"}, []int{2}},
+		{store.AuditFilter{Outcome: "failure"}, []int{1}},
+		{store.AuditFilter{Before: events[2].ID, Limit: 1}, []int{1}},
+	} {
+		list, err := st.Audit.List(ctx, f.filter)
+		check(t, err)
+		var got []int
+		for _, e := range list {
+			for i := range events {
+				if events[i].ID == e.ID {
+					got = append(got, i)
+				}
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(f.want) {
+			t.Fatalf("List(%+v): events %v, want %v", f.filter, got, f.want)
+		}
+	}
+
+	var walked []string
+	check(t, st.Audit.Walk(ctx, func(e models.AuditEvent) error {
+		walked = append(walked, e.Hash)
+		return nil
+	}))
+	if fmt.Sprint(walked) != "[hash-0 hash-1 hash-2]" {
+		t.Fatalf("Walk: %v, want the oldest first", walked)
+	}
+	stop := errors.New("stop")
+	n := 0
+	err = st.Audit.Walk(ctx, func(models.AuditEvent) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Fatalf("Walk with a failing fn: %v after %d events", err, n)
+	}
+}
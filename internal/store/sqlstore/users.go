@@ -0,0 +1,158 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/store"
+)
+
+type users struct {
+	db  *database.Handle
+	get *database.Stmt // checks the account of every request
+}
+
+const userColumns = "u.id, u.mobile, u.password_hash, u.role, u.disabled, u.created_at, u.tokens_valid_after"
+
+func scanUser(row scanner, extra ...interface{}) (models.User, error) {
+	var u models.User
+	var validAfter sql.NullTime
+	err := row.Scan(append([]interface{}{&u.ID, &u.Mobile, &u.PasswordHash, &u.Role, &u.Disabled, &u.CreatedAt, &validAfter}, extra...)...)
+	if validAfter.Valid {
+		u.TokensValidAfter = &validAfter.Time
+	}
+	return u, err
+}
+
+func (s *users) Create(ctx context.Context, u *models.User) error {
+	if u.Role == "" {
+		u.Role = models.RoleUser
+	}
+	u.CreatedAt = time.Now()
+	err := s.db.QueryRowContext(ctx, "INSERT INTO users (mobile, password_hash, role) VALUES (?, ?, ?) RETURNING id",
+		u.Mobile, u.PasswordHash, u.Role).Scan(&u.ID)
+	return conflict(err)
+}
+
+func (s *users) Get(ctx context.Context, id int64) (models.User, error) {
+	u, err := scanUser(s.get.QueryRowContext(ctx, id))
+	return u, notFound(err)
+}
+
+func (s *users) GetByMobile(ctx context.Context, mobile string) (models.User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users u WHERE u.mobile = ?", mobile))
+	return u, notFound(err)
+}
+
+// summaryColumns adds up a user's sessions and the bytes of their
+// recordings.
+const summaryColumns = `(SELECT COUNT(*) FROM sessions s WHERE s.user_id = u.id) AS session_count,
+	(SELECT COALESCE(SUM(r.bytes), 0) FROM recordings r JOIN sessions s ON s.id = r.session_id WHERE s.user_id = u.id) AS storage`
+
+func (s *users) Summary(ctx context.Context, id int64) (store.UserSummary, error) {
+	var sum store.UserSummary
+	var err error
+	sum.User, err = scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+", "+summaryColumns+" FROM users u WHERE u.id = ?", id),
+		&sum.Sessions, &sum.StorageBytes)
+	return sum, notFound(err)
+}
+
+func (s *users) List(ctx context.Context, limit, offset int) ([]models.User, int, error) {
+	var total int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users u ORDER BY u.created_at DESC, u.id DESC LIMIT ? OFFSET ?",
+		limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var list []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, u)
+	}
+	return list, total, rows.Err()
+}
+
+func (s *users) sorts() map[string]listSort {
+	return map[string]listSort{
+		"newest":  {timeKey(s.db.Dialect, "u.created_at"), true},
+		"oldest":  {timeKey(s.db.Dialect, "u.created_at"), false},
+		"mobile":  {"u.mobile", false},
+		"storage": {numberKey(s.db.Dialect, "u.storage"), true},
+	}
+}
+
+func (s *users) Search(ctx context.Context, q store.ListQuery) ([]store.UserSummary, string, error) {
+	sort, ok := s.sorts()[q.Sort]
+	if !ok {
+		sort = s.sorts()[store.UserSorts[0]]
+	}
+	conds, args := listQuery(q, sort, "u.mobile", "u.id", func(id string) interface{} {
+		n, _ := strconv.ParseInt(id, 10, 64)
+		return n
+	})
+	// The summary columns are computed in a subquery so the storage sort
+	// can refer to them
+	query := `SELECT ` + userColumns + `, u.session_count, u.storage, ` + sort.key + ` FROM (
+		SELECT u.*, ` + summaryColumns + ` FROM users u) u` +
+		where(conds) + " ORDER BY " + sort.orderBy("u.id") + " LIMIT ?"
+	args = append(args, q.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var list []store.UserSummary
+	var next, lastKey string
+	for rows.Next() {
+		var sum store.UserSummary
+		var key string
+		if sum.User, err = scanUser(rows, &sum.Sessions, &sum.StorageBytes, &key); err != nil {
+			return nil, "", err
+		}
+		if len(list) == q.Limit {
+			next = cursor{Key: lastKey, ID: strconv.FormatInt(list[len(list)-1].ID, 10)}.encode()
+			break
+		}
+		list = append(list, sum)
+		lastKey = key
+	}
+	return list, next, rows.Err()
+}
+
+func (s *users) SetRole(ctx context.Context, id int64, role models.Role) error {
+	return affected(s.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, id))
+}
+
+func (s *users) SetDisabled(ctx context.Context, id int64, disabled bool) error {
+	if !disabled {
+		return affected(s.db.ExecContext(ctx, "UPDATE users SET disabled = 0 WHERE id = ?", id))
+	}
+	return affected(s.db.ExecContext(ctx, "UPDATE users SET disabled = 1, tokens_valid_after = ? WHERE id = ?", time.Now().UTC(), id))
+}
+
+func (s *users) SetPassword(ctx context.Context, id int64, hash string) error {
+	return affected(s.db.ExecContext(ctx, "UPDATE users SET password_hash = ?, tokens_valid_after = ? WHERE id = ?",
+		hash, time.Now().UTC(), id))
+}
+
+func (s *users) SignOut(ctx context.Context, id int64, at time.Time) error {
+	return affected(s.db.ExecContext(ctx, "UPDATE users SET tokens_valid_after = ? WHERE id = ?", at.UTC(), id))
+}
+
+func (s *users) Delete(ctx context.Context, id int64) error {
+	return affected(s.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id))
+}
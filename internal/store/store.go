@@ -0,0 +1,275 @@
+// Package store defines the persistent state the handlers work with:
+// users, sessions and who they are shared with, the organizations owning
+// sessions as rooms, recordings, push devices and the audit log. The
+// interfaces say nothing about the database behind them; sqlstore
+// implements them for SQLite and PostgreSQL, and the server picks one with
+// DB_DRIVER.
+//
+// Every method takes the context of the request it serves. Methods
+// returning a single row report a missing one with ErrNotFound.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zamibd/a2web/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when the row asked for does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a row would duplicate a unique value,
+	// like a second user with the same mobile number.
+	ErrConflict = errors.New("already exists")
+)
+
+// Store bundles the stores of one database.
+type Store struct {
+	Users      Users
+	Sessions   Sessions
+	Members    Members
+	Orgs       Orgs
+	Recordings Recordings
+	Devices    Devices
+	Audit      AuditLog
+}
+
+// ListQuery selects one page of an admin listing.
+type ListQuery struct {
+	Search string // matched anywhere in the mobile number or session name, literally
+	Sort   string // one of UserSorts or SessionSorts; the first when empty or unknown
+	Cursor string // NextCursor of the previous page; empty for the first
+	Limit  int
+}
+
+// Orders of the admin listings.
+var (
+	UserSorts    = []string{"newest", "oldest", "mobile", "storage"}
+	SessionSorts = []string{"newest", "oldest", "name"}
+)
+
+// UserSummary is a user with what their sessions add up to.
+type UserSummary struct {
+	models.User
+	Sessions     int
+	StorageBytes int64
+}
+
+// SessionSummary is a session with its owner and what its recordings add
+// up to.
+type SessionSummary struct {
+	models.Session
+	OwnerMobile  string
+	Recordings   int
+	StorageBytes int64
+}
+
+// Users stores accounts.
+type Users interface {
+	// Create adds u and sets its ID. A taken mobile number is ErrConflict.
+	Create(ctx context.Context, u *models.User) error
+	Get(ctx context.Context, id int64) (models.User, error)
+	GetByMobile(ctx context.Context, mobile string) (models.User, error)
+	Summary(ctx context.Context, id int64) (UserSummary, error)
+	// List returns one page of users, newest first, and the total number.
+	List(ctx context.Context, limit, offset int) ([]models.User, int, error)
+	// Search returns one page of the admin user listing and the cursor of
+	// the next, empty on the last page.
+	Search(ctx context.Context, q ListQuery) ([]UserSummary, string, error)
+	SetRole(ctx context.Context, id int64, role models.Role) error
+	// SetDisabled blocks or unblocks a user. Blocking also signs them out.
+	SetDisabled(ctx context.Context, id int64, disabled bool) error
+	// SetPassword replaces the password hash and signs the user out.
+	SetPassword(ctx context.Context, id int64, hash string) error
+	// SignOut invalidates the browser cookies issued before at.
+	SignOut(ctx context.Context, id int64, at time.Time) error
+	// Delete removes a user with everything they own.
+	Delete(ctx context.Context, id int64) error
+}
+
+// Sessions stores listening sessions.
+type Sessions interface {
+	// Create adds s, which must have its ID set, and sets CreatedAt. The
+	// BroadcastID defaults to the ID.
+	Create(ctx context.Context, s *models.Session) error
+	Get(ctx context.Context, id string) (models.Session, error)
+	// GetByBroadcastID returns the session a kid link is for.
+	GetByBroadcastID(ctx context.Context, broadcastID string) (models.Session, error)
+	// Update stores the name, description, status and settings of s.
+	Update(ctx context.Context, s models.Session) error
+	// SetBroadcastID replaces the ID of a session's kid link.
+	SetBroadcastID(ctx context.Context, id, broadcastID string) error
+	// ListByUser returns a user's sessions, newest first, and their total
+	// number. A limit of 0 returns all of them.
+	ListByUser(ctx context.Context, userID int64, limit, offset int) ([]models.Session, int, error)
+	// Summaries returns a user's sessions with their recordings, newest
+	// first.
+	Summaries(ctx context.Context, userID int64) ([]SessionSummary, error)
+	// Search returns one page of the admin session listing and the cursor
+	// of the next, empty on the last page.
+	Search(ctx context.Context, q ListQuery) ([]SessionSummary, string, error)
+	// All returns every session, for bridges announcing them.
+	All(ctx context.Context) ([]models.Session, error)
+	// Delete removes a session with its recordings' rows; the files are
+	// the caller's.
+	Delete(ctx context.Context, id string) error
+}
+
+// MemberSession is a session the user owns or was given a role in.
+type MemberSession struct {
+	models.Session
+	Role        models.MemberRole
+	OwnerMobile string
+	OrgName     string // of the organization the session is a room of
+}
+
+// Members stores who besides their owners may use sessions, and the
+// invitations to join them. The owner of a session is its UserID and has
+// no row.
+type Members interface {
+	// Role returns the role of a user who is not the session's owner;
+	// ErrNotFound when they have none.
+	Role(ctx context.Context, sessionID string, userID int64) (models.MemberRole, error)
+	// List returns the members of a session, oldest first, without the
+	// owner.
+	List(ctx context.Context, sessionID string) ([]models.Member, error)
+	// Sessions returns the sessions a user owns or has a role in, through
+	// their organizations too, newest first, and their total number. An
+	// orgID other than 0 returns only that organization's rooms. A limit of
+	// 0 returns all of them.
+	Sessions(ctx context.Context, userID, orgID int64, limit, offset int) ([]MemberSession, int, error)
+	// Remove revokes a member's role.
+	Remove(ctx context.Context, sessionID string, userID int64) error
+
+	// CreateInvite adds inv, with the hash of its link token for link
+	// invites, and sets its ID and CreatedAt.
+	CreateInvite(ctx context.Context, inv *models.Invite, tokenHash string) error
+	// Invites returns a session's unexpired invites, newest first.
+	Invites(ctx context.Context, sessionID string) ([]models.Invite, error)
+	// InvitesFor returns the unexpired invites to a mobile number, newest
+	// first.
+	InvitesFor(ctx context.Context, mobile string) ([]models.Invite, error)
+	// GetInvite and GetInviteByToken return an unexpired invite.
+	GetInvite(ctx context.Context, id int64) (models.Invite, error)
+	GetInviteByToken(ctx context.Context, tokenHash string) (models.Invite, error)
+	// Accept gives userID the invite's role, replacing any role they had,
+	// and deletes the invite. An invite that is gone or expired is
+	// ErrNotFound.
+	Accept(ctx context.Context, inviteID, userID int64) error
+	DeleteInvite(ctx context.Context, id int64) error
+}
+
+// OrgSummary is an organization with what it uses of its quotas.
+type OrgSummary struct {
+	models.Organization
+	Usage models.OrgUsage
+}
+
+// UserOrg is an organization a user is a member of.
+type UserOrg struct {
+	models.Organization
+	Role models.OrgRole
+}
+
+// Orgs stores organizations and their members. Their rooms are sessions
+// with their OrgID set.
+type Orgs interface {
+	// Create adds o and sets its ID and CreatedAt.
+	Create(ctx context.Context, o *models.Organization) error
+	Get(ctx context.Context, id int64) (models.Organization, error)
+	// List returns every organization with its usage, by name.
+	List(ctx context.Context) ([]OrgSummary, error)
+	// ListByUser returns the organizations a user is a member of, by name.
+	ListByUser(ctx context.Context, userID int64) ([]UserOrg, error)
+	Usage(ctx context.Context, id int64) (models.OrgUsage, error)
+	// Update stores the name and settings of o.
+	Update(ctx context.Context, o models.Organization) error
+	SetQuotas(ctx context.Context, id int64, q models.OrgQuotas) error
+	// Rooms returns an organization's sessions, newest first.
+	Rooms(ctx context.Context, id int64) ([]models.Session, error)
+	// Delete removes an organization with its rooms and their recordings'
+	// rows; the files are the caller's.
+	Delete(ctx context.Context, id int64) error
+
+	// Role returns a user's role in an organization; ErrNotFound when they
+	// are not a member.
+	Role(ctx context.Context, orgID, userID int64) (models.OrgRole, error)
+	// Members returns an organization's members, admins first.
+	Members(ctx context.Context, orgID int64) ([]models.OrgMember, error)
+	// SetMember adds m.UserID to m.OrgID or changes their role to m.Role,
+	// and sets m.CreatedAt to when they joined.
+	SetMember(ctx context.Context, m *models.OrgMember) error
+	// RemoveMember removes a member together with their roles in the
+	// organization's rooms.
+	RemoveMember(ctx context.Context, orgID, userID int64) error
+	// HandOver gives the organization's rooms whose user is userID to the
+	// longest-standing other admin, in every organization or in orgID's
+	// when it is not 0. Rooms without another admin stay with userID.
+	HandOver(ctx context.Context, userID, orgID int64) error
+}
+
+// Recordings stores the rows of recording files.
+type Recordings interface {
+	// Create adds rec with an empty path and sets its ID.
+	Create(ctx context.Context, rec *models.Recording) error
+	SetPath(ctx context.Context, id int64, path string) error
+	// Finish marks a recording finalized with its size and end time.
+	Finish(ctx context.Context, id, bytes int64, endedAt time.Time) error
+	// SetBytes corrects the recorded size.
+	SetBytes(ctx context.Context, id, bytes int64) error
+	Get(ctx context.Context, id int64) (models.Recording, error)
+	// ListBySession returns a session's recordings, newest first.
+	ListBySession(ctx context.Context, sessionID string) ([]models.Recording, error)
+	// ListByUser returns one page of the recordings of the sessions a user
+	// owns, is a viewer of or is an organization's admin or staff for,
+	// optionally of one session, newest first, and their total number.
+	ListByUser(ctx context.Context, userID int64, sessionID string, limit, offset int) ([]models.Recording, int, error)
+	// ListOpen returns the recordings not finalized yet.
+	ListOpen(ctx context.Context) ([]models.Recording, error)
+	// All returns every recording, oldest first.
+	All(ctx context.Context) ([]models.Recording, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+// Devices stores push subscriptions.
+type Devices interface {
+	// Save stores d, replacing the user's subscription with the same
+	// endpoint, and reports whether the endpoint is new to the user. An
+	// endpoint another user subscribed is ErrConflict.
+	Save(ctx context.Context, d *models.Device) (bool, error)
+	// ListByUser returns a user's devices, oldest first.
+	ListByUser(ctx context.Context, userID int64) ([]models.Device, error)
+	Delete(ctx context.Context, userID int64, endpoint string) error
+	// DeleteByID removes one of a user's devices and reports whether it
+	// existed.
+	DeleteByID(ctx context.Context, userID, id int64) (bool, error)
+	// DeleteAll removes every device and returns how many there were.
+	DeleteAll(ctx context.Context) (int64, error)
+}
+
+// AuditFilter selects audit events. Zero fields match everything.
+type AuditFilter struct {
+	Action  string
+	Actor   string // substring of the actor's mobile number
+	ActorID int64
+	Target  string // target ID
+	Outcome string
+	Before  int64 // only events with a lower ID, for paging
+	Limit   int
+}
+
+// AuditLog stores the audit events. It keeps them; hashing them is the
+// audit package's job.
+type AuditLog interface {
+	// Append adds e as the newest event. While no other event can be
+	// appended, it sets e.PrevHash to the hash of the newest event, empty
+	// for the first, and calls seal, which fills in e.Time and e.Hash. It
+	// then sets e.ID.
+	Append(ctx context.Context, e *models.AuditEvent, seal func()) error
+	// List returns the events matching f, newest first.
+	List(ctx context.Context, f AuditFilter) ([]models.AuditEvent, error)
+	// Walk calls fn with every event, oldest first, until fn fails.
+	Walk(ctx context.Context, fn func(models.AuditEvent) error) error
+}
@@ -0,0 +1,60 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrPrivateAddress refuses webhook and push URLs that resolve to the
+// server's own network rather than the internet.
+var ErrPrivateAddress = errors.New("URLs must point to a public address")
+
+// privateIP reports whether ip is loopback, private (RFC 1918 and RFC 4193),
+// link-local, which includes the cloud metadata address 169.254.169.254,
+// multicast or unspecified.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// CheckURL validates a webhook URL: an absolute http(s) URL whose host
+// resolves only to public addresses, unless allowPrivate is set.
+func CheckURL(ctx context.Context, raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("URL must be an absolute http(s) URL")
+	}
+	if allowPrivate {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return errors.New("URL host cannot be resolved")
+	}
+	for _, a := range addrs {
+		if privateIP(a.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// DialControl refuses connections to private addresses; set it as the
+// Control of a net.Dialer. It runs after name resolution, for every
+// connection including redirects, so a host that resolved to a public
+// address when the URL was registered cannot be pointed at the local
+// network later.
+func DialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zamibd/a2web/internal/events"
+)
+
+// Webhook event names. They mirror the bus event types where one exists;
+// alert.triggered is the public name of alert.raised.
+const (
+	EventStreamStarted      = "stream.started"
+	EventStreamStopped      = "stream.stopped"
+	EventAlertTriggered     = "alert.triggered"
+	EventRecordingFinalized = "recording.finalized"
+	EventSessionDeleted     = "session.deleted"
+)
+
+// Events lists every event a webhook can subscribe to.
+var Events = []string{
+	EventStreamStarted, EventStreamStopped, EventAlertTriggered, EventRecordingFinalized, EventSessionDeleted,
+}
+
+// busEvents maps bus event types to webhook event names.
+var busEvents = map[string]string{
+	events.StreamStarted:      EventStreamStarted,
+	events.StreamStopped:      EventStreamStopped,
+	events.AlertRaised:        EventAlertTriggered,
+	events.RecordingFinalized: EventRecordingFinalized,
+	events.SessionDeleted:     EventSessionDeleted,
+}
+
+// Signature headers. The signature is HMAC-SHA256 over
+// "{timestamp}.{body}" keyed with the webhook secret, hex encoded, so
+// receivers can reject replays by checking the timestamp.
+const (
+	HeaderEvent     = "X-A2web-Event"
+	HeaderDelivery  = "X-A2web-Delivery"
+	HeaderTimestamp = "X-A2web-Timestamp"
+	HeaderSignature = "X-A2web-Signature"
+)
+
+const (
+	pollInterval = 2 * time.Second
+	batchSize    = 20
+	maxAttempts  = 8
+	baseBackoff  = 15 * time.Second
+	maxBackoff   = 6 * time.Hour
+	// keepFinished is how long delivered and failed rows stay in the log.
+	keepFinished = 30 * 24 * time.Hour
+)
+
+// Payload is the JSON body posted to webhook endpoints.
+type Payload struct {
+	Event     string      `json:"event"`
+	SessionID string      `json:"session_id,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// ValidEvent reports whether name is an event webhooks can subscribe to.
+func ValidEvent(name string) bool {
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateSecret returns a new random signing secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign computes the signature header value for a body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher turns bus events into queued deliveries for the owning user's
+// webhooks and posts them, retrying with exponential backoff.
+type Dispatcher struct {
+	logger *slog.Logger
+	bus    *events.Bus
+	store  *Store
+	client *http.Client
+	wake   chan struct{}
+}
+
+// NewDispatcher creates a dispatcher. Unless allowPrivate is set it
+// refuses to connect to loopback, private and link-local addresses, so
+// webhooks cannot reach the server's own network.
+func NewDispatcher(logger *slog.Logger, bus *events.Bus, st *Store, allowPrivate bool) *Dispatcher {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = DialControl
+	}
+	return &Dispatcher{
+		logger: logger,
+		bus:    bus,
+		store:  st,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Wake triggers an immediate delivery pass, e.g. after a redeliver request.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run queues and delivers events until ctx is done. Events are queued on
+// their own goroutine, so a slow endpoint never leaves bus events unread
+// until they are dropped.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ch, cancel := d.bus.Subscribe(256)
+	defer cancel()
+	go d.queueEvents(ctx, ch)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+			d.deliverDue(ctx)
+		case <-ticker.C:
+			d.deliverDue(ctx)
+		case <-purge.C:
+			if err := d.store.purgeDeliveries(time.Now().Add(-keepFinished)); err != nil {
+				d.logger.Error("Failed to purge webhook deliveries", "error", err)
+			}
+		}
+	}
+}
+
+// queueEvents queues a delivery for every bus event read from ch until ctx
+// is done.
+func (d *Dispatcher) queueEvents(ctx context.Context, ch <-chan events.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if name, ok := busEvents[e.Type]; ok {
+				d.enqueue(name, e)
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) enqueue(name string, e events.Event) {
+	hooks, err := d.store.List(e.UserID)
+	if err != nil {
+		d.logger.Error("Failed to load webhooks", "user_id", e.UserID, "error", err)
+		return
+	}
+
+	var body []byte
+	queued := false
+	for _, hook := range hooks {
+		if !hook.Active || !hook.Subscribes(name) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(Payload{Event: name, SessionID: e.SessionID, Timestamp: e.Time, Data: e.Data}); err != nil {
+				d.logger.Error("Failed to encode webhook payload", "event", name, "error", err)
+				return
+			}
+		}
+		if err := d.store.enqueue(hook.ID, name, body); err != nil {
+			d.logger.Error("Failed to queue webhook delivery", "webhook_id", hook.ID, "error", err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		d.Wake()
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	due, err := d.store.dueDeliveries(time.Now(), batchSize)
+	if err != nil {
+		d.logger.Error("Failed to load webhook deliveries", "error", err)
+		return
+	}
+	for _, p := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		d.deliver(ctx, p)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, p pending) {
+	status, err := d.post(ctx, p)
+	if err == nil && status >= 200 && status < 300 {
+		d.store.finish(p.id, "delivered", status, "", time.Now())
+		return
+	}
+
+	reason := "HTTP " + strconv.Itoa(status)
+	if err != nil {
+		reason = err.Error()
+	}
+	if p.attempts+1 >= maxAttempts {
+		d.logger.Warn("Giving up on webhook delivery", "delivery_id", p.id, "error", reason)
+		d.store.finish(p.id, "failed", status, reason, time.Now())
+		return
+	}
+	d.store.finish(p.id, "pending", status, reason, time.Now().Add(backoff(p.attempts)))
+}
+
+func (d *Dispatcher) post(ctx context.Context, p pending) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(p.payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "a2web-webhooks/1")
+	req.Header.Set(HeaderEvent, p.event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(p.id, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(p.secret, ts, p.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func backoff(attempts int) time.Duration {
+	dur := baseBackoff << attempts
+	if dur > maxBackoff || dur <= 0 {
+		return maxBackoff
+	}
+	return dur
+}
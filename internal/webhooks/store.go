@@ -0,0 +1,216 @@
+package webhooks
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+)
+
+// ErrNotFound is returned when a webhook or delivery does not exist or
+// belongs to another user.
+var ErrNotFound = errors.New("not found")
+
+// Store keeps webhooks and their deliveries.
+type Store struct {
+	db *database.Handle
+}
+
+// NewStore returns the webhook store of db.
+func NewStore(db *database.Handle) *Store {
+	return &Store{db: db}
+}
+
+// Webhook is an endpoint registered by a user for a set of events.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribes reports whether the webhook wants events of the given type.
+func (w Webhook) Subscribes(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is one attempt sequence to deliver an event to a webhook.
+type Delivery struct {
+	ID             int64     `json:"id"`
+	WebhookID      int64     `json:"webhook_id"`
+	Event          string    `json:"event"`
+	Payload        string    `json:"payload"`
+	Status         string    `json:"status"` // "pending", "delivered", "failed"
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	ResponseStatus *int      `json:"response_status,omitempty"`
+	LastError      *string   `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (s *Store) Create(w *Webhook) error {
+	err := s.db.QueryRow("INSERT INTO webhooks (user_id, url, secret, events) VALUES (?, ?, ?, ?) RETURNING id",
+		w.UserID, w.URL, w.Secret, strings.Join(w.Events, ",")).Scan(&w.ID)
+	w.Active = true
+	w.CreatedAt = time.Now()
+	return err
+}
+
+func (s *Store) Delete(id, userID int64) error {
+	res, err := s.db.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List returns a user's webhooks, or every active webhook when userID is 0.
+func (s *Store) List(userID int64) ([]Webhook, error) {
+	query := "SELECT id, user_id, url, secret, events, active, created_at FROM webhooks"
+	var args []interface{}
+	if userID != 0 {
+		query += " WHERE user_id = ?"
+		args = append(args, userID)
+	} else {
+		query += " WHERE active = 1"
+	}
+	rows, err := s.db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		var evs string
+		if err := rows.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &evs, &w.Active, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		w.Events = strings.Split(evs, ",")
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *Store) enqueue(webhookID int64, event string, payload []byte) error {
+	_, err := s.db.Exec("INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at) VALUES (?, ?, ?, ?)",
+		webhookID, event, payload, time.Now().UTC())
+	return err
+}
+
+// Deliveries returns the delivery log of one of userID's webhooks.
+func (s *Store) Deliveries(webhookID, userID int64, limit int) ([]Delivery, error) {
+	rows, err := s.db.Query(`SELECT d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt_at,
+			d.response_status, d.last_error, d.created_at
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.webhook_id = ? AND w.user_id = ? ORDER BY d.id DESC LIMIT ?`, webhookID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Delivery
+	for rows.Next() {
+		var d Delivery
+		var payload []byte
+		var status sql.NullInt64
+		var lastErr sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&status, &lastErr, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = string(payload)
+		if status.Valid {
+			code := int(status.Int64)
+			d.ResponseStatus = &code
+		}
+		if lastErr.Valid {
+			d.LastError = &lastErr.String
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// Redeliver queues a copy of an earlier delivery of one of userID's webhooks.
+func (s *Store) Redeliver(deliveryID, userID int64) error {
+	var webhookID int64
+	var event string
+	var payload []byte
+	err := s.db.QueryRow(`SELECT d.webhook_id, d.event, d.payload
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = ? AND w.user_id = ?`, deliveryID, userID).Scan(&webhookID, &event, &payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.enqueue(webhookID, event, payload)
+}
+
+type pending struct {
+	id       int64
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+func (s *Store) dueDeliveries(now time.Time, limit int) ([]pending, error) {
+	rows, err := s.db.Query(`SELECT d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ? AND w.active = 1
+		ORDER BY d.next_attempt_at LIMIT ?`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.event, &p.payload, &p.attempts, &p.url, &p.secret); err != nil {
+			return nil, err
+		}
+		due = append(due, p)
+	}
+	return due, rows.Err()
+}
+
+// finish records the outcome of an attempt. status is "delivered", "failed"
+// or "pending" (retry at next).
+func (s *Store) finish(id int64, status string, responseStatus int, lastErr string, next time.Time) error {
+	var code interface{}
+	if responseStatus != 0 {
+		code = responseStatus
+	}
+	var errText interface{}
+	if lastErr != "" {
+		errText = lastErr
+	}
+	_, err := s.db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, response_status = ?,
+		last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?`,
+		status, code, errText, next.UTC(), time.Now().UTC(), id)
+	return err
+}
+
+func (s *Store) purgeDeliveries(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM webhook_deliveries WHERE status != 'pending' AND updated_at < ?", before.UTC())
+	return err
+}
@@ -0,0 +1,486 @@
+// Package webm splits a live WebM byte stream, as produced by MediaRecorder
+// or ffmpeg, into its init segment, clusters and blocks with their
+// presentation times. It understands just enough EBML to pace a stream in
+// real time and to stitch streams together by shifting cluster timecodes;
+// block payloads are passed through untouched.
+package webm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Element IDs, with their length marker bits as they appear on the wire.
+const (
+	idEBML          = 0x1A45DFA3
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114D9B74
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idTracks        = 0x1654AE6B
+	idCluster       = 0x1F43B675
+	idCues          = 0x1C53BB6B
+	idChapters      = 0x1043A770
+	idTags          = 0x1254C367
+	idAttachments   = 0x1941A469
+	idTimecode      = 0xE7
+	idSimpleBlock   = 0xA3
+	idBlockGroup    = 0xA0
+	idBlock         = 0xA1
+)
+
+// unknownSize is the size field of an element whose end is only known once
+// the next element starts, as used by live streams.
+const unknownSize = -1
+
+// maxElementSize bounds what is read into memory, so garbage input cannot
+// make the reader allocate gigabytes.
+const maxElementSize = 32 << 20
+
+const defaultTimecodeScale = int64(time.Millisecond)
+
+var (
+	// ErrInvalid is returned for input that is not a WebM stream.
+	ErrInvalid = errors.New("webm: invalid stream")
+)
+
+// Kind tells what a Chunk holds.
+type Kind int
+
+const (
+	// KindInit is the init segment: the EBML header, the Segment header
+	// and everything before the first Cluster.
+	KindInit Kind = iota
+	// KindCluster is a Cluster header up to and including its Timecode.
+	KindCluster
+	// KindBlock is a block, or another element inside a cluster.
+	KindBlock
+)
+
+// Chunk is a piece of the stream. Concatenating the Data of every chunk
+// gives back the input, minus the top-level elements after the first
+// Cluster (cues, tags, ...) that a live stream has no use for.
+type Chunk struct {
+	Kind Kind
+	Data []byte
+	// Time is the presentation time of a cluster or block.
+	Time time.Duration
+	// Tracks is the raw Tracks element of an init segment, which tells
+	// whether two streams can be stitched together.
+	Tracks []byte
+
+	timecodeScale int64
+}
+
+// Shift moves a chunk by d on the timeline. Clusters are always rewritten
+// with an unknown size and an 8-byte Timecode, so the new value fits and
+// blocks may be dropped from them; blocks carry timecodes relative to their
+// cluster and keep their bytes.
+func (c Chunk) Shift(d time.Duration) Chunk {
+	if c.Kind == KindInit {
+		return c
+	}
+	c.Time += d
+	if c.Kind != KindCluster {
+		return c
+	}
+	var b bytes.Buffer
+	b.Write([]byte{0x1F, 0x43, 0xB6, 0x75})
+	b.Write([]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	b.Write([]byte{idTimecode, 0x88})
+	binary.Write(&b, binary.BigEndian, uint64(int64(c.Time)/c.timecodeScale))
+	c.Data = b.Bytes()
+	return c
+}
+
+// header is an element ID and size with the bytes they were read from.
+type header struct {
+	id   uint32
+	size int64
+	raw  []byte
+}
+
+// Reader reads chunks from a WebM stream. A new EBML header in the middle
+// of the stream, as found in concatenated files, starts over with a new
+// init segment.
+type Reader struct {
+	r *bufio.Reader
+
+	timecodeScale int64
+	pending       *header
+
+	inCluster   bool
+	remaining   int64 // bytes left in a cluster of known size
+	clusterTime int64 // ticks
+	lastTime    time.Duration
+}
+
+// NewReader returns a reader for a WebM stream.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64<<10), timecodeScale: defaultTimecodeScale}
+}
+
+// Next returns the next chunk. It returns io.EOF at the end of the stream,
+// and io.ErrUnexpectedEOF when the stream ends inside an element.
+func (r *Reader) Next() (Chunk, error) {
+	for {
+		h, err := r.nextHeader()
+		if err != nil {
+			return Chunk{}, err
+		}
+
+		if r.inCluster && r.remaining == 0 {
+			r.inCluster = false
+		}
+		if r.inCluster && r.remaining == unknownSize && topLevel(h.id) {
+			r.inCluster = false
+		}
+
+		if r.inCluster {
+			if r.remaining != unknownSize {
+				r.remaining -= int64(len(h.raw)) + h.size
+				if h.size == unknownSize || r.remaining < 0 {
+					return Chunk{}, fmt.Errorf("%w: element overruns its cluster", ErrInvalid)
+				}
+			}
+			body, err := r.body(h)
+			if err != nil {
+				return Chunk{}, err
+			}
+			t := r.lastTime
+			if bt, ok := r.blockTime(h.id, body); ok {
+				t = bt
+				r.lastTime = bt
+			}
+			return r.chunk(KindBlock, append(h.raw, body...), t), nil
+		}
+
+		switch h.id {
+		case idEBML:
+			return r.readInit(h)
+		case idCluster:
+			return r.readClusterHeader(h)
+		default:
+			// Seek heads, cues, tags and voids after the first cluster
+			if h.size == unknownSize {
+				return Chunk{}, fmt.Errorf("%w: top-level element %x of unknown size", ErrInvalid, h.id)
+			}
+			if _, err := r.body(h); err != nil {
+				return Chunk{}, err
+			}
+		}
+	}
+}
+
+// readInit reads the EBML header, the Segment header and the elements up to
+// the first Cluster, which is left pending.
+func (r *Reader) readInit(ebml header) (Chunk, error) {
+	body, err := r.body(ebml)
+	if err != nil {
+		return Chunk{}, err
+	}
+	var b bytes.Buffer
+	b.Write(ebml.raw)
+	b.Write(body)
+
+	seg, err := r.readHeader()
+	if err != nil {
+		return Chunk{}, eof(err)
+	}
+	if seg.id != idSegment {
+		return Chunk{}, fmt.Errorf("%w: expected Segment, got %x", ErrInvalid, seg.id)
+	}
+	b.Write(seg.raw)
+
+	r.timecodeScale = defaultTimecodeScale
+	r.inCluster = false
+	var tracks []byte
+	for {
+		h, err := r.readHeader()
+		if err != nil {
+			return Chunk{}, eof(err)
+		}
+		if h.id == idCluster {
+			r.pending = &h
+			break
+		}
+		if h.size == unknownSize {
+			return Chunk{}, fmt.Errorf("%w: element %x of unknown size in the init segment", ErrInvalid, h.id)
+		}
+		body, err := r.body(h)
+		if err != nil {
+			return Chunk{}, err
+		}
+		switch h.id {
+		case idInfo:
+			if scale, ok := findUint(body, idTimecodeScale); ok && scale > 0 {
+				r.timecodeScale = int64(scale)
+			}
+		case idTracks:
+			tracks = append(append([]byte(nil), h.raw...), body...)
+		}
+		b.Write(h.raw)
+		b.Write(body)
+	}
+
+	c := r.chunk(KindInit, b.Bytes(), 0)
+	c.Tracks = tracks
+	return c, nil
+}
+
+// readClusterHeader reads a Cluster header and its children up to the
+// Timecode.
+func (r *Reader) readClusterHeader(cluster header) (Chunk, error) {
+	var b bytes.Buffer
+	b.Write(cluster.raw)
+	r.inCluster = true
+	r.remaining = cluster.size
+	for {
+		h, err := r.readHeader()
+		if err != nil {
+			return Chunk{}, eof(err)
+		}
+		if h.size == unknownSize {
+			return Chunk{}, fmt.Errorf("%w: cluster child %x of unknown size", ErrInvalid, h.id)
+		}
+		if r.remaining != unknownSize {
+			r.remaining -= int64(len(h.raw)) + h.size
+			if r.remaining < 0 {
+				return Chunk{}, fmt.Errorf("%w: element overruns its cluster", ErrInvalid)
+			}
+		}
+		body, err := r.body(h)
+		if err != nil {
+			return Chunk{}, err
+		}
+		b.Write(h.raw)
+		b.Write(body)
+		if h.id == idTimecode {
+			r.clusterTime = int64(readUint(body))
+			r.lastTime = time.Duration(r.clusterTime * r.timecodeScale)
+			return r.chunk(KindCluster, b.Bytes(), r.lastTime), nil
+		}
+	}
+}
+
+func (r *Reader) chunk(kind Kind, data []byte, t time.Duration) Chunk {
+	return Chunk{Kind: kind, Data: data, Time: t, timecodeScale: r.timecodeScale}
+}
+
+// blockTime returns the presentation time of a SimpleBlock or BlockGroup.
+func (r *Reader) blockTime(id uint32, body []byte) (time.Duration, bool) {
+	switch id {
+	case idSimpleBlock:
+	case idBlockGroup:
+		b, ok := findElement(body, idBlock)
+		if !ok {
+			return 0, false
+		}
+		body = b
+	default:
+		return 0, false
+	}
+	// Track number (vint), then a signed 16-bit timecode relative to the
+	// cluster
+	_, n, err := parseVint(body, true)
+	if err != nil || len(body) < n+2 {
+		return 0, false
+	}
+	rel := int64(int16(binary.BigEndian.Uint16(body[n:])))
+	return time.Duration((r.clusterTime + rel) * r.timecodeScale), true
+}
+
+func (r *Reader) nextHeader() (header, error) {
+	if r.pending != nil {
+		h := *r.pending
+		r.pending = nil
+		return h, nil
+	}
+	return r.readHeader()
+}
+
+// readHeader reads an element ID and size. It returns io.EOF only when the
+// stream ends cleanly between elements.
+func (r *Reader) readHeader() (header, error) {
+	first, err := r.r.ReadByte()
+	if err != nil {
+		return header{}, err
+	}
+	idLen := vintLength(first)
+	if idLen == 0 || idLen > 4 {
+		return header{}, fmt.Errorf("%w: bad element ID", ErrInvalid)
+	}
+	raw := make([]byte, idLen, idLen+8)
+	raw[0] = first
+	if _, err := io.ReadFull(r.r, raw[1:]); err != nil {
+		return header{}, eof(err)
+	}
+	var id uint32
+	for _, c := range raw {
+		id = id<<8 | uint32(c)
+	}
+
+	return r.readSize(id, raw)
+}
+
+// readSize reads the size following an element ID.
+func (r *Reader) readSize(id uint32, raw []byte) (header, error) {
+	sizeFirst, err := r.r.ReadByte()
+	if err != nil {
+		return header{}, eof(err)
+	}
+	sizeLen := vintLength(sizeFirst)
+	if sizeLen == 0 {
+		return header{}, fmt.Errorf("%w: bad element size", ErrInvalid)
+	}
+	sizeRaw := make([]byte, sizeLen)
+	sizeRaw[0] = sizeFirst
+	if _, err := io.ReadFull(r.r, sizeRaw[1:]); err != nil {
+		return header{}, eof(err)
+	}
+	size, _, err := parseVint(sizeRaw, false)
+	if err != nil {
+		return header{}, err
+	}
+	return header{id: id, size: size, raw: append(raw, sizeRaw...)}, nil
+}
+
+// Resync skips ahead to the next Cluster after Next returned ErrInvalid,
+// e.g. for a stream joined in the middle of an element. The timecode scale
+// of the last init segment is kept.
+func (r *Reader) Resync() error {
+	r.pending = nil
+	r.inCluster = false
+	var window uint32
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		window = window<<8 | uint32(b)
+		if window != idCluster {
+			continue
+		}
+		h, err := r.readSize(idCluster, []byte{0x1F, 0x43, 0xB6, 0x75})
+		if errors.Is(err, ErrInvalid) {
+			window = 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		r.pending = &h
+		return nil
+	}
+}
+
+// body reads the payload of an element of known size.
+func (r *Reader) body(h header) ([]byte, error) {
+	if h.size == unknownSize || h.size > maxElementSize {
+		return nil, fmt.Errorf("%w: element %x too large", ErrInvalid, h.id)
+	}
+	b := make([]byte, h.size)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, eof(err)
+	}
+	return b, nil
+}
+
+// topLevel reports whether id ends an unknown-size cluster.
+func topLevel(id uint32) bool {
+	switch id {
+	case idEBML, idCluster, idCues, idTags, idSeekHead, idInfo, idTracks, idChapters, idAttachments:
+		return true
+	}
+	return false
+}
+
+// vintLength returns the length of a variable-size integer from its first
+// byte, or 0 if the byte cannot start one.
+func vintLength(b byte) int {
+	for n := 1; n <= 8; n++ {
+		if b&(0x80>>(n-1)) != 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// parseVint decodes a variable-size integer. Sizes with all value bits set
+// mean "unknown"; track numbers never are.
+func parseVint(b []byte, trackNumber bool) (int64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrInvalid
+	}
+	n := vintLength(b[0])
+	if n == 0 || len(b) < n {
+		return 0, 0, ErrInvalid
+	}
+	v := int64(b[0] & (0xFF >> n))
+	allOnes := v == int64(0xFF>>n)
+	for _, c := range b[1:n] {
+		v = v<<8 | int64(c)
+		allOnes = allOnes && c == 0xFF
+	}
+	if allOnes && !trackNumber {
+		return unknownSize, n, nil
+	}
+	return v, n, nil
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// findElement returns the body of the first child with the given ID in a
+// master element body.
+func findElement(body []byte, id uint32) ([]byte, bool) {
+	for len(body) > 0 {
+		idLen := vintLength(body[0])
+		if idLen == 0 || idLen > 4 || len(body) < idLen {
+			return nil, false
+		}
+		var got uint32
+		for _, c := range body[:idLen] {
+			got = got<<8 | uint32(c)
+		}
+		size, n, err := parseVint(body[idLen:], false)
+		if err != nil || size == unknownSize {
+			return nil, false
+		}
+		start := idLen + n
+		if int64(len(body)-start) < size {
+			return nil, false
+		}
+		if got == id {
+			return body[start : start+int(size)], true
+		}
+		body = body[start+int(size):]
+	}
+	return nil, false
+}
+
+func findUint(body []byte, id uint32) (uint64, bool) {
+	b, ok := findElement(body, id)
+	if !ok {
+		return 0, false
+	}
+	return readUint(b), true
+}
+
+// eof turns an io.EOF in the middle of an element into io.ErrUnexpectedEOF.
+func eof(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
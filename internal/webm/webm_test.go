@@ -0,0 +1,271 @@
+package webm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// el encodes an element of known size.
+func el(id uint32, body ...[]byte) []byte {
+	b := idBytes(id)
+	data := bytes.Join(body, nil)
+	if len(data) < 0x7F {
+		b = append(b, 0x80|byte(len(data)))
+	} else {
+		b = append(b, 0x01, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], uint32(len(data)))
+	}
+	return append(b, data...)
+}
+
+// open encodes the header of an element of unknown size, whose children
+// follow it.
+func open(id uint32) []byte {
+	return append(idBytes(id), 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+}
+
+func idBytes(id uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+func uintBytes(v uint64) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(v))
+}
+
+var tracks = el(idTracks, el(0xAE, el(0xD7, []byte{1}), el(0x86, []byte("A_OPUS"))))
+
+// initSegment is an EBML header, a live Segment and its Info and Tracks.
+// A scale of 0 leaves out the TimecodeScale.
+func initSegment(scale uint64) []byte {
+	var info []byte
+	if scale != 0 {
+		info = el(idTimecodeScale, binary.BigEndian.AppendUint32(nil, uint32(scale)))
+	}
+	return bytes.Join([][]byte{
+		el(idEBML, el(0x4282, []byte("webm"))),
+		open(idSegment),
+		el(idInfo, info),
+		tracks,
+	}, nil)
+}
+
+// block is a SimpleBlock of track 1 at rel ticks from its cluster.
+func block(rel int16) []byte {
+	body := []byte{0x81, 0, 0, 0x80, 0xFC, 0xFF, 0xFE}
+	binary.BigEndian.PutUint16(body[1:], uint16(rel))
+	return el(idSimpleBlock, body)
+}
+
+// cluster is a Cluster of known size at timecode tc.
+func cluster(tc uint64, blocks ...[]byte) []byte {
+	return el(idCluster, append([][]byte{el(idTimecode, uintBytes(tc))}, blocks...)...)
+}
+
+// liveCluster is a Cluster of unknown size at timecode tc.
+func liveCluster(tc uint64, blocks ...[]byte) []byte {
+	return bytes.Join(append([][]byte{open(idCluster), el(idTimecode, uintBytes(tc))}, blocks...), nil)
+}
+
+func cat(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+type want struct {
+	kind Kind
+	time time.Duration
+}
+
+func ms(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		// dropped is left out of the chunks' data
+		dropped []byte
+		want    []want
+		err     error
+	}{
+		{
+			name:  "clusters of known size",
+			input: cat(initSegment(0), cluster(1000, block(0), block(20)), cluster(1040, block(0))),
+			want: []want{{KindInit, 0}, {KindCluster, ms(1000)}, {KindBlock, ms(1000)}, {KindBlock, ms(1020)},
+				{KindCluster, ms(1040)}, {KindBlock, ms(1040)}},
+			err: io.EOF,
+		},
+		{
+			name:  "clusters of unknown size",
+			input: cat(initSegment(0), liveCluster(0, block(0), block(20)), liveCluster(40, block(0), block(20))),
+			want: []want{{KindInit, 0}, {KindCluster, 0}, {KindBlock, 0}, {KindBlock, ms(20)},
+				{KindCluster, ms(40)}, {KindBlock, ms(40)}, {KindBlock, ms(60)}},
+			err: io.EOF,
+		},
+		{
+			name:  "timecode scale",
+			input: cat(initSegment(100_000), liveCluster(10, block(-5), block(5))),
+			want:  []want{{KindInit, 0}, {KindCluster, ms(1)}, {KindBlock, 500 * time.Microsecond}, {KindBlock, 1500 * time.Microsecond}},
+			err:   io.EOF,
+		},
+		{
+			name: "block group",
+			input: cat(initSegment(0), cluster(100,
+				el(idBlockGroup, el(idBlock, []byte{0x81, 0, 7, 0, 0xFC}), el(0x9B, []byte{20})))),
+			want: []want{{KindInit, 0}, {KindCluster, ms(100)}, {KindBlock, ms(107)}},
+			err:  io.EOF,
+		},
+		{
+			name:    "cues after the clusters",
+			input:   cat(initSegment(0), cluster(0, block(0)), el(idCues, el(0xBB, []byte{0})), cluster(20, block(0))),
+			dropped: el(idCues, el(0xBB, []byte{0})),
+			want:    []want{{KindInit, 0}, {KindCluster, 0}, {KindBlock, 0}, {KindCluster, ms(20)}, {KindBlock, ms(20)}},
+			err:     io.EOF,
+		},
+		{
+			name: "concatenated streams",
+			input: cat(initSegment(0), liveCluster(500, block(0)),
+				initSegment(100_000), liveCluster(0, block(10))),
+			want: []want{{KindInit, 0}, {KindCluster, ms(500)}, {KindBlock, ms(500)},
+				{KindInit, 0}, {KindCluster, 0}, {KindBlock, ms(1)}},
+			err: io.EOF,
+		},
+		{
+			name:  "ends inside a block",
+			input: cat(initSegment(0), liveCluster(0, block(0)), block(20)[:5]),
+			want:  []want{{KindInit, 0}, {KindCluster, 0}, {KindBlock, 0}},
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "ends inside the init segment",
+			input: initSegment(0)[:20],
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "top-level element of unknown size",
+			input: cat(initSegment(0), cluster(0, block(0)), open(idTags)),
+			want:  []want{{KindInit, 0}, {KindCluster, 0}, {KindBlock, 0}},
+			err:   ErrInvalid,
+		},
+		{
+			name:  "unknown size inside a cluster of known size",
+			input: cat(initSegment(0), el(idCluster, el(idTimecode, uintBytes(0)), open(idBlockGroup))),
+			want:  []want{{KindInit, 0}, {KindCluster, 0}},
+			err:   ErrInvalid,
+		},
+		{
+			name:  "element overruns its cluster",
+			input: cat(initSegment(0), el(idCluster, el(idTimecode, uintBytes(0)), block(0)[:4]), block(0)[4:]),
+			want:  []want{{KindInit, 0}, {KindCluster, 0}},
+			err:   ErrInvalid,
+		},
+	}
+
+	readers := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"whole", func(r io.Reader) io.Reader { return r }},
+		// Every element, clusters included, is split across reads
+		{"byte by byte", iotest.OneByteReader},
+	}
+
+	for _, tt := range tests {
+		for _, rd := range readers {
+			t.Run(tt.name+"/"+rd.name, func(t *testing.T) {
+				r := NewReader(rd.wrap(bytes.NewReader(tt.input)))
+				var got []want
+				var data []byte
+				var err error
+				for {
+					var c Chunk
+					if c, err = r.Next(); err != nil {
+						break
+					}
+					got = append(got, want{c.Kind, c.Time})
+					data = append(data, c.Data...)
+					if c.Kind == KindInit && !bytes.Equal(c.Tracks, tracks) {
+						t.Errorf("Tracks = %x, want %x", c.Tracks, tracks)
+					}
+				}
+				if !errors.Is(err, tt.err) {
+					t.Errorf("error %v, want %v", err, tt.err)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("chunks %v, want %v", got, tt.want)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Fatalf("chunks %v, want %v", got, tt.want)
+					}
+				}
+				if tt.err == io.EOF {
+					if expect := bytes.Replace(tt.input, tt.dropped, nil, 1); !bytes.Equal(data, expect) {
+						t.Errorf("data of the chunks differs from the input")
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	input := cat(initSegment(100_000), cluster(10, block(5)))
+	r := NewReader(bytes.NewReader(input))
+	var out []byte
+	for {
+		c, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		c = c.Shift(time.Hour)
+		if c.Kind != KindInit && c.Time <= time.Hour {
+			t.Errorf("shifted %v chunk at %v", c.Kind, c.Time)
+		}
+		out = append(out, c.Data...)
+	}
+
+	// The rewritten cluster has an unknown size and reads back shifted
+	var got []time.Duration
+	r = NewReader(bytes.NewReader(out))
+	for {
+		c, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, c.Time)
+	}
+	want := []time.Duration{0, time.Hour + ms(1), time.Hour + 1500*time.Microsecond}
+	if len(got) != len(want) || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("times %v, want %v", got, want)
+	}
+}
+
+// TestResync joins a stream in the middle of a block.
+func TestResync(t *testing.T) {
+	input := cat(block(20)[3:], liveCluster(60, block(0)))
+	r := NewReader(bytes.NewReader(input))
+	if _, err := r.Next(); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Next in a block: %v", err)
+	}
+	if err := r.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := r.Next(); err != nil || c.Kind != KindCluster || c.Time != ms(60) {
+		t.Fatalf("Next after Resync = %v at %v, %v", c.Kind, c.Time, err)
+	}
+	if c, err := r.Next(); err != nil || c.Kind != KindBlock || c.Time != ms(60) {
+		t.Fatalf("Next after the cluster = %v at %v, %v", c.Kind, c.Time, err)
+	}
+}
@@ -0,0 +1,116 @@
+package webpush
+
+import (
+	"time"
+
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/models"
+)
+
+// Subscription is a browser push subscription of one user's device. The
+// subscriptions themselves are kept by store.Devices; this package only
+// queues deliveries to them.
+type Subscription = models.Device
+
+// store is the delivery queue in the database.
+type store struct {
+	db *database.Handle
+}
+
+// enqueue creates one pending delivery per subscription of the user.
+func (s *store) enqueue(userID int64, payload []byte, urgency string, ttl int) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM push_subscriptions WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if _, err := tx.Exec("INSERT INTO push_deliveries (subscription_id, payload, urgency, ttl, next_attempt_at) VALUES (?, ?, ?, ?, ?)",
+			id, payload, urgency, ttl, now); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), tx.Commit()
+}
+
+type delivery struct {
+	id       int64
+	payload  []byte
+	urgency  string
+	ttl      int
+	attempts int
+	sub      Subscription
+}
+
+func (s *store) dueDeliveries(now time.Time, limit int) ([]delivery, error) {
+	rows, err := s.db.Query(`SELECT d.id, d.payload, d.urgency, d.ttl, d.attempts, s.id, s.user_id, s.endpoint, s.p256dh, s.auth
+		FROM push_deliveries d JOIN push_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at LIMIT ?`, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.id, &d.payload, &d.urgency, &d.ttl, &d.attempts,
+			&d.sub.ID, &d.sub.UserID, &d.sub.Endpoint, &d.sub.P256dh, &d.sub.Auth); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+func (s *store) markDelivered(id int64) error {
+	_, err := s.db.Exec("UPDATE push_deliveries SET status = 'delivered', attempts = attempts + 1, last_error = NULL, updated_at = ? WHERE id = ?",
+		time.Now().UTC(), id)
+	return err
+}
+
+func (s *store) markFailed(id int64, reason string) error {
+	_, err := s.db.Exec("UPDATE push_deliveries SET status = 'failed', attempts = attempts + 1, last_error = ?, updated_at = ? WHERE id = ?",
+		reason, time.Now().UTC(), id)
+	return err
+}
+
+func (s *store) scheduleRetry(id int64, next time.Time, reason string) error {
+	_, err := s.db.Exec("UPDATE push_deliveries SET attempts = attempts + 1, next_attempt_at = ?, last_error = ?, updated_at = ? WHERE id = ?",
+		next.UTC(), reason, time.Now().UTC(), id)
+	return err
+}
+
+// pruneSubscription removes a subscription the push service reported as
+// gone. Its pending deliveries go with it.
+func (s *store) pruneSubscription(id int64) error {
+	_, err := s.db.Exec("DELETE FROM push_subscriptions WHERE id = ?", id)
+	return err
+}
+
+// purgeDeliveries drops finished deliveries older than the cutoff.
+func (s *store) purgeDeliveries(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM push_deliveries WHERE status != 'pending' AND updated_at < ?", before.UTC())
+	return err
+}
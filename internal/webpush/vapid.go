@@ -0,0 +1,129 @@
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// VAPIDKeys identifies this application server to push services (RFC 8292).
+type VAPIDKeys struct {
+	private *ecdsa.PrivateKey
+	// PublicKey is the uncompressed P-256 public key, base64url without
+	// padding, as browsers expect for applicationServerKey.
+	PublicKey string
+}
+
+type storedKeys struct {
+	PublicKey  string `json:"public_key"`
+	PrivateKey string `json:"private_key"`
+}
+
+// ParseVAPIDKeys loads a key pair from its base64url encoding. The public key
+// is derived from the private key; if given it must match.
+func ParseVAPIDKeys(publicKey, privateKey string) (*VAPIDKeys, error) {
+	raw, err := decodeBase64(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	priv, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	keys, err := newVAPIDKeys(priv)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey != "" && publicKey != keys.PublicKey {
+		return nil, errors.New("VAPID public key does not match private key")
+	}
+	return keys, nil
+}
+
+// LoadOrCreateVAPIDKeys reads the key pair stored at path, generating and
+// saving a new one on first use. Keys must stay stable: browsers bind every
+// subscription to the public key it was created with.
+func LoadOrCreateVAPIDKeys(path string) (*VAPIDKeys, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var stored storedKeys
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return ParseVAPIDKeys(stored.PublicKey, stored.PrivateKey)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return GenerateVAPIDKeys(path)
+}
+
+// GenerateVAPIDKeys creates a new key pair and saves it to path, replacing
+// any stored before. Existing push subscriptions stop working.
+func GenerateVAPIDKeys(path string) (*VAPIDKeys, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := newVAPIDKeys(priv)
+	if err != nil {
+		return nil, err
+	}
+	rawPriv, err := priv.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(storedKeys{
+		PublicKey:  keys.PublicKey,
+		PrivateKey: base64.RawURLEncoding.EncodeToString(rawPriv),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Written aside and renamed, so a crash never leaves half a key file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func newVAPIDKeys(priv *ecdsa.PrivateKey) (*VAPIDKeys, error) {
+	pub, err := priv.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKeys{private: priv, PublicKey: base64.RawURLEncoding.EncodeToString(pub)}, nil
+}
+
+// authorization builds the "vapid" Authorization header value for a push
+// endpoint. The token audience is the origin of the endpoint.
+func (k *VAPIDKeys) authorization(endpoint, subject string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": subject,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(k.private)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + token + ", k=" + k.PublicKey, nil
+}
@@ -0,0 +1,287 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zamibd/a2web/internal/alerts"
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/events"
+	"github.com/zamibd/a2web/internal/models"
+	"github.com/zamibd/a2web/internal/webhooks"
+)
+
+const (
+	pollInterval = 2 * time.Second
+	batchSize    = 20
+	maxAttempts  = 6
+	baseBackoff  = 10 * time.Second
+	maxBackoff   = time.Hour
+	// keepFinished is how long delivered and failed rows stay for inspection.
+	keepFinished = 7 * 24 * time.Hour
+)
+
+// Urgency values of RFC 8030 section 5.3.
+const (
+	UrgencyNormal = "normal"
+	UrgencyHigh   = "high"
+)
+
+// Message is the JSON payload the service worker receives.
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// Worker delivers queued push messages, retrying transient failures with
+// exponential backoff and pruning subscriptions the push service reports as
+// expired. It queues a message for every alert raised on the bus.
+type Worker struct {
+	Keys *VAPIDKeys
+
+	logger   *slog.Logger
+	bus      *events.Bus
+	store    *store
+	alerts   *alerts.Store
+	subject  string
+	override *url.URL
+	client   *http.Client
+	wake     chan struct{}
+}
+
+// NewWorker creates a delivery worker that queues in db. subject is the VAPID contact
+// ("mailto:" or "https:" URL). If endpointOverride is set, every request is
+// sent to that scheme and host instead of the subscription's push service,
+// keeping the path, which lets tests run against a local receiver.
+// Otherwise the worker refuses to connect to loopback, private and
+// link-local addresses, as webhooks do.
+func NewWorker(logger *slog.Logger, bus *events.Bus, db *database.Handle, keys *VAPIDKeys, subject, endpointOverride string) (*Worker, error) {
+	w := &Worker{
+		Keys:    keys,
+		logger:  logger,
+		bus:     bus,
+		store:   &store{db: db},
+		alerts:  alerts.NewStore(db),
+		subject: subject,
+		wake:    make(chan struct{}, 1),
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if endpointOverride != "" {
+		u, err := url.Parse(endpointOverride)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid push endpoint override %q", endpointOverride)
+		}
+		w.override = u
+	} else {
+		dialer.Control = webhooks.DialControl
+	}
+	w.client = &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+	return w, nil
+}
+
+// CheckEndpoint validates the endpoint of a new subscription: an absolute
+// https URL whose host resolves only to public addresses. With an endpoint
+// override every request goes to the override instead, so any endpoint
+// is accepted.
+func (w *Worker) CheckEndpoint(ctx context.Context, endpoint string) error {
+	if w.override != nil {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("endpoint must be an absolute https URL")
+	}
+	return webhooks.CheckURL(ctx, endpoint, false)
+}
+
+// Notify queues a message for every push subscription of the user.
+func (w *Worker) Notify(userID int64, msg Message, urgency string) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	n, err := w.store.enqueue(userID, payload, urgency, 24*60*60)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run queues a message for every alert raised on the bus and delivers the
+// queue until ctx is done. Alerts are queued on their own goroutine, so a
+// slow push service never leaves bus events unread until they are dropped.
+func (w *Worker) Run(ctx context.Context) {
+	ch, cancel := w.bus.Subscribe(64)
+	defer cancel()
+	go w.queueAlerts(ctx, ch)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+			w.deliverDue(ctx)
+		case <-ticker.C:
+			w.deliverDue(ctx)
+		case <-purge.C:
+			if err := w.store.purgeDeliveries(time.Now().Add(-keepFinished)); err != nil {
+				w.logger.Error("Failed to purge push deliveries", "error", err)
+			}
+		}
+	}
+}
+
+// queueAlerts queues a push message to the recipients of every alert read
+// from ch until ctx is done.
+func (w *Worker) queueAlerts(ctx context.Context, ch <-chan events.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if e.Type != events.AlertRaised {
+				continue
+			}
+			alert, ok := e.Data.(models.Alert)
+			if !ok {
+				continue
+			}
+			msg := Message{Title: "a2web alert", Body: alert.Message, URL: "/user/" + alert.SessionID, Tag: "alert-" + strconv.FormatInt(alert.ID, 10)}
+			if alert.Kind == models.AlertSOS {
+				msg.Title = "SOS"
+			}
+			users, err := w.alerts.Recipients(alert)
+			if err != nil {
+				w.logger.Error("Failed to load alert recipients", "alert_id", alert.ID, "error", err)
+				users = []int64{alert.UserID}
+			}
+			for _, userID := range users {
+				if err := w.Notify(userID, msg, UrgencyHigh); err != nil {
+					w.logger.Error("Failed to queue push notification", "alert_id", alert.ID, "user_id", userID, "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (w *Worker) deliverDue(ctx context.Context) {
+	due, err := w.store.dueDeliveries(time.Now(), batchSize)
+	if err != nil {
+		w.logger.Error("Failed to load push deliveries", "error", err)
+		return
+	}
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		w.deliver(ctx, d)
+	}
+}
+
+func (w *Worker) deliver(ctx context.Context, d delivery) {
+	status, err := w.send(ctx, d)
+
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		w.store.markDelivered(d.id)
+
+	case status == http.StatusNotFound || status == http.StatusGone:
+		w.logger.Info("Pruning expired push subscription", "subscription_id", d.sub.ID, "status", status)
+		if err := w.store.pruneSubscription(d.sub.ID); err != nil {
+			w.logger.Error("Failed to prune push subscription", "subscription_id", d.sub.ID, "error", err)
+		}
+
+	case err == nil && status != http.StatusTooManyRequests && status < 500:
+		// Other client errors (bad payload, bad VAPID) will not succeed on retry.
+		w.store.markFailed(d.id, "HTTP "+strconv.Itoa(status))
+
+	default:
+		reason := "HTTP " + strconv.Itoa(status)
+		if err != nil {
+			reason = err.Error()
+		}
+		if d.attempts+1 >= maxAttempts {
+			w.logger.Warn("Giving up on push delivery", "delivery_id", d.id, "error", reason)
+			w.store.markFailed(d.id, reason)
+			return
+		}
+		w.store.scheduleRetry(d.id, time.Now().Add(backoff(d.attempts)), reason)
+	}
+}
+
+// send encrypts and posts one message. It returns the push service's status
+// code, or an error if the request could not be made.
+func (w *Worker) send(ctx context.Context, d delivery) (int, error) {
+	body, err := Encrypt(d.payload, d.sub.P256dh, d.sub.Auth)
+	if err != nil {
+		// A subscription with unusable keys can never be delivered to.
+		return http.StatusGone, nil
+	}
+	auth, err := w.Keys.authorization(d.sub.Endpoint, w.subject)
+	if err != nil {
+		return 0, err
+	}
+
+	target := d.sub.Endpoint
+	if w.override != nil {
+		u, err := url.Parse(target)
+		if err != nil {
+			return 0, err
+		}
+		u.Scheme, u.Host = w.override.Scheme, w.override.Host
+		target = u.String()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(d.ttl))
+	req.Header.Set("Urgency", d.urgency)
+	req.Header.Set("Authorization", auth)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func backoff(attempts int) time.Duration {
+	d := baseBackoff << attempts
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,94 @@
+// Package wstickets issues short-lived, single-use tickets that authorize one
+// WebSocket connection. Clients that cannot send the token cookie or an
+// Authorization header with the upgrade request, such as native apps and
+// webviews on another origin, fetch a ticket over an authenticated HTTP call
+// and pass it as a query parameter.
+//
+// Tickets are kept in memory only: they live for seconds, and a restart
+// simply makes clients fetch a new one.
+package wstickets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Roles a ticket can be bound to.
+const (
+	RoleListener = "listener" // /ws/parent/{id}
+	RoleSource   = "source"   // /ws/kid/{id}
+)
+
+// DefaultTTL is how long a ticket stays valid.
+const DefaultTTL = 10 * time.Second
+
+var (
+	// ErrInvalid is returned for unknown, already used and expired tickets.
+	ErrInvalid = errors.New("invalid or expired ticket")
+	// ErrMismatch is returned when a ticket is presented for another session
+	// or role than it was issued for. The ticket is spent all the same.
+	ErrMismatch = errors.New("ticket was issued for another session or role")
+)
+
+// Ticket is the connection a ticket authorizes.
+type Ticket struct {
+	SessionID string
+	UserID    int64
+	Role      string
+	ExpiresAt time.Time
+}
+
+// Store holds outstanding tickets.
+type Store struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	tickets map[string]Ticket
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{ttl: ttl, tickets: make(map[string]Ticket)}
+}
+
+// Issue creates a ticket for userID to connect to sessionID in role.
+func (s *Store) Issue(sessionID string, userID int64, role string) (string, Ticket, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Ticket{}, err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	t := Ticket{SessionID: sessionID, UserID: userID, Role: role, ExpiresAt: now.Add(s.ttl)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Drop expired tickets nobody redeemed
+	for k, old := range s.tickets {
+		if now.After(old.ExpiresAt) {
+			delete(s.tickets, k)
+		}
+	}
+	s.tickets[raw] = t
+	return raw, t, nil
+}
+
+// Redeem spends a ticket. It succeeds at most once per ticket, and only for
+// the session and role the ticket was issued for.
+func (s *Store) Redeem(raw, sessionID, role string) (Ticket, error) {
+	s.mu.Lock()
+	t, ok := s.tickets[raw]
+	delete(s.tickets, raw)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(t.ExpiresAt) {
+		return Ticket{}, ErrInvalid
+	}
+	if t.SessionID != sessionID || t.Role != role {
+		return Ticket{}, ErrMismatch
+	}
+	return t, nil
+}
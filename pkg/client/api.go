@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// User is an a2web account.
+type User struct {
+	ID        int64     `json:"id"`
+	Mobile    string    `json:"mobile"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Live states of a session.
+const (
+	StateIdle         = "idle"
+	StateLive         = "live"
+	StateReconnecting = "reconnecting"
+)
+
+// LiveState is the live state of a session as tracked by the server.
+type LiveState struct {
+	SessionID string     `json:"session_id"`
+	State     string     `json:"state"`
+	Listeners int        `json:"listeners"`
+	Bitrate   int        `json:"bitrate"`
+	Muted     bool       `json:"muted"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// Session is a streaming session with its live state. Role is the
+// caller's role in it: "owner", or "listener" or "viewer" for sessions
+// shared with them.
+type Session struct {
+	ID          string          `json:"id"`
+	UserID      int64           `json:"user_id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Status      string          `json:"status"`           // "active" or "archived"
+	OrgID       int64           `json:"org_id,omitempty"` // organization the session is a room of
+	Settings    SessionSettings `json:"settings"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Role        string          `json:"role"` // "owner", "staff", "listener" or "viewer"
+	Live        LiveState       `json:"live"`
+	KidLink     string          `json:"kid_link,omitempty"` // for owners only
+}
+
+// SessionSettings are the recording and audio settings of a session.
+type SessionSettings struct {
+	Recording        bool `json:"recording"`
+	AudioBitrate     int  `json:"audio_bitrate"` // bits/s; 0 leaves it to the browser
+	EchoCancellation bool `json:"echo_cancellation"`
+	NoiseSuppression bool `json:"noise_suppression"`
+	AutoGainControl  bool `json:"auto_gain_control"`
+}
+
+// SessionUpdate changes a session. Nil fields keep their values.
+type SessionUpdate struct {
+	Name        *string          `json:"name,omitempty"`
+	Description *string          `json:"description,omitempty"`
+	Status      *string          `json:"status,omitempty"`
+	Settings    *SessionSettings `json:"settings,omitempty"`
+}
+
+// Recording is the audio of one source connection.
+type Recording struct {
+	ID        int64      `json:"id"`
+	SessionID string     `json:"session_id"`
+	Status    string     `json:"status"` // "recording", "finalized"
+	Bytes     int64      `json:"bytes"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// Pagination describes one page of a list. NextOffset is nil on the last
+// page.
+type Pagination struct {
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	Total      int  `json:"total"`
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// Page is one page of a list endpoint.
+type Page[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// ListOptions selects a page. Zero values use the server defaults.
+type ListOptions struct {
+	Limit  int
+	Offset int
+}
+
+func (o ListOptions) query(q url.Values) string {
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+const apiPrefix = "/api/v1"
+
+func sessionPath(id string) string {
+	return apiPrefix + "/sessions/" + url.PathEscape(id)
+}
+
+// Me returns the authenticated user.
+func (c *Client) Me(ctx context.Context) (*User, error) {
+	var u User
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/me", nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListSessions returns one page of the caller's sessions, newest first.
+func (c *Client) ListSessions(ctx context.Context, opts ListOptions) (*Page[Session], error) {
+	var p Page[Session]
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/sessions"+opts.query(url.Values{}), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreateSession creates a session. An empty name lets the server pick one.
+func (c *Client) CreateSession(ctx context.Context, name string) (*Session, error) {
+	var s Session
+	if err := c.do(ctx, http.MethodPost, apiPrefix+"/sessions", map[string]string{"name": name}, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSession returns one session with its live state.
+func (c *Client) GetSession(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	if err := c.do(ctx, http.MethodGet, sessionPath(id), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// UpdateSession renames, describes, archives or restores a session, or
+// changes its settings.
+func (c *Client) UpdateSession(ctx context.Context, id string, u SessionUpdate) (*Session, error) {
+	var s Session
+	if err := c.do(ctx, http.MethodPatch, sessionPath(id), u, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// RotateKidLink gives a session a new kid link; the old one stops working.
+func (c *Client) RotateKidLink(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	if err := c.do(ctx, http.MethodPost, sessionPath(id)+"/rotate-kid-link", nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DeleteSession deletes a session and its recordings.
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, sessionPath(id), nil, nil)
+}
+
+// ListRecordings returns one page of recordings, newest first, of one
+// session or, with an empty sessionID, of all the caller's sessions.
+func (c *Client) ListRecordings(ctx context.Context, sessionID string, opts ListOptions) (*Page[Recording], error) {
+	q := url.Values{}
+	if sessionID != "" {
+		q.Set("session_id", sessionID)
+	}
+	var p Page[Recording]
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/recordings"+opts.query(q), nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetRecording returns one recording.
+func (c *Client) GetRecording(ctx context.Context, id int64) (*Recording, error) {
+	var r Recording
+	if err := c.do(ctx, http.MethodGet, apiPrefix+"/recordings/"+strconv.FormatInt(id, 10), nil, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// DownloadRecording writes the WebM audio of a recording to w and returns
+// the number of bytes written.
+func (c *Client) DownloadRecording(ctx context.Context, id int64, w io.Writer) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, apiPrefix+"/recordings/"+strconv.FormatInt(id, 10)+"/audio", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, readError(resp)
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// DeleteRecording deletes a finished recording.
+func (c *Client) DeleteRecording(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, apiPrefix+"/recordings/"+strconv.FormatInt(id, 10), nil, nil)
+}
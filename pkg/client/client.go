@@ -0,0 +1,204 @@
+// Package client is a Go SDK for a2web servers.
+//
+// It wraps login, the /api/v1 JSON API and both WebSocket roles: a source
+// stream that broadcasts audio into a session, exposed as an io.Writer, and
+// a listener stream that receives it, exposed as an io.Reader. Both streams
+// reconnect automatically.
+//
+//	c, err := client.New("https://a2web.example.com", client.WithToken(os.Getenv("A2WEB_TOKEN")))
+//	sessions, err := c.ListSessions(ctx, client.ListOptions{})
+//	l, err := c.Listen(ctx, sessions.Data[0].ID, nil)
+//	io.Copy(file, l)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// APIError is an error answered by the server. Code is one of the API's
+// error codes, e.g. "not_found" or "insufficient_scope"; it is empty for
+// endpoints outside /api/v1.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("a2web: %d %s: %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("a2web: %d: %s", e.Status, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// permanent reports whether retrying the request that failed with err cannot
+// succeed, e.g. because the credentials were rejected.
+func permanent(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Status >= 400 && apiErr.Status < 500 && apiErr.Status != http.StatusTooManyRequests
+}
+
+// Client talks to one a2web server. It is safe for concurrent use.
+type Client struct {
+	baseURL *url.URL
+	http    *http.Client
+	dialer  *websocket.Dialer
+	token   string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken authenticates with a personal API token instead of a login.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient sets the HTTP client used for API calls. A cookie jar is
+// added when it has none, since Login relies on it.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithDialer sets the WebSocket dialer used by streams.
+func WithDialer(d *websocket.Dialer) Option {
+	return func(c *Client) { c.dialer = d }
+}
+
+// New creates a client for the server at baseURL, e.g.
+// "https://a2web.example.com".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("a2web: base URL must be http or https, got %q", baseURL)
+	}
+
+	c := &Client{baseURL: u, http: &http.Client{}, dialer: websocket.DefaultDialer}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.http.Jar == nil {
+		hc := *c.http
+		hc.Jar, _ = cookiejar.New(nil)
+		c.http = &hc
+	}
+	return c, nil
+}
+
+// Login signs in with a mobile number and password. The session cookie is
+// kept by the client for later calls.
+func (c *Client) Login(ctx context.Context, mobile, password string) error {
+	return c.do(ctx, http.MethodPost, "/login", map[string]string{"mobile": mobile, "password": password}, nil)
+}
+
+// Logout ends the cookie session.
+func (c *Client) Logout(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/logout", nil, nil)
+}
+
+// authenticated reports whether the client has a token or a login cookie.
+func (c *Client) authenticated() bool {
+	if c.token != "" {
+		return true
+	}
+	for _, ck := range c.http.Jar.Cookies(c.baseURL) {
+		if ck.Name == "token" && ck.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) url(path string) string {
+	return c.baseURL.String() + path
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends a request with an optional JSON body and decodes a JSON answer
+// into out, unless out is nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// readError turns a failed response into an *APIError, using the API's error
+// object when there is one and the plain text body otherwise.
+func readError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	apiErr := &APIError{Status: resp.StatusCode}
+
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(b, &body) == nil && body.Error.Code != "" {
+		apiErr.Code = body.Error.Code
+		apiErr.Message = body.Error.Message
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(b))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
@@ -0,0 +1,274 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zamibd/a2web/internal/database"
+	"github.com/zamibd/a2web/internal/handlers"
+	"github.com/zamibd/a2web/internal/middleware"
+	"github.com/zamibd/a2web/internal/store/sqlstore"
+	"github.com/zamibd/a2web/pkg/client"
+)
+
+const (
+	testMobile   = "01700000001"
+	testPassword = "correct horse"
+)
+
+// newServer runs the server's router against a fresh SQLite database in a
+// temporary directory, which is also where recordings are written.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	t.Chdir(t.TempDir())
+
+	if err := database.Open(database.SQLite, "a2web.db"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DB.Close() })
+	if _, err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := handlers.New(logger, map[string]*template.Template{}, sqlstore.New(database.DB), database.DB)
+	srv := httptest.NewServer(h.Routes(middleware.New(logger)))
+	t.Cleanup(srv.Close)
+
+	body, _ := json.Marshal(map[string]string{"mobile": testMobile, "password": testPassword})
+	resp, err := http.Post(srv.URL+"/register", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("register: HTTP %d", resp.StatusCode)
+	}
+	return srv
+}
+
+// conns remembers the network connections of a client, so a test can cut
+// them like a network failure would.
+type conns struct {
+	mu   sync.Mutex
+	last net.Conn
+}
+
+func (c *conns) dialer() *websocket.Dialer {
+	var d net.Dialer
+	return &websocket.Dialer{
+		HandshakeTimeout: 5 * time.Second,
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := d.DialContext(ctx, network, addr)
+			if err == nil {
+				c.mu.Lock()
+				c.last = conn
+				c.mu.Unlock()
+			}
+			return conn, err
+		},
+	}
+}
+
+// cut closes the last connection without a WebSocket close frame.
+func (c *conns) cut() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last.Close()
+}
+
+var fastReconnect = &client.ReconnectPolicy{MinDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+
+func TestClient(t *testing.T) {
+	srv := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	listenerConns := &conns{}
+	c, err := client.New(srv.URL, client.WithDialer(listenerConns.dialer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Login
+	if err := c.Login(ctx, testMobile, "wrong"); err == nil {
+		t.Fatal("login with a wrong password succeeded")
+	}
+	if _, err := c.Me(ctx); err == nil {
+		t.Fatal("Me succeeded without a login")
+	}
+	if err := c.Login(ctx, testMobile, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	me, err := c.Me(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.Mobile != testMobile {
+		t.Fatalf("Me: mobile %q, want %q", me.Mobile, testMobile)
+	}
+
+	// Sessions API
+	s, err := c.CreateSession(ctx, "Nursery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "Nursery" || s.Role != "owner" || s.KidLink == "" || !s.Settings.Recording {
+		t.Fatalf("CreateSession: %+v", s)
+	}
+	name := "Baby room"
+	if s, err = c.UpdateSession(ctx, s.ID, client.SessionUpdate{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetSession(ctx, s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != name {
+		t.Fatalf("GetSession: name %q, want %q", got.Name, name)
+	}
+	page, err := c.ListSessions(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Pagination.Total != 1 || len(page.Data) != 1 || page.Data[0].ID != s.ID {
+		t.Fatalf("ListSessions: %+v", page)
+	}
+	if _, err := c.GetSession(ctx, "no-such-session"); !client.IsNotFound(err) {
+		t.Fatalf("GetSession of an unknown session: %v, want not found", err)
+	}
+
+	// Relay. The listener connects first and gets the init segment with
+	// the source's first frame.
+	listener, err := c.Listen(ctx, s.ID, &client.StreamOptions{Reconnect: fastReconnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	waitFor(t, ctx, "the listener to register", func() bool {
+		s, err := c.GetSession(ctx, s.ID)
+		return err == nil && s.Live.Listeners == 1
+	})
+
+	sourceConns := &conns{}
+	kid, err := client.New(srv.URL, client.WithDialer(sourceConns.dialer()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	dials := 0
+	src, err := kid.Source(ctx, path.Base(s.KidLink), &client.StreamOptions{
+		Reconnect: fastReconnect,
+		OnConnect: func(n int) {
+			mu.Lock()
+			dials = n
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	write(t, src, "init")
+	write(t, src, "chunk-1")
+	expectChunk(t, ctx, listener, 1, true, "init")
+	expectChunk(t, ctx, listener, 1, false, "chunk-1")
+
+	// The source reconnects after its socket is cut and sends the init
+	// segment again, which starts a new epoch for the listener
+	sourceConns.cut()
+	write(t, src, "chunk-2")
+	mu.Lock()
+	if dials != 2 {
+		t.Errorf("source connections: %d, want 2", dials)
+	}
+	mu.Unlock()
+	expectChunk(t, ctx, listener, 2, true, "init")
+	expectChunk(t, ctx, listener, 2, false, "chunk-2")
+
+	// The listener reconnects after its socket is cut and is sent the
+	// init segment before anything else
+	listenerConns.cut()
+	expectChunk(t, ctx, listener, 3, true, "init")
+	write(t, src, "chunk-3")
+	expectChunk(t, ctx, listener, 3, false, "chunk-3")
+
+	// Recording download. Every source connection is recorded on its own.
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var recs *client.Page[client.Recording]
+	waitFor(t, ctx, "both recordings to be finalized", func() bool {
+		recs, err = c.ListRecordings(ctx, s.ID, client.ListOptions{})
+		if err != nil || len(recs.Data) != 2 {
+			return false
+		}
+		for _, r := range recs.Data {
+			if r.Status != "finalized" {
+				return false
+			}
+		}
+		return true
+	})
+	want := map[int64]string{}
+	first, second := recs.Data[0], recs.Data[1]
+	if first.ID > second.ID {
+		first, second = second, first
+	}
+	want[first.ID] = "initchunk-1"
+	want[second.ID] = "initchunk-2chunk-3"
+	for id, data := range want {
+		var buf bytes.Buffer
+		n, err := c.DownloadRecording(ctx, id, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != data || n != int64(len(data)) {
+			t.Errorf("recording %d: %q (%d bytes), want %q", id, buf.String(), n, data)
+		}
+	}
+}
+
+func write(t *testing.T, src *client.SourceStream, data string) {
+	t.Helper()
+	if _, err := src.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectChunk reads the next chunk and checks it.
+func expectChunk(t *testing.T, ctx context.Context, l *client.ListenerStream, epoch int, init bool, data string) {
+	t.Helper()
+	c, err := l.NextChunk(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Epoch != epoch || c.Init != init || string(c.Data) != data {
+		t.Fatalf("chunk: epoch %d, init %v, %q; want epoch %d, init %v, %q", c.Epoch, c.Init, c.Data, epoch, init, data)
+	}
+}
+
+// waitFor polls cond until it holds or ctx is done.
+func waitFor(t *testing.T, ctx context.Context, what string, cond func() bool) {
+	t.Helper()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %s", what)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
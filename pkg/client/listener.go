@@ -0,0 +1,245 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Chunk is one binary frame of a live session.
+type Chunk struct {
+	// Epoch counts init segments. Chunks with the same epoch form one
+	// playable WebM stream; a new epoch starts whenever the source
+	// (re)connects or the listener reconnects.
+	Epoch int
+	// Init marks the init segment that starts an epoch.
+	Init bool
+	Data []byte
+}
+
+// ListenerStream receives a live session, like the parent page does. Read
+// returns the raw bytes of every chunk in order, which is enough to save or
+// pipe a single broadcast; use NextChunk to tell where a new init segment
+// starts after a reconnect.
+type ListenerStream struct {
+	c         *Client
+	sessionID string
+	opts      *StreamOptions
+	policy    ReconnectPolicy
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	chunks  chan Chunk
+	control chan ControlMessage
+	done    chan struct{}
+	buf     []byte
+
+	mu   sync.Mutex // guards conn for writes and err
+	conn *websocket.Conn
+	err  error
+}
+
+// Listen connects to a session as a listener. It needs a login or a token
+// with the sessions:read scope. ctx bounds the whole stream, not just the
+// first connect.
+func (c *Client) Listen(ctx context.Context, sessionID string, opts *StreamOptions) (*ListenerStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	l := &ListenerStream{
+		c:         c,
+		sessionID: sessionID,
+		opts:      opts,
+		policy:    opts.policy(),
+		ctx:       ctx,
+		cancel:    cancel,
+		chunks:    make(chan Chunk, 64),
+		control:   make(chan ControlMessage, 16),
+		done:      make(chan struct{}),
+	}
+	// The first connect is not retried past a permanent error, so a wrong
+	// session or missing scope surfaces here
+	conn, err := c.connect(ctx, sessionID, roleListener, l.policy)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Cancelling ctx interrupts the read on the current connection
+	context.AfterFunc(ctx, l.interrupt)
+	go l.run(conn)
+	return l, nil
+}
+
+// Control delivers the control messages sent to listeners: source_lost,
+// source_started and sos. Messages are dropped when nobody reads them. The
+// channel is closed when the stream ends.
+func (l *ListenerStream) Control() <-chan ControlMessage { return l.control }
+
+// NextChunk returns the next binary frame. After the stream ended it returns
+// ErrClosed if it was closed, or the error that made it give up.
+func (l *ListenerStream) NextChunk(ctx context.Context) (Chunk, error) {
+	select {
+	case c, ok := <-l.chunks:
+		if !ok {
+			return Chunk{}, l.Err()
+		}
+		return c, nil
+	case <-ctx.Done():
+		return Chunk{}, ctx.Err()
+	}
+}
+
+// Read reads the concatenated chunks. It returns io.EOF once the stream was
+// closed.
+func (l *ListenerStream) Read(p []byte) (int, error) {
+	for len(l.buf) == 0 {
+		c, err := l.NextChunk(context.Background())
+		if errors.Is(err, ErrClosed) {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		l.buf = c.Data
+	}
+	n := copy(p, l.buf)
+	l.buf = l.buf[n:]
+	return n, nil
+}
+
+// Acknowledge tells the server a parent saw an alert, e.g. an SOS, which
+// stops it ringing on the other listeners and confirms it to the kid.
+func (l *ListenerStream) Acknowledge(alertID int64) error {
+	b, err := json.Marshal(ControlMessage{Type: MsgAck, AlertID: alertID})
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if l.conn == nil {
+		return errors.New("a2web: not connected")
+	}
+	return l.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// Close stops listening.
+func (l *ListenerStream) Close() error {
+	l.cancel()
+	<-l.done
+	return nil
+}
+
+// interrupt closes the current connection once the stream's context is done.
+func (l *ListenerStream) interrupt() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		closeConn(l.conn)
+	}
+}
+
+// Err returns why the stream ended, or nil while it runs.
+func (l *ListenerStream) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// run reads connection after connection until the stream is closed or the
+// reconnect policy gives up.
+func (l *ListenerStream) run(conn *websocket.Conn) {
+	defer close(l.done)
+	defer close(l.control)
+	defer close(l.chunks)
+
+	epoch := 0
+	for dials := 1; ; dials++ {
+		l.mu.Lock()
+		l.conn = conn
+		if l.ctx.Err() != nil {
+			// Cancelled before interrupt could see the connection
+			closeConn(conn)
+		}
+		l.mu.Unlock()
+		if l.opts != nil && l.opts.OnConnect != nil {
+			l.opts.OnConnect(dials)
+		}
+
+		err := l.receive(conn, &epoch)
+
+		l.mu.Lock()
+		l.conn = nil
+		l.mu.Unlock()
+		conn.Close()
+
+		if l.ctx.Err() != nil {
+			l.finish(ErrClosed)
+			return
+		}
+		if l.opts != nil && l.opts.OnDisconnect != nil {
+			l.opts.OnDisconnect(err)
+		}
+		if l.policy.MaxAttempts < 0 {
+			l.finish(err)
+			return
+		}
+		if conn, err = l.c.connect(l.ctx, l.sessionID, roleListener, l.policy); err != nil {
+			if l.ctx.Err() != nil {
+				err = ErrClosed
+			}
+			l.finish(err)
+			return
+		}
+	}
+}
+
+// receive handles one connection. The first binary frame after connecting,
+// and the first one after source_started, is an init segment.
+func (l *ListenerStream) receive(conn *websocket.Conn, epoch *int) error {
+	expectInit := true
+	for {
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		switch messageType {
+		case websocket.BinaryMessage:
+			c := Chunk{Epoch: *epoch, Data: p}
+			if expectInit {
+				*epoch++
+				c = Chunk{Epoch: *epoch, Init: true, Data: p}
+				expectInit = false
+			}
+			select {
+			case l.chunks <- c:
+			case <-l.ctx.Done():
+				return l.ctx.Err()
+			}
+		case websocket.TextMessage:
+			var msg ControlMessage
+			if err := json.Unmarshal(p, &msg); err != nil {
+				continue
+			}
+			if msg.Type == MsgSourceStarted {
+				expectInit = true
+			}
+			select {
+			case l.control <- msg:
+			default:
+			}
+		}
+	}
+}
+
+func (l *ListenerStream) finish(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+}
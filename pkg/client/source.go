@@ -0,0 +1,276 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeTimeout bounds a single frame write, so a dead connection is noticed
+// even when the network does not report it.
+const writeTimeout = 10 * time.Second
+
+// SourceStream broadcasts audio into a session, like the kid page does. It
+// is an io.Writer: every Write sends one binary frame, and the first frame
+// must be the WebM init segment. After a reconnect the init segment is sent
+// again before anything else, so listeners and the new recording can decode
+// the stream.
+type SourceStream struct {
+	c         *Client
+	sessionID string
+	opts      *StreamOptions
+	policy    ReconnectPolicy
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex // serializes writes and guards the fields below
+	conn  *sourceConn
+	init  []byte
+	dials int
+	err   error
+
+	muted   atomic.Bool
+	stopped atomic.Bool
+	control chan ControlMessage
+	readers sync.WaitGroup
+	once    sync.Once
+}
+
+// sourceConn is one connection of a source stream. done is closed when its
+// reader goroutine ends, with the cause in err.
+type sourceConn struct {
+	ws   *websocket.Conn
+	done chan struct{}
+	err  error
+}
+
+func (sc *sourceConn) dead() bool {
+	select {
+	case <-sc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Source connects to a session as its broadcaster. Without a login or token
+// it uses the unauthenticated kid link, and id must be the broadcast ID, the
+// last part of /kids/{id}; with credentials the session ID works too. A
+// personal API token needs the stream:ingest scope. ctx bounds the whole
+// stream, not just the first connect.
+func (c *Client) Source(ctx context.Context, id string, opts *StreamOptions) (*SourceStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &SourceStream{
+		c:         c,
+		sessionID: id,
+		opts:      opts,
+		policy:    opts.policy(),
+		ctx:       ctx,
+		cancel:    cancel,
+		control:   make(chan ControlMessage, 16),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reconnect(); err != nil {
+		s.terminate(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Control delivers the control messages the server sends to the source:
+// mute, unmute, stop and sos_ack. Messages are dropped when nobody reads
+// them. The channel is closed when the stream ends.
+func (s *SourceStream) Control() <-chan ControlMessage { return s.control }
+
+// Muted reports whether a parent muted the source. The stream keeps
+// flowing while muted; the broadcaster should send silence, as the kid page
+// does by disabling its microphone track.
+func (s *SourceStream) Muted() bool { return s.muted.Load() }
+
+// Write sends p as one binary frame, reconnecting first when the connection
+// dropped. The first Write is remembered as the init segment.
+func (s *SourceStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.send(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	// Kept only once sent, so a reconnect during the first Write does not
+	// send it twice
+	if s.init == nil {
+		s.init = append([]byte(nil), p...)
+	}
+	return len(p), nil
+}
+
+// SendLevel reports the current audio level on the 0-100 scale of sound
+// alert thresholds. The kid page maps -60 dBFS and quieter to 0 and full
+// scale to 100, and reports once a second.
+func (s *SourceStream) SendLevel(level float64) error {
+	return s.sendJSON(ControlMessage{Type: MsgLevel, Level: level})
+}
+
+// SOS asks the parents for help. They are alerted on every channel they
+// enabled; a sos_ack control message follows once one of them saw it.
+func (s *SourceStream) SOS() error {
+	return s.sendJSON(ControlMessage{Type: MsgSOS})
+}
+
+func (s *SourceStream) sendJSON(msg ControlMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.send(websocket.TextMessage, b)
+}
+
+// Close ends the broadcast with a normal close, which the server does not
+// treat as a lost source.
+func (s *SourceStream) Close() error {
+	// Cancel first, so a reconnect in progress gives up and frees the lock
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.terminate(ErrClosed)
+	return nil
+}
+
+// Err returns why the stream ended, or nil while it runs.
+func (s *SourceStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// send writes one frame, reconnecting as often as the policy allows when
+// the connection is gone. Callers hold s.mu.
+func (s *SourceStream) send(messageType int, p []byte) error {
+	for {
+		if s.err != nil {
+			return s.err
+		}
+		if s.conn == nil || s.conn.dead() {
+			if err := s.reconnect(); err != nil {
+				s.terminate(err)
+				return err
+			}
+		}
+		s.conn.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err := s.conn.ws.WriteMessage(messageType, p)
+		if err == nil {
+			return nil
+		}
+		s.drop(err)
+	}
+}
+
+// reconnect replaces a dropped connection and replays the init segment.
+// Callers hold s.mu.
+func (s *SourceStream) reconnect() error {
+	s.drop(nil)
+	if s.stopped.Load() {
+		return ErrStopped
+	}
+	for {
+		ws, err := s.c.connect(s.ctx, s.sessionID, roleSource, s.policy)
+		if err != nil {
+			return err
+		}
+		sc := &sourceConn{ws: ws, done: make(chan struct{})}
+		s.readers.Add(1)
+		go s.read(sc)
+		s.muted.Store(false)
+
+		if s.init != nil {
+			ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := ws.WriteMessage(websocket.BinaryMessage, s.init); err != nil {
+				ws.Close()
+				<-sc.done
+				s.notifyDisconnect(err)
+				continue
+			}
+		}
+		s.conn = sc
+		s.dials++
+		if s.opts != nil && s.opts.OnConnect != nil {
+			s.opts.OnConnect(s.dials)
+		}
+		return nil
+	}
+}
+
+// drop closes the current connection after a failure. Callers hold s.mu.
+func (s *SourceStream) drop(err error) {
+	if s.conn == nil {
+		return
+	}
+	s.conn.ws.Close()
+	<-s.conn.done
+	if err == nil {
+		err = s.conn.err
+	}
+	s.conn = nil
+	s.notifyDisconnect(err)
+}
+
+func (s *SourceStream) notifyDisconnect(err error) {
+	if s.opts != nil && s.opts.OnDisconnect != nil && s.ctx.Err() == nil {
+		s.opts.OnDisconnect(err)
+	}
+}
+
+// terminate ends the stream for good. Callers hold s.mu.
+func (s *SourceStream) terminate(err error) {
+	s.once.Do(func() {
+		s.err = err
+		s.cancel()
+		if s.conn != nil {
+			closeConn(s.conn.ws)
+			s.conn = nil
+		}
+		s.readers.Wait()
+		close(s.control)
+	})
+}
+
+// read handles the server's control messages on one connection. A stop
+// makes the next send fail with ErrStopped instead of reconnecting.
+func (s *SourceStream) read(sc *sourceConn) {
+	defer s.readers.Done()
+	defer close(sc.done)
+	for {
+		messageType, p, err := sc.ws.ReadMessage()
+		if err != nil {
+			sc.err = err
+			return
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		var msg ControlMessage
+		if err := json.Unmarshal(p, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case MsgMute:
+			s.muted.Store(true)
+		case MsgUnmute:
+			s.muted.Store(false)
+		case MsgStop:
+			s.stopped.Store(true)
+			sc.ws.Close()
+		}
+		select {
+		case s.control <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Control message types exchanged over the WebSocket streams.
+const (
+	MsgLevel         = "level"          // source → server: audio level 0-100
+	MsgSOS           = "sos"            // source → server, server → listeners
+	MsgSOSAck        = "sos_ack"        // server → source: a parent saw the SOS
+	MsgAck           = "ack"            // listener → server: acknowledge an alert
+	MsgMute          = "mute"           // server → source
+	MsgUnmute        = "unmute"         // server → source
+	MsgStop          = "stop"           // server → source: stop broadcasting
+	MsgSourceLost    = "source_lost"    // server → listeners
+	MsgSourceStarted = "source_started" // server → listeners: a new init segment follows
+)
+
+// ControlMessage is a JSON text frame on a stream.
+type ControlMessage struct {
+	Type    string  `json:"type"`
+	Reason  string  `json:"reason,omitempty"`
+	Level   float64 `json:"level,omitempty"`
+	AlertID int64   `json:"alert_id,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// Stream roles, matching the roles of WebSocket tickets.
+const (
+	roleListener = "listener"
+	roleSource   = "source"
+)
+
+var (
+	// ErrClosed is returned by stream operations after Close.
+	ErrClosed = errors.New("a2web: stream closed")
+	// ErrStopped is returned by a source stream the server told to stop.
+	ErrStopped = errors.New("a2web: stream stopped by the server")
+	// ErrNotAuthenticated is returned when listening without a login or token.
+	ErrNotAuthenticated = errors.New("a2web: not authenticated")
+)
+
+// ReconnectPolicy controls how streams reconnect after the connection
+// drops. Delays double from MinDelay up to MaxDelay between attempts.
+type ReconnectPolicy struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// MaxAttempts is the number of failed attempts in a row after which the
+	// stream gives up; 0 retries forever. A negative value disables
+	// reconnecting.
+	MaxAttempts int
+}
+
+// DefaultReconnectPolicy retries forever, starting at half a second.
+var DefaultReconnectPolicy = ReconnectPolicy{MinDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+// StreamOptions configures a source or listener stream.
+type StreamOptions struct {
+	// Reconnect is the reconnect policy; nil uses DefaultReconnectPolicy.
+	Reconnect *ReconnectPolicy
+	// OnConnect is called after every successful (re)connect with the number
+	// of the connection, starting at 1.
+	OnConnect func(n int)
+	// OnDisconnect is called when a connection drops, with the cause.
+	OnDisconnect func(err error)
+}
+
+func (o *StreamOptions) policy() ReconnectPolicy {
+	if o == nil || o.Reconnect == nil {
+		return DefaultReconnectPolicy
+	}
+	p := *o.Reconnect
+	if p.MinDelay <= 0 {
+		p.MinDelay = DefaultReconnectPolicy.MinDelay
+	}
+	if p.MaxDelay < p.MinDelay {
+		p.MaxDelay = p.MinDelay
+	}
+	return p
+}
+
+// wsURL turns a path on the server into a ws:// or wss:// URL.
+func (c *Client) wsURL(path string) string {
+	u := *c.baseURL
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	return u.String() + path
+}
+
+// wsTicket fetches a single-use ticket and returns the path to connect to.
+func (c *Client) wsTicket(ctx context.Context, sessionID, role string) (string, error) {
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := c.do(ctx, http.MethodPost, sessionPath(sessionID)+"/ws-ticket", map[string]string{"role": role}, &resp); err != nil {
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+// dial opens one WebSocket connection to a session. Authenticated clients
+// connect with a fresh ticket each time, since tickets are single-use; a
+// source without credentials uses the plain kid link.
+func (c *Client) dial(ctx context.Context, sessionID, role string) (*websocket.Conn, error) {
+	var path string
+	header := http.Header{}
+	switch {
+	case c.authenticated():
+		p, err := c.wsTicket(ctx, sessionID, role)
+		if err != nil {
+			return nil, err
+		}
+		path = p
+	case role == roleSource:
+		path = "/ws/kid/" + url.PathEscape(sessionID)
+		// The kid link is checked against the allowed origins like a browser
+		header.Set("Origin", c.baseURL.Scheme+"://"+c.baseURL.Host)
+	default:
+		return nil, ErrNotAuthenticated
+	}
+
+	conn, resp, err := c.dialer.DialContext(ctx, c.wsURL(path), header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			return nil, readError(resp)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connect dials until it succeeds, the policy gives up, the error is
+// permanent or ctx is done.
+func (c *Client) connect(ctx context.Context, sessionID, role string, p ReconnectPolicy) (*websocket.Conn, error) {
+	delay := p.MinDelay
+	for attempt := 1; ; attempt++ {
+		conn, err := c.dial(ctx, sessionID, role)
+		if err == nil {
+			return conn, nil
+		}
+		if permanent(err) || errors.Is(err, ErrNotAuthenticated) || p.MaxAttempts < 0 || (p.MaxAttempts > 0 && attempt >= p.MaxAttempts) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		delay *= 2
+		if delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// closeConn says goodbye with a normal close frame, so the server does not
+// report the source as lost.
+func closeConn(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
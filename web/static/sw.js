@@ -0,0 +1,25 @@
+// Service worker for Web Push alerts.
+self.addEventListener('push', (event) => {
+    let msg = { title: 'a2web', body: '' };
+    if (event.data) {
+        try { msg = event.data.json(); } catch (e) { msg.body = event.data.text(); }
+    }
+    event.waitUntil(self.registration.showNotification(msg.title, {
+        body: msg.body,
+        tag: msg.tag,
+        renotify: !!msg.tag,
+        requireInteraction: true,
+        data: { url: msg.url || '/dashboard' },
+    }));
+});
+
+self.addEventListener('notificationclick', (event) => {
+    event.notification.close();
+    const url = event.notification.data && event.notification.data.url || '/dashboard';
+    event.waitUntil(clients.matchAll({ type: 'window' }).then((windows) => {
+        for (const w of windows) {
+            if (w.url.endsWith(url) && 'focus' in w) return w.focus();
+        }
+        return clients.openWindow(url);
+    }));
+});